@@ -3,12 +3,32 @@ package main
 import "github.com/graeme-hill/sqlstuff-go/lib"
 
 func main() {
-	err := lib.Generate("./test/basic/migrations", "./test/basic/queries", "./test/basic/store/queries.go", "store")
+	err := lib.Generate(
+		"./test/basic/migrations",
+		"./test/basic/queries",
+		"./test/basic/store/queries.go",
+		"store",
+		lib.GenerateOptions{})
 	if err != nil {
 		panic(err)
 	}
 
-	err = lib.Generate("./test/bugtracker/migrations", "./test/bugtracker/queries", "./test/bugtracker/store/queries.go", "store")
+	err = lib.Generate(
+		"./test/bugtracker/migrations",
+		"./test/bugtracker/queries",
+		"./test/bugtracker/store/queries.go",
+		"store",
+		lib.GenerateOptions{Mock: true, MaxParameters: 4})
+	if err != nil {
+		panic(err)
+	}
+
+	err = lib.Generate(
+		"./test/bugtracker/migrations",
+		"./test/bugtracker/queries",
+		"./test/bugtracker/pgxstore/queries.go",
+		"pgxstore",
+		lib.GenerateOptions{Backend: lib.BackendPgx, Mock: true, MaxParameters: 4})
 	if err != nil {
 		panic(err)
 	}
@@ -3,6 +3,8 @@ module github.com/graeme-hill/sqlstuff-go
 go 1.12
 
 require (
-	github.com/lib/pq v1.1.1
-	github.com/stretchr/testify v1.3.0
+	github.com/jackc/pgtype v1.14.0
+	github.com/jackc/pgx/v4 v4.18.3
+	github.com/lib/pq v1.10.2
+	github.com/stretchr/testify v1.8.1
 )
@@ -5,6 +5,19 @@ type constraintType int
 const (
 	ConstraintTypePrimaryKey constraintType = iota
 	ConstraintTypeUnique
+	ConstraintTypeForeignKey
+	ConstraintTypeCheck
+)
+
+// What happens to the rows that reference a row that is deleted or updated.
+type referentialAction int
+
+const (
+	ReferentialActionNoAction referentialAction = iota
+	ReferentialActionRestrict
+	ReferentialActionCascade
+	ReferentialActionSetNull
+	ReferentialActionSetDefault
 )
 
 type joinType int
@@ -72,6 +85,13 @@ const (
 	DataTypeBinaryJSON
 )
 
+type quantifierType int
+
+const (
+	QuantifierAny quantifierType = iota
+	QuantifierAll
+)
+
 type binaryCondOpType int
 
 const (
@@ -109,22 +129,56 @@ const (
 type Program struct {
 	Statements []Statement
 	Parameters []Parameter
+
+	// Where each of the Parameters is first used, in the same order.
+	parameterLocations []charLocation
+
+	// The comparisons that use optional parameters, which have to be rewritten
+	// before the SQL is sent to Postgres.
+	optionalConditions []optionalCondition
 }
 
 type Parameter struct {
 	Name string
+
+	// The type that the query pinned the parameter to with a cast like
+	// "$since::timestamptz" or an annotation like
+	// "-- param: $since timestamptz null". Nil when the type is left to be
+	// inferred from how the parameter is used. Only an annotation can make the
+	// parameter Nullable.
+	Type *ColumnDefinition
+
+	// Set for parameters like $status? that leave out the comparisons they are
+	// in when they are NULL.
+	Optional bool
 }
 
 type Statement interface {
 	isStatement()
 }
 
-func (s Select) isStatement()      {}
-func (i Insert) isStatement()      {}
-func (s CreateTable) isStatement() {}
-func (s AddColumn) isStatement()   {}
-func (s DropColumn) isStatement()  {}
-func (s DropTable) isStatement()   {}
+func (s Select) isStatement()                  {}
+func (i Insert) isStatement()                  {}
+func (s CreateTable) isStatement()             {}
+func (s AddColumn) isStatement()               {}
+func (s DropColumn) isStatement()              {}
+func (s DropTable) isStatement()               {}
+func (r RenameTable) isStatement()             {}
+func (r RenameColumn) isStatement()            {}
+func (a AlterColumnType) isStatement()         {}
+func (a AlterColumnNullable) isStatement()     {}
+func (a AlterColumnDefault) isStatement()      {}
+func (a AddConstraint) isStatement()           {}
+func (d DropConstraint) isStatement()          {}
+func (c CreateView) isStatement()              {}
+func (d DropView) isStatement()                {}
+func (r RefreshMaterializedView) isStatement() {}
+func (c CreateSchema) isStatement()            {}
+func (s SetVariable) isStatement()             {}
+func (c CreateEnum) isStatement()              {}
+func (a AddEnumValue) isStatement()            {}
+func (c CreateIndex) isStatement()             {}
+func (d DropIndex) isStatement()               {}
 
 type Literal interface {
 	isLiteral()
@@ -137,16 +191,21 @@ type Expression interface {
 	isExpression()
 }
 
-func (p ParameterExpression) isExpression() {}
-func (f FunctionExpression) isExpression()  {}
-func (b BinaryExpression) isExpression()    {}
-func (b UnaryExpression) isExpression()     {}
-func (s StringLiteral) isExpression()       {}
-func (n NumberLiteral) isExpression()       {}
-func (c ColumnExpression) isExpression()    {}
+func (p ParameterExpression) isExpression()  {}
+func (f FunctionExpression) isExpression()   {}
+func (b BinaryExpression) isExpression()     {}
+func (b UnaryExpression) isExpression()      {}
+func (s StringLiteral) isExpression()        {}
+func (n NumberLiteral) isExpression()        {}
+func (c ColumnExpression) isExpression()     {}
+func (s SubscriptExpression) isExpression()  {}
+func (a ArrayExpression) isExpression()      {}
+func (q QuantifiedExpression) isExpression() {}
+func (c CastExpression) isExpression()       {}
 
 type ParameterExpression struct {
-	Name string
+	Name     string
+	Optional bool
 }
 
 type StringLiteral struct {
@@ -160,6 +219,11 @@ type NumberLiteral struct {
 type ColumnExpression struct {
 	ColumnName string
 	TableName  string
+
+	// The names as they were written, for error messages. Unquoted names are
+	// folded to lower case in ColumnName and TableName.
+	WrittenColumnName string
+	WrittenTableName  string
 }
 
 func (c ColumnExpression) String() string {
@@ -169,11 +233,44 @@ func (c ColumnExpression) String() string {
 	return c.TableName + "." + c.ColumnName
 }
 
+// Returns a name as it was written in the SQL, or the name itself when that
+// isn't known because the statement wasn't parsed.
+func asWritten(written string, name string) string {
+	if written == "" {
+		return name
+	}
+	return written
+}
+
 type FunctionExpression struct {
 	FuncName   string
 	Parameters []Expression
 }
 
+// An element of an array like "labels[1]".
+type SubscriptExpression struct {
+	Array Expression
+	Index Expression
+}
+
+// A conversion to another type like "$since::timestamptz".
+type CastExpression struct {
+	Expr Expression
+	Type ColumnDefinition
+}
+
+// An array constructor like "ARRAY[1, 2, 3]".
+type ArrayExpression struct {
+	Elements []Expression
+}
+
+// The right side of a comparison to every element of an array like
+// "id = ANY($ids)". It is only valid in a BinaryCondition.
+type QuantifiedExpression struct {
+	Quantifier quantifierType
+	Array      Expression
+}
+
 type BinaryExpression struct {
 	Left  Expression
 	Right Expression
@@ -194,6 +291,9 @@ type TargetTable struct {
 	Alias     string
 	TableName string
 	Subselect *Select
+
+	// The table name as it was written, for error messages.
+	WrittenTableName string
 }
 
 type Condition interface {
@@ -243,6 +343,12 @@ type Insert struct {
 	Target  TargetTable
 	Columns []ColumnExpression
 	Values  []Expression
+
+	// Where the table name and each column and value start, for pointing
+	// error messages at the right place.
+	TargetLocation  charLocation
+	ColumnLocations []charLocation
+	ValueLocations  []charLocation
 }
 
 type Select struct {
@@ -266,30 +372,133 @@ type CreateConstraint struct {
 	Name    string
 	Type    constraintType
 	Columns []string
+
+	// Only set for foreign keys.
+	References *ForeignKey
+
+	// Only set for CHECK constraints.
+	Check Condition
+}
+
+// The parent side of a foreign key like "REFERENCES users (id) ON DELETE
+// CASCADE". Columns is empty when it refers to the primary key implicitly.
+type ForeignKey struct {
+	TableName string
+	Columns   []string
+	OnDelete  referentialAction
+	OnUpdate  referentialAction
 }
 
 type ColumnDefinition struct {
-	Name     string
-	Type     dataType
-	Param1   int
-	Param2   int
+	Name string
+	Type dataType
+
+	// The type's modifiers, eg the length of VARCHAR(200) or the precision and
+	// scale of NUMERIC(10, 2). Zero when there aren't any.
+	Param1 int
+	Param2 int
+
+	// The number of dimensions when the column is an array, eg 1 for TEXT[].
+	// Zero when it isn't one.
+	ArrayDims int
+
+	// The name of the enum for columns with a type from CREATE TYPE, and the
+	// values that it has.
+	TypeName string
 	Values   []string
+
 	Nullable bool
-	Default  string
+
+	// The value used when an INSERT leaves the column out. Nil when there is
+	// no DEFAULT clause.
+	Default Expression
+
+	// The table column that a query's result column or parameter comes from.
+	// Empty for columns that are computed by the query.
+	Source ColumnSource
+}
+
+// Returns true if the column gets a value when an INSERT leaves it out, either
+// from a DEFAULT clause or because it is a serial.
+func (c ColumnDefinition) HasDefault() bool {
+	switch c.Type {
+	case DataTypeSmallSerial, DataTypeSerial, DataTypeBigSerial:
+		return true
+	}
+	return c.Default != nil
+}
+
+type ColumnSource struct {
+	TableName  string
+	ColumnName string
 }
 
 type AddColumn struct {
-	TableName string
-	Column    ColumnDefinition
+	TableName   string
+	Column      ColumnDefinition
+	Constraints []CreateConstraint
+	IfNotExists bool
 }
 
 type DropColumn struct {
 	TableName  string
 	ColumnName string
+	IfExists   bool
+	Cascade    bool
+
+	// The names as they were written, for error messages.
+	WrittenTableName  string
+	WrittenColumnName string
 }
 
-type DropTable struct {
+// ALTER COLUMN ... TYPE. Only the type fields of NewType are set.
+type AlterColumnType struct {
+	TableName  string
+	ColumnName string
+	NewType    ColumnDefinition
+	Using      Expression
+
+	WrittenTableName  string
+	WrittenColumnName string
+}
+
+// ALTER COLUMN ... SET NOT NULL or DROP NOT NULL.
+type AlterColumnNullable struct {
+	TableName  string
+	ColumnName string
+	Nullable   bool
+
+	WrittenTableName  string
+	WrittenColumnName string
+}
+
+// ALTER COLUMN ... SET DEFAULT or DROP DEFAULT. Default is nil when it is
+// dropped.
+type AlterColumnDefault struct {
+	TableName  string
+	ColumnName string
+	Default    Expression
+
+	WrittenTableName  string
+	WrittenColumnName string
+}
+
+type AddConstraint struct {
+	TableName  string
+	Constraint CreateConstraint
+}
+
+type DropConstraint struct {
 	TableName string
+	Name      string
+	IfExists  bool
+	Cascade   bool
+}
+
+type DropTable struct {
+	TableNames []string
+	IfExists   bool
+	Cascade    bool
 }
 
 type RenameTable struct {
@@ -301,4 +510,84 @@ type RenameColumn struct {
 	TableName string
 	From      string
 	To        string
+
+	// The names as they were written, for error messages.
+	WrittenTableName string
+	WrittenFrom      string
+	WrittenTo        string
+}
+
+type CreateEnum struct {
+	Name   string
+	Values []string
+}
+
+// Adds a value to an enum. The new value goes at the end unless Before or
+// After is set.
+type AddEnumValue struct {
+	TypeName    string
+	Value       string
+	IfNotExists bool
+	Before      string
+	After       string
+}
+
+// CREATE INDEX. Name is empty when the migration leaves it for Postgres to
+// choose. Where is nil unless it is a partial index.
+type CreateIndex struct {
+	Name         string
+	TableName    string
+	Columns      []string
+	Unique       bool
+	Concurrently bool
+	IfNotExists  bool
+	Where        Condition
+
+	// True if any of the indexed items is an expression like lower(email)
+	// rather than a plain column. Those are left out of Columns.
+	HasExpressions bool
+}
+
+type DropIndex struct {
+	Names        []string
+	Concurrently bool
+	IfExists     bool
+	Cascade      bool
+}
+
+type CreateView struct {
+	Name         string
+	Query        Select
+	OrReplace    bool
+	Materialized bool
+	IfNotExists  bool
+
+	// Names for the view's columns from "CREATE VIEW name (a, b) AS ...".
+	// Empty when the names come from the query.
+	ColumnNames []string
+}
+
+type DropView struct {
+	Names        []string
+	Materialized bool
+	IfExists     bool
+	Cascade      bool
+}
+
+type CreateSchema struct {
+	Name        string
+	IfNotExists bool
+}
+
+// SET name TO value, eg: "SET search_path TO audit, public". Values is empty
+// for "SET name TO DEFAULT".
+type SetVariable struct {
+	Name   string
+	Values []string
+	Local  bool
+}
+
+type RefreshMaterializedView struct {
+	Name         string
+	Concurrently bool
 }
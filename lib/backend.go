@@ -0,0 +1,598 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Enum for the database drivers that generated code can run on.
+//   BackendDatabaseSQL: database/sql with github.com/lib/pq
+//   BackendPgx: github.com/jackc/pgx/v4 used directly
+type backendType int
+
+const (
+	BackendDatabaseSQL backendType = iota
+	BackendPgx
+)
+
+// Everything that differs between the drivers generated code can target. The
+// template of a backend must define "client", which declares the type that
+// implements DBClient along with its constructors, "query", which runs a
+// single statement and assigns the result to rows and err, "exec", which runs
+// query with args in the transaction that "begin" started and assigns err,
+// "execRows", the body of a function that runs a single statement and
+// returns the number of rows it affected, or nothing when the statement is
+// annotated :exec,
+// "copyFrom", the body of a CopyFrom method, and "begin", "rollback" and
+// "commit", which run the statements of a batch with more than one in a
+// transaction that the client copy of the batch uses.
+type codeGenBackend interface {
+	template() string
+	clientType() string
+	imports(options GenerateOptions) []string
+
+	// Returns the Go type to use for a column when the driver has a better
+	// representation than the default one, otherwise false. The type must be
+	// able to hold NULL since it isn't made a pointer for nullable columns.
+	goType(def ColumnDefinition) (string, bool)
+
+	// Returns the Go type to use for an array column, or an error when the
+	// driver can't scan that kind of array.
+	arrayGoType(def ColumnDefinition) (string, error)
+
+	// Returns what to pass to the driver for a query argument or Scan
+	// destination of an array type, and adds any imports that needs.
+	arrayArg(expr string, imports importSet) string
+
+	// Whether COPY can send values of the column's Go type to the column, and
+	// the imports that "copyFrom" needs when it does.
+	canCopy(def ColumnDefinition) bool
+	copyImports() []string
+
+	validate(options GenerateOptions) error
+}
+
+func getBackend(typ backendType) (codeGenBackend, error) {
+	switch typ {
+	case BackendDatabaseSQL:
+		return databaseSQLBackend{}, nil
+	case BackendPgx:
+		return pgxBackend{}, nil
+	default:
+		return nil, errors.New("Unknown backend")
+	}
+}
+
+/******************************************************************************
+ * database/sql
+ *****************************************************************************/
+
+var databaseSQLTemplate = `{{define "client" -}}
+type SQLDBClient struct {
+	db *sql.DB
+	{{- if .Prepared}}
+	stmts *sqlStatements
+	{{- end}}
+	{{- if .Transactions}}
+
+	// The transaction that a batch of several statements runs them in
+	tx *sql.Tx
+	{{- end}}
+}
+
+func NewDBClient(connectionString string) (DBClient, error) {
+	db, err := sql.Open("postgres", connectionString)
+	if err != nil {
+		return SQLDBClient{}, err
+	}
+
+	return SQLDBClient{
+		db: db,
+		{{- if .Prepared}}
+		stmts: &sqlStatements{},
+		{{- end}}
+	}, nil
+}
+
+func (client SQLDBClient) Close() {
+	{{- if .Prepared}}
+	client.closeStatements()
+	{{- end}}
+	client.db.Close()
+}
+{{if .Prepared}}
+// Holds a prepared statement for every query once Prepare has been called.
+// Batches with more than one statement can't be prepared as one, so each of
+// their statements gets its own.
+type sqlStatements struct {
+	{{range .Batches}}{{range .Queries -}}
+	{{.StmtName}} *sql.Stmt
+	{{end}}{{end}}
+}
+
+// Prepare creates a prepared statement for every query so that Postgres only
+// parses each one once. Call it once before using the client. Queries run
+// without preparing if it is never called.
+func (client SQLDBClient) Prepare(ctx context.Context) error {
+	var err error
+	{{range .Batches}}{{range .Queries}}
+	client.stmts.{{.StmtName}}, err = client.db.PrepareContext(ctx, {{.SQLName}})
+	if err != nil {
+		client.closeStatements()
+		return err
+	}
+	{{end}}{{end}}
+	return nil
+}
+
+func (client SQLDBClient) closeStatements() {
+	{{range .Batches}}{{range .Queries -}}
+	if client.stmts.{{.StmtName}} != nil {
+		client.stmts.{{.StmtName}}.Close()
+		client.stmts.{{.StmtName}} = nil
+	}
+	{{end}}{{end}}
+}
+
+func (client SQLDBClient) query(
+	ctx context.Context,
+	stmt *sql.Stmt,
+	query string,
+	args ...interface{},
+) (*sql.Rows, error) {
+	{{- if .Transactions}}
+	if client.tx != nil {
+		if stmt != nil {
+			return client.tx.StmtContext(ctx, stmt).QueryContext(ctx, args...)
+		}
+		return client.tx.QueryContext(ctx, query, args...)
+	}
+	{{- end}}
+	if stmt != nil {
+		return stmt.QueryContext(ctx, args...)
+	}
+	return client.db.QueryContext(ctx, query, args...)
+}
+{{- if .ExecRows}}
+
+func (client SQLDBClient) exec(
+	ctx context.Context,
+	stmt *sql.Stmt,
+	query string,
+	args ...interface{},
+) (sql.Result, error) {
+	{{- if .Transactions}}
+	if client.tx != nil {
+		if stmt != nil {
+			return client.tx.StmtContext(ctx, stmt).ExecContext(ctx, args...)
+		}
+		return client.tx.ExecContext(ctx, query, args...)
+	}
+	{{- end}}
+	if stmt != nil {
+		return stmt.ExecContext(ctx, args...)
+	}
+	return client.db.ExecContext(ctx, query, args...)
+}
+{{- end}}
+{{end}}
+{{- end}}
+
+{{- define "query" -}}
+{{if .Prepared -}}
+rows, err := client.query(ctx, client.stmts.{{.StmtName}}, {{.SQLName}}{{range .Parameters}}, {{.Arg}}{{end}})
+{{- else if .InTx -}}
+rows, err := client.tx.QueryContext(ctx, {{.SQLName}}{{range .Parameters}}, {{.Arg}}{{end}})
+{{- else -}}
+rows, err := client.db.QueryContext(ctx, {{.SQLName}}{{range .Parameters}}, {{.Arg}}{{end}})
+{{- end}}
+{{- end}}
+
+{{- define "execRows" -}}
+{{if .Exec}}_, err = {{else}}res, err := {{end}}
+{{- if .Prepared -}}
+client.exec(ctx, client.stmts.{{.StmtName}}, {{.SQLName}}{{range .Parameters}}, {{.Arg}}{{end}})
+{{- else if .InTx -}}
+client.tx.ExecContext(ctx, {{.SQLName}}{{range .Parameters}}, {{.Arg}}{{end}})
+{{- else -}}
+client.db.ExecContext(ctx, {{.SQLName}}{{range .Parameters}}, {{.Arg}}{{end}})
+{{- end}}
+{{- if .Exec}}
+return
+{{- else}}
+if err != nil {
+	return
+}
+return res.RowsAffected()
+{{- end}}
+{{- end}}
+
+{{- define "begin" -}}
+tx, err := client.db.BeginTx(ctx, nil)
+if err != nil {
+	return
+}
+client.tx = tx
+{{- end}}
+
+{{- define "rollback" -}}
+tx.Rollback()
+{{- end}}
+
+{{- define "commit" -}}
+err = tx.Commit()
+{{- end}}
+
+{{- define "exec" -}}
+_, err = client.tx.ExecContext(ctx, query, args...)
+{{- end}}
+
+{{- define "copyFrom" -}}
+{{if .Copy.COPY -}}
+tx, err := client.db.BeginTx(ctx, nil)
+if err != nil {
+	return
+}
+
+stmt, err := tx.PrepareContext(ctx, pq.CopyIn
+	{{- if .Copy.Schema}}Schema({{printf "%q" .Copy.Schema}}, {{else}}({{end}}
+	{{- printf "%q" .Copy.Table}}{{range .Copy.Columns}}, {{printf "%q" .}}{{end}}))
+if err != nil {
+	tx.Rollback()
+	return
+}
+
+for _, row := range rows {
+	_, err = stmt.ExecContext(ctx{{range .Copy.Args}}, {{.}}{{end}})
+	if err != nil {
+		stmt.Close()
+		tx.Rollback()
+		return
+	}
+}
+
+// Executing without arguments sends the rows that were buffered
+_, err = stmt.ExecContext(ctx)
+if err != nil {
+	stmt.Close()
+	tx.Rollback()
+	return
+}
+
+err = stmt.Close()
+if err != nil {
+	tx.Rollback()
+	return
+}
+err = tx.Commit()
+return
+{{- else -}}
+{{template "insertValues" .}}
+{{- end}}
+{{- end}}
+`
+
+type databaseSQLBackend struct{}
+
+func (b databaseSQLBackend) template() string {
+	return databaseSQLTemplate
+}
+
+func (b databaseSQLBackend) clientType() string {
+	return "SQLDBClient"
+}
+
+func (b databaseSQLBackend) imports(options GenerateOptions) []string {
+	return []string{"database/sql", "_ github.com/lib/pq"}
+}
+
+func (b databaseSQLBackend) goType(def ColumnDefinition) (string, bool) {
+	return "", false
+}
+
+// pq.Array only knows how to scan one dimensional arrays of bool, float64,
+// int64, string and types that implement sql.Scanner, so arrays of anything
+// else are read as text.
+func (b databaseSQLBackend) arrayGoType(def ColumnDefinition) (string, error) {
+	if def.ArrayDims > 1 {
+		return "", errors.New("lib/pq does not support multidimensional arrays")
+	}
+
+	switch def.Type {
+	case DataTypeSmallInt, DataTypeInteger, DataTypeBigInt,
+		DataTypeSmallSerial, DataTypeSerial, DataTypeBigSerial:
+		return "[]int64", nil
+	case DataTypeReal, DataTypeDoublePrecision:
+		return "[]float64", nil
+	case DataTypeBoolean:
+		return "[]bool", nil
+	case DataTypeEnum:
+		// Generated enum types implement sql.Scanner, which pq.Array can use
+		return "[]" + enumGoType(def.TypeName), nil
+	default:
+		return "[]string", nil
+	}
+}
+
+func (b databaseSQLBackend) arrayArg(expr string, imports importSet) string {
+	imports.add("github.com/lib/pq")
+	return "pq.Array(" + expr + ")"
+}
+
+// lib/pq sends []byte as bytea, so JSON that is held in a json.RawMessage
+// can't be copied.
+func (b databaseSQLBackend) canCopy(def ColumnDefinition) bool {
+	if def.ArrayDims > 0 {
+		return true
+	}
+	return def.Type != DataTypeJSON && def.Type != DataTypeBinaryJSON
+}
+
+func (b databaseSQLBackend) copyImports() []string {
+	return []string{"github.com/lib/pq"}
+}
+
+func (b databaseSQLBackend) validate(options GenerateOptions) error {
+	return nil
+}
+
+/******************************************************************************
+ * pgx
+ *****************************************************************************/
+
+var pgxTemplate = `{{define "client" -}}
+// PgxDB is the part of *pgx.Conn, *pgxpool.Pool and pgx.Tx that PgxDBClient
+// needs, so any of them can run the generated queries.
+type PgxDB interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	{{- if .Transactions}}
+	Begin(ctx context.Context) (pgx.Tx, error)
+	{{- end}}
+}
+
+var (
+	_ PgxDB = (*pgx.Conn)(nil)
+	_ PgxDB = (*pgxpool.Pool)(nil)
+	_ PgxDB = pgx.Tx(nil)
+)
+
+type PgxDBClient struct {
+	db    PgxDB
+	close func()
+}
+
+// NewDBClient connects a new pgxpool.Pool that is closed along with the client.
+func NewDBClient(ctx context.Context, connectionString string) (DBClient, error) {
+	pool, err := pgxpool.Connect(ctx, connectionString)
+	if err != nil {
+		return PgxDBClient{}, err
+	}
+
+	return PgxDBClient{
+		db:    pool,
+		close: pool.Close,
+	}, nil
+}
+
+// NewDBClientFromPgx runs queries on an existing connection, pool or
+// transaction. Closing the client does not close db.
+func NewDBClientFromPgx(db PgxDB) DBClient {
+	return PgxDBClient{
+		db:    db,
+		close: func() {},
+	}
+}
+
+func (client PgxDBClient) Close() {
+	client.close()
+}
+{{- if .InsertValues}}
+
+// pgxCopier is the part of *pgx.Conn, *pgxpool.Pool and pgx.Tx that the
+// CopyFrom methods use. They insert without COPY when the PgxDB is something
+// else.
+type pgxCopier interface {
+	CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error)
+}
+
+var (
+	_ pgxCopier = (*pgx.Conn)(nil)
+	_ pgxCopier = (*pgxpool.Pool)(nil)
+	_ pgxCopier = pgx.Tx(nil)
+)
+{{- end}}
+{{- end}}
+
+{{- define "begin" -}}
+tx, err := client.db.Begin(ctx)
+if err != nil {
+	return
+}
+client.db = tx
+{{- end}}
+
+{{- define "rollback" -}}
+tx.Rollback(ctx)
+{{- end}}
+
+{{- define "commit" -}}
+err = tx.Commit(ctx)
+{{- end}}
+
+{{- define "exec" -}}
+var inserted pgx.Rows
+inserted, err = client.db.Query(ctx, query, args...)
+if err == nil {
+	inserted.Close()
+	err = inserted.Err()
+}
+{{- end}}
+
+{{- define "copyFrom" -}}
+{{if .Copy.COPY -}}
+copier, ok := client.db.(pgxCopier)
+if ok {
+	_, err = copier.CopyFrom(
+		ctx,
+		pgx.Identifier{ {{- if .Copy.Schema}}{{printf "%q" .Copy.Schema}}, {{end}}{{printf "%q" .Copy.Table -}} },
+		[]string{ {{- range $i, $column := .Copy.Columns}}{{if $i}}, {{end}}{{printf "%q" $column}}{{end -}} },
+		pgx.CopyFromSlice(len(rows), func(i int) ([]interface{}, error) {
+			row := rows[i]
+			return []interface{}{ {{- range $i, $arg := .Copy.Args}}{{if $i}}, {{end}}{{$arg}}{{end -}} }, nil
+		}),
+	)
+	return
+}
+
+{{template "insertValues" .}}
+{{- else -}}
+{{template "insertValues" .}}
+{{- end}}
+{{- end}}
+
+{{- define "query" -}}
+rows, err := client.db.Query(ctx, {{.SQLName}}{{range .Parameters}}, {{.Arg}}{{end}})
+{{- end}}
+
+{{- define "execRows" -}}
+{{template "query" .}}
+if err != nil {
+	return
+}
+// The command tag is only complete once the rows are closed
+rows.Close()
+err = rows.Err()
+{{- if not .Exec}}
+if err != nil {
+	return
+}
+result = rows.CommandTag().RowsAffected()
+{{- end}}
+return
+{{- end}}
+`
+
+type pgxBackend struct{}
+
+func (b pgxBackend) template() string {
+	return pgxTemplate
+}
+
+func (b pgxBackend) clientType() string {
+	return "PgxDBClient"
+}
+
+func (b pgxBackend) imports(options GenerateOptions) []string {
+	return []string{"github.com/jackc/pgx/v4", "github.com/jackc/pgx/v4/pgxpool"}
+}
+
+func (b pgxBackend) goType(def ColumnDefinition) (string, bool) {
+	switch def.Type {
+	case DataTypeUUID:
+		return "pgtype.UUID", true
+	case DataTypeJSON:
+		return "pgtype.JSON", true
+	case DataTypeBinaryJSON:
+		return "pgtype.JSONB", true
+	case DataTypeTimestamp:
+		return "pgtype.Timestamp", true
+	case DataTypeTimestampWithTimeZone:
+		return "pgtype.Timestamptz", true
+	case DataTypeDate:
+		return "pgtype.Date", true
+	case DataTypeTime:
+		return "pgtype.Time", true
+	case DataTypeTimeWithTimeZone:
+		// pgtype has no timetz so it can only be read as text
+		return "pgtype.Text", true
+	case DataTypeInterval:
+		return "pgtype.Interval", true
+	case DataTypeDecimal, DataTypeNumeric:
+		return "pgtype.Numeric", true
+	case DataTypeCidr, DataTypeInet:
+		return "pgtype.Inet", true
+	case DataTypeMacAddr:
+		return "pgtype.Macaddr", true
+	case DataTypeVarBit, DataTypeBit:
+		return "pgtype.Varbit", true
+	case DataTypePoint:
+		return "pgtype.Point", true
+	case DataTypeLine:
+		return "pgtype.Line", true
+	case DataTypeLineSegment:
+		return "pgtype.Lseg", true
+	case DataTypeBox:
+		return "pgtype.Box", true
+	case DataTypePath:
+		return "pgtype.Path", true
+	case DataTypePolygon:
+		return "pgtype.Polygon", true
+	case DataTypeCircle:
+		return "pgtype.Circle", true
+	default:
+		// Types without a pgtype equivalent are sent as text, which pgx can
+		// scan into the default string type.
+		return "", false
+	}
+}
+
+// pgx scans arrays straight into slices of the types that it supports as array
+// elements, and into pgtype's array types for the ones it doesn't.
+func (b pgxBackend) arrayGoType(def ColumnDefinition) (string, error) {
+	switch def.Type {
+	case DataTypeDecimal, DataTypeNumeric:
+		return "pgtype.NumericArray", nil
+	case DataTypeInet:
+		return "pgtype.InetArray", nil
+	case DataTypeCidr:
+		return "pgtype.CIDRArray", nil
+	case DataTypeMacAddr:
+		return "pgtype.MacaddrArray", nil
+	case DataTypeSmallInt, DataTypeInteger, DataTypeBigInt,
+		DataTypeSmallSerial, DataTypeSerial, DataTypeBigSerial,
+		DataTypeReal, DataTypeDoublePrecision, DataTypeBoolean,
+		DataTypeChar, DataTypeVarChar, DataTypeText, DataTypeBytea, DataTypeUUID,
+		DataTypeTimestamp, DataTypeTimestampWithTimeZone, DataTypeDate,
+		DataTypeJSON, DataTypeBinaryJSON:
+		typ, err := baseGoType(def.Type)
+		if err != nil {
+			return "", err
+		}
+		return strings.Repeat("[]", def.ArrayDims) + typ, nil
+	default:
+		return "", fmt.Errorf("pgx does not support arrays of type %v", def.Type)
+	}
+}
+
+func (b pgxBackend) arrayArg(expr string, imports importSet) string {
+	return expr
+}
+
+// pgx copies in the binary format, which needs the Go value to match the
+// column's type. Enums and the types that are held as text don't.
+func (b pgxBackend) canCopy(def ColumnDefinition) bool {
+	if def.TypeName != "" {
+		return false
+	}
+	switch def.Type {
+	case DataTypeMoney, DataTypeTimeWithTimeZone, DataTypeTextSearchVector,
+		DataTypeTextSearchQuery, DataTypeXML:
+		return false
+	default:
+		return true
+	}
+}
+
+func (b pgxBackend) copyImports() []string {
+	return nil
+}
+
+func (b pgxBackend) validate(options GenerateOptions) error {
+	if options.Prepared {
+		return errors.New(
+			"The pgx backend does not support Prepared because pgx already " +
+				"prepares and caches every statement it runs")
+	}
+	return nil
+}
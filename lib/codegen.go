@@ -4,123 +4,524 @@ import (
 	"bytes"
 	"fmt"
 	"go/format"
+	gotoken "go/token"
 	"io"
 	"io/ioutil"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"text/template"
 )
 
-var templateString = `// This code was generated by a tool (>^_^)>
+var templateString = `{{define "params" -}}
+ctx context.Context{{range .Parameters}}, {{.Name}} {{.Type}}{{end}}
+{{- end}}
+
+{{- define "args" -}}
+ctx{{range .Parameters}}, {{.Name}}{{end}}
+{{- end}}
+
+{{- define "batchParams" -}}
+{{if .ParamsArg}}ctx context.Context, params {{.ParamsStruct}}{{else}}{{template "params" .}}{{end}}
+{{- end}}
+
+{{- define "batchArgs" -}}
+{{if .ParamsArg}}ctx, params{{else}}{{template "args" .}}{{end}}
+{{- end}}
+
+{{- define "results" -}}
+{{range .Queries}}{{if not .Exec}}r{{.Index}} {{template "resultType" .}}, {{end}}{{end}}err error
+{{- end -}}
+
+{{- define "resultType" -}}
+{{if .RowsAffected}}int64{{else if .One}}*{{.Result.Name}}{{else}}[]{{.Result.Name}}{{end}}
+{{- end -}}
+
+{{- define "call" -}}
+{{if .Exec}}{{.StmtName}}Exec{{else if .RowsAffected}}{{.StmtName}}RowsAffected{{else if .One}}queryOne{{.Result.Name}}{{else}}query{{.Result.Name}}{{end}}
+{{- end -}}
+
+{{- define "assignResult" -}}
+{{if not .Exec}}r{{.Index}}, {{end}}err
+{{- end -}}
+
+{{- define "insertValues" -}}
+// The rows go in one transaction so that a failure doesn't leave the chunks
+// before it behind
+{{template "begin" .}}
+
+for start := 0; start < len(rows); start += {{.Copy.RowsPerInsert}} {
+	end := start + {{.Copy.RowsPerInsert}}
+	if end > len(rows) {
+		end = len(rows)
+	}
+
+	args := make([]interface{}, 0, (end-start)*{{len .Copy.Args}})
+	for _, row := range rows[start:end] {
+		args = append(args{{range .Copy.Args}}, {{.}}{{end}})
+	}
+
+	query := insertValuesSQL({{.Copy.InsertSQL}}, {{len .Copy.Args}}, end-start)
+	{{template "exec" .}}
+	if err != nil {
+		{{template "rollback" .}}
+		return
+	}
+}
+{{template "commit" .}}
+return
+{{- end -}}
+
+// This code was generated by a tool (>^_^)>
 
 package {{.Package}}
 
 import (
-	"database/sql"
-	"time"
-	"fmt"
-	"os"
-  _ "github.com/lib/pq"
+	{{range .Imports.Standard -}}
+	{{.}}
+	{{end}}
+	{{range .Imports.ThirdParty -}}
+	{{.}}
+	{{end}}
 )
 
 type DBClient interface {
 	{{range .Batches -}}
-	{{.FuncName}}() ({{range .Queries}}[]{{.Result.Name}}, {{end}}error)
+	{{.FuncName}}({{template "batchParams" .}}) ({{template "results" .}})
+	{{if .ForEach -}}
+	ForEach{{.FuncName}}({{template "batchParams" .}}, fn func({{.ForEach.Result.Name}}) error) (err error)
+	{{end -}}
+	{{if .Copy -}}
+	CopyFrom{{.FuncName}}(ctx context.Context, rows []{{.ParamsStruct}}) (err error)
+	{{end -}}
+	{{end -}}
+	{{if .Prepared -}}
+	Prepare(ctx context.Context) error
 	{{end -}}
 	Close()
 }
 
-type SQLDBClient struct {
-	db *sql.DB
-}
+{{template "client" .}}
 
-func NewDBClient(connectionString string) (DBClient, error) {
-	db, err := sql.Open("postgres", connectionString)
-	if err != nil {
-		return SQLDBClient{}, err
+{{range $enum := .Enums}}
+/******************************************************************************
+ * {{.SQLName}} enum
+ *****************************************************************************/
+
+type {{.Name}} string
+
+const (
+	{{range .Values -}}
+	{{.Name}} {{$enum.Name}} = {{.Value}}
+	{{end}}
+)
+
+// Valid returns true if e is one of the values that {{.SQLName}} had when this
+// code was generated.
+func (e {{.Name}}) Valid() bool {
+	{{- if .Values}}
+	switch e {
+	case {{range $i, $value := .Values}}{{if $i}}, {{end}}{{$value.Name}}{{end}}:
+		return true
 	}
+	{{- end}}
+	return false
+}
 
-	return SQLDBClient{
-		db: db,
-	}, nil
+// Scan implements sql.Scanner so that query results can be read into {{.Name}}.
+func (e *{{.Name}}) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case string:
+		*e = {{.Name}}(v)
+	case []byte:
+		*e = {{.Name}}(v)
+	default:
+		return fmt.Errorf("cannot scan %T into {{.Name}}", src)
+	}
+	return nil
 }
 
-func (client SQLDBClient) Close() {
-	client.db.Close()
+// Value implements driver.Valuer so that {{.Name}} can be passed to queries.
+func (e {{.Name}}) Value() (driver.Value, error) {
+	return string(e), nil
 }
+{{end}}
 
 {{range .Batches}}
 /******************************************************************************
  * {{.Name}}
  *****************************************************************************/
-
+{{if .ParamsStruct}}
+// {{.ParamsStruct}} holds the parameters of DBClient.{{.FuncName}}.
+type {{.ParamsStruct}} struct {
+	{{range .Parameters -}}
+	// {{.Doc}}
+	{{.FieldName}} {{.Type}}
+	{{end}}
+}
+{{end}}
 {{range .Queries}}
+const {{.SQLName}} = {{.SQL}}
+{{if .Exec}}
+// Runs {{.SQLName}}.
+func (client {{$.ClientType}}) {{.StmtName}}Exec({{template "params" .}}) (err error) {
+	{{template "execRows" .}}
+}
+{{else if .RowsAffected}}
+// Runs {{.SQLName}} and returns the number of rows it affected.
+func (client {{$.ClientType}}) {{.StmtName}}RowsAffected({{template "params" .}}) (result int64, err error) {
+	{{template "execRows" .}}
+}
+{{else}}
 type {{.Result.Name}} struct {
   {{range .Result.Columns -}}
 	{{.Name}} {{.Type}}
 	{{end}}
 }
-{{end}}
-
-func (client SQLDBClient) {{.FuncName}}({{range .Parameters}}{{if (gt .Index 1)}}, {{end}}{{.Name}} interface{{"{}"}}{{end}}) ({{range .Queries}}r{{.Index}} []{{.Result.Name}}, {{end}}err error) {
-	{{range .Queries -}}
-	r{{.Index}} = nil
-	{{end}}
 
-	sql := "{{.SQL}}"
-	rows, err := client.db.Query(sql{{range .Parameters}}, {{.Name}}{{end}})
+{{if .One}}
+// Returns the first row, or nil when there are none.
+func (client {{$.ClientType}}) queryOne{{.Result.Name}}({{template "params" .}}) (result *{{.Result.Name}}, err error) {
+	err = client.each{{.Result.Name}}({{template "args" .}}, func(row {{.Result.Name}}) error {
+		if result == nil {
+			result = &row
+		}
+		return nil
+	})
+	return
+}
+{{else}}
+func (client {{$.ClientType}}) query{{.Result.Name}}({{template "params" .}}) (result []{{.Result.Name}}, err error) {
+	err = client.each{{.Result.Name}}({{template "args" .}}, func(row {{.Result.Name}}) error {
+		result = append(result, row)
+		return nil
+	})
+	return
+}
+{{end}}
+// Calls fn with each row as it is scanned and stops at the first error.
+func (client {{$.ClientType}}) each{{.Result.Name}}({{template "params" .}}, fn func({{.Result.Name}}) error) (err error) {
+	{{template "query" .}}
 	if err != nil {
 		return
 	}
 	defer rows.Close()
 
-	{{range .Queries}}
-	{{if (gt .Index 1)}}
-	if !rows.NextResultSet() {
-		err = fmt.Errorf("Expecting more result sets: %v", rows.Err())
-		return
-	}
-	{{end}}
-
 	for rows.Next() {
 		var (
 			{{range .Result.Columns -}}
 			{{.NameLower}} {{.Type}}
 			{{end}}
 		)
-		err = rows.Scan({{range .Result.Columns}}{{if (gt .Index 1)}}, {{end}}&{{.NameLower}}{{end}})
+		err = rows.Scan({{range .Result.Columns}}{{if (gt .Index 1)}}, {{end}}{{.ScanArg}}{{end}})
 		if err != nil {
 			return
 		}
 
-		r{{.Index}} = append(r{{.Index}}, {{.Result.Name}}{
+		err = fn({{.Result.Name}}{
 			{{range .Result.Columns -}}
 			{{.Name}}: {{.NameLower}},
 			{{end}}
 		})
+		if err != nil {
+			return
+		}
 	}
-	{{- end}}
 
+	err = rows.Err()
+	return
+}
+{{end}}
+{{- end}}
+
+func (client {{$.ClientType}}) {{.FuncName}}({{template "batchParams" .}}) ({{template "results" .}}) {
+	{{- if .ParamsArg}}
+	{{range .Parameters}}{{.Name}} := params.{{.FieldName}}
+	{{end}}
+	{{- end}}
+	{{- if gt (len .Queries) 1}}
+	// The statements run in one transaction, like they would if Postgres got
+	// them all at once
+	{{template "begin" .}}
+	{{- range .Queries}}
+	{{template "assignResult" .}} = client.{{template "call" .}}({{template "args" .}})
+	if err != nil {
+		{{template "rollback" .}}
+		return
+	}
+	{{- end}}
+	{{template "commit" .}}
+	{{- else}}
+	{{- range .Queries}}
+	{{template "assignResult" .}} = client.{{template "call" .}}({{template "args" .}})
+	if err != nil {
+		return
+	}
+	{{- end}}
+	{{- end}}
 	return
 }
+{{if .ForEach}}
+// ForEach{{.FuncName}} calls fn with each row that {{.FuncName}} would return as it
+// is read, without holding them all in memory. It stops at the first error
+// from fn and returns it.
+func (client {{$.ClientType}}) ForEach{{.FuncName}}({{template "batchParams" .}}, fn func({{.ForEach.Result.Name}}) error) (err error) {
+	{{- if .ParamsArg}}
+	{{range .Parameters}}{{.Name}} := params.{{.FieldName}}
+	{{end}}
+	{{- end}}
+	return client.each{{.ForEach.Result.Name}}({{template "args" .ForEach}}, fn)
+}
+{{end}}
+{{- if .Copy}}
+// CopyFrom{{.FuncName}} inserts every row like {{.FuncName}} does, but in bulk.
+func (client {{$.ClientType}}) CopyFrom{{.FuncName}}(ctx context.Context, rows []{{.ParamsStruct}}) (err error) {
+	{{template "copyFrom" .}}
+}
+{{end}}
+{{end}}
+{{- if .InsertValues}}
+// insertValuesSQL appends a row of positional parameters for each of rows to
+// an INSERT that ends with VALUES.
+func insertValuesSQL(insert string, columns int, rows int) string {
+	var sql strings.Builder
+	sql.WriteString(insert)
+	for i := 0; i < rows; i++ {
+		if i > 0 {
+			sql.WriteString(", ")
+		}
+		sql.WriteString("(")
+		for j := 0; j < columns; j++ {
+			if j > 0 {
+				sql.WriteString(", ")
+			}
+			sql.WriteString("$" + strconv.Itoa(i*columns+j+1))
+		}
+		sql.WriteString(")")
+	}
+	return sql.String()
+}
+{{end}}
+
+{{- if .Mock}}
+/******************************************************************************
+ * Mock
+ *****************************************************************************/
+
+var _ DBClient = &MockDBClient{}
+
+// MockDBClient is an in-memory DBClient for unit tests. Set the Func field of
+// each method the code under test calls. Every call is recorded in the
+// matching Calls field whether or not its Func is set, and calling a method
+// without a Func returns an error.
+type MockDBClient struct {
+	{{range .Batches -}}
+	{{.FuncName}}Func func({{template "batchParams" .}}) ({{template "results" .}})
+	{{.FuncName}}Calls []{{.FuncName}}Call
+	{{if .ForEach -}}
+	ForEach{{.FuncName}}Func func({{template "batchParams" .}}, fn func({{.ForEach.Result.Name}}) error) (err error)
+	ForEach{{.FuncName}}Calls []{{.FuncName}}Call
+	{{end -}}
+	{{if .Copy -}}
+	CopyFrom{{.FuncName}}Func func(ctx context.Context, rows []{{.ParamsStruct}}) (err error)
+	CopyFrom{{.FuncName}}Calls []CopyFrom{{.FuncName}}Call
+	{{end}}
+	{{- end}}
+	{{- if .Prepared}}
+	PrepareCalls int
+	{{- end}}
+	CloseCalls int
+
+	mu sync.Mutex
+}
+
+{{range .Batches}}
+{{- $batch := .}}
+// {{.FuncName}}Call holds the arguments of one call to MockDBClient.{{.FuncName}}.
+type {{.FuncName}}Call struct {
+	{{range .Parameters -}}
+	{{.FieldName}} {{.Type}}
+	{{end}}
+}
+
+func (mock *MockDBClient) {{.FuncName}}({{template "batchParams" .}}) ({{template "results" .}}) {
+	mock.mu.Lock()
+	mock.{{.FuncName}}Calls = append(mock.{{.FuncName}}Calls, {{.FuncName}}Call{
+		{{range .Parameters -}}
+		{{.FieldName}}: {{if $batch.ParamsArg}}params.{{.FieldName}}{{else}}{{.Name}}{{end}},
+		{{end}}
+	})
+	mock.mu.Unlock()
+
+	if mock.{{.FuncName}}Func == nil {
+		err = errors.New("MockDBClient.{{.FuncName}}Func is not configured")
+		return
+	}
+	return mock.{{.FuncName}}Func({{template "batchArgs" .}})
+}
+{{if .ForEach}}
+func (mock *MockDBClient) ForEach{{.FuncName}}({{template "batchParams" .}}, fn func({{.ForEach.Result.Name}}) error) (err error) {
+	mock.mu.Lock()
+	mock.ForEach{{.FuncName}}Calls = append(mock.ForEach{{.FuncName}}Calls, {{.FuncName}}Call{
+		{{range .Parameters -}}
+		{{.FieldName}}: {{if $batch.ParamsArg}}params.{{.FieldName}}{{else}}{{.Name}}{{end}},
+		{{end}}
+	})
+	mock.mu.Unlock()
+
+	if mock.ForEach{{.FuncName}}Func == nil {
+		err = errors.New("MockDBClient.ForEach{{.FuncName}}Func is not configured")
+		return
+	}
+	return mock.ForEach{{.FuncName}}Func({{template "batchArgs" .}}, fn)
+}
+{{end}}
+{{- if .Copy}}
+// CopyFrom{{.FuncName}}Call holds the arguments of one call to MockDBClient.CopyFrom{{.FuncName}}.
+type CopyFrom{{.FuncName}}Call struct {
+	Rows []{{.ParamsStruct}}
+}
+
+func (mock *MockDBClient) CopyFrom{{.FuncName}}(ctx context.Context, rows []{{.ParamsStruct}}) (err error) {
+	mock.mu.Lock()
+	mock.CopyFrom{{.FuncName}}Calls = append(mock.CopyFrom{{.FuncName}}Calls, CopyFrom{{.FuncName}}Call{
+		Rows: rows,
+	})
+	mock.mu.Unlock()
+
+	if mock.CopyFrom{{.FuncName}}Func == nil {
+		err = errors.New("MockDBClient.CopyFrom{{.FuncName}}Func is not configured")
+		return
+	}
+	return mock.CopyFrom{{.FuncName}}Func(ctx, rows)
+}
+{{end}}
+{{- end}}
+{{if .Prepared}}
+func (mock *MockDBClient) Prepare(ctx context.Context) error {
+	mock.mu.Lock()
+	mock.PrepareCalls++
+	mock.mu.Unlock()
+	return nil
+}
+{{end}}
+func (mock *MockDBClient) Close() {
+	mock.mu.Lock()
+	mock.CloseCalls++
+	mock.mu.Unlock()
+}
 {{end}}
 `
 
+// The names that the generated methods declare or use in the same scope as
+// the parameters of a query, which parameters therefore can't have. Results
+// are also named r1, r2 and so on.
+var generatedIdentifiers = map[string]bool{
+	"ctx":    true,
+	"params": true,
+	"fn":     true,
+	"err":    true,
+	"result": true,
+	"rows":   true,
+	"row":    true,
+	"res":    true,
+	"tx":     true,
+	"client": true,
+	"mock":   true,
+	"pq":     true,
+	"errors": true,
+	"append": true,
+	"error":  true,
+	"nil":    true,
+}
+
+var resultIdentifier = regexp.MustCompile(`^r\d+$`)
+
+// Returns true if a parameter with the name would clash with the generated
+// code or is a Go keyword.
+func isGeneratedIdentifier(name string) bool {
+	return generatedIdentifiers[name] ||
+		resultIdentifier.MatchString(name) ||
+		gotoken.Lookup(name).IsKeyword()
+}
+
 var numberSequence = regexp.MustCompile(`([a-zA-Z])(\d+)([a-zA-Z]?)`)
 var numberReplacement = []byte(`$1 $2 $3`)
 
 type codeGenViewModel struct {
-	Package string
-	Batches []batchViewModel
+	Package    string
+	Imports    importsViewModel
+	Enums      []enumViewModel
+	Batches    []batchViewModel
+	ClientType string
+	Mock       bool
+	Prepared   bool
+
+	// Set when some batch has a CopyFrom method that can fall back to
+	// insertValuesSQL.
+	InsertValues bool
+
+	// Set when some batch has more than one statement or InsertValues is set,
+	// since both run their statements in a transaction.
+	Transactions bool
+
+	// Set when some statement is annotated :exec or :execrows.
+	ExecRows bool
+}
+
+type importsViewModel struct {
+	Standard   []string
+	ThirdParty []string
+}
+
+type enumViewModel struct {
+	Name    string
+	SQLName string
+	Values  []enumValueViewModel
+}
+
+type enumValueViewModel struct {
+	Name  string
+	Value string
 }
 
 type batchViewModel struct {
 	Name       string
 	Queries    []queryViewModel
 	FuncName   string
-	SQL        string
 	Parameters []parameterViewModel
+
+	// The name of the struct that holds the batch's parameters, or empty when
+	// there isn't one. The batch takes it instead of separate arguments when
+	// ParamsArg is set, and CopyFrom takes a slice of it.
+	ParamsStruct string
+	ParamsArg    bool
+
+	// Nil unless the batch is an INSERT that can also be run in bulk.
+	Copy *copyViewModel
+
+	// The batch's only query when it can return many rows, which can then be
+	// streamed with ForEach as well. Nil otherwise.
+	ForEach *queryViewModel
+}
+
+// An INSERT whose values are all parameters, so that many rows of them can be
+// sent with COPY or, when COPY can't encode some column, with one INSERT of
+// many rows.
+type copyViewModel struct {
+	Schema  string
+	Table   string
+	Columns []string
+
+	// What to pass to the driver for each column, given a row variable.
+	Args []string
+
+	// Whether COPY can be used. The fallback INSERT is the quoted InsertSQL
+	// followed by RowsPerInsert rows of parameters at a time.
+	COPY          bool
+	InsertSQL     string
+	RowsPerInsert int
 }
 
 type resultTypeViewModel struct {
@@ -132,21 +533,117 @@ type columnViewModel struct {
 	Name      string
 	NameLower string
 	Type      string
+	ScanArg   string
 	Index     int
 }
 
 type queryViewModel struct {
-	Result resultTypeViewModel
-	Index  int
-	Type   queryResultType
+	Result     resultTypeViewModel
+	Index      int
+	Type       queryResultType
+	SQL        string
+	SQLName    string
+	StmtName   string
+	Prepared   bool
+	Parameters []parameterViewModel
+
+	// Whether the statement is one of several in its batch, so that it runs in
+	// the batch's transaction.
+	InTx bool
+
+	// Set by the kind of the query: :one returns the first row or nil rather
+	// than every row, :exec returns nothing and :execrows returns the number
+	// of rows affected.
+	One          bool
+	Exec         bool
+	RowsAffected bool
 }
 
 type parameterViewModel struct {
-	Name  string
-	Index int
+	Name      string
+	FieldName string
+	Type      string
+	Arg       string
+	Index     int
+	Doc       string
+}
+
+// Controls how Generate writes DBClient and what it writes alongside it.
+type GenerateOptions struct {
+	// The driver that the generated client runs queries with. Defaults to
+	// database/sql.
+	Backend backendType
+
+	// Also generate MockDBClient, an in-memory implementation of DBClient for
+	// unit tests that can't reach Postgres.
+	Mock bool
+
+	// Add a Prepare method to DBClient that prepares every query once so that
+	// Postgres doesn't have to parse it again on each call.
+	Prepared bool
+
+	// Go types to use instead of the defaults for result columns and
+	// parameters. A column override beats a data type override.
+	TypeOverrides []TypeOverride
+
+	// The schemas that unqualified names in queries are looked up in, in
+	// order. Defaults to just public. It should match the search_path of the
+	// connections that run the queries.
+	SearchPath []string
+
+	// Queries with more parameters than this take them in a struct, eg
+	// CreateIssueParams, instead of as separate arguments. Zero means there is
+	// no limit.
+	MaxParameters int
+}
+
+// Replaces the Go type generated for a column. eg:
+//   TypeOverride{DataType: DataTypeUUID, GoType: "uuid.UUID", NullableGoType: "uuid.NullUUID", Import: "github.com/google/uuid"}
+//   TypeOverride{Column: "issues.fields", GoType: "json.RawMessage", Import: "encoding/json"}
+type TypeOverride struct {
+	// A "table.column" to override. When empty the override applies to every
+	// column of DataType instead.
+	Column   string
+	DataType dataType
+
+	// The type as it is written in generated code and the package it needs, if
+	// any.
+	GoType string
+	Import string
+
+	// The type to use for nullable columns. When empty they get a pointer to
+	// GoType, except for slices which can already be nil.
+	NullableGoType string
 }
 
-func Generate(migrationDir string, queryDir string, dest string, pkg string) error {
+func (o TypeOverride) goType(def ColumnDefinition) string {
+	if def.ArrayDims > 0 {
+		return strings.Repeat("[]", def.ArrayDims) + o.GoType
+	}
+	if !def.Nullable || strings.HasPrefix(o.GoType, "[]") {
+		return o.GoType
+	}
+	if o.NullableGoType != "" {
+		return o.NullableGoType
+	}
+	return "*" + o.GoType
+}
+
+func (o TypeOverride) matches(def ColumnDefinition) bool {
+	if o.Column == "" {
+		return o.DataType == def.Type
+	}
+	return def.Source.TableName != "" &&
+		o.Column == def.Source.TableName+"."+def.Source.ColumnName
+}
+
+func Generate(
+	migrationDir string,
+	queryDir string,
+	dest string,
+	pkg string,
+	options GenerateOptions,
+) error {
 	migrations, err := ReadMigrationsDir(migrationDir)
 	if err != nil {
 		return err
@@ -156,6 +653,7 @@ func Generate(migrationDir string, queryDir string, dest string, pkg string) err
 	if err != nil {
 		return err
 	}
+	model.SearchPath = options.SearchPath
 
 	batches, err := ReadQueriesFromDir(queryDir, model)
 	if err != nil {
@@ -163,7 +661,7 @@ func Generate(migrationDir string, queryDir string, dest string, pkg string) err
 	}
 
 	buf := bytes.Buffer{}
-	err = writeCode(&buf, pkg, batches)
+	err = writeCode(&buf, pkg, model, batches, options)
 	if err != nil {
 		return err
 	}
@@ -182,8 +680,24 @@ func Generate(migrationDir string, queryDir string, dest string, pkg string) err
 	return nil
 }
 
-func writeCode(writer io.Writer, pkg string, batches []QueryBatch) error {
-	vm, err := newViewModel(pkg, batches)
+func writeCode(
+	writer io.Writer,
+	pkg string,
+	model Model,
+	batches []QueryBatch,
+	options GenerateOptions,
+) error {
+	backend, err := getBackend(options.Backend)
+	if err != nil {
+		return err
+	}
+
+	err = backend.validate(options)
+	if err != nil {
+		return err
+	}
+
+	vm, err := newViewModel(pkg, model, batches, options, backend)
 	if err != nil {
 		return err
 	}
@@ -193,65 +707,394 @@ func writeCode(writer io.Writer, pkg string, batches []QueryBatch) error {
 		return err
 	}
 
+	_, err = tmpl.Parse(backend.template())
+	if err != nil {
+		return err
+	}
+
 	err = tmpl.Execute(writer, vm)
 	return err
 }
 
-func newViewModel(pkg string, batches []QueryBatch) (codeGenViewModel, error) {
+func newViewModel(
+	pkg string,
+	model Model,
+	batches []QueryBatch,
+	options GenerateOptions,
+	backend codeGenBackend,
+) (codeGenViewModel, error) {
 	vm := codeGenViewModel{
-		Package: pkg,
-		Batches: []batchViewModel{},
+		Package:    pkg,
+		Batches:    []batchViewModel{},
+		ClientType: backend.clientType(),
+		Mock:       options.Mock,
+		Prepared:   options.Prepared,
+	}
+	imports := newImportSet("context")
+	imports.add(backend.imports(options)...)
+	if options.Mock {
+		imports.add("errors")
+		imports.add("sync")
+	}
+	types := typeResolver{
+		backend:   backend,
+		overrides: options.TypeOverrides,
+		imports:   imports,
+		model:     model,
+		enums:     map[string][]string{},
 	}
 
+	funcNames := map[string]string{}
 	for _, qb := range batches {
+		funcName := pascalCase(qb.Name)
+		if other, ok := funcNames[funcName]; ok {
+			return codeGenViewModel{}, fmt.Errorf(
+				"The queries '%s' and '%s' have the same Go name %s",
+				other, qb.Name, funcName)
+		}
+		funcNames[funcName] = qb.Name
+
 		queries := []queryViewModel{}
 
 		for i, shape := range qb.Shapes {
-			rvm, err := newResultTypeViewModel(i, len(qb.Shapes), qb.Name, shape)
+			rvm, err := newResultTypeViewModel(i, len(qb.Shapes), qb.Name, shape, types)
 			if err != nil {
 				return codeGenViewModel{}, err
 			}
 
+			numSuffix := ""
+			if len(qb.Shapes) > 1 {
+				numSuffix = strconv.Itoa(i + 1)
+			}
+
+			positional := qb.Positional[i]
+			queryParams := []parameterViewModel{}
+			for j, name := range positional.Parameters {
+				param, err := newParameterViewModel(qb, name, j, types)
+				if err != nil {
+					return codeGenViewModel{}, err
+				}
+				queryParams = append(queryParams, param)
+			}
+
+			// A query annotated :many returns a slice even when the shape
+			// says one row
+			resultType := shape.Type
+			if qb.Kind == QueryKindMany {
+				resultType = QueryResultTypeManyRows
+			}
+
 			queries = append(queries, queryViewModel{
-				Result: rvm,
-				Index:  i + 1,
-				Type:   shape.Type,
+				Result:       rvm,
+				Index:        i + 1,
+				Type:         resultType,
+				SQL:          strconv.Quote(positional.SQL),
+				SQLName:      camelCase(qb.Name) + "SQL" + numSuffix,
+				StmtName:     camelCase(qb.Name) + numSuffix,
+				Prepared:     options.Prepared,
+				Parameters:   queryParams,
+				InTx:         len(qb.Shapes) > 1,
+				One:          qb.Kind == QueryKindOne,
+				Exec:         qb.Kind == QueryKindExec,
+				RowsAffected: qb.Kind == QueryKindExecRows,
 			})
+			if qb.Kind == QueryKindExec || qb.Kind == QueryKindExecRows {
+				vm.ExecRows = true
+			}
 		}
 
 		params := []parameterViewModel{}
 		for i, param := range qb.Parameters {
-			params = append(params, parameterViewModel{
-				Name:  param.Name,
-				Index: i + 1,
-			})
+			pvm, err := newParameterViewModel(qb, param.Name, i, types)
+			if err != nil {
+				return codeGenViewModel{}, err
+			}
+			params = append(params, pvm)
 		}
 
-		vm.Batches = append(vm.Batches, batchViewModel{
+		bvm := batchViewModel{
 			Name:       qb.Name,
-			FuncName:   pascalCase(qb.Name),
+			FuncName:   funcName,
 			Queries:    queries,
-			SQL:        formatSQLForGo(qb.SQL),
 			Parameters: params,
-		})
+			ParamsArg:  options.MaxParameters > 0 && len(params) > options.MaxParameters,
+			Copy:       newCopyViewModel(qb, types),
+		}
+		if len(queries) > 1 {
+			vm.Transactions = true
+		}
+		if len(queries) == 1 && queries[0].Type == QueryResultTypeManyRows {
+			bvm.ForEach = &queries[0]
+		}
+		if bvm.ParamsArg || bvm.Copy != nil {
+			bvm.ParamsStruct = funcName + "Params"
+		}
+		if bvm.Copy != nil {
+			vm.InsertValues = true
+			vm.Transactions = true
+			imports.add("strconv", "strings")
+			if bvm.Copy.COPY {
+				imports.add(backend.copyImports()...)
+			}
+		}
+		vm.Batches = append(vm.Batches, bvm)
+	}
+
+	enums, err := newEnumViewModels(types.enums)
+	if err != nil {
+		return codeGenViewModel{}, err
+	}
+	if len(enums) > 0 {
+		imports.add("database/sql/driver", "fmt")
 	}
+	vm.Enums = enums
 
+	vm.Imports = imports.viewModel()
 	return vm, nil
 }
 
-func newResultTypeViewModel(index int, of int, batchName string, shape Shape) (resultTypeViewModel, error) {
+// Only the enums that queries use get a Go type, in order of their names.
+func newEnumViewModels(enums map[string][]string) ([]enumViewModel, error) {
+	names := []string{}
+	for name := range enums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	vms := []enumViewModel{}
+	typeNames := map[string]string{}
+	for _, name := range names {
+		vm := enumViewModel{
+			Name:    enumGoType(name),
+			SQLName: name,
+			Values:  []enumValueViewModel{},
+		}
+
+		other, exists := typeNames[vm.Name]
+		if exists {
+			return nil, fmt.Errorf(
+				"The enums '%s' and '%s' have the same Go name %s",
+				other, name, vm.Name)
+		}
+		typeNames[vm.Name] = name
+
+		constNames := map[string]string{}
+		for _, value := range enums[name] {
+			constName := vm.Name + pascalCase(value)
+			other, exists := constNames[constName]
+			if exists {
+				return nil, fmt.Errorf(
+					"The values '%s' and '%s' of enum '%s' have the same Go name %s",
+					other, value, name, constName)
+			}
+			constNames[constName] = value
+
+			vm.Values = append(vm.Values, enumValueViewModel{
+				Name:  constName,
+				Value: strconv.Quote(value),
+			})
+		}
+		vms = append(vms, vm)
+	}
+
+	return vms, nil
+}
+
+// Enums from schemas other than public are prefixed with their schema, eg
+// audit.status becomes AuditStatus.
+func enumGoType(name string) string {
+	return pascalCase(strings.Replace(name, ".", "_", -1))
+}
+
+// Packages that generated code must import when it uses a type from them,
+// keyed by the name the type is qualified with.
+var typeImports = map[string]string{
+	"json":   "encoding/json",
+	"time":   "time",
+	"pgtype": "github.com/jackc/pgtype",
+}
+
+// The packages imported by generated code. A path that starts with "_ " is
+// only imported for its side effects.
+type importSet map[string]struct{}
+
+func newImportSet(paths ...string) importSet {
+	set := importSet{}
+	set.add(paths...)
+	return set
+}
+
+func (set importSet) add(paths ...string) {
+	for _, path := range paths {
+		set[path] = struct{}{}
+	}
+}
+
+// Adds the package that a type like "time.Time" or "[]*pgtype.UUID" comes
+// from, if any.
+func (set importSet) addForType(typ string) {
+	qualified := strings.TrimLeft(typ, "[]*")
+	dot := strings.Index(qualified, ".")
+	if dot < 0 {
+		return
+	}
+	path, ok := typeImports[qualified[:dot]]
+	if ok {
+		set.add(path)
+	}
+}
+
+// Splits the imports into standard library and third party groups like
+// goimports would, with each one formatted as an import spec.
+func (set importSet) viewModel() importsViewModel {
+	vm := importsViewModel{
+		Standard:   []string{},
+		ThirdParty: []string{},
+	}
+
+	paths := []string{}
+	for path := range set {
+		// No need for a blank import when the package is used anyway
+		_, named := set[strings.TrimPrefix(path, "_ ")]
+		if strings.HasPrefix(path, "_ ") && named {
+			continue
+		}
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		name := ""
+		if strings.HasPrefix(path, "_ ") {
+			name = "_ "
+			path = strings.TrimPrefix(path, "_ ")
+		}
+		spec := name + strconv.Quote(path)
+
+		firstElem := strings.Split(path, "/")[0]
+		if strings.Contains(firstElem, ".") {
+			vm.ThirdParty = append(vm.ThirdParty, spec)
+		} else {
+			vm.Standard = append(vm.Standard, spec)
+		}
+	}
+
+	return vm
+}
+
+// Parameters get the type of the column they were inferred from. When there
+// isn't one they accept anything the driver does.
+func newParameterViewModel(
+	qb QueryBatch,
+	name string,
+	index int,
+	types typeResolver,
+) (parameterViewModel, error) {
+	typ := "interface{}"
+	arg := name
+	def, ok := qb.ParameterColumns[name]
+	if ok {
+		resolved, err := types.goType(def)
+		if err != nil {
+			return parameterViewModel{}, err
+		}
+		typ = resolved
+		arg = types.driverArg(name, def)
+	}
+
+	fieldName := pascalCase(name)
+	doc := fmt.Sprintf("%s is passed as $%s", fieldName, name)
+	if ok && def.Name != "" {
+		doc += fmt.Sprintf(" and has the type of the column %s", def.Name)
+	}
+	doc += "."
+	for _, param := range qb.Parameters {
+		if param.Name == name && param.Optional {
+			doc += " Leave it nil to skip the comparisons that use it."
+		}
+	}
+
+	return parameterViewModel{
+		Name:      name,
+		FieldName: fieldName,
+		Type:      typ,
+		Arg:       arg,
+		Index:     index + 1,
+		Doc:       doc,
+	}, nil
+}
+
+// Postgres doesn't accept more parameters than this in one statement.
+const maxStatementParameters = 65535
+
+// Returns nil unless the batch is a single INSERT of a list of columns whose
+// values are all parameters.
+func newCopyViewModel(qb QueryBatch, types typeResolver) *copyViewModel {
+	if len(qb.AST) != 1 {
+		return nil
+	}
+	insert, ok := qb.AST[0].(Insert)
+	if !ok || len(insert.Columns) == 0 || len(insert.Columns) != len(insert.Values) {
+		return nil
+	}
+
+	vm := &copyViewModel{COPY: true}
+	vm.Schema, vm.Table = splitQualifiedName(insert.Target.TableName)
+	quoted := []string{}
+	for i, column := range insert.Columns {
+		param, ok := insert.Values[i].(ParameterExpression)
+		if !ok {
+			return nil
+		}
+
+		def, ok := qb.ParameterColumns[param.Name]
+		if !ok || !types.backend.canCopy(def) {
+			vm.COPY = false
+		}
+		vm.Columns = append(vm.Columns, column.ColumnName)
+		vm.Args = append(vm.Args, types.driverArg("row."+pascalCase(param.Name), def))
+		quoted = append(quoted, quoteIdentifier(column.ColumnName))
+	}
+
+	table := quoteIdentifier(vm.Table)
+	if vm.Schema != "" {
+		table = quoteIdentifier(vm.Schema) + "." + table
+	}
+	vm.InsertSQL = strconv.Quote(fmt.Sprintf(
+		"INSERT INTO %s (%s) VALUES ", table, strings.Join(quoted, ", ")))
+
+	vm.RowsPerInsert = maxStatementParameters / len(vm.Columns)
+	if vm.RowsPerInsert > 1000 {
+		vm.RowsPerInsert = 1000
+	}
+	return vm
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+func newResultTypeViewModel(
+	index int,
+	of int,
+	batchName string,
+	shape Shape,
+	types typeResolver,
+) (resultTypeViewModel, error) {
 	columns := []columnViewModel{}
 
 	for i, c := range shape.Columns {
-		typ, err := goType(c)
+		typ, err := types.goType(c)
 		if err != nil {
 			return resultTypeViewModel{}, err
 		}
 
+		nameLower := camelCase(c.Name)
 		columns = append(columns, columnViewModel{
 			Name:      pascalCase(c.Name),
-			NameLower: camelCase(c.Name),
+			NameLower: nameLower,
 			Type:      typ,
+			ScanArg:   types.driverArg("&"+nameLower, c),
 			Index:     i + 1,
 		})
 	}
@@ -267,31 +1110,156 @@ func newResultTypeViewModel(index int, of int, batchName string, shape Shape) (r
 	}, nil
 }
 
+// Decides the Go type of a column by checking the user's overrides, then the
+// backend, then the defaults. Also records the imports that the type needs.
+type typeResolver struct {
+	backend   codeGenBackend
+	overrides []TypeOverride
+	imports   importSet
+
+	// The values of every enum that a resolved type refers to, keyed by name.
+	// They come from the model rather than the column since that always has
+	// the latest values.
+	model Model
+	enums map[string][]string
+}
+
+// An override of an array column's type is the type of its elements.
+func (r typeResolver) goType(def ColumnDefinition) (string, error) {
+	override, ok := r.findOverride(def)
+	if ok {
+		if override.Import != "" {
+			r.imports.add(override.Import)
+		}
+		return override.goType(def), nil
+	}
+
+	if def.TypeName != "" {
+		enum, ok := r.model.Enums[def.TypeName]
+		if !ok {
+			return "", fmt.Errorf("Unknown enum '%s'", def.TypeName)
+		}
+		r.enums[def.TypeName] = enum.Values
+	}
+
+	if def.ArrayDims > 0 {
+		typ, err := r.backend.arrayGoType(def)
+		if err != nil {
+			return "", err
+		}
+		r.imports.addForType(typ)
+		return typ, nil
+	}
+
+	typ, ok := r.backend.goType(def)
+	if !ok {
+		var err error
+		typ, err = goType(def)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	r.imports.addForType(typ)
+	return typ, nil
+}
+
+// Returns what to pass to the driver for a query argument or Scan destination
+// of the column's type.
+func (r typeResolver) driverArg(expr string, def ColumnDefinition) string {
+	if def.ArrayDims > 0 {
+		return r.backend.arrayArg(expr, r.imports)
+	}
+	return expr
+}
+
+func (r typeResolver) findOverride(def ColumnDefinition) (TypeOverride, bool) {
+	for _, o := range r.overrides {
+		if o.Column != "" && o.matches(def) {
+			return o, true
+		}
+	}
+	for _, o := range r.overrides {
+		if o.Column == "" && o.matches(def) {
+			return o, true
+		}
+	}
+	return TypeOverride{}, false
+}
+
+// The default Go type of a column, which is always something that lib/pq can
+// scan into. Nullable columns become pointers so that NULL can be scanned as
+// nil, except for slices which can already be nil.
 func goType(def ColumnDefinition) (string, error) {
-	switch def.Type {
-	case DataTypeBigInt:
+	typ, err := baseGoType(def.Type)
+	if err != nil {
+		return "", err
+	}
+
+	if def.TypeName != "" {
+		typ = enumGoType(def.TypeName)
+	}
+
+	if def.ArrayDims > 0 {
+		return strings.Repeat("[]", def.ArrayDims) + typ, nil
+	}
+	if def.Nullable && !strings.HasPrefix(typ, "[]") {
+		return "*" + typ, nil
+	}
+	return typ, nil
+}
+
+// Types that lib/pq returns as text, like numeric, interval and the geometric
+// types, become strings rather than losing precision or meaning in a lossy
+// conversion. Use a TypeOverride to get something richer.
+func baseGoType(typ dataType) (string, error) {
+	switch typ {
+	case DataTypeSmallInt, DataTypeSmallSerial:
+		return "int16", nil
+	case DataTypeInteger, DataTypeSerial:
+		return "int32", nil
+	case DataTypeBigInt, DataTypeBigSerial:
 		return "int64", nil
+	case DataTypeReal:
+		return "float32", nil
+	case DataTypeDoublePrecision:
+		return "float64", nil
+	case DataTypeDecimal, DataTypeNumeric, DataTypeMoney:
+		return "string", nil
+	case DataTypeChar, DataTypeVarChar, DataTypeText:
+		return "string", nil
+	case DataTypeBytea:
+		return "[]byte", nil
+	case DataTypeTimestamp, DataTypeTimestampWithTimeZone, DataTypeDate:
+		return "time.Time", nil
+	case DataTypeTime, DataTypeTimeWithTimeZone:
+		return "time.Time", nil
+	case DataTypeInterval:
+		return "string", nil
 	case DataTypeBoolean:
 		return "bool", nil
-	case DataTypeInteger:
-		return "int32", nil
-	case DataTypeVarChar:
+	case DataTypeEnum:
 		return "string", nil
-	case DataTypeText:
+	case DataTypePoint, DataTypeLine, DataTypeLineSegment, DataTypeBox,
+		DataTypePath, DataTypePolygon, DataTypeCircle:
 		return "string", nil
-	case DataTypeBinaryJSON:
-		return "map[string]interface{}", nil
-	case DataTypeTimestampWithTimeZone:
-		return "time.Time", nil
+	case DataTypeCidr, DataTypeInet, DataTypeMacAddr:
+		return "string", nil
+	case DataTypeVarBit, DataTypeBit:
+		return "string", nil
+	case DataTypeTextSearchVector, DataTypeTextSearchQuery:
+		return "string", nil
+	case DataTypeUUID:
+		return "string", nil
+	case DataTypeXML:
+		return "string", nil
+	case DataTypeJSON, DataTypeBinaryJSON:
+		return "json.RawMessage", nil
 	default:
-		return "", fmt.Errorf("Unsupported type %v", def.Type)
+		return "", fmt.Errorf("Unsupported type %v", typ)
 	}
 }
 
-func formatSQLForGo(sql string) string {
-	return strings.ReplaceAll(strings.ReplaceAll(sql, "\"", "\\\""), "\n", "\\n")
-}
-
 func addWordBoundariesToNumbers(s string) string {
 	b := []byte(s)
 	b = numberSequence.ReplaceAll(b, numberReplacement)
@@ -0,0 +1,578 @@
+package lib
+
+import (
+	"bytes"
+	"go/format"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// A test helper that reads the queries in one of the test directories and
+// returns the formatted code generated for them.
+func generateForTestDir(t *testing.T, dir string, options GenerateOptions) string {
+	migrations, err := ReadMigrationsDir("../test/" + dir + "/migrations")
+	require.NoError(t, err)
+	model, err := ModelFromMigrations(migrations)
+	require.NoError(t, err)
+	batches, err := ReadQueriesFromDir("../test/"+dir+"/queries", model)
+	require.NoError(t, err)
+
+	buf := bytes.Buffer{}
+	err = writeCode(&buf, "store", model, batches, options)
+	require.NoError(t, err)
+
+	formatted, err := format.Source(buf.Bytes())
+	require.NoError(t, err)
+	return string(formatted)
+}
+
+func TestGenerateWithoutMock(t *testing.T) {
+	code := generateForTestDir(t, "bugtracker", GenerateOptions{})
+
+	require.Contains(t, code, "GetIssue(ctx context.Context, tid string, id string) (r1 []GetIssueResult1, r2 []GetIssueResult2, err error)")
+	require.NotContains(t, code, "MockDBClient")
+	require.NotContains(t, code, `"sync"`)
+}
+
+func TestGenerateMock(t *testing.T) {
+	code := generateForTestDir(t, "bugtracker", GenerateOptions{Mock: true})
+
+	require.Contains(t, code, "var _ DBClient = &MockDBClient{}")
+	require.Regexp(t, `GetIssueFunc\s+func\(ctx context.Context, tid string, id string\) \(r1 \[\]GetIssueResult1, r2 \[\]GetIssueResult2, err error\)`, code)
+	require.Regexp(t, `GetIssueCalls\s+\[\]GetIssueCall`, code)
+	require.Contains(t, code, "func (mock *MockDBClient) GetIssue(ctx context.Context, tid string, id string) (r1 []GetIssueResult1, r2 []GetIssueResult2, err error) {")
+	require.Contains(t, code, `errors.New("MockDBClient.GetIssueFunc is not configured")`)
+	require.Contains(t, code, "return mock.GetIssueFunc(ctx, tid, id)")
+	require.Contains(t, code, `"sync"`)
+}
+
+func TestGeneratePositionalQueries(t *testing.T) {
+	code := generateForTestDir(t, "bugtracker", GenerateOptions{})
+
+	require.Contains(t, code, `getIssueSQL2 = "SELECT\n  tag_key,\n  created\nFROM issue_tags\nWHERE tid = $1 AND issue_id = $2"`)
+	require.Contains(t, code, "rows, err := client.tx.QueryContext(ctx, getIssueSQL2, tid, id)")
+	require.Contains(t, code, "r2, err = client.queryGetIssueResult2(ctx, tid, id)")
+
+	// The statements of a batch share a transaction
+	require.Contains(t, code, "tx, err := client.db.BeginTx(ctx, nil)")
+	require.Contains(t, code, "client.tx = tx")
+	require.Contains(t, code, "err = tx.Commit()")
+	require.Contains(t, code, "rows, err := client.db.QueryContext(ctx, getProjectsSQL, tid)")
+	require.NotContains(t, code, "Prepare(ctx context.Context)")
+
+	// The driver is only imported for its side effects without arrays
+	code = generateForTestDir(t, "basic", GenerateOptions{})
+	require.Contains(t, code, `_ "github.com/lib/pq"`)
+}
+
+func TestGeneratePrepared(t *testing.T) {
+	code := generateForTestDir(t, "bugtracker", GenerateOptions{Prepared: true, Mock: true})
+
+	require.Contains(t, code, "Prepare(ctx context.Context) error")
+	require.Regexp(t, `getIssue1\s+\*sql.Stmt`, code)
+	require.Regexp(t, `getIssue2\s+\*sql.Stmt`, code)
+	require.Contains(t, code, "client.stmts.getIssue2, err = client.db.PrepareContext(ctx, getIssueSQL2)")
+	require.Contains(t, code, "rows, err := client.query(ctx, client.stmts.getIssue2, getIssueSQL2, tid, id)")
+	require.Contains(t, code, "func (mock *MockDBClient) Prepare(ctx context.Context) error {")
+}
+
+func TestGeneratePgx(t *testing.T) {
+	code := generateForTestDir(t, "bugtracker", GenerateOptions{Backend: BackendPgx, Mock: true})
+
+	require.Contains(t, code, `"github.com/jackc/pgx/v4"`)
+	require.Contains(t, code, `"github.com/jackc/pgx/v4/pgxpool"`)
+	require.NotContains(t, code, `"database/sql"`)
+	require.NotContains(t, code, "github.com/lib/pq")
+
+	require.Contains(t, code, "Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)")
+	require.Contains(t, code, "func NewDBClient(ctx context.Context, connectionString string) (DBClient, error) {")
+	require.Contains(t, code, "func NewDBClientFromPgx(db PgxDB) DBClient {")
+	require.Contains(t, code, "_ PgxDB = (*pgxpool.Pool)(nil)")
+	require.Contains(t, code, "func (client PgxDBClient) GetIssue(ctx context.Context, tid pgtype.UUID, id string) (r1 []GetIssueResult1, r2 []GetIssueResult2, err error) {")
+	require.Contains(t, code, "rows, err := client.db.Query(ctx, getIssueSQL2, tid, id)")
+	require.Contains(t, code, "var _ DBClient = &MockDBClient{}")
+}
+
+func TestGeneratePgxTransactions(t *testing.T) {
+	code := generateForTestDir(t, "bugtracker", GenerateOptions{Backend: BackendPgx})
+
+	require.Contains(t, code, "Begin(ctx context.Context) (pgx.Tx, error)")
+	require.Contains(t, code, "tx, err := client.db.Begin(ctx)")
+	require.Contains(t, code, "client.db = tx")
+	require.Contains(t, code, "tx.Rollback(ctx)")
+	require.Contains(t, code, "err = tx.Commit(ctx)")
+}
+
+func TestGeneratePgxTypes(t *testing.T) {
+	model, err := ModelFromMigrations([]*Migration{&Migration{UpSQL: `
+		CREATE TABLE things (
+			id UUID NOT NULL PRIMARY KEY,
+			doc JSONB NOT NULL,
+			created TIMESTAMPTZ NOT NULL
+		)`}})
+	require.NoError(t, err)
+
+	batch, err := newQueryBatch("get_things", "SELECT id, doc, created FROM things", model)
+	require.NoError(t, err)
+
+	buf := bytes.Buffer{}
+	err = writeCode(&buf, "store", model, []QueryBatch{batch}, GenerateOptions{Backend: BackendPgx})
+	require.NoError(t, err)
+	formatted, err := format.Source(buf.Bytes())
+	require.NoError(t, err)
+	code := string(formatted)
+
+	require.Regexp(t, `Id\s+pgtype.UUID`, code)
+	require.Regexp(t, `Doc\s+pgtype.JSONB`, code)
+	require.Regexp(t, `Created\s+pgtype.Timestamptz`, code)
+	require.Contains(t, code, `"github.com/jackc/pgtype"`)
+}
+
+func TestGeneratePgxPrepared(t *testing.T) {
+	buf := bytes.Buffer{}
+	err := writeCode(&buf, "store", Model{}, []QueryBatch{}, GenerateOptions{Backend: BackendPgx, Prepared: true})
+	require.Error(t, err)
+}
+
+func TestGenerateTypeOverrides(t *testing.T) {
+	code := generateForTestDir(t, "bugtracker", GenerateOptions{
+		TypeOverrides: []TypeOverride{
+			TypeOverride{
+				DataType: DataTypeUUID,
+				GoType:   "uuid.UUID",
+				Import:   "github.com/google/uuid",
+			},
+			TypeOverride{
+				DataType: DataTypeBinaryJSON,
+				GoType:   "map[string]string",
+			},
+			TypeOverride{
+				Column: "issues.fields",
+				GoType: "json.RawMessage",
+				Import: "encoding/json",
+			},
+		},
+	})
+
+	// Parameters
+	require.Contains(t, code, "GetIssue(ctx context.Context, tid uuid.UUID, id string)")
+	require.Contains(t, code, "CreateIssue(ctx context.Context, tid uuid.UUID, id string, name string, project_key string, fields json.RawMessage)")
+
+	// Result columns
+	require.Regexp(t, `Fields\s+json.RawMessage`, code)
+	require.NotContains(t, code, "map[string]string")
+
+	require.Contains(t, code, `"github.com/google/uuid"`)
+	require.Contains(t, code, `"encoding/json"`)
+}
+
+func TestGenerateTypeOverrideThroughAlias(t *testing.T) {
+	model, err := ModelFromMigrations([]*Migration{&Migration{UpSQL: `
+		CREATE TABLE prices (
+			id INT NOT NULL PRIMARY KEY,
+			amount INT NOT NULL
+		)`}})
+	require.NoError(t, err)
+
+	batch, err := newQueryBatch("get_prices", `
+		SELECT p.amount AS cost FROM (SELECT amount FROM prices) p WHERE p.amount > $min`, model)
+	require.NoError(t, err)
+
+	buf := bytes.Buffer{}
+	err = writeCode(&buf, "store", model, []QueryBatch{batch}, GenerateOptions{
+		TypeOverrides: []TypeOverride{
+			TypeOverride{
+				Column: "prices.amount",
+				GoType: "decimal.Decimal",
+				Import: "github.com/shopspring/decimal",
+			},
+		},
+	})
+	require.NoError(t, err)
+	formatted, err := format.Source(buf.Bytes())
+	require.NoError(t, err)
+	code := string(formatted)
+
+	require.Regexp(t, `Cost\s+decimal.Decimal`, code)
+	require.Contains(t, code, "GetPrices(ctx context.Context, min decimal.Decimal)")
+}
+
+func TestGenerateTypeOverrideNullable(t *testing.T) {
+	model, err := ModelFromMigrations([]*Migration{&Migration{UpSQL: `
+		CREATE TABLE payments (
+			id UUID NOT NULL PRIMARY KEY,
+			amt NUMERIC NULL,
+			refunded_from UUID NULL,
+			tags TEXT[] NULL
+		)`}})
+	require.NoError(t, err)
+
+	get, err := newQueryBatch("get_payments", `
+		SELECT id, amt, refunded_from, tags FROM payments WHERE amt > $min`, model)
+	require.NoError(t, err)
+	create, err := newQueryBatch("create_payment", `
+		INSERT INTO payments (id, amt, refunded_from) VALUES ($id, $amt, $refunded_from)`, model)
+	require.NoError(t, err)
+
+	buf := bytes.Buffer{}
+	err = writeCode(&buf, "store", model, []QueryBatch{get, create}, GenerateOptions{
+		TypeOverrides: []TypeOverride{
+			TypeOverride{
+				DataType: DataTypeNumeric,
+				GoType:   "decimal.Decimal",
+				Import:   "github.com/shopspring/decimal",
+			},
+			TypeOverride{
+				DataType:       DataTypeUUID,
+				GoType:         "uuid.UUID",
+				NullableGoType: "uuid.NullUUID",
+				Import:         "github.com/google/uuid",
+			},
+			TypeOverride{
+				Column: "payments.tags",
+				GoType: "string",
+			},
+		},
+	})
+	require.NoError(t, err)
+	formatted, err := format.Source(buf.Bytes())
+	require.NoError(t, err)
+	code := string(formatted)
+
+	// Result columns
+	require.Regexp(t, `Id\s+uuid.UUID\n`, code)
+	require.Regexp(t, `Amt\s+\*decimal.Decimal`, code)
+	require.Regexp(t, `RefundedFrom\s+uuid.NullUUID`, code)
+	require.Regexp(t, `Tags\s+\[\]string`, code)
+
+	// Parameters. Comparing to NULL is never true so min can't be nil.
+	require.Contains(t, code, "GetPayments(ctx context.Context, min decimal.Decimal)")
+	require.Contains(t, code, "CreatePayment(ctx context.Context, id uuid.UUID, amt *decimal.Decimal, refunded_from uuid.NullUUID)")
+}
+
+func TestGoTypeCoversEveryDataType(t *testing.T) {
+	for typ := DataTypeSmallInt; typ <= DataTypeBinaryJSON; typ++ {
+		_, err := goType(ColumnDefinition{Type: typ})
+		require.NoError(t, err, "data type %v", typ)
+	}
+
+	_, err := goType(ColumnDefinition{Type: DataTypeBinaryJSON + 1})
+	require.Error(t, err)
+}
+
+func TestGoTypeNullable(t *testing.T) {
+	typ, err := goType(ColumnDefinition{Type: DataTypeInteger})
+	require.NoError(t, err)
+	require.Equal(t, "int32", typ)
+
+	typ, err = goType(ColumnDefinition{Type: DataTypeInteger, Nullable: true})
+	require.NoError(t, err)
+	require.Equal(t, "*int32", typ)
+
+	typ, err = goType(ColumnDefinition{Type: DataTypeBinaryJSON, Nullable: true})
+	require.NoError(t, err)
+	require.Equal(t, "*json.RawMessage", typ)
+
+	// A nil slice already means NULL
+	typ, err = goType(ColumnDefinition{Type: DataTypeBytea, Nullable: true})
+	require.NoError(t, err)
+	require.Equal(t, "[]byte", typ)
+}
+
+func TestGenerateNullableColumns(t *testing.T) {
+	code := generateForTestDir(t, "bugtracker", GenerateOptions{})
+
+	require.Contains(t, code, "GetIssue(ctx context.Context, tid string, id string)")
+	require.Regexp(t, `Fields\s+json.RawMessage`, code)
+	require.Regexp(t, `Created\s+time.Time`, code)
+	require.Regexp(t, `Modified\s+\*time.Time`, code)
+	require.Contains(t, code, `"encoding/json"`)
+}
+
+func TestGenerateArrays(t *testing.T) {
+	code := generateForTestDir(t, "bugtracker", GenerateOptions{})
+
+	require.Contains(t, code, "GetIssuesByLabel(ctx context.Context, tid string, ids []string, label string)")
+	require.Regexp(t, `Labels\s+\[\]string`, code)
+	require.Contains(t, code, "tid, pq.Array(ids), label)")
+	require.Contains(t, code, "pq.Array(&labels)")
+
+	// The package is used so it doesn't need a blank import too
+	require.Contains(t, code, `"github.com/lib/pq"`)
+	require.NotContains(t, code, `_ "github.com/lib/pq"`)
+}
+
+func TestGeneratePgxArrays(t *testing.T) {
+	code := generateForTestDir(t, "bugtracker", GenerateOptions{Backend: BackendPgx})
+
+	require.Contains(t, code, "GetIssuesByLabel(ctx context.Context, tid pgtype.UUID, ids []string, label string)")
+	require.Contains(t, code, "tid, ids, label)")
+	require.Contains(t, code, "&labels)")
+	require.NotContains(t, code, "pq.Array")
+}
+
+func TestGenerateMultidimensionalArrayWithPq(t *testing.T) {
+	model, err := ModelFromMigrations([]*Migration{&Migration{UpSQL: `
+		CREATE TABLE grids (id INT NOT NULL PRIMARY KEY, cells INT[][] NOT NULL)`}})
+	require.NoError(t, err)
+
+	batch, err := newQueryBatch("get_grids", "SELECT cells FROM grids", model)
+	require.NoError(t, err)
+
+	buf := bytes.Buffer{}
+	err = writeCode(&buf, "store", model, []QueryBatch{batch}, GenerateOptions{})
+	require.Error(t, err)
+
+	buf = bytes.Buffer{}
+	err = writeCode(&buf, "store", model, []QueryBatch{batch}, GenerateOptions{Backend: BackendPgx})
+	require.NoError(t, err)
+	require.Regexp(t, `Cells\s+\[\]\[\]int32`, buf.String())
+
+	// Parameters of the same type fail the same way instead of falling back to
+	// interface{}
+	batch, err = newQueryBatch("find_grids", "SELECT id FROM grids WHERE cells = $cells", model)
+	require.NoError(t, err)
+
+	err = writeCode(&bytes.Buffer{}, "store", model, []QueryBatch{batch}, GenerateOptions{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "lib/pq does not support multidimensional arrays")
+}
+
+func TestGenerateEnums(t *testing.T) {
+	code := generateForTestDir(t, "bugtracker", GenerateOptions{})
+
+	require.Contains(t, code, "type IssueStatus string")
+	require.Regexp(t, `IssueStatusInProgress\s+IssueStatus = "in_progress"`, code)
+	require.Contains(t, code, "case IssueStatusOpen, IssueStatusInProgress, IssueStatusClosed:")
+	require.Contains(t, code, "func (e *IssueStatus) Scan(src interface{}) error {")
+	require.Contains(t, code, "func (e IssueStatus) Value() (driver.Value, error) {")
+	require.Contains(t, code, `"database/sql/driver"`)
+
+	require.Contains(t, code, "GetIssuesByStatus(ctx context.Context, tid string, status IssueStatus)")
+	require.Regexp(t, `Status\s+\*IssueStatus`, code)
+
+	// Only enums that are used get a type
+	code = generateForTestDir(t, "basic", GenerateOptions{})
+	require.NotContains(t, code, "Valid()")
+}
+
+func TestGenerateEnumValuesFromModel(t *testing.T) {
+	model, err := ModelFromMigrations([]*Migration{&Migration{UpSQL: `
+		CREATE TYPE status AS ENUM ('open', 'closed');
+		CREATE TABLE issues (id INT NOT NULL, s status NOT NULL);`}})
+	require.NoError(t, err)
+	batch, err := newQueryBatch("get_issues", "SELECT s FROM issues", model)
+	require.NoError(t, err)
+
+	// The batch's copy of the values is stale once another value is added
+	model.Enums["status"].Values = append(model.Enums["status"].Values, "archived")
+
+	buf := bytes.Buffer{}
+	err = writeCode(&buf, "store", model, []QueryBatch{batch}, GenerateOptions{})
+	require.NoError(t, err)
+	formatted, err := format.Source(buf.Bytes())
+	require.NoError(t, err)
+	require.Contains(t, string(formatted), "case StatusOpen, StatusClosed, StatusArchived:")
+}
+
+func TestGenerateSchemaEnums(t *testing.T) {
+	model, err := ModelFromMigrations([]*Migration{&Migration{UpSQL: `
+		CREATE SCHEMA audit;
+		CREATE TYPE status AS ENUM ('open', 'closed');
+		CREATE TYPE audit.status AS ENUM ('logged', 'reviewed');
+		CREATE TABLE events (id INT NOT NULL PRIMARY KEY, status status NOT NULL);
+		CREATE TABLE audit.events (id INT NOT NULL PRIMARY KEY, status audit.status NOT NULL)`}})
+	require.NoError(t, err)
+
+	batch, err := newQueryBatch("get_statuses", `
+		SELECT e.status, a.status AS audit_status
+		FROM events e
+		JOIN audit.events a ON a.id = e.id`, model)
+	require.NoError(t, err)
+
+	buf := bytes.Buffer{}
+	err = writeCode(&buf, "store", model, []QueryBatch{batch}, GenerateOptions{})
+	require.NoError(t, err)
+	formatted, err := format.Source(buf.Bytes())
+	require.NoError(t, err)
+	code := string(formatted)
+
+	require.Contains(t, code, "type Status string")
+	require.Contains(t, code, "type AuditStatus string")
+	require.Regexp(t, `AuditStatusLogged\s+AuditStatus = "logged"`, code)
+	require.Regexp(t, `AuditStatus\s+AuditStatus`, code)
+
+	// Names that only differ by their schema's punctuation can't both be used
+	model, err = ModelFromMigrations([]*Migration{&Migration{UpSQL: `
+		CREATE SCHEMA audit;
+		CREATE TYPE audit_status AS ENUM ('a');
+		CREATE TYPE audit.status AS ENUM ('b');
+		CREATE TABLE t (a audit_status NOT NULL, b audit.status NOT NULL)`}})
+	require.NoError(t, err)
+	batch, err = newQueryBatch("get_t", "SELECT a, b FROM t", model)
+	require.NoError(t, err)
+	err = writeCode(&bytes.Buffer{}, "store", model, []QueryBatch{batch}, GenerateOptions{})
+	require.Error(t, err)
+}
+
+func TestGenerateAnnotatedQueries(t *testing.T) {
+	code := generateForTestDir(t, "bugtracker", GenerateOptions{})
+
+	require.Contains(t, code, "GetTag(ctx context.Context, tid string, key string) (r1 *GetTagResult, err error)")
+	require.Contains(t, code, "r1, err = client.queryOneGetTagResult(ctx, tid, key)")
+	require.Contains(t, code, `getTagSQL = "SELECT \"key\", created FROM tags WHERE tid = $1 AND \"key\" = $2"`)
+	require.Contains(t, code, "GetTagsCreatedWithin(ctx context.Context, tid string, age string) (r1 []GetTagsCreatedWithinResult, err error)")
+
+	// :execrows returns the number of rows affected instead of rows
+	require.Contains(t, code, "AddTag(ctx context.Context, tid string, key string) (r1 int64, err error)")
+	require.Contains(t, code, "r1, err = client.addTagRowsAffected(ctx, tid, key)")
+	require.Contains(t, code, "res, err := client.db.ExecContext(ctx, addTagSQL, tid, key)")
+	require.Contains(t, code, "return res.RowsAffected()")
+	require.NotContains(t, code, "AddTagResult")
+
+	// :exec returns nothing but the error
+	require.Contains(t, code, "CreateTag(ctx context.Context, tid string, key string) (err error)")
+	require.Contains(t, code, "err = client.createTagExec(ctx, tid, key)")
+	require.Contains(t, code, "_, err = client.db.ExecContext(ctx, createTagSQL, tid, key)")
+	require.NotContains(t, code, "CreateTagResult")
+
+	code = generateForTestDir(t, "bugtracker", GenerateOptions{Prepared: true})
+	require.Contains(t, code, "res, err := client.exec(ctx, client.stmts.addTag, addTagSQL, tid, key)")
+	require.Contains(t, code, "return stmt.ExecContext(ctx, args...)")
+
+	code = generateForTestDir(t, "bugtracker", GenerateOptions{Backend: BackendPgx})
+	require.Contains(t, code, "AddTag(ctx context.Context, tid pgtype.UUID, key string) (r1 int64, err error)")
+	require.Contains(t, code, "result = rows.CommandTag().RowsAffected()")
+	require.Contains(t, code, "CreateTag(ctx context.Context, tid pgtype.UUID, key string) (err error)")
+}
+
+func TestGenerateDuplicateQueryNames(t *testing.T) {
+	model, err := ModelFromMigrations([]*Migration{&Migration{UpSQL: "CREATE TABLE t (a INT NOT NULL)"}})
+	require.NoError(t, err)
+
+	first, err := newQueryBatch("get_t", "SELECT a FROM t", model)
+	require.NoError(t, err)
+	second, err := newQueryBatch("GetT", "SELECT a FROM t", model)
+	require.NoError(t, err)
+
+	err = writeCode(&bytes.Buffer{}, "store", model, []QueryBatch{first, second}, GenerateOptions{})
+	require.EqualError(t, err, "The queries 'get_t' and 'GetT' have the same Go name GetT")
+}
+
+func TestGenerateParameterTypeAnnotations(t *testing.T) {
+	model, err := ModelFromMigrations([]*Migration{&Migration{UpSQL: `
+		CREATE TABLE t (id INT NOT NULL, created TIMESTAMPTZ NOT NULL)`}})
+	require.NoError(t, err)
+
+	batch, err := newQueryBatch("get_t", `
+		-- param: $since timestamptz null
+		SELECT id, $label::text AS label FROM t WHERE created > COALESCE($since, created - $age::interval)`, model)
+	require.NoError(t, err)
+
+	buf := bytes.Buffer{}
+	err = writeCode(&buf, "store", model, []QueryBatch{batch}, GenerateOptions{})
+	require.NoError(t, err)
+	formatted, err := format.Source(buf.Bytes())
+	require.NoError(t, err)
+	code := string(formatted)
+
+	require.Contains(t, code, "GetT(ctx context.Context, label string, since *time.Time, age string) (r1 []GetTResult, err error)")
+	require.Regexp(t, `Label\s+\*string`, code)
+}
+
+func TestGenerateOptionalParameters(t *testing.T) {
+	code := generateForTestDir(t, "bugtracker", GenerateOptions{})
+
+	require.Contains(t, code, "ListIssues(ctx context.Context, tid string, status *IssueStatus, min_priority *int32) (r1 []ListIssuesResult, err error)")
+	require.Contains(t, code, `listIssuesSQL = "SELECT id, \"name\", status, priority\nFROM issues\nWHERE tid = $1 AND (status = $2 OR $2 IS NULL) AND (priority >= $3 OR $3 IS NULL)"`)
+}
+
+func TestGenerateParamsStruct(t *testing.T) {
+	code := generateForTestDir(t, "bugtracker", GenerateOptions{Mock: true, MaxParameters: 4})
+
+	require.Contains(t, code, "type CreateIssueParams struct {")
+	require.Contains(t, code, "// Tid is passed as $tid and has the type of the column tid.")
+	require.Regexp(t, `Tid\s+string\s+// Id is passed as \$id`, code)
+	require.Contains(t, code, "CreateIssue(ctx context.Context, params CreateIssueParams) (r1 []CreateIssueResult, err error)")
+	require.Contains(t, code, "tid := params.Tid")
+	require.Contains(t, code, "return mock.CreateIssueFunc(ctx, params)")
+	require.Regexp(t, `Fields:\s+params.Fields,`, code)
+
+	// Batches at the limit keep their separate arguments
+	require.Contains(t, code, "GetIssue(ctx context.Context, tid string, id string) (r1 []GetIssueResult1, r2 []GetIssueResult2, err error)")
+	require.NotContains(t, code, "GetIssueParams")
+}
+
+func TestGenerateCopyFrom(t *testing.T) {
+	code := generateForTestDir(t, "bugtracker", GenerateOptions{Mock: true})
+
+	require.Contains(t, code, "CopyFromTagIssue(ctx context.Context, rows []TagIssueParams) (err error)")
+	require.Contains(t, code, "TagIssue(ctx context.Context, tid string, issue_id string, tag_key string, created time.Time) (r1 []TagIssueResult, err error)")
+	require.Contains(t, code, `pq.CopyIn("issue_tags", "tid", "issue_id", "tag_key", "created")`)
+	require.Contains(t, code, "_, err = stmt.ExecContext(ctx, row.Tid, row.IssueId, row.TagKey, row.Created)")
+	require.Contains(t, code, "return mock.CopyFromTagIssueFunc(ctx, rows)")
+
+	// Inserts of anything other than parameters can't be copied
+	require.NotContains(t, code, "CopyFromCreateIssue(")
+}
+
+func TestGeneratePgxCopyFrom(t *testing.T) {
+	code := generateForTestDir(t, "bugtracker", GenerateOptions{Backend: BackendPgx})
+
+	require.Contains(t, code, `pgx.Identifier{"issue_tags"}`)
+	require.Contains(t, code, `[]string{"tid", "issue_id", "tag_key", "created"}`)
+	require.Contains(t, code, "return []interface{}{row.Tid, row.IssueId, row.TagKey, row.Created}, nil")
+	require.Contains(t, code, "_ pgxCopier = pgx.Tx(nil)")
+	require.Contains(t, code, `query := insertValuesSQL("INSERT INTO \"issue_tags\" (\"tid\", \"issue_id\", \"tag_key\", \"created\") VALUES ", 4, end-start)`)
+	require.Contains(t, code, "Begin(ctx context.Context) (pgx.Tx, error)")
+	require.Contains(t, code, "tx.Rollback(ctx)")
+	require.Contains(t, code, "err = tx.Commit(ctx)")
+}
+
+func TestGenerateCopyFromFallsBackToInsert(t *testing.T) {
+	model, err := ModelFromMigrations([]*Migration{&Migration{UpSQL: `
+		CREATE SCHEMA audit;
+		CREATE TABLE audit.events (id INT NOT NULL, body JSONB NOT NULL)`}})
+	require.NoError(t, err)
+
+	batch, err := newQueryBatch("add_event", `INSERT INTO audit.events (id, body) VALUES ($id, $body)`, model)
+	require.NoError(t, err)
+
+	buf := bytes.Buffer{}
+	err = writeCode(&buf, "store", model, []QueryBatch{batch}, GenerateOptions{})
+	require.NoError(t, err)
+	formatted, err := format.Source(buf.Bytes())
+	require.NoError(t, err)
+	code := string(formatted)
+
+	require.NotContains(t, code, "pq.CopyIn")
+	require.Contains(t, code, `query := insertValuesSQL("INSERT INTO \"audit\".\"events\" (\"id\", \"body\") VALUES ", 2, end-start)`)
+	require.Contains(t, code, "args = append(args, row.Id, row.Body)")
+	require.Contains(t, code, "for start := 0; start < len(rows); start += 1000 {")
+
+	// The chunks are inserted in one transaction
+	require.Contains(t, code, "tx, err := client.db.BeginTx(ctx, nil)")
+	require.Contains(t, code, "_, err = client.tx.ExecContext(ctx, query, args...)")
+	require.Contains(t, code, "tx.Rollback()")
+	require.Contains(t, code, "err = tx.Commit()")
+}
+
+func TestGenerateForEach(t *testing.T) {
+	code := generateForTestDir(t, "bugtracker", GenerateOptions{Mock: true, MaxParameters: 2})
+
+	require.Contains(t, code, "ForEachGetProjects(ctx context.Context, tid string, fn func(GetProjectsResult) error) (err error)")
+	require.Contains(t, code, "return client.eachGetProjectsResult(ctx, tid, fn)")
+	require.Contains(t, code, "err = client.eachGetProjectsResult(ctx, tid, func(row GetProjectsResult) error {")
+	require.Regexp(t, `ForEachGetProjectsCalls\s+\[\]GetProjectsCall`, code)
+	require.Contains(t, code, "return mock.ForEachGetProjectsFunc(ctx, tid, fn)")
+
+	// Batches that take a params struct unpack it for the query
+	require.Contains(t, code, "ForEachListIssues(ctx context.Context, params ListIssuesParams, fn func(ListIssuesResult) error) (err error)")
+	require.Contains(t, code, "return mock.ForEachListIssuesFunc(ctx, params, fn)")
+
+	// Only single statements that can return many rows are streamed
+	require.NotContains(t, code, "ForEachGetIssue(")
+	require.NotContains(t, code, "ForEachGetTag(")
+	require.NotContains(t, code, "ForEachCreateTag(")
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"unicode"
+	"unicode/utf8"
 )
 
 type charInfo struct {
@@ -24,22 +25,44 @@ type lexer struct {
 	tokenStartIndex  int
 	tokenLocation    charLocation
 	emitCallback     func(token)
+	trivia           []comment
 }
 
 func newLexer(sql string, emit func(token)) *lexer {
-	return &lexer{
-		sql:              []rune(sql),
-		length:           len(sql),
+	runes := []rune(sql)
+	l := &lexer{
+		sql:              runes,
+		length:           len(runes),
 		currentCharIndex: 0,
 		currentLocation:  charLocation{line: 1, col: 1},
 		tokenStartIndex:  0,
 		tokenLocation:    charLocation{line: 1, col: 1},
-		emitCallback:     emit,
 	}
+
+	// Comments are held back until the next token so they can be attached to
+	// it. Comments at the very end of the SQL go on a final EOF token.
+	l.emitCallback = func(tok token) {
+		tok.trivia = l.trivia
+		l.trivia = nil
+		emit(tok)
+	}
+
+	return l
 }
 
 func (l *lexer) emit(tok token) {
 	l.endWord()
+	tok.length = 1
+	l.emitCallback(tok)
+	l.resetToken()
+}
+
+// Emits a token that is two characters long like "<=" after consuming the
+// second character.
+func (l *lexer) emitPair(tok token) {
+	l.endWord()
+	_, _ = l.advance()
+	tok.length = 2
 	l.emitCallback(tok)
 	l.resetToken()
 }
@@ -74,9 +97,28 @@ func (l *lexer) scan() error {
 			break
 		}
 	}
+
+	if len(l.trivia) > 0 {
+		l.emitCallback(token{tokType: tokenTypeEOF, location: l.endLocation()})
+	}
 	return nil
 }
 
+// The location just after the last character. currentLocation can't be used
+// because advance keeps moving it past the end.
+func (l *lexer) endLocation() charLocation {
+	location := charLocation{line: 1, col: 1}
+	for _, ch := range l.sql {
+		if ch == '\n' {
+			location.line++
+			location.col = 1
+		} else {
+			location.col++
+		}
+	}
+	return location
+}
+
 func (l *lexer) next() (bool, error) {
 	chInfo, ok := l.advance()
 	if !ok {
@@ -92,36 +134,41 @@ func (l *lexer) next() (bool, error) {
 		l.emit(token{tokType: tokenTypeEqual, location: chInfo.location})
 	case '<':
 		{
-			ahead, ok := l.peek(1)
+			ahead, ok := l.peek(0)
 			if ok && ahead.ch == '=' {
-				_, _ = l.advance()
-				l.emit(token{tokType: tokenTypeLessOrEqual, location: chInfo.location})
+				l.emitPair(token{tokType: tokenTypeLessOrEqual, location: chInfo.location})
 			} else if ok && ahead.ch == '>' {
-				_, _ = l.advance()
-				l.emit(token{tokType: tokenTypeNotEqual, location: chInfo.location})
+				l.emitPair(token{tokType: tokenTypeNotEqual, location: chInfo.location})
 			} else {
 				l.emit(token{tokType: tokenTypeLess, location: chInfo.location})
 			}
 		}
 	case '>':
 		{
-			ahead, ok := l.peek(1)
+			ahead, ok := l.peek(0)
 			if ok && ahead.ch == '=' {
-				_, _ = l.advance()
-				l.emit(token{tokType: tokenTypeGreaterOrEqual, location: chInfo.location})
+				l.emitPair(token{tokType: tokenTypeGreaterOrEqual, location: chInfo.location})
 			} else {
 				l.emit(token{tokType: tokenTypeGreater, location: chInfo.location})
 			}
 		}
 	case '.':
 		{
-			ahead, ok := l.peek(1)
+			ahead, ok := l.peek(0)
 			if ok && isDigit(ahead.ch) && l.isFirstCharOfToken() {
 				more, err = l.scanNumber(ch)
 			} else {
 				l.emit(token{tokType: tokenTypeDot, location: chInfo.location})
 			}
 		}
+	case ':':
+		{
+			// A single colon is left in the word it is part of
+			ahead, ok := l.peek(0)
+			if ok && ahead.ch == ':' {
+				l.emitPair(token{tokType: tokenTypeCast, location: chInfo.location})
+			}
+		}
 	case ',':
 		l.emit(token{tokType: tokenTypeComma, location: chInfo.location})
 	case ';':
@@ -130,12 +177,30 @@ func (l *lexer) next() (bool, error) {
 		l.emit(token{tokType: tokenTypeLParen, location: chInfo.location})
 	case ')':
 		l.emit(token{tokType: tokenTypeRParen, location: chInfo.location})
+	case '[':
+		l.emit(token{tokType: tokenTypeLBracket, location: chInfo.location})
+	case ']':
+		l.emit(token{tokType: tokenTypeRBracket, location: chInfo.location})
 	case '+':
 		l.emit(token{tokType: tokenTypePlus, location: chInfo.location})
 	case '-':
-		l.emit(token{tokType: tokenTypeMinus, location: chInfo.location})
+		{
+			ahead, ok := l.peek(0)
+			if ok && ahead.ch == '-' {
+				l.scanLineComment(chInfo.location)
+			} else {
+				l.emit(token{tokType: tokenTypeMinus, location: chInfo.location})
+			}
+		}
 	case '/':
-		l.emit(token{tokType: tokenTypeSlash, location: chInfo.location})
+		{
+			ahead, ok := l.peek(0)
+			if ok && ahead.ch == '*' {
+				err = l.scanBlockComment(chInfo.location)
+			} else {
+				l.emit(token{tokType: tokenTypeSlash, location: chInfo.location})
+			}
+		}
 	case '*':
 		l.emit(token{tokType: tokenTypeAsterisk, location: chInfo.location})
 	case ' ':
@@ -147,16 +212,29 @@ func (l *lexer) next() (bool, error) {
 	case '\n':
 		l.eatWhitespace()
 	case '\'':
-		more, err = l.wrapped('\'', tokenTypeString)
+		l.endWord()
+		more, err = l.scanString(chInfo.location, false)
 	case '"':
-		more, err = l.wrapped('"', tokenTypeWord)
+		more, err = l.scanQuotedIdentifier()
 	case '$':
-		more, err = l.scanParameter()
+		if tag, ok := l.peekDollarTag(); ok {
+			more, err = l.scanDollarString(chInfo.location, tag)
+		} else {
+			more, err = l.scanParameter()
+		}
 	default:
 		// keep going with this word unless starting a number, then do some
-		// different stuff because that's different
-		if isDigit(ch) {
+		// different stuff because that's different. Digits after the start
+		// of a word like "int4" are just part of the word.
+		if isDigit(ch) && l.isFirstCharOfToken() {
 			more, err = l.scanNumber(ch)
+		} else if (ch == 'E' || ch == 'e') && l.isFirstCharOfToken() {
+			// E'...' is a string with C-style backslash escapes
+			ahead, ok := l.peek(0)
+			if ok && ahead.ch == '\'' {
+				_, _ = l.advance()
+				more, err = l.scanString(chInfo.location, true)
+			}
 		}
 	}
 
@@ -195,7 +273,12 @@ func (l *lexer) scanNumber(first rune) (bool, error) {
 	}
 
 	substr := l.sql[l.tokenStartIndex : end-1]
-	l.emitCallback(token{tokType: tokenTypeNumber, value: substr, location: l.tokenLocation})
+	l.emitCallback(token{
+		tokType:  tokenTypeNumber,
+		value:    substr,
+		location: l.tokenLocation,
+		length:   len(substr),
+	})
 	l.resetToken()
 	return true, nil
 }
@@ -220,9 +303,135 @@ func (l *lexer) eatWhitespace() {
 func (l *lexer) endWord() {
 	if !l.isFirstCharOfToken() {
 		substr := l.sql[l.tokenStartIndex : l.currentCharIndex-1]
-		l.emitCallback(token{tokType: tokenTypeWord, value: substr, location: l.tokenLocation})
+		l.emitCallback(token{
+			tokType:  tokenTypeWord,
+			value:    foldIdentifier(substr),
+			raw:      substr,
+			location: l.tokenLocation,
+			length:   len(substr),
+		})
+	}
+	l.resetToken()
+}
+
+// Postgres folds unquoted identifiers to lower case. Only ASCII letters are
+// folded.
+func foldIdentifier(word []rune) []rune {
+	folded := make([]rune, len(word))
+	for i, ch := range word {
+		if ch >= 'A' && ch <= 'Z' {
+			ch += 'a' - 'A'
+		}
+		folded[i] = ch
+	}
+	return folded
+}
+
+// Scans forward from a " to the end of a quoted identifier like "Users". Two
+// quotes in a row stand for one quote within the name.
+func (l *lexer) scanQuotedIdentifier() (bool, error) {
+	l.endWord()
+
+	start := l.currentCharIndex - 1
+	startLoc := l.currentLocation
+	startLoc.col--
+	name := []rune{}
+
+	for {
+		current, ok := l.advance()
+		if !ok {
+			return false, l.errorf("looking for %s", `"`)
+		}
+
+		if current.ch == '"' {
+			next, hasNext := l.peek(0)
+			if !hasNext || next.ch != '"' {
+				break
+			}
+			_, _ = l.advance()
+		}
+		name = append(name, current.ch)
+	}
+
+	if len(name) == 0 {
+		return false, fmt.Errorf(
+			"Error at line %d:%d: zero-length quoted identifier",
+			startLoc.line,
+			startLoc.col)
+	}
+
+	l.emitCallback(token{
+		tokType:  tokenTypeWord,
+		value:    name,
+		raw:      l.sql[start:l.currentCharIndex],
+		quoted:   true,
+		location: startLoc,
+		length:   l.currentCharIndex - start,
+	})
+	l.resetToken()
+	return true, nil
+}
+
+// Scans forward from -- to the end of the line like:
+//   -- this is a comment
+func (l *lexer) scanLineComment(location charLocation) {
+	l.endWord()
+	_, _ = l.advance()
+
+	start := l.currentCharIndex
+	end := start
+	for {
+		current, ok := l.advance()
+		if !ok || current.ch == '\n' {
+			break
+		}
+		if current.ch != '\r' {
+			end = l.currentCharIndex
+		}
+	}
+
+	l.trivia = append(l.trivia, comment{
+		text:     string(l.sql[start:end]),
+		location: location,
+	})
+	l.resetToken()
+}
+
+// Scans forward from /* to the matching */. Block comments can be nested like
+// they can in Postgres:
+//   /* outer /* inner */ still a comment */
+func (l *lexer) scanBlockComment(location charLocation) error {
+	l.endWord()
+	_, _ = l.advance()
+
+	start := l.currentCharIndex
+	depth := 1
+	for depth > 0 {
+		current, ok := l.advance()
+		if !ok {
+			return l.errorf("looking for %s", "*/")
+		}
+
+		next, hasNext := l.peek(0)
+		if !hasNext {
+			continue
+		}
+		if current.ch == '/' && next.ch == '*' {
+			_, _ = l.advance()
+			depth++
+		} else if current.ch == '*' && next.ch == '/' {
+			_, _ = l.advance()
+			depth--
+		}
 	}
+
+	l.trivia = append(l.trivia, comment{
+		text:     string(l.sql[start : l.currentCharIndex-2]),
+		block:    true,
+		location: location,
+	})
 	l.resetToken()
+	return nil
 }
 
 func (l *lexer) resetToken() {
@@ -255,49 +464,263 @@ func (l *lexer) scanParameter() (bool, error) {
 		first = false
 		l.advance()
 	}
-	l.emitCallback(token{tokType: tokenTypeParameter, location: location, value: param})
+
+	// A ? right after the name marks an optional parameter like $status?
+	optional := false
+	if current, ok := l.peek(0); ok && current.ch == '?' {
+		optional = true
+		l.advance()
+		_, more = l.peek(0)
+	}
+
+	length := 1 + len(param)
+	if optional {
+		length++
+	}
+	l.emitCallback(token{
+		tokType:  tokenTypeParameter,
+		location: location,
+		value:    param,
+		length:   length,
+		optional: optional,
+	})
 	l.resetToken()
 	return more, nil
 }
 
-func (l *lexer) wrapped(terminator rune, tokType tokenType) (bool, error) {
-	l.endWord()
-
-	escaping := false
-	start := l.currentCharIndex
-	startLoc := l.currentLocation
-	startLoc.col--
+// Scans forward from the opening ' of a string to the closing one and emits
+// the decoded value. Two quotes in a row stand for one quote within the
+// string. Backslashes are only escapes in E'...' strings:
+//   'it''s'
+//   E'it\'s\n'
+func (l *lexer) scanString(location charLocation, escapes bool) (bool, error) {
+	// Escapes like \xff are bytes rather than characters so the value is built
+	// up as UTF-8 and checked once the string is finished
+	value := []byte{}
+
+	// The opening quote has been read, along with the E before it if there
+	// was one
+	start := l.currentCharIndex - 1
+	if escapes {
+		start--
+	}
 
 	for {
-		escapingThisIteration := false
 		current, ok := l.advance()
 		if !ok {
-			return false, l.errorf("looking for %s", string(terminator))
-		}
-
-		if !escaping {
-			if current.ch == '\\' {
-				escapingThisIteration = true
-			} else if current.ch == terminator {
-				next, hasNext := l.peek(0)
-				if hasNext && next.ch == terminator {
-					escapingThisIteration = true
-				} else {
-					break
-				}
+			return false, l.errorf("looking for %s", "'")
+		}
+
+		if current.ch == '\'' {
+			next, hasNext := l.peek(0)
+			if !hasNext || next.ch != '\'' {
+				break
 			}
+			_, _ = l.advance()
+			value = append(value, '\'')
+		} else if current.ch == '\\' && escapes {
+			var err error
+			value, err = l.scanEscape(value)
+			if err != nil {
+				return false, err
+			}
+		} else {
+			value = append(value, string(current.ch)...)
 		}
+	}
 
-		escaping = escapingThisIteration
+	// Postgres rejects strings that escapes made into invalid UTF-8
+	for i := 0; i < len(value); {
+		r, size := utf8.DecodeRune(value[i:])
+		if r == utf8.RuneError && size == 1 {
+			return false, l.errorf(`invalid byte sequence for encoding "UTF8": 0x%02x`, value[i])
+		}
+		i += size
 	}
 
-	substr := l.sql[start : l.currentCharIndex-1]
-	l.emitCallback(token{tokType: tokType, value: substr, location: startLoc})
+	l.emitCallback(token{
+		tokType:  tokenTypeString,
+		value:    []rune(string(value)),
+		location: location,
+		length:   l.currentCharIndex - start,
+	})
 	l.resetToken()
 	return true, nil
 }
 
-func (l *lexer) errorf(msg string, args ...string) error {
-	formatted := fmt.Sprintf(msg, args)
+// Decodes the escape sequence after a backslash in an E'...' string and
+// appends it to value. Octal and \x escapes are single bytes like in Postgres
+// while \u and \U escapes are Unicode code points.
+func (l *lexer) scanEscape(value []byte) ([]byte, error) {
+	current, ok := l.advance()
+	if !ok {
+		return nil, l.errorf("looking for %s", "'")
+	}
+
+	switch current.ch {
+	case 'b':
+		return append(value, '\b'), nil
+	case 'f':
+		return append(value, '\f'), nil
+	case 'n':
+		return append(value, '\n'), nil
+	case 'r':
+		return append(value, '\r'), nil
+	case 't':
+		return append(value, '\t'), nil
+	case 'x':
+		// Like Postgres, \x without a hex digit after it is just x
+		next, ok := l.peek(0)
+		if !ok || digitValue(next.ch) < 0 {
+			return append(value, 'x'), nil
+		}
+		b, err := l.scanEscapeDigits(16, 1, 2)
+		if err != nil {
+			return nil, err
+		}
+		return l.appendEscapedByte(value, byte(b))
+	case 'u', 'U':
+		digits := 4
+		if current.ch == 'U' {
+			digits = 8
+		}
+		ch, err := l.scanEscapeDigits(16, digits, digits)
+		if err != nil {
+			return nil, err
+		}
+		if ch == 0 || !utf8.ValidRune(ch) {
+			return nil, l.errorf("invalid Unicode escape value")
+		}
+		return append(value, string(ch)...), nil
+	}
+
+	if current.ch >= '0' && current.ch <= '7' {
+		b := current.ch - '0'
+		for i := 0; i < 2; i++ {
+			next, ok := l.peek(0)
+			if !ok || next.ch < '0' || next.ch > '7' {
+				break
+			}
+			_, _ = l.advance()
+			b = b*8 + next.ch - '0'
+		}
+		return l.appendEscapedByte(value, byte(b))
+	}
+
+	// Any other character like \\ or \' is just itself
+	return append(value, string(current.ch)...), nil
+}
+
+// Postgres strings can't hold a zero byte, so escapes like \0 and \x00 are
+// rejected.
+func (l *lexer) appendEscapedByte(value []byte, b byte) ([]byte, error) {
+	if b == 0 {
+		return nil, l.errorf(`invalid byte sequence for encoding "UTF8": 0x00`)
+	}
+	return append(value, b), nil
+}
+
+// Reads between min and max digits in the given base after an escape like \x
+// or \u.
+func (l *lexer) scanEscapeDigits(base int, min int, max int) (rune, error) {
+	var value rune
+	count := 0
+	for count < max {
+		next, ok := l.peek(0)
+		if !ok {
+			break
+		}
+		digit := digitValue(next.ch)
+		if digit < 0 || digit >= base {
+			break
+		}
+		_, _ = l.advance()
+		value = value*rune(base) + rune(digit)
+		count++
+	}
+
+	if count < min {
+		return 0, l.errorf("invalid escape sequence")
+	}
+	return value, nil
+}
+
+func digitValue(ch rune) int {
+	switch {
+	case ch >= '0' && ch <= '9':
+		return int(ch - '0')
+	case ch >= 'a' && ch <= 'f':
+		return int(ch-'a') + 10
+	case ch >= 'A' && ch <= 'F':
+		return int(ch-'A') + 10
+	}
+	return -1
+}
+
+// Checks whether the $ that was just read opens a dollar-quoted string like
+// $$...$$ or $body$...$body$ rather than a parameter, and returns the tag.
+func (l *lexer) peekDollarTag() (string, bool) {
+	tag := []rune{}
+	for i := 0; ; i++ {
+		next, ok := l.peek(i)
+		if !ok {
+			return "", false
+		}
+		if next.ch == '$' {
+			return string(tag), true
+		}
+		if !isValidParameterChar(next.ch) || (i == 0 && isDigit(next.ch)) {
+			return "", false
+		}
+		tag = append(tag, next.ch)
+	}
+}
+
+// Scans forward from the opening $tag$ of a dollar-quoted string to the
+// closing one. Nothing is escaped within the string:
+//   $body$ SELECT 'it''s' $body$
+func (l *lexer) scanDollarString(location charLocation, tag string) (bool, error) {
+	l.endWord()
+
+	delimiter := []rune("$" + tag + "$")
+	opening := l.currentCharIndex - 1
+	for range delimiter[1:] {
+		_, _ = l.advance()
+	}
+
+	start := l.currentCharIndex
+	for !l.lookingAt(delimiter) {
+		if _, ok := l.advance(); !ok {
+			return false, l.errorf("looking for %s", string(delimiter))
+		}
+	}
+	value := l.sql[start:l.currentCharIndex]
+
+	for range delimiter {
+		_, _ = l.advance()
+	}
+
+	l.emitCallback(token{
+		tokType:  tokenTypeString,
+		value:    value,
+		location: location,
+		length:   l.currentCharIndex - opening,
+	})
+	l.resetToken()
+	return true, nil
+}
+
+func (l *lexer) lookingAt(text []rune) bool {
+	for i, ch := range text {
+		next, ok := l.peek(i)
+		if !ok || next.ch != ch {
+			return false
+		}
+	}
+	return true
+}
+
+func (l *lexer) errorf(msg string, args ...interface{}) error {
+	formatted := fmt.Sprintf(msg, args...)
 	return fmt.Errorf("Error at line %d:%d: %s", l.currentLocation.line, l.currentLocation.col, formatted)
 }
@@ -64,6 +64,35 @@ from bar`)
 	requireTok(t, tokens[3], tokenTypeWord, "bar", 4, 6)
 }
 
+func TestLexerWordWithDigits(t *testing.T) {
+	tokens, err := getTokens("int4 col2")
+	require.NoError(t, err)
+	require.Len(t, tokens, 2)
+	requireTok(t, tokens[0], tokenTypeWord, "int4", 1, 1)
+	requireTok(t, tokens[1], tokenTypeWord, "col2", 1, 6)
+}
+
+func TestLexerBrackets(t *testing.T) {
+	tokens, err := getTokens("labels[1]")
+	require.NoError(t, err)
+	require.Len(t, tokens, 4)
+	requireTok(t, tokens[0], tokenTypeWord, "labels", 1, 1)
+	requireTok(t, tokens[1], tokenTypeLBracket, "", 1, 7)
+	requireTok(t, tokens[2], tokenTypeNumber, "1", 1, 8)
+	requireTok(t, tokens[3], tokenTypeRBracket, "", 1, 9)
+}
+
+func TestLexerComparisonOperators(t *testing.T) {
+	tokens, err := getTokens("a <= b <> c >= d < e > f")
+	require.NoError(t, err)
+	require.Len(t, tokens, 11)
+	requireTok(t, tokens[1], tokenTypeLessOrEqual, "", 1, 3)
+	requireTok(t, tokens[3], tokenTypeNotEqual, "", 1, 8)
+	requireTok(t, tokens[5], tokenTypeGreaterOrEqual, "", 1, 13)
+	requireTok(t, tokens[7], tokenTypeLess, "", 1, 18)
+	requireTok(t, tokens[9], tokenTypeGreater, "", 1, 22)
+}
+
 func TestLexerNumber(t *testing.T) {
 	tokens, err := getTokens(`0123456789.9876543210`)
 	require.NoError(t, err)
@@ -90,12 +119,12 @@ func TestNumberParenthesis(t *testing.T) {
 	tokens, err := getTokens(`VARCHAR(200) NOT NULL`)
 	require.NoError(t, err)
 	require.Len(t, tokens, 6)
-	requireTok(t, tokens[0], tokenTypeWord, "VARCHAR", 1, 1)
+	requireTok(t, tokens[0], tokenTypeWord, "varchar", 1, 1)
 	requireTok(t, tokens[1], tokenTypeLParen, "", 1, 8)
 	requireTok(t, tokens[2], tokenTypeNumber, "200", 1, 9)
 	requireTok(t, tokens[3], tokenTypeRParen, "", 1, 12)
-	requireTok(t, tokens[4], tokenTypeWord, "NOT", 1, 14)
-	requireTok(t, tokens[5], tokenTypeWord, "NULL", 1, 18)
+	requireTok(t, tokens[4], tokenTypeWord, "not", 1, 14)
+	requireTok(t, tokens[5], tokenTypeWord, "null", 1, 18)
 }
 
 func TestLexerRealSelect(t *testing.T) {
@@ -121,20 +150,163 @@ func TestLexerString(t *testing.T) {
 }
 
 func TestLexerStringEscapedWithBackslash(t *testing.T) {
-	tokens, err := getTokens("'foo\\'s'")
+	tokens, err := getTokens("E'foo\\'s' e'\\\\\\n\\t\\x41\\101\\u00e9\\q' 'foo\\'")
 	require.NoError(t, err)
-	require.Len(t, tokens, 1)
-	requireTok(t, tokens[0], tokenTypeString, "foo\\'s", 1, 1)
+	require.Len(t, tokens, 3)
+	requireTok(t, tokens[0], tokenTypeString, "foo's", 1, 1)
+	requireTok(t, tokens[1], tokenTypeString, "\\\n\tAAéq", 1, 11)
+	requireTok(t, tokens[2], tokenTypeString, "foo\\", 1, 37)
 }
 
 func TestLexerStringEscapedWithDoubleSingleQuote(t *testing.T) {
 	tokens, err := getTokens("'foo''s'")
 	require.NoError(t, err)
 	require.Len(t, tokens, 1)
-	requireTok(t, tokens[0], tokenTypeString, "foo''s", 1, 1)
+	requireTok(t, tokens[0], tokenTypeString, "foo's", 1, 1)
 }
 
 func TestLexerStringInvalid(t *testing.T) {
 	_, err := getTokens("'foo")
+	require.EqualError(t, err, "Error at line 1:6: looking for '")
+}
+
+func TestLexerFoldsUnquotedWords(t *testing.T) {
+	tokens, err := getTokens(`SELECT Email FROM Users`)
+	require.NoError(t, err)
+	require.Len(t, tokens, 4)
+	requireTok(t, tokens[1], tokenTypeWord, "email", 1, 8)
+	require.Equal(t, "Email", string(tokens[1].raw))
+	require.False(t, tokens[1].quoted)
+}
+
+func TestLexerQuotedIdentifier(t *testing.T) {
+	tokens, err := getTokens(`"Users" "say ""hi""" x`)
+	require.NoError(t, err)
+	require.Len(t, tokens, 3)
+	requireTok(t, tokens[0], tokenTypeWord, "Users", 1, 1)
+	require.True(t, tokens[0].quoted)
+	requireTok(t, tokens[1], tokenTypeWord, `say "hi"`, 1, 9)
+	require.Equal(t, `"say ""hi"""`, string(tokens[1].raw))
+	requireTok(t, tokens[2], tokenTypeWord, "x", 1, 22)
+}
+
+func TestLexerQuotedIdentifierInvalid(t *testing.T) {
+	_, err := getTokens(`"Users`)
+	require.EqualError(t, err, `Error at line 1:8: looking for "`)
+	_, err = getTokens(`select ""`)
+	require.EqualError(t, err, "Error at line 1:8: zero-length quoted identifier")
+}
+
+func TestLexerLineComment(t *testing.T) {
+	tokens, err := getTokens("-- first\r\nselect a-- second\n-- third\nfrom b --end")
+	require.NoError(t, err)
+	require.Len(t, tokens, 5)
+	requireTok(t, tokens[0], tokenTypeWord, "select", 2, 1)
+	require.Equal(t, []comment{{text: " first", location: charLocation{line: 1, col: 1}}}, tokens[0].trivia)
+	requireTok(t, tokens[1], tokenTypeWord, "a", 2, 8)
+	require.Len(t, tokens[1].trivia, 0)
+	requireTok(t, tokens[2], tokenTypeWord, "from", 4, 1)
+	require.Len(t, tokens[2].trivia, 2)
+	require.Equal(t, " second", tokens[2].trivia[0].text)
+	require.Equal(t, " third", tokens[2].trivia[1].text)
+	requireTok(t, tokens[3], tokenTypeWord, "b", 4, 6)
+
+	// Comments after the last token are carried by an EOF token
+	requireTok(t, tokens[4], tokenTypeEOF, "", 4, 13)
+	require.Equal(t, []comment{{text: "end", location: charLocation{line: 4, col: 8}}}, tokens[4].trivia)
+
+	tokens, err = getTokens("select a")
+	require.NoError(t, err)
+	require.Len(t, tokens, 2)
+}
+
+func TestLexerBlockComment(t *testing.T) {
+	tokens, err := getTokens("select /* a /* nested */ comment */ 1 /**/ - 2 / 3")
+	require.NoError(t, err)
+	require.Len(t, tokens, 6)
+	requireTok(t, tokens[1], tokenTypeNumber, "1", 1, 37)
+	require.Equal(t, []comment{{
+		text:     " a /* nested */ comment ",
+		block:    true,
+		location: charLocation{line: 1, col: 8},
+	}}, tokens[1].trivia)
+	requireTok(t, tokens[2], tokenTypeMinus, "", 1, 44)
+	require.Equal(t, "", tokens[2].trivia[0].text)
+	requireTok(t, tokens[4], tokenTypeSlash, "", 1, 48)
+}
+
+func TestLexerBlockCommentInvalid(t *testing.T) {
+	_, err := getTokens("select /* a /* nested */ comment")
+	require.EqualError(t, err, "Error at line 1:34: looking for */")
+}
+
+func TestLexerStringByteEscapes(t *testing.T) {
+	tokens, err := getTokens("E'caf\\xc3\\xa9' E'\\303\\251'")
+	require.NoError(t, err)
+	require.Len(t, tokens, 2)
+	requireTok(t, tokens[0], tokenTypeString, "café", 1, 1)
+	requireTok(t, tokens[1], tokenTypeString, "é", 1, 16)
+
+	// Like Postgres, \x without a hex digit after it is just x
+	tokens, err = getTokens("E'\\xzz' E'\\x'")
+	require.NoError(t, err)
+	requireTok(t, tokens[0], tokenTypeString, "xzz", 1, 1)
+	requireTok(t, tokens[1], tokenTypeString, "x", 1, 9)
+}
+
+func TestLexerStringInvalidEscape(t *testing.T) {
+	_, err := getTokens("E'\\u12'")
+	require.EqualError(t, err, "Error at line 1:7: invalid escape sequence")
+	_, err = getTokens("E'foo\\'")
 	require.Error(t, err)
+	_, err = getTokens("E'\\xff'")
+	require.EqualError(t, err, `Error at line 1:8: invalid byte sequence for encoding "UTF8": 0xff`)
+	_, err = getTokens("E'\\uD800'")
+	require.EqualError(t, err, "Error at line 1:9: invalid Unicode escape value")
+
+	// Postgres strings can't hold a zero byte however it is written
+	_, err = getTokens("E'a\\0b'")
+	require.EqualError(t, err, `Error at line 1:6: invalid byte sequence for encoding "UTF8": 0x00`)
+	_, err = getTokens("E'\\x00'")
+	require.EqualError(t, err, `Error at line 1:7: invalid byte sequence for encoding "UTF8": 0x00`)
+	_, err = getTokens("E'\\u0000'")
+	require.EqualError(t, err, "Error at line 1:9: invalid Unicode escape value")
+}
+
+func TestLexerDollarQuotedString(t *testing.T) {
+	tokens, err := getTokens("$$it's$$ $body$ SELECT $$x$$, $p; $body$ $1 $p$ é $p$ $q")
+	require.NoError(t, err)
+	require.Len(t, tokens, 5)
+	requireTok(t, tokens[0], tokenTypeString, "it's", 1, 1)
+	requireTok(t, tokens[1], tokenTypeString, " SELECT $$x$$, $p; ", 1, 10)
+	requireTok(t, tokens[2], tokenTypeParameter, "1", 1, 42)
+	requireTok(t, tokens[3], tokenTypeString, " é ", 1, 45)
+	requireTok(t, tokens[4], tokenTypeParameter, "q", 1, 55)
+}
+
+func TestLexerDollarQuotedStringInvalid(t *testing.T) {
+	_, err := getTokens("$body$ SELECT 1 $$")
+	require.Error(t, err)
+}
+
+func TestLexerCast(t *testing.T) {
+	tokens, err := getTokens("$since::timestamptz a:b")
+	require.NoError(t, err)
+	require.Len(t, tokens, 4)
+	requireTok(t, tokens[0], tokenTypeParameter, "since", 1, 1)
+	requireTok(t, tokens[1], tokenTypeCast, "", 1, 7)
+	requireTok(t, tokens[2], tokenTypeWord, "timestamptz", 1, 9)
+	requireTok(t, tokens[3], tokenTypeWord, "a:b", 1, 21)
+}
+
+func TestLexerOptionalParameter(t *testing.T) {
+	tokens, err := getTokens("$status? = $id")
+	require.NoError(t, err)
+	require.Len(t, tokens, 3)
+	requireTok(t, tokens[0], tokenTypeParameter, "status", 1, 1)
+	require.True(t, tokens[0].optional)
+	require.Equal(t, 8, tokens[0].length)
+	requireTok(t, tokens[1], tokenTypeEqual, "", 1, 10)
+	requireTok(t, tokens[2], tokenTypeParameter, "id", 1, 12)
+	require.False(t, tokens[2].optional)
 }
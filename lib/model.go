@@ -1,27 +1,318 @@
 package lib
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
 
+// Tables, views and enums are keyed by their names qualified with their schema,
+// like "audit.events", except for those in the public schema which are keyed
+// by their bare names.
 type Model struct {
 	Tables map[string]*Table
+	Enums  map[string]*Enum
+	Views  map[string]*View
+
+	// Every schema, in the order they were created. Always includes public.
+	Schemas []string
+
+	// The schemas that unqualified names are looked up in, in order. Empty
+	// means just public.
+	SearchPath []string
+}
+
+// The schema that holds everything that migrations don't put somewhere else.
+const defaultSchema = "public"
+
+// Splits a name like "audit.events" into its schema and the rest. The schema
+// is empty when the name isn't qualified. Parts that were quoted because they
+// hold a dot or a quote, eg: "a.b".c, are unquoted.
+func splitQualifiedName(name string) (string, string) {
+	i := 0
+	if strings.HasPrefix(name, `"`) {
+		// Skip to the closing quote, stepping over doubled quotes
+		for i = 1; i < len(name); i++ {
+			if name[i] != '"' {
+				continue
+			}
+			if i+1 < len(name) && name[i+1] == '"' {
+				i++
+				continue
+			}
+			break
+		}
+	}
+	dot := strings.Index(name[i:], ".")
+	if dot < 0 {
+		return "", unquoteNamePart(name)
+	}
+	dot += i
+	return unquoteNamePart(name[:dot]), unquoteNamePart(name[dot+1:])
+}
+
+// Quotes a part of a qualified name when it holds a dot or a quote so that
+// "a.b".c can't be confused with a."b.c".
+func quoteNamePart(part string) string {
+	if !strings.ContainsAny(part, `."`) {
+		return part
+	}
+	return `"` + strings.Replace(part, `"`, `""`, -1) + `"`
+}
+
+func unquoteNamePart(part string) string {
+	if len(part) < 2 || !strings.HasPrefix(part, `"`) || !strings.HasSuffix(part, `"`) {
+		return part
+	}
+	return strings.Replace(part[1:len(part)-1], `""`, `"`, -1)
+}
+
+// Joins a schema and a name, keeping the schema even when it's public.
+func joinQualifiedName(schema string, name string) string {
+	return quoteNamePart(schema) + "." + quoteNamePart(name)
+}
+
+// The key of an object in the model's maps.
+func qualifiedName(schema string, name string) string {
+	if schema == "" || schema == defaultSchema {
+		return quoteNamePart(name)
+	}
+	return joinQualifiedName(schema, name)
+}
+
+func (m Model) searchPath() []string {
+	if len(m.SearchPath) == 0 {
+		return []string{defaultSchema}
+	}
+	return m.SearchPath
+}
+
+// Returns the schemas to look for a name in and the name without its schema.
+func (m Model) lookupSchemas(name string) ([]string, string) {
+	schema, bare := splitQualifiedName(name)
+	if schema != "" {
+		return []string{schema}, bare
+	}
+	return m.searchPath(), bare
+}
+
+// Finds the key of the table or view with the given name. Tables and views
+// share names so an unqualified name means the first one along the search
+// path.
+func (m Model) resolveRelation(name string) (string, bool) {
+	schemas, bare := m.lookupSchemas(name)
+	for _, schema := range schemas {
+		key := qualifiedName(schema, bare)
+		_, isTable := m.Tables[key]
+		_, isView := m.Views[key]
+		if isTable || isView {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+func (m Model) table(name string) (*Table, bool) {
+	key, ok := m.resolveRelation(name)
+	if !ok {
+		return nil, false
+	}
+	tbl, ok := m.Tables[key]
+	return tbl, ok
+}
+
+func (m Model) view(name string) (*View, bool) {
+	key, ok := m.resolveRelation(name)
+	if !ok {
+		return nil, false
+	}
+	view, ok := m.Views[key]
+	return view, ok
+}
+
+func (m Model) enum(name string) (*Enum, bool) {
+	schemas, bare := m.lookupSchemas(name)
+	for _, schema := range schemas {
+		enum, ok := m.Enums[qualifiedName(schema, bare)]
+		if ok {
+			return enum, true
+		}
+	}
+	return nil, false
 }
 
 type Table struct {
+	// Qualified with the schema unless it is public, like the keys of
+	// Model.Tables.
 	Name        string
+	Schema      string
 	Columns     []ColumnDefinition
 	Constraints []Constraint
+	Indexes     []Index
+}
+
+// An index from CREATE INDEX. Indexes that back constraints are not included
+// since they are already described by Table.Constraints.
+type Index struct {
+	Name    string
+	Columns []string
+	Unique  bool
+
+	// The predicate of a partial index. Nil when the index covers every row.
+	Where Condition
+
+	// True when some of the indexed items are expressions. Those are left out
+	// of Columns.
+	HasExpressions bool
+}
+
+// Returns true if the index guarantees that its columns are unique across
+// the whole table.
+func (i Index) IsUniqueConstraint() bool {
+	return i.Unique && i.Where == nil && !i.HasExpressions
+}
+
+// A view from CREATE VIEW. Like Postgres, its columns are worked out once when
+// it is created and do not change if the tables it selects from do.
+type View struct {
+	Name         string
+	Schema       string
+	Columns      []ColumnDefinition
+	Materialized bool
+
+	// True when the view's query returns at most one row.
+	OneRow bool
+
+	// The tables and views that the view's query selects from.
+	DependsOn []string
+
+	// The table columns that the view's query uses anywhere, which can't be
+	// dropped or have their type changed while the view exists.
+	ColumnsUsed []ColumnSource
+
+	// Only materialized views can have indexes.
+	Indexes []Index
+}
+
+// The view's name without its schema.
+func (v *View) localName() string {
+	_, name := splitQualifiedName(v.Name)
+	return name
+}
+
+func (v *View) hasColumn(name string) bool {
+	for _, col := range v.Columns {
+		if col.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+type Enum struct {
+	Name   string
+	Schema string
+	Values []string
 }
 
 type Constraint struct {
 	Name    string
 	Type    constraintType
 	Columns []string
+
+	// Only set for foreign keys. The referenced columns are always filled in,
+	// even when the migration left them implicit.
+	References *ForeignKey
+
+	// Only set for CHECK constraints.
+	Check Condition
 }
 
 func (c Constraint) IsUnique() bool {
 	return c.Type == ConstraintTypePrimaryKey || c.Type == ConstraintTypeUnique
 }
 
+func (c Constraint) IsForeignKey() bool {
+	return c.Type == ConstraintTypeForeignKey
+}
+
+// Returns the foreign keys of this table that reference the given table.
+func (t *Table) ForeignKeysTo(tableName string) []Constraint {
+	fks := []Constraint{}
+	for _, c := range t.Constraints {
+		if c.IsForeignKey() && c.References.TableName == tableName {
+			fks = append(fks, c)
+		}
+	}
+	return fks
+}
+
+// The table's name without its schema.
+func (t *Table) localName() string {
+	_, name := splitQualifiedName(t.Name)
+	return name
+}
+
+func (t *Table) column(name string) (ColumnDefinition, bool) {
+	for _, col := range t.Columns {
+		if col.Name == name {
+			return col, true
+		}
+	}
+	return ColumnDefinition{}, false
+}
+
+func (t *Table) hasColumn(name string) bool {
+	_, found := t.column(name)
+	return found
+}
+
+// Returns true if the table has a primary key, unique constraint or unique
+// index on exactly the given columns, in any order.
+func (t *Table) hasUniqueConstraint(columns []string) bool {
+	for _, c := range t.Constraints {
+		if c.IsUnique() && sameColumns(c.Columns, columns) {
+			return true
+		}
+	}
+	for _, index := range t.Indexes {
+		if index.IsUniqueConstraint() && sameColumns(index.Columns, columns) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Table) constraint(name string) (Constraint, int, bool) {
+	for i, c := range t.Constraints {
+		if c.Name == name {
+			return c, i, true
+		}
+	}
+	return Constraint{}, -1, false
+}
+
+func (t *Table) primaryKey() (Constraint, bool) {
+	for _, c := range t.Constraints {
+		if c.Type == ConstraintTypePrimaryKey {
+			return c, true
+		}
+	}
+	return Constraint{}, false
+}
+
+func sameColumns(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, col := range a {
+		if !containsString(b, col) {
+			return false
+		}
+	}
+	return true
+}
+
 type ModelBuilder struct {
 	model Model
 }
@@ -29,7 +320,10 @@ type ModelBuilder struct {
 func NewModelBuilder() *ModelBuilder {
 	return &ModelBuilder{
 		model: Model{
-			Tables: map[string]*Table{},
+			Tables:  map[string]*Table{},
+			Enums:   map[string]*Enum{},
+			Views:   map[string]*View{},
+			Schemas: []string{defaultSchema},
 		},
 	}
 }
@@ -42,38 +336,280 @@ func (m *ModelBuilder) handleStmt(stmt Statement) error {
 		return m.handleAddColumnStmt(s)
 	case DropColumn:
 		return m.handleDropColumnStmt(s)
+	case CreateEnum:
+		return m.handleCreateEnumStmt(s)
+	case AddEnumValue:
+		return m.handleAddEnumValueStmt(s)
+	case DropTable:
+		return m.handleDropTableStmt(s)
+	case RenameTable:
+		return m.handleRenameTableStmt(s)
+	case RenameColumn:
+		return m.handleRenameColumnStmt(s)
+	case CreateIndex:
+		return m.handleCreateIndexStmt(s)
+	case DropIndex:
+		return m.handleDropIndexStmt(s)
+	case AlterColumnType:
+		return m.handleAlterColumnTypeStmt(s)
+	case AlterColumnNullable:
+		return m.handleAlterColumnNullableStmt(s)
+	case AlterColumnDefault:
+		return m.handleAlterColumnDefaultStmt(s)
+	case AddConstraint:
+		return m.handleAddConstraintStmt(s)
+	case DropConstraint:
+		return m.handleDropConstraintStmt(s)
+	case CreateSchema:
+		return m.handleCreateSchemaStmt(s)
+	case SetVariable:
+		return m.handleSetVariableStmt(s)
+	case CreateView:
+		return m.handleCreateViewStmt(s)
+	case DropView:
+		return m.handleDropViewStmt(s)
 	default:
 		// Ignore because we don't care about SELECT, INSERT, etc
 		return nil
 	}
 }
 
+// Decides which schema a new object goes in and the key it will have. Like
+// Postgres, an unqualified name goes in the first schema of the search path
+// that exists.
+func (m *ModelBuilder) newName(name string) (string, string, error) {
+	schema, bare := splitQualifiedName(name)
+	if schema != "" {
+		if !containsString(m.model.Schemas, schema) {
+			return "", "", fmt.Errorf("Cannot create '%s' because the schema '%s' does not exist", name, schema)
+		}
+		return schema, qualifiedName(schema, bare), nil
+	}
+
+	for _, schema := range m.model.searchPath() {
+		if containsString(m.model.Schemas, schema) {
+			return schema, qualifiedName(schema, bare), nil
+		}
+	}
+	return "", "", fmt.Errorf("Cannot create '%s' because no schema in the search path exists", name)
+}
+
+func (m *ModelBuilder) handleCreateSchemaStmt(cs CreateSchema) error {
+	if containsString(m.model.Schemas, cs.Name) {
+		if cs.IfNotExists {
+			return nil
+		}
+		return fmt.Errorf("Schema named '%s' already exists", cs.Name)
+	}
+
+	m.model.Schemas = append(m.model.Schemas, cs.Name)
+	return nil
+}
+
+// Only the search path matters to the model. Other settings are ignored.
+func (m *ModelBuilder) handleSetVariableStmt(sv SetVariable) error {
+	if sv.Name != "search_path" {
+		return nil
+	}
+
+	m.model.SearchPath = sv.Values
+	return nil
+}
+
 func (m *ModelBuilder) handleCreateTableStmt(ct CreateTable) error {
-	_, exists := m.model.Tables[ct.Name]
+	schema, key, err := m.newName(ct.Name)
+	if err != nil {
+		return err
+	}
+
+	_, exists := m.model.Tables[key]
 	if exists {
 		return fmt.Errorf("Table named '%s' already exists", ct.Name)
 	}
+	_, exists = m.model.Views[key]
+	if exists {
+		return fmt.Errorf("Cannot create table '%s' because a view with that name already exists", ct.Name)
+	}
 
 	tbl := &Table{
-		Name:        ct.Name,
-		Columns:     ct.Columns,
+		Name:        key,
+		Schema:      schema,
+		Columns:     []ColumnDefinition{},
 		Constraints: []Constraint{},
+		Indexes:     []Index{},
+	}
+
+	for _, col := range ct.Columns {
+		err := m.resolveColumnType(&col)
+		if err != nil {
+			return err
+		}
+		tbl.Columns = append(tbl.Columns, col)
 	}
 
-	for _, c := range ct.Constraints {
+	// Added before its constraints so that foreign keys can find it
+	m.model.Tables[tbl.Name] = tbl
+	return m.addConstraints(tbl, ct.Constraints)
+}
+
+// Adds the constraints to the table after checking that their columns exist.
+// Foreign keys are checked last so that they can reference a unique
+// constraint on the same table.
+func (m *ModelBuilder) addConstraints(tbl *Table, constraints []CreateConstraint) error {
+	for _, c := range constraints {
+		for _, col := range c.Columns {
+			if !tbl.hasColumn(col) {
+				return fmt.Errorf(
+					"Constraint on the table '%s' uses the column '%s' which does not exist",
+					tbl.Name,
+					col,
+				)
+			}
+		}
+		if c.Type == ConstraintTypePrimaryKey {
+			_, exists := tbl.primaryKey()
+			if exists {
+				return fmt.Errorf("Table '%s' cannot have more than one primary key", tbl.Name)
+			}
+		}
+		check := c.Check
+		if check != nil {
+			check = unqualifyCondition(check, tbl.localName())
+		}
+		name := c.Name
+		if name == "" {
+			name = generateConstraintName(tbl, c.Type, c.Columns, check)
+		} else if _, _, exists := tbl.constraint(name); exists {
+			return fmt.Errorf(
+				"Constraint named '%s' already exists on the table '%s'",
+				name,
+				tbl.Name,
+			)
+		}
 		tbl.Constraints = append(tbl.Constraints, Constraint{
-			Name:    c.Name,
+			Name:    name,
 			Type:    c.Type,
 			Columns: c.Columns,
+			Check:   check,
 		})
 	}
 
-	m.model.Tables[tbl.Name] = tbl
+	// Resolve foreign keys once all the other constraints are known
+	first := len(tbl.Constraints) - len(constraints)
+	for i, c := range constraints {
+		if c.Type != ConstraintTypeForeignKey {
+			continue
+		}
+		ref, err := m.resolveForeignKey(tbl, c)
+		if err != nil {
+			return err
+		}
+		tbl.Constraints[first+i].References = &ref
+	}
 	return nil
 }
 
+// Picks a name for a constraint the same way Postgres does, eg: users_pkey,
+// users_email_key or issues_tid_fkey.
+func generateConstraintName(tbl *Table, constraintType constraintType, columns []string, check Condition) string {
+	suffix := ""
+	switch constraintType {
+	case ConstraintTypePrimaryKey:
+		suffix = "pkey"
+	case ConstraintTypeUnique:
+		suffix = "key"
+	case ConstraintTypeForeignKey:
+		suffix = "fkey"
+	case ConstraintTypeCheck:
+		suffix = "check"
+	}
+
+	parts := []string{tbl.localName()}
+	if constraintType != ConstraintTypePrimaryKey {
+		if constraintType == ConstraintTypeCheck && len(columns) == 0 {
+			columns = conditionColumns(check)
+		}
+		for _, col := range columns {
+			if !containsString(parts[1:], col) {
+				parts = append(parts, col)
+			}
+		}
+	}
+	base := strings.Join(append(parts, suffix), "_")
+
+	name := base
+	for i := 1; ; i++ {
+		_, _, exists := tbl.constraint(name)
+		if !exists {
+			return name
+		}
+		name = fmt.Sprintf("%s%d", base, i)
+	}
+}
+
+// Checks that a foreign key references unique columns of a table that exists.
+// A reference without columns means the primary key of the parent table.
+func (m *ModelBuilder) resolveForeignKey(tbl *Table, c CreateConstraint) (ForeignKey, error) {
+	ref := *c.References
+
+	parent, ok := m.model.table(ref.TableName)
+	if !ok {
+		return ForeignKey{}, fmt.Errorf(
+			"Foreign key on the table '%s' references the table '%s' which does not exist",
+			tbl.Name,
+			ref.TableName,
+		)
+	}
+
+	ref.TableName = parent.Name
+
+	if len(ref.Columns) == 0 {
+		pk, hasPK := parent.primaryKey()
+		if !hasPK {
+			return ForeignKey{}, fmt.Errorf(
+				"Foreign key on the table '%s' references the table '%s' which has no primary key",
+				tbl.Name,
+				ref.TableName,
+			)
+		}
+		ref.Columns = pk.Columns
+	}
+
+	if len(ref.Columns) != len(c.Columns) {
+		return ForeignKey{}, fmt.Errorf(
+			"Foreign key on the table '%s' has %d columns but references %d columns of the table '%s'",
+			tbl.Name,
+			len(c.Columns),
+			len(ref.Columns),
+			ref.TableName,
+		)
+	}
+
+	for _, col := range ref.Columns {
+		if !parent.hasColumn(col) {
+			return ForeignKey{}, fmt.Errorf(
+				"Foreign key on the table '%s' references the column '%s' which does not exist in the table '%s'",
+				tbl.Name,
+				col,
+				ref.TableName,
+			)
+		}
+	}
+
+	if !parent.hasUniqueConstraint(ref.Columns) {
+		return ForeignKey{}, fmt.Errorf(
+			"Foreign key on the table '%s' references (%s) of the table '%s' which is not a primary key or unique constraint",
+			tbl.Name,
+			strings.Join(ref.Columns, ", "),
+			ref.TableName,
+		)
+	}
+
+	return ref, nil
+}
+
 func (m *ModelBuilder) handleAddColumnStmt(ac AddColumn) error {
-	tbl, tblExists := m.model.Tables[ac.TableName]
+	tbl, tblExists := m.model.table(ac.TableName)
 	if !tblExists {
 		return fmt.Errorf(
 			"Cannot add column '%s' because the table '%s' does not exist",
@@ -84,6 +620,9 @@ func (m *ModelBuilder) handleAddColumnStmt(ac AddColumn) error {
 
 	for _, col := range tbl.Columns {
 		if col.Name == ac.Column.Name {
+			if ac.IfNotExists {
+				return nil
+			}
 			return fmt.Errorf(
 				"Cannot add column '%s' to the table '%s' because the column already exists",
 				ac.Column.Name,
@@ -92,17 +631,42 @@ func (m *ModelBuilder) handleAddColumnStmt(ac AddColumn) error {
 		}
 	}
 
-	tbl.Columns = append(tbl.Columns, ac.Column)
+	col := ac.Column
+	err := m.resolveColumnType(&col)
+	if err != nil {
+		return err
+	}
+
+	tbl.Columns = append(tbl.Columns, col)
+	return m.addConstraints(tbl, ac.Constraints)
+}
+
+// Fills in the values of a column whose type is an enum.
+func (m *ModelBuilder) resolveColumnType(col *ColumnDefinition) error {
+	if col.TypeName == "" {
+		return nil
+	}
+
+	enum, ok := m.model.enum(col.TypeName)
+	if !ok {
+		return fmt.Errorf("Unknown data type '%s' for column '%s'", col.TypeName, col.Name)
+	}
+
+	col.TypeName = enum.Name
+	col.Values = append([]string{}, enum.Values...)
 	return nil
 }
 
 func (m *ModelBuilder) handleDropColumnStmt(dc DropColumn) error {
-	tbl, tblExists := m.model.Tables[dc.TableName]
+	tableName := asWritten(dc.WrittenTableName, dc.TableName)
+	columnName := asWritten(dc.WrittenColumnName, dc.ColumnName)
+
+	tbl, tblExists := m.model.table(dc.TableName)
 	if !tblExists {
 		return fmt.Errorf(
 			"Cannot drop column '%s' because the table '%s' does not exist",
-			dc.ColumnName,
-			dc.TableName,
+			columnName,
+			tableName,
 		)
 	}
 
@@ -115,30 +679,995 @@ func (m *ModelBuilder) handleDropColumnStmt(dc DropColumn) error {
 	}
 
 	if toRemove < 0 {
+		if dc.IfExists {
+			return nil
+		}
 		return fmt.Errorf(
 			"Cannot drop column '%s' from the table '%s' because the column does not exist",
-			dc.ColumnName,
-			dc.TableName,
+			columnName,
+			tableName,
+		)
+	}
+
+	// So do the views that use it, which a cascade drops along with anything
+	// built on them
+	views := m.viewsUsingColumn(tbl.Name, dc.ColumnName)
+	if len(views) > 0 && !dc.Cascade {
+		return fmt.Errorf(
+			"Cannot drop column '%s' from the table '%s' because the view '%s' depends on it",
+			columnName,
+			tableName,
+			views[0],
 		)
 	}
 
+	// Foreign keys that reference the column depend on it so they block the
+	// drop unless it cascades, which drops the foreign keys instead
+	err := m.dropReferencingForeignKeys(tbl, dc.Cascade, func(fk *ForeignKey) bool {
+		return containsString(fk.Columns, dc.ColumnName)
+	}, func(other *Table) error {
+		return fmt.Errorf(
+			"Cannot drop column '%s' from the table '%s' because the table '%s' has a foreign key that references it",
+			columnName,
+			tableName,
+			other.Name,
+		)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = m.dropDependentViews(views, true)
+	if err != nil {
+		return err
+	}
+	for _, name := range views {
+		delete(m.model.Views, name)
+	}
+
 	tbl.Columns = append(tbl.Columns[:toRemove], tbl.Columns[toRemove+1:]...)
+
+	// Postgres drops the constraints and indexes that use the column along
+	// with it
+	constraints := []Constraint{}
+	for _, c := range tbl.Constraints {
+		used := containsString(c.Columns, dc.ColumnName) ||
+			containsString(conditionColumns(c.Check), dc.ColumnName)
+		if !used {
+			constraints = append(constraints, c)
+		}
+	}
+	tbl.Constraints = constraints
+
+	indexes := []Index{}
+	for _, index := range tbl.Indexes {
+		used := containsString(index.Columns, dc.ColumnName) ||
+			containsString(conditionColumns(index.Where), dc.ColumnName)
+		if !used {
+			indexes = append(indexes, index)
+		}
+	}
+	tbl.Indexes = indexes
 	return nil
 }
 
-func ModelFromMigrations(migrations []*Migration) (Model, error) {
-	builder := NewModelBuilder()
-	for _, migration := range migrations {
-		prog, err := Parse(migration.UpSQL)
-		if err != nil {
-			return Model{}, err
+// Removes the foreign keys that reference the table and match the filter. The
+// foreign keys of the table itself are left for the caller. When cascade is
+// false nothing is removed and the error from blocked is returned instead.
+func (m *ModelBuilder) dropReferencingForeignKeys(
+	tbl *Table,
+	cascade bool,
+	filter func(fk *ForeignKey) bool,
+	blocked func(other *Table) error,
+) error {
+	for _, other := range m.model.Tables {
+		for _, c := range other.ForeignKeysTo(tbl.Name) {
+			if filter(c.References) && !cascade {
+				return blocked(other)
+			}
 		}
-		for _, stmt := range prog.Statements {
-			err = builder.handleStmt(stmt)
-			if err != nil {
-				return Model{}, err
+	}
+
+	for _, other := range m.model.Tables {
+		constraints := []Constraint{}
+		for _, c := range other.Constraints {
+			isMatch := c.IsForeignKey() &&
+				c.References.TableName == tbl.Name &&
+				filter(c.References)
+			if !isMatch {
+				constraints = append(constraints, c)
 			}
 		}
+		other.Constraints = constraints
+	}
+	return nil
+}
+
+// Returns the table and the index of the column, or an error that explains
+// which one does not exist. The written names are only for the error.
+func (m *ModelBuilder) findColumn(
+	tableName string,
+	columnName string,
+	writtenTableName string,
+	writtenColumnName string,
+) (*Table, int, error) {
+	tbl, tblExists := m.model.table(tableName)
+	if !tblExists {
+		return nil, -1, fmt.Errorf(
+			"Cannot alter column '%s' because the table '%s' does not exist",
+			asWritten(writtenColumnName, columnName),
+			asWritten(writtenTableName, tableName),
+		)
+	}
+
+	for i, col := range tbl.Columns {
+		if col.Name == columnName {
+			return tbl, i, nil
+		}
+	}
+
+	return nil, -1, fmt.Errorf(
+		"Cannot alter column '%s' of the table '%s' because the column does not exist",
+		asWritten(writtenColumnName, columnName),
+		asWritten(writtenTableName, tableName),
+	)
+}
+
+func (m *ModelBuilder) handleAlterColumnTypeStmt(ac AlterColumnType) error {
+	tbl, i, err := m.findColumn(ac.TableName, ac.ColumnName, ac.WrittenTableName, ac.WrittenColumnName)
+	if err != nil {
+		return err
+	}
+
+	// Postgres won't change the type of a column that a view uses even with
+	// CASCADE, so the view has to be dropped first
+	views := m.viewsUsingColumn(tbl.Name, ac.ColumnName)
+	if len(views) > 0 {
+		return fmt.Errorf(
+			"Cannot change the type of the column '%s' of the table '%s' because the view '%s' depends on it",
+			asWritten(ac.WrittenColumnName, ac.ColumnName),
+			asWritten(ac.WrittenTableName, ac.TableName),
+			views[0],
+		)
+	}
+
+	col := tbl.Columns[i]
+	col.Type = ac.NewType.Type
+	col.Param1 = ac.NewType.Param1
+	col.Param2 = ac.NewType.Param2
+	col.ArrayDims = ac.NewType.ArrayDims
+	col.TypeName = ac.NewType.TypeName
+	col.Values = nil
+
+	err = m.resolveColumnType(&col)
+	if err != nil {
+		return err
+	}
+
+	tbl.Columns[i] = col
+	return nil
+}
+
+func (m *ModelBuilder) handleAlterColumnNullableStmt(ac AlterColumnNullable) error {
+	tbl, i, err := m.findColumn(ac.TableName, ac.ColumnName, ac.WrittenTableName, ac.WrittenColumnName)
+	if err != nil {
+		return err
+	}
+
+	if ac.Nullable {
+		pk, hasPK := tbl.primaryKey()
+		if hasPK && containsString(pk.Columns, ac.ColumnName) {
+			return fmt.Errorf(
+				"Cannot drop NOT NULL from the column '%s' of the table '%s' because it is in the primary key",
+				asWritten(ac.WrittenColumnName, ac.ColumnName),
+				asWritten(ac.WrittenTableName, ac.TableName),
+			)
+		}
+	}
+
+	tbl.Columns[i].Nullable = ac.Nullable
+	return nil
+}
+
+func (m *ModelBuilder) handleAlterColumnDefaultStmt(ac AlterColumnDefault) error {
+	tbl, i, err := m.findColumn(ac.TableName, ac.ColumnName, ac.WrittenTableName, ac.WrittenColumnName)
+	if err != nil {
+		return err
 	}
+
+	tbl.Columns[i].Default = ac.Default
+	return nil
+}
+
+func (m *ModelBuilder) handleAddConstraintStmt(ac AddConstraint) error {
+	tbl, tblExists := m.model.table(ac.TableName)
+	if !tblExists {
+		return fmt.Errorf(
+			"Cannot add constraint because the table '%s' does not exist",
+			ac.TableName,
+		)
+	}
+
+	return m.addConstraints(tbl, []CreateConstraint{ac.Constraint})
+}
+
+func (m *ModelBuilder) handleDropConstraintStmt(dc DropConstraint) error {
+	tbl, tblExists := m.model.table(dc.TableName)
+	if !tblExists {
+		return fmt.Errorf(
+			"Cannot drop constraint '%s' because the table '%s' does not exist",
+			dc.Name,
+			dc.TableName,
+		)
+	}
+
+	c, i, exists := tbl.constraint(dc.Name)
+	if !exists {
+		if dc.IfExists {
+			return nil
+		}
+		return fmt.Errorf(
+			"Cannot drop constraint '%s' from the table '%s' because it does not exist",
+			dc.Name,
+			dc.TableName,
+		)
+	}
+
+	remaining := append([]Constraint{}, tbl.Constraints[:i]...)
+	remaining = append(remaining, tbl.Constraints[i+1:]...)
+
+	// Foreign keys depend on the unique constraint that they reference unless
+	// another constraint or unique index covers the same columns
+	rest := &Table{Name: tbl.Name, Constraints: remaining, Indexes: tbl.Indexes}
+	if c.IsUnique() && !rest.hasUniqueConstraint(c.Columns) {
+		err := m.dropReferencingForeignKeys(tbl, dc.Cascade, func(fk *ForeignKey) bool {
+			return sameColumns(fk.Columns, c.Columns)
+		}, func(other *Table) error {
+			return fmt.Errorf(
+				"Cannot drop constraint '%s' from the table '%s' because the table '%s' has a foreign key that depends on it",
+				dc.Name,
+				dc.TableName,
+				other.Name,
+			)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	tbl.Constraints = remaining
+	return nil
+}
+
+func (m *ModelBuilder) handleDropTableStmt(dt DropTable) error {
+	dropped := []string{}
+	for _, name := range dt.TableNames {
+		tbl, exists := m.model.table(name)
+		if !exists {
+			if dt.IfExists {
+				continue
+			}
+			return fmt.Errorf("Cannot drop table '%s' because it does not exist", name)
+		}
+		dropped = append(dropped, tbl.Name)
+	}
+
+	err := m.dropDependentViews(dropped, dt.Cascade)
+	if err != nil {
+		return err
+	}
+
+	// Foreign keys from tables that are not being dropped block the drop
+	// unless it cascades, which drops the foreign keys instead
+	for _, tbl := range m.model.Tables {
+		if containsString(dropped, tbl.Name) {
+			continue
+		}
+		constraints := []Constraint{}
+		for _, c := range tbl.Constraints {
+			if c.IsForeignKey() && containsString(dropped, c.References.TableName) {
+				if !dt.Cascade {
+					return fmt.Errorf(
+						"Cannot drop table '%s' because the table '%s' has a foreign key that references it",
+						c.References.TableName,
+						tbl.Name,
+					)
+				}
+				continue
+			}
+			constraints = append(constraints, c)
+		}
+		tbl.Constraints = constraints
+	}
+
+	for _, name := range dropped {
+		delete(m.model.Tables, name)
+	}
+	return nil
+}
+
+func (m *ModelBuilder) handleRenameTableStmt(rt RenameTable) error {
+	tbl, exists := m.model.table(rt.From)
+	if !exists {
+		return fmt.Errorf("Cannot rename table '%s' because it does not exist", rt.From)
+	}
+
+	// The table stays in its schema
+	oldName := tbl.Name
+	newName := qualifiedName(tbl.Schema, rt.To)
+	_, taken := m.model.Tables[newName]
+	if !taken {
+		_, taken = m.model.Views[newName]
+	}
+	if taken {
+		return fmt.Errorf(
+			"Cannot rename table '%s' to '%s' because a table with that name already exists",
+			rt.From,
+			rt.To,
+		)
+	}
+
+	delete(m.model.Tables, oldName)
+	tbl.Name = newName
+	m.model.Tables[newName] = tbl
+
+	for _, other := range m.model.Tables {
+		for _, c := range other.ForeignKeysTo(oldName) {
+			c.References.TableName = newName
+		}
+	}
+
+	// Views keep working because Postgres tracks tables by their id
+	for _, view := range m.model.Views {
+		for i, name := range view.DependsOn {
+			if name == oldName {
+				view.DependsOn[i] = newName
+			}
+		}
+		for i, col := range view.Columns {
+			if col.Source.TableName == oldName {
+				view.Columns[i].Source.TableName = newName
+			}
+		}
+		for i, source := range view.ColumnsUsed {
+			if source.TableName == oldName {
+				view.ColumnsUsed[i].TableName = newName
+			}
+		}
+	}
+	return nil
+}
+
+func (m *ModelBuilder) handleRenameColumnStmt(rc RenameColumn) error {
+	tableName := asWritten(rc.WrittenTableName, rc.TableName)
+	from := asWritten(rc.WrittenFrom, rc.From)
+
+	tbl, exists := m.model.table(rc.TableName)
+	if !exists {
+		return fmt.Errorf(
+			"Cannot rename column '%s' because the table '%s' does not exist",
+			from,
+			tableName,
+		)
+	}
+
+	if !tbl.hasColumn(rc.From) {
+		return fmt.Errorf(
+			"Cannot rename column '%s' of the table '%s' because the column does not exist",
+			from,
+			tableName,
+		)
+	}
+
+	if tbl.hasColumn(rc.To) {
+		return fmt.Errorf(
+			"Cannot rename column '%s' of the table '%s' to '%s' because that column already exists",
+			from,
+			tableName,
+			asWritten(rc.WrittenTo, rc.To),
+		)
+	}
+
+	for i, col := range tbl.Columns {
+		if col.Name == rc.From {
+			tbl.Columns[i].Name = rc.To
+		}
+	}
+
+	// Everything that refers to the column by name has to follow it
+	renameColumns := func(columns []string) []string {
+		renamed := []string{}
+		for _, col := range columns {
+			if col == rc.From {
+				col = rc.To
+			}
+			renamed = append(renamed, col)
+		}
+		return renamed
+	}
+	renameInCondition := func(cond Condition) Condition {
+		if cond == nil {
+			return nil
+		}
+		return mapConditionColumns(cond, func(col ColumnExpression) ColumnExpression {
+			if col.ColumnName == rc.From {
+				col.ColumnName = rc.To
+			}
+			return col
+		})
+	}
+
+	for i, c := range tbl.Constraints {
+		tbl.Constraints[i].Columns = renameColumns(c.Columns)
+		tbl.Constraints[i].Check = renameInCondition(c.Check)
+	}
+	for i, index := range tbl.Indexes {
+		tbl.Indexes[i].Columns = renameColumns(index.Columns)
+		tbl.Indexes[i].Where = renameInCondition(index.Where)
+	}
+	for _, other := range m.model.Tables {
+		for _, c := range other.ForeignKeysTo(tbl.Name) {
+			c.References.Columns = renameColumns(c.References.Columns)
+		}
+	}
+	for _, view := range m.model.Views {
+		for i, col := range view.Columns {
+			if col.Source.TableName == tbl.Name && col.Source.ColumnName == rc.From {
+				view.Columns[i].Source.ColumnName = rc.To
+			}
+		}
+		for i, source := range view.ColumnsUsed {
+			if source.TableName == tbl.Name && source.ColumnName == rc.From {
+				view.ColumnsUsed[i].ColumnName = rc.To
+			}
+		}
+	}
+	return nil
+}
+
+func (m *ModelBuilder) handleCreateEnumStmt(ce CreateEnum) error {
+	schema, key, err := m.newName(ce.Name)
+	if err != nil {
+		return err
+	}
+
+	_, exists := m.model.Enums[key]
+	if exists {
+		return fmt.Errorf("Type named '%s' already exists", ce.Name)
+	}
+
+	for i, value := range ce.Values {
+		for _, other := range ce.Values[:i] {
+			if value == other {
+				return fmt.Errorf("Enum '%s' has the value '%s' more than once", ce.Name, value)
+			}
+		}
+	}
+
+	m.model.Enums[key] = &Enum{
+		Name:   key,
+		Schema: schema,
+		Values: ce.Values,
+	}
+	return nil
+}
+
+func (m *ModelBuilder) handleAddEnumValueStmt(av AddEnumValue) error {
+	enum, exists := m.model.enum(av.TypeName)
+	if !exists {
+		return fmt.Errorf(
+			"Cannot add value '%s' because the type '%s' does not exist",
+			av.Value,
+			av.TypeName,
+		)
+	}
+
+	for _, value := range enum.Values {
+		if value == av.Value {
+			if av.IfNotExists {
+				return nil
+			}
+			return fmt.Errorf(
+				"Cannot add value '%s' to the type '%s' because the value already exists",
+				av.Value,
+				av.TypeName,
+			)
+		}
+	}
+
+	position := len(enum.Values)
+	neighbour := av.Before
+	if av.After != "" {
+		neighbour = av.After
+	}
+	if neighbour != "" {
+		position = -1
+		for i, value := range enum.Values {
+			if value == neighbour {
+				position = i
+				if av.After != "" {
+					position++
+				}
+				break
+			}
+		}
+		if position < 0 {
+			return fmt.Errorf(
+				"Cannot add value '%s' next to '%s' because the type '%s' has no such value",
+				av.Value,
+				neighbour,
+				av.TypeName,
+			)
+		}
+	}
+
+	values := append([]string{}, enum.Values[:position]...)
+	values = append(values, av.Value)
+	enum.Values = append(values, enum.Values[position:]...)
+
+	// Columns keep their own copy of the values, including those of views
+	for _, tbl := range m.model.Tables {
+		for i, col := range tbl.Columns {
+			if col.TypeName == enum.Name {
+				tbl.Columns[i].Values = append([]string{}, enum.Values...)
+			}
+		}
+	}
+	for _, view := range m.model.Views {
+		for i, col := range view.Columns {
+			if col.TypeName == enum.Name {
+				view.Columns[i].Values = append([]string{}, enum.Values...)
+			}
+		}
+	}
+	return nil
+}
+
+func (m *ModelBuilder) handleCreateIndexStmt(ci CreateIndex) error {
+	// Materialized views store their rows like tables do, so they can be
+	// indexed too
+	var indexes *[]Index
+	var schema, localName string
+	var hasColumn func(string) bool
+	tbl, isTable := m.model.table(ci.TableName)
+	view, isView := m.model.view(ci.TableName)
+	switch {
+	case isTable:
+		indexes, schema, localName, hasColumn = &tbl.Indexes, tbl.Schema, tbl.localName(), tbl.hasColumn
+	case isView && view.Materialized:
+		indexes, schema, localName, hasColumn = &view.Indexes, view.Schema, view.localName(), view.hasColumn
+	case isView:
+		return fmt.Errorf(
+			"Cannot create index '%s' because '%s' is a view that is not materialized",
+			ci.Name,
+			ci.TableName,
+		)
+	default:
+		return fmt.Errorf(
+			"Cannot create index '%s' because the table '%s' does not exist",
+			ci.Name,
+			ci.TableName,
+		)
+	}
+
+	for _, col := range ci.Columns {
+		if !hasColumn(col) {
+			return fmt.Errorf(
+				"Cannot create index '%s' because the column '%s' does not exist in the table '%s'",
+				ci.Name,
+				col,
+				ci.TableName,
+			)
+		}
+	}
+
+	name := ci.Name
+	if name == "" {
+		name = m.generateIndexName(schema, localName, ci)
+	}
+
+	_, _, _, exists := m.findIndex(joinQualifiedName(schema, name))
+	if exists {
+		if ci.IfNotExists {
+			return nil
+		}
+		return fmt.Errorf("Index named '%s' already exists", name)
+	}
+
+	// Predicates are kept without the table name, like Postgres does, so that
+	// they survive the table being renamed
+	where := ci.Where
+	if where != nil {
+		where = unqualifyCondition(where, localName)
+	}
+
+	*indexes = append(*indexes, Index{
+		Name:           name,
+		Columns:        ci.Columns,
+		Unique:         ci.Unique,
+		Where:          where,
+		HasExpressions: ci.HasExpressions,
+	})
+	return nil
+}
+
+// Picks a name for an index the same way Postgres does, eg: users_email_idx.
+func (m *ModelBuilder) generateIndexName(schema string, localName string, ci CreateIndex) string {
+	parts := append([]string{localName}, ci.Columns...)
+	if ci.HasExpressions {
+		parts = append(parts, "expr")
+	}
+	base := strings.Join(parts, "_") + "_idx"
+
+	name := base
+	for i := 1; ; i++ {
+		_, _, _, exists := m.findIndex(joinQualifiedName(schema, name))
+		if !exists {
+			return name
+		}
+		name = fmt.Sprintf("%s%d", base, i)
+	}
+}
+
+// Index names are unique across the tables and materialized views of a
+// schema. An unqualified name is looked for along the search path. Returns the
+// indexes that hold it, the table they belong to, which is nil for a
+// materialized view, and where it is in them.
+func (m *ModelBuilder) findIndex(name string) (*[]Index, *Table, int, bool) {
+	schemas, bare := m.model.lookupSchemas(name)
+	for _, schema := range schemas {
+		for _, tbl := range m.model.Tables {
+			if tbl.Schema != schema {
+				continue
+			}
+			for i, index := range tbl.Indexes {
+				if index.Name == bare {
+					return &tbl.Indexes, tbl, i, true
+				}
+			}
+		}
+		for _, view := range m.model.Views {
+			if view.Schema != schema {
+				continue
+			}
+			for i, index := range view.Indexes {
+				if index.Name == bare {
+					return &view.Indexes, nil, i, true
+				}
+			}
+		}
+	}
+	return nil, nil, -1, false
+}
+
+func (m *ModelBuilder) handleDropIndexStmt(di DropIndex) error {
+	for _, name := range di.Names {
+		indexes, tbl, i, exists := m.findIndex(name)
+		if !exists {
+			if di.IfExists {
+				continue
+			}
+			return fmt.Errorf("Cannot drop index '%s' because it does not exist", name)
+		}
+
+		index := (*indexes)[i]
+		remaining := append([]Index{}, (*indexes)[:i]...)
+		remaining = append(remaining, (*indexes)[i+1:]...)
+
+		// Foreign keys can reference a unique index just like a unique
+		// constraint, see handleDropConstraintStmt
+		if tbl != nil && index.IsUniqueConstraint() {
+			rest := &Table{Name: tbl.Name, Constraints: tbl.Constraints, Indexes: remaining}
+			if !rest.hasUniqueConstraint(index.Columns) {
+				err := m.dropReferencingForeignKeys(tbl, di.Cascade, func(fk *ForeignKey) bool {
+					return sameColumns(fk.Columns, index.Columns)
+				}, func(other *Table) error {
+					return fmt.Errorf(
+						"Cannot drop index '%s' because the table '%s' has a foreign key that depends on it",
+						name,
+						other.Name,
+					)
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		*indexes = remaining
+	}
+	return nil
+}
+
+func (m *ModelBuilder) handleCreateViewStmt(cv CreateView) error {
+	schema, key, err := m.newName(cv.Name)
+	if err != nil {
+		return err
+	}
+
+	_, exists := m.model.Tables[key]
+	if exists {
+		return fmt.Errorf("Cannot create view '%s' because a table with that name already exists", cv.Name)
+	}
+
+	existing, exists := m.model.Views[key]
+	if exists && cv.IfNotExists {
+		return nil
+	}
+	if exists && !(cv.OrReplace && !existing.Materialized) {
+		return fmt.Errorf("View named '%s' already exists", cv.Name)
+	}
+
+	shape, err := getSelectShape(cv.Query, m.model)
+	if err != nil {
+		return fmt.Errorf("Invalid query for the view '%s': %s", cv.Name, err.Error())
+	}
+
+	if len(cv.ColumnNames) > len(shape.Columns) {
+		return fmt.Errorf(
+			"View '%s' names %d columns but its query only has %d",
+			cv.Name,
+			len(cv.ColumnNames),
+			len(shape.Columns),
+		)
+	}
+	columns := []ColumnDefinition{}
+	for i, col := range shape.Columns {
+		if i < len(cv.ColumnNames) {
+			col.Name = cv.ColumnNames[i]
+		}
+		for _, prev := range columns {
+			if prev.Name == col.Name {
+				return fmt.Errorf("View '%s' has more than one column named '%s'", cv.Name, col.Name)
+			}
+		}
+		columns = append(columns, col)
+	}
+
+	// Postgres only lets a view be replaced by one that keeps the same columns
+	// and adds any new ones at the end
+	if exists {
+		if len(columns) < len(existing.Columns) {
+			return fmt.Errorf("Cannot replace the view '%s' because the new query drops some of its columns", cv.Name)
+		}
+		for i, old := range existing.Columns {
+			col := columns[i]
+			sameType := col.Type == old.Type &&
+				col.TypeName == old.TypeName &&
+				col.ArrayDims == old.ArrayDims
+			if col.Name != old.Name || !sameType {
+				return fmt.Errorf(
+					"Cannot replace the view '%s' because the new query changes the column '%s'",
+					cv.Name,
+					old.Name,
+				)
+			}
+		}
+	}
+
+	dependsOn := []string{}
+	for _, name := range selectTargetNames(cv.Query) {
+		key, _ := m.model.resolveRelation(name)
+		dependsOn = append(dependsOn, key)
+	}
+
+	columnsUsed, err := selectColumnSources(cv.Query, m.model)
+	if err != nil {
+		return fmt.Errorf("Invalid query for the view '%s': %s", cv.Name, err.Error())
+	}
+
+	m.model.Views[key] = &View{
+		Name:         key,
+		Schema:       schema,
+		Columns:      columns,
+		Materialized: cv.Materialized,
+		OneRow:       shape.Type == QueryResultTypeOneRow,
+		DependsOn:    dependsOn,
+		ColumnsUsed:  columnsUsed,
+	}
+	return nil
+}
+
+func (m *ModelBuilder) handleDropViewStmt(dv DropView) error {
+	dropped := []string{}
+	for _, name := range dv.Names {
+		view, exists := m.model.view(name)
+		if !exists {
+			if dv.IfExists {
+				continue
+			}
+			return fmt.Errorf("Cannot drop view '%s' because it does not exist", name)
+		}
+		if view.Materialized != dv.Materialized {
+			if view.Materialized {
+				return fmt.Errorf("Cannot drop '%s' with DROP VIEW because it is a materialized view", name)
+			}
+			return fmt.Errorf("Cannot drop '%s' with DROP MATERIALIZED VIEW because it is not materialized", name)
+		}
+		dropped = append(dropped, view.Name)
+	}
+
+	err := m.dropDependentViews(dropped, dv.Cascade)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range dropped {
+		delete(m.model.Views, name)
+	}
+	return nil
+}
+
+// Views that select from the dropped tables or views block the drop unless it
+// cascades, which drops those views too along with anything built on them.
+func (m *ModelBuilder) dropDependentViews(dropped []string, cascade bool) error {
+	for {
+		found := false
+		for _, view := range m.model.Views {
+			if containsString(dropped, view.Name) {
+				continue
+			}
+			for _, dependency := range view.DependsOn {
+				if !containsString(dropped, dependency) {
+					continue
+				}
+				if !cascade {
+					return fmt.Errorf(
+						"Cannot drop '%s' because the view '%s' depends on it",
+						dependency,
+						view.Name,
+					)
+				}
+				delete(m.model.Views, view.Name)
+				dropped = append(dropped, view.Name)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil
+		}
+	}
+}
+
+// Returns the table columns that the query refers to in any of its clauses,
+// including those in its subselects.
+func selectColumnSources(query Select, model Model) ([]ColumnSource, error) {
+	available, err := getAvailableColumns(query, model)
+	if err != nil {
+		return nil, err
+	}
+
+	sources := []ColumnSource{}
+	add := func(source ColumnSource) {
+		if source.TableName == "" {
+			return
+		}
+		for _, existing := range sources {
+			if existing == source {
+				return
+			}
+		}
+		sources = append(sources, source)
+	}
+
+	visit := func(col ColumnExpression) ColumnExpression {
+		for key, columns := range available {
+			if col.TableName != "" && col.TableName != key {
+				continue
+			}
+			for _, def := range columns {
+				if def.Name == col.ColumnName {
+					add(def.Source)
+				}
+			}
+		}
+		return col
+	}
+	for _, field := range query.Fields {
+		mapExpressionColumns(field.Expr, visit)
+	}
+	for _, join := range query.Joins {
+		mapConditionColumns(join.On, visit)
+	}
+	mapConditionColumns(query.Where, visit)
+	mapConditionColumns(query.Having, visit)
+	for _, order := range query.OrderBy {
+		mapExpressionColumns(order.expr, visit)
+	}
+
+	subselects := []Select{}
+	for _, target := range append([]TargetTable{query.From}, joinTargets(query.Joins)...) {
+		if target.Subselect != nil {
+			subselects = append(subselects, *target.Subselect)
+		}
+	}
+	if query.Next != nil {
+		subselects = append(subselects, query.Next.Query)
+	}
+	for _, subselect := range subselects {
+		subSources, err := selectColumnSources(subselect, model)
+		if err != nil {
+			return nil, err
+		}
+		for _, source := range subSources {
+			add(source)
+		}
+	}
+	return sources, nil
+}
+
+// Returns the names of the views that use the column, in order.
+func (m *ModelBuilder) viewsUsingColumn(tableName string, columnName string) []string {
+	names := []string{}
+	for _, view := range m.model.Views {
+		for _, source := range view.ColumnsUsed {
+			if source.TableName == tableName && source.ColumnName == columnName {
+				names = append(names, view.Name)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Returns the names of the tables and views that the query selects from,
+// including those in sub-selects and the other SELECTs of a UNION.
+func selectTargetNames(query Select) []string {
+	names := []string{}
+	add := func(name string) {
+		if !containsString(names, name) {
+			names = append(names, name)
+		}
+	}
+
+	targets := append([]TargetTable{query.From}, joinTargets(query.Joins)...)
+	for _, target := range targets {
+		if target.Subselect != nil {
+			for _, name := range selectTargetNames(*target.Subselect) {
+				add(name)
+			}
+		} else {
+			add(target.TableName)
+		}
+	}
+	if query.Next != nil {
+		for _, name := range selectTargetNames(query.Next.Query) {
+			add(name)
+		}
+	}
+	return names
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func ModelFromMigrations(migrations []*Migration) (Model, error) {
+	builder := NewModelBuilder()
+	for _, migration := range migrations {
+		// Each migration starts with the default search path because SET
+		// only lasts for the session that runs it
+		builder.model.SearchPath = nil
+
+		prog, err := Parse(migration.UpSQL)
+		if err != nil {
+			return Model{}, err
+		}
+		for _, stmt := range prog.Statements {
+			err = builder.handleStmt(stmt)
+			if err != nil {
+				return Model{}, err
+			}
+		}
+	}
+
+	builder.model.SearchPath = nil
 	return builder.model, nil
 }
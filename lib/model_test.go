@@ -1,6 +1,7 @@
 package lib
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -112,3 +113,676 @@ func TestModelBuilderAddColumnFail(t *testing.T) {
 	})
 	require.Error(t, err)
 }
+
+func TestModelBuilderEnums(t *testing.T) {
+	model, err := ModelFromMigrations([]*Migration{
+		&Migration{UpSQL: `
+			CREATE TYPE status AS ENUM ('open', 'closed');
+			CREATE TABLE issues (id INT NOT NULL, s status NOT NULL);
+			CREATE VIEW issue_statuses AS SELECT s FROM issues;`},
+		&Migration{UpSQL: `
+			ALTER TYPE status ADD VALUE 'in_progress' BEFORE 'closed';
+			ALTER TYPE status ADD VALUE 'archived' AFTER 'closed';
+			ALTER TYPE status ADD VALUE IF NOT EXISTS 'open';`},
+	})
+	require.NoError(t, err)
+
+	expected := []string{"open", "in_progress", "closed", "archived"}
+	require.Equal(t, expected, model.Enums["status"].Values)
+
+	// Columns see values that were added after they were created
+	col := model.Tables["issues"].Columns[1]
+	require.Equal(t, DataTypeEnum, col.Type)
+	require.Equal(t, "status", col.TypeName)
+	require.Equal(t, expected, col.Values)
+	require.Equal(t, expected, model.Views["issue_statuses"].Columns[0].Values)
+}
+
+func TestModelBuilderEnumsFail(t *testing.T) {
+	invalid := []string{
+		"CREATE TABLE issues (s notatype)",
+		"CREATE TYPE status AS ENUM ('a', 'a')",
+		"CREATE TYPE status AS ENUM ('a'); CREATE TYPE status AS ENUM ('b')",
+		"CREATE TYPE status AS ENUM ('a'); ALTER TYPE status ADD VALUE 'a'",
+		"CREATE TYPE status AS ENUM ('a'); ALTER TYPE status ADD VALUE 'b' BEFORE 'c'",
+		"ALTER TYPE status ADD VALUE 'a'",
+	}
+	for _, sql := range invalid {
+		_, err := ModelFromMigrations([]*Migration{&Migration{UpSQL: sql}})
+		require.Error(t, err, sql)
+	}
+}
+
+func TestModelBuilderForeignKeys(t *testing.T) {
+	model, err := ModelFromMigrations([]*Migration{&Migration{UpSQL: `
+		CREATE TABLE projects (
+			tid INT NOT NULL,
+			"key" TEXT NOT NULL,
+			PRIMARY KEY (tid, "key")
+		);
+		CREATE TABLE issues (
+			id INT NOT NULL PRIMARY KEY,
+			tid INT NOT NULL,
+			project_key TEXT NOT NULL,
+			parent_id INT NULL REFERENCES issues ON DELETE CASCADE,
+			FOREIGN KEY (tid, project_key) REFERENCES projects ("key", tid)
+		);
+		ALTER TABLE issues ADD COLUMN duplicate_of INT NULL REFERENCES issues (id);`}})
+	require.NoError(t, err)
+
+	issues := model.Tables["issues"]
+	require.Len(t, issues.ForeignKeysTo("projects"), 1)
+	require.Empty(t, model.Tables["projects"].ForeignKeysTo("issues"))
+
+	fks := issues.ForeignKeysTo("issues")
+	require.Len(t, fks, 2)
+
+	// Implicit references are to the primary key
+	require.Equal(t, []string{"parent_id"}, fks[0].Columns)
+	require.Equal(t, []string{"id"}, fks[0].References.Columns)
+	require.Equal(t, ReferentialActionCascade, fks[0].References.OnDelete)
+	require.Equal(t, []string{"duplicate_of"}, fks[1].Columns)
+}
+
+func TestModelBuilderForeignKeysFail(t *testing.T) {
+	parent := "CREATE TABLE p (id INT NOT NULL PRIMARY KEY, a INT NOT NULL, b INT NOT NULL UNIQUE);"
+	invalid := []string{
+		"CREATE TABLE c (pid INT REFERENCES nope)",
+		"CREATE TABLE c (pid INT REFERENCES p (nope))",
+		"CREATE TABLE c (pid INT REFERENCES p (a))",
+		"CREATE TABLE c (x INT, FOREIGN KEY (nope) REFERENCES p)",
+		"CREATE TABLE c (x INT, y INT, FOREIGN KEY (x, y) REFERENCES p)",
+		"CREATE TABLE c (x INT REFERENCES c)",
+		"CREATE TABLE c (x INT PRIMARY KEY, y INT, PRIMARY KEY (y))",
+		"CREATE TABLE c (x INT); ALTER TABLE c ADD COLUMN y INT REFERENCES p (a)",
+	}
+	for _, sql := range invalid {
+		_, err := ModelFromMigrations([]*Migration{&Migration{UpSQL: parent + sql}})
+		require.Error(t, err, sql)
+	}
+
+	// A unique constraint can be referenced as well as a primary key
+	_, err := ModelFromMigrations([]*Migration{&Migration{UpSQL: parent + "CREATE TABLE c (x INT REFERENCES p (b))"}})
+	require.NoError(t, err)
+}
+
+func TestModelBuilderDefaultsAndChecks(t *testing.T) {
+	migrations, err := ReadMigrationsDir("../test/bugtracker/migrations")
+	require.NoError(t, err)
+	model, err := ModelFromMigrations(migrations)
+	require.NoError(t, err)
+
+	issues := model.Tables["issues"]
+	priority := issues.Columns[len(issues.Columns)-1]
+	require.Equal(t, "priority", priority.Name)
+	require.Equal(t, NumberLiteral{Value: "0"}, priority.Default)
+	require.True(t, priority.HasDefault())
+
+	// NOT NULL without a default has to be supplied by every INSERT
+	require.Equal(t, "tid", issues.Columns[0].Name)
+	require.False(t, issues.Columns[0].HasDefault())
+
+	check := issues.Constraints[len(issues.Constraints)-1]
+	require.Equal(t, ConstraintTypeCheck, check.Type)
+	require.Equal(t, []string{"priority"}, check.Columns)
+	require.NotNil(t, check.Check)
+	require.False(t, check.IsUnique())
+
+	model, err = ModelFromMigrations([]*Migration{&Migration{UpSQL: `
+		CREATE TABLE t (id SERIAL NOT NULL PRIMARY KEY, CHECK (id > 0))`}})
+	require.NoError(t, err)
+	require.True(t, model.Tables["t"].Columns[0].HasDefault())
+	require.Len(t, model.Tables["t"].Constraints, 2)
+}
+
+func TestModelBuilderIndexes(t *testing.T) {
+	model, err := ModelFromMigrations([]*Migration{
+		&Migration{UpSQL: `
+			CREATE TABLE users (id INT NOT NULL PRIMARY KEY, email TEXT NOT NULL, deleted TIMESTAMP NULL);
+			CREATE INDEX ON users (email);
+			CREATE INDEX ON users (email);
+			CREATE UNIQUE INDEX ix_email ON users (email) WHERE deleted IS NULL;
+			CREATE UNIQUE INDEX IF NOT EXISTS ix_email ON users (id);
+			CREATE INDEX ix_deleted ON users (deleted);`},
+		&Migration{UpSQL: `
+			DROP INDEX users_email_idx1, ix_nope;`},
+	})
+	require.Error(t, err)
+
+	model, err = ModelFromMigrations([]*Migration{
+		&Migration{UpSQL: `
+			CREATE TABLE users (id INT NOT NULL PRIMARY KEY, email TEXT NOT NULL, deleted TIMESTAMP NULL);
+			CREATE INDEX ON users (email);
+			CREATE INDEX ON users (email);
+			CREATE UNIQUE INDEX ix_email ON users (email) WHERE deleted IS NULL;
+			CREATE UNIQUE INDEX IF NOT EXISTS ix_email ON users (id);
+			CREATE INDEX ix_deleted ON users (deleted);`},
+		&Migration{UpSQL: `
+			DROP INDEX IF EXISTS users_email_idx1, ix_nope;`},
+	})
+	require.NoError(t, err)
+
+	indexes := model.Tables["users"].Indexes
+	require.Len(t, indexes, 3)
+	require.Equal(t, "users_email_idx", indexes[0].Name)
+	require.False(t, indexes[0].Unique)
+	require.Equal(t, "ix_email", indexes[1].Name)
+	require.Equal(t, []string{"email"}, indexes[1].Columns)
+	require.True(t, indexes[1].Unique)
+	require.NotNil(t, indexes[1].Where)
+	require.False(t, indexes[1].IsUniqueConstraint())
+
+	// Dropping a column drops its indexes
+	b := ModelBuilder{model: model}
+	err = b.handleStmt(DropColumn{TableName: "users", ColumnName: "deleted"})
+	require.NoError(t, err)
+	require.Len(t, model.Tables["users"].Indexes, 1)
+	require.Equal(t, "users_email_idx", model.Tables["users"].Indexes[0].Name)
+
+	// Materialized views can be indexed like tables
+	model, err = ModelFromMigrations([]*Migration{&Migration{UpSQL: `
+		CREATE TABLE users (id INT NOT NULL PRIMARY KEY, email TEXT NOT NULL);
+		CREATE MATERIALIZED VIEW user_emails AS SELECT id, email FROM users;
+		CREATE UNIQUE INDEX ix_user_emails_id ON user_emails (id);
+		CREATE INDEX ON user_emails (email);
+		DROP INDEX user_emails_email_idx;`}})
+	require.NoError(t, err)
+	indexes = model.Views["user_emails"].Indexes
+	require.Len(t, indexes, 1)
+	require.Equal(t, "ix_user_emails_id", indexes[0].Name)
+	require.True(t, indexes[0].IsUniqueConstraint())
+}
+
+func TestModelBuilderIndexesFail(t *testing.T) {
+	table := "CREATE TABLE users (id INT NOT NULL, email TEXT NOT NULL);"
+	invalid := []string{
+		"CREATE INDEX ix ON nope (id)",
+		"CREATE INDEX ix ON users (nope)",
+		"CREATE INDEX ix ON users (id); CREATE INDEX ix ON users (email)",
+		"DROP INDEX ix",
+		"CREATE VIEW v AS SELECT id FROM users; CREATE INDEX ix ON v (id)",
+		"CREATE MATERIALIZED VIEW v AS SELECT id FROM users; CREATE INDEX ix ON v (email)",
+		"CREATE MATERIALIZED VIEW v AS SELECT id FROM users; CREATE INDEX ix ON users (id); CREATE INDEX ix ON v (id)",
+		// Only a unique index that covers every row can be referenced
+		"CREATE UNIQUE INDEX ix ON users (email) WHERE id > 0; CREATE TABLE t (e TEXT REFERENCES users (email))",
+	}
+	for _, sql := range invalid {
+		_, err := ModelFromMigrations([]*Migration{&Migration{UpSQL: table + sql}})
+		require.Error(t, err, sql)
+	}
+
+	_, err := ModelFromMigrations([]*Migration{&Migration{UpSQL: table + `
+		CREATE UNIQUE INDEX ix ON users (email);
+		CREATE TABLE t (e TEXT REFERENCES users (email))`}})
+	require.NoError(t, err)
+
+	// The foreign key depends on the index, so the index can only be dropped
+	// along with it
+	_, err = ModelFromMigrations([]*Migration{&Migration{UpSQL: table + `
+		CREATE UNIQUE INDEX ix ON users (email);
+		CREATE TABLE t (e TEXT REFERENCES users (email));
+		DROP INDEX ix`}})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "Cannot drop index 'ix' because the table 't' has a foreign key that depends on it")
+
+	model, err := ModelFromMigrations([]*Migration{&Migration{UpSQL: table + `
+		CREATE UNIQUE INDEX ix ON users (email);
+		CREATE TABLE t (e TEXT REFERENCES users (email));
+		DROP INDEX ix CASCADE`}})
+	require.NoError(t, err)
+	require.Len(t, model.Tables["users"].Indexes, 0)
+	require.Len(t, model.Tables["t"].ForeignKeysTo("users"), 0)
+}
+
+func TestModelBuilderDropAndRename(t *testing.T) {
+	model, err := ModelFromMigrations([]*Migration{&Migration{UpSQL: `
+		CREATE TABLE groups (id INT NOT NULL PRIMARY KEY);
+		CREATE TABLE users (
+			id INT NOT NULL PRIMARY KEY,
+			group_id INT NOT NULL REFERENCES groups,
+			email TEXT NOT NULL CHECK (email <> '')
+		);
+		CREATE TABLE logins (user_id INT NOT NULL REFERENCES users (id));
+		CREATE TABLE tmp (id INT NOT NULL);
+		CREATE UNIQUE INDEX ix_email ON users (email) WHERE users.email <> 'x';
+		DROP TABLE tmp;
+		DROP TABLE IF EXISTS tmp, nope;
+		ALTER TABLE users RENAME TO people;
+		ALTER TABLE people RENAME COLUMN id TO person_id;
+		ALTER TABLE people RENAME email TO email_address;
+		DROP TABLE groups CASCADE;`}})
+	require.NoError(t, err)
+
+	require.Len(t, model.Tables, 2)
+	people := model.Tables["people"]
+	require.Equal(t, "people", people.Name)
+	require.Equal(t, "person_id", people.Columns[0].Name)
+	require.Equal(t, "email_address", people.Columns[2].Name)
+
+	// The foreign key to groups went with it
+	require.Len(t, people.Constraints, 2)
+	require.Equal(t, []string{"person_id"}, people.Constraints[0].Columns)
+	require.Equal(t, []string{"email_address"}, people.Constraints[1].Columns)
+	require.Equal(t, []string{"email_address"}, conditionColumns(people.Constraints[1].Check))
+	require.Equal(t, []string{"email_address"}, people.Indexes[0].Columns)
+	require.Equal(t, []string{"email_address"}, conditionColumns(people.Indexes[0].Where))
+
+	fks := model.Tables["logins"].ForeignKeysTo("people")
+	require.Len(t, fks, 1)
+	require.Equal(t, []string{"person_id"}, fks[0].References.Columns)
+}
+
+func TestModelBuilderDropAndRenameFail(t *testing.T) {
+	tables := `
+		CREATE TABLE groups (id INT NOT NULL PRIMARY KEY, "name" TEXT NOT NULL);
+		CREATE TABLE users (id INT NOT NULL, group_id INT NOT NULL REFERENCES groups);`
+	invalid := []string{
+		"DROP TABLE nope",
+		"DROP TABLE groups",
+		"DROP TABLE users; SELECT id FROM users",
+		"ALTER TABLE nope RENAME TO other",
+		"ALTER TABLE users RENAME TO groups",
+		"ALTER TABLE users RENAME TO people; ALTER TABLE users RENAME id TO user_id",
+		"ALTER TABLE nope RENAME a TO b",
+		"ALTER TABLE groups RENAME nope TO b",
+		"ALTER TABLE groups RENAME id TO name",
+		"DROP TABLE users; CREATE TABLE t (uid INT REFERENCES users)",
+	}
+	for _, sql := range invalid {
+		migrations := []*Migration{&Migration{UpSQL: tables + sql}}
+		model, err := ModelFromMigrations(migrations)
+		if err == nil {
+			// Queries against dropped tables fail when their shape is found
+			prog, parseErr := Parse(sql[strings.LastIndex(sql, ";")+1:])
+			require.NoError(t, parseErr)
+			_, err = getShape(prog.Statements[0], model)
+		}
+		require.Error(t, err, sql)
+	}
+
+	// Dropping both sides of a foreign key at once is fine
+	_, err := ModelFromMigrations([]*Migration{&Migration{UpSQL: tables + "DROP TABLE groups, users"}})
+	require.NoError(t, err)
+}
+
+func TestModelBuilderAlterTable(t *testing.T) {
+	model, err := ModelFromMigrations([]*Migration{&Migration{UpSQL: `
+		CREATE TYPE mood AS ENUM ('happy', 'sad');
+		CREATE TABLE users (
+			id INT NOT NULL PRIMARY KEY,
+			"name" TEXT NULL,
+			age INT NOT NULL CHECK (age > 0),
+			status TEXT NOT NULL,
+			UNIQUE (id, age)
+		);
+		CREATE TABLE logins (user_id INT NOT NULL, CONSTRAINT fk_user FOREIGN KEY (user_id) REFERENCES users);
+		ALTER TABLE users
+			ADD COLUMN IF NOT EXISTS "name" TEXT NULL,
+			ADD email TEXT NULL,
+			ADD CONSTRAINT uq_email UNIQUE (email),
+			ALTER COLUMN id TYPE BIGINT,
+			ALTER COLUMN status TYPE mood USING status::mood,
+			ALTER "name" SET NOT NULL,
+			ALTER age DROP NOT NULL,
+			ALTER COLUMN email SET DEFAULT 'x',
+			DROP COLUMN IF EXISTS nope,
+			DROP COLUMN age;
+		ALTER TABLE logins DROP CONSTRAINT fk_user, DROP CONSTRAINT IF EXISTS nope;
+		ALTER TABLE users DROP CONSTRAINT users_pkey;`}})
+	require.NoError(t, err)
+
+	users := model.Tables["users"]
+	require.Len(t, users.Columns, 4)
+	require.Equal(t, DataTypeBigInt, users.Columns[0].Type)
+	require.False(t, users.Columns[1].Nullable)
+	require.Equal(t, "mood", users.Columns[2].TypeName)
+	require.Equal(t, []string{"happy", "sad"}, users.Columns[2].Values)
+	require.Equal(t, StringLiteral{Value: "x"}, users.Columns[3].Default)
+
+	// Dropping age took its CHECK and the UNIQUE constraint with it
+	require.Len(t, users.Constraints, 1)
+	require.Equal(t, "uq_email", users.Constraints[0].Name)
+	require.Len(t, model.Tables["logins"].Constraints, 0)
+}
+
+func TestModelBuilderConstraintNames(t *testing.T) {
+	model, err := ModelFromMigrations([]*Migration{&Migration{UpSQL: `
+		CREATE TABLE users (
+			id INT NOT NULL PRIMARY KEY,
+			email TEXT NOT NULL UNIQUE,
+			parent_id INT NULL REFERENCES users,
+			CHECK (email <> ''),
+			UNIQUE (email)
+		);`}})
+	require.NoError(t, err)
+
+	names := []string{}
+	for _, c := range model.Tables["users"].Constraints {
+		names = append(names, c.Name)
+	}
+	require.Equal(t, []string{
+		"users_pkey",
+		"users_email_key",
+		"users_parent_id_fkey",
+		"users_email_check",
+		"users_email_key1",
+	}, names)
+}
+
+func TestModelBuilderAlterTableFail(t *testing.T) {
+	tables := `
+		CREATE TABLE groups (id INT NOT NULL PRIMARY KEY, code TEXT NOT NULL UNIQUE);
+		CREATE TABLE users (
+			id INT NOT NULL PRIMARY KEY,
+			group_code TEXT NOT NULL REFERENCES groups (code),
+			CONSTRAINT ck_id CHECK (id > 0)
+		);`
+	invalid := []string{
+		"ALTER TABLE users ADD COLUMN id INT",
+		"ALTER TABLE nope ADD COLUMN a INT",
+		"ALTER TABLE users ADD CONSTRAINT ck_id CHECK (id < 10)",
+		"ALTER TABLE users ADD PRIMARY KEY (group_code)",
+		"ALTER TABLE users ADD UNIQUE (nope)",
+		"ALTER TABLE nope ADD UNIQUE (id)",
+		"ALTER TABLE users DROP COLUMN nope",
+		"ALTER TABLE groups DROP COLUMN code",
+		"ALTER TABLE users DROP CONSTRAINT nope",
+		"ALTER TABLE nope DROP CONSTRAINT ck_id",
+		"ALTER TABLE groups DROP CONSTRAINT groups_code_key",
+		"ALTER TABLE users ALTER COLUMN nope SET NOT NULL",
+		"ALTER TABLE nope ALTER COLUMN id SET NOT NULL",
+		"ALTER TABLE users ALTER COLUMN id DROP NOT NULL",
+		"ALTER TABLE users ALTER COLUMN id TYPE nope",
+		"ALTER TABLE users ALTER COLUMN nope DROP DEFAULT",
+	}
+	for _, sql := range invalid {
+		migrations := []*Migration{&Migration{UpSQL: tables + sql}}
+		_, err := ModelFromMigrations(migrations)
+		require.Error(t, err, sql)
+	}
+
+	// Cascading drops the foreign keys that depend on the column or constraint
+	valid := []string{
+		"ALTER TABLE groups DROP COLUMN code CASCADE",
+		"ALTER TABLE groups DROP CONSTRAINT groups_code_key CASCADE",
+		"CREATE UNIQUE INDEX ix_code ON groups (code); ALTER TABLE groups DROP CONSTRAINT groups_code_key",
+	}
+	for _, sql := range valid {
+		migrations := []*Migration{&Migration{UpSQL: tables + sql}}
+		model, err := ModelFromMigrations(migrations)
+		require.NoError(t, err, sql)
+		fks := model.Tables["users"].ForeignKeysTo("groups")
+		if strings.Contains(sql, "CASCADE") {
+			require.Len(t, fks, 0, sql)
+		} else {
+			require.Len(t, fks, 1, sql)
+		}
+	}
+}
+
+func TestModelBuilderViews(t *testing.T) {
+	model, err := ModelFromMigrations([]*Migration{&Migration{UpSQL: `
+		CREATE TABLE users (id INT NOT NULL PRIMARY KEY, email TEXT NOT NULL);
+		CREATE TABLE logins (user_id INT NOT NULL, at TIMESTAMP NOT NULL);
+		CREATE VIEW user_emails AS SELECT id, email FROM users;
+		CREATE OR REPLACE VIEW user_emails AS SELECT id, email, email AS contact FROM users;
+		CREATE MATERIALIZED VIEW user_logins AS
+			SELECT e.id, l.at FROM user_emails e JOIN logins l ON l.user_id = e.id;
+		CREATE MATERIALIZED VIEW IF NOT EXISTS user_logins AS SELECT id FROM users;
+		ALTER TABLE users RENAME TO people;
+		CREATE VIEW tmp AS SELECT id FROM people;
+		DROP VIEW tmp;
+		DROP TABLE logins CASCADE;`}})
+	require.NoError(t, err)
+
+	require.Len(t, model.Views, 1)
+	view := model.Views["user_emails"]
+	require.False(t, view.Materialized)
+	require.Equal(t, []string{"people"}, view.DependsOn)
+	require.Len(t, view.Columns, 3)
+	require.Equal(t, "contact", view.Columns[2].Name)
+	require.Equal(t, "people", view.Columns[0].Source.TableName)
+}
+
+func TestModelBuilderViewsFail(t *testing.T) {
+	tables := `
+		CREATE TABLE users (id INT NOT NULL PRIMARY KEY, email TEXT NOT NULL);
+		CREATE VIEW user_emails AS SELECT id, email FROM users;
+		CREATE MATERIALIZED VIEW user_ids AS SELECT id FROM user_emails;`
+	invalid := []string{
+		"CREATE VIEW users AS SELECT id FROM users",
+		"CREATE TABLE user_emails (id INT)",
+		"CREATE VIEW user_emails AS SELECT id FROM users",
+		"CREATE VIEW v AS SELECT nope FROM users",
+		"CREATE VIEW v AS SELECT id FROM nope",
+		"CREATE VIEW v (a, b, c) AS SELECT id, email FROM users",
+		"CREATE VIEW v (email) AS SELECT id, email FROM users",
+		"CREATE OR REPLACE VIEW user_emails AS SELECT id FROM users",
+		"CREATE OR REPLACE VIEW user_emails AS SELECT email, id FROM users",
+		"CREATE OR REPLACE VIEW user_emails AS SELECT id, id AS email FROM users",
+		"CREATE MATERIALIZED VIEW user_ids AS SELECT id FROM users",
+		"ALTER TABLE users RENAME TO user_emails",
+		"DROP VIEW nope",
+		"DROP VIEW user_ids",
+		"DROP MATERIALIZED VIEW user_emails",
+		"DROP VIEW user_emails",
+		"DROP TABLE users",
+		"CREATE TABLE logins (id INT NOT NULL); CREATE VIEW ids AS SELECT id FROM users UNION SELECT id FROM logins; DROP TABLE logins",
+	}
+	for _, sql := range invalid {
+		migrations := []*Migration{&Migration{UpSQL: tables + sql}}
+		_, err := ModelFromMigrations(migrations)
+		require.Error(t, err, sql)
+	}
+
+	// Cascading drops everything built on top of the dropped object
+	model, err := ModelFromMigrations([]*Migration{&Migration{UpSQL: tables + "DROP TABLE users CASCADE"}})
+	require.NoError(t, err)
+	require.Len(t, model.Views, 0)
+}
+
+func TestModelBuilderViewColumnDependencies(t *testing.T) {
+	tables := `
+		CREATE TABLE users (id INT NOT NULL PRIMARY KEY, email TEXT NOT NULL, deleted BOOLEAN NOT NULL, age INT NULL);
+		CREATE VIEW live_users AS SELECT id FROM users WHERE deleted = 'false';
+		CREATE VIEW live_ids AS SELECT id FROM live_users;
+		CREATE VIEW contacts AS SELECT u.email FROM (SELECT email, age FROM users) u;`
+	invalid := map[string]string{
+		"ALTER TABLE users DROP COLUMN deleted":                                               "Cannot drop column 'deleted' from the table 'users' because the view 'live_users' depends on it",
+		"ALTER TABLE users DROP COLUMN age":                                                   "Cannot drop column 'age' from the table 'users' because the view 'contacts' depends on it",
+		"ALTER TABLE users ALTER COLUMN id TYPE BIGINT":                                       "Cannot change the type of the column 'id' of the table 'users' because the view 'live_ids' depends on it",
+		"ALTER TABLE users RENAME COLUMN deleted TO gone; ALTER TABLE users DROP COLUMN gone": "Cannot drop column 'gone' from the table 'users' because the view 'live_users' depends on it",
+		"CREATE TABLE admins (id INT NOT NULL); CREATE VIEW all_ids AS SELECT id FROM users UNION SELECT id FROM admins; ALTER TABLE admins DROP COLUMN id": "Cannot drop column 'id' from the table 'admins' because the view 'all_ids' depends on it",
+	}
+	for sql, message := range invalid {
+		_, err := ModelFromMigrations([]*Migration{&Migration{UpSQL: tables + sql}})
+		require.Error(t, err, sql)
+		require.Contains(t, err.Error(), message, sql)
+	}
+
+	// Columns that no view uses can change freely
+	model, err := ModelFromMigrations([]*Migration{&Migration{UpSQL: `
+		CREATE TABLE users (id INT NOT NULL PRIMARY KEY, email TEXT NOT NULL, deleted BOOLEAN NOT NULL);
+		CREATE VIEW live_users AS SELECT id FROM users WHERE deleted = 'false';
+		ALTER TABLE users ALTER COLUMN email TYPE VARCHAR(200);
+		ALTER TABLE users DROP COLUMN email;`}})
+	require.NoError(t, err)
+	require.Len(t, model.Views, 1)
+
+	// Cascading drops the views that use the column and those built on them
+	model, err = ModelFromMigrations([]*Migration{&Migration{UpSQL: tables + `
+		ALTER TABLE users DROP COLUMN deleted CASCADE`}})
+	require.NoError(t, err)
+	require.Len(t, model.Views, 1)
+	require.Contains(t, model.Views, "contacts")
+}
+
+func TestModelBuilderSchemas(t *testing.T) {
+	migrations := []*Migration{
+		&Migration{UpSQL: `
+			CREATE SCHEMA audit;
+			CREATE TYPE audit.level AS ENUM ('info', 'error');
+			CREATE TABLE users (id INT NOT NULL PRIMARY KEY);
+			CREATE TABLE audit.users (id INT NOT NULL PRIMARY KEY, level audit.level NOT NULL);
+			SET search_path TO audit, public;
+			CREATE TABLE events (
+				id INT NOT NULL PRIMARY KEY,
+				user_id INT NOT NULL REFERENCES public.users,
+				audit_user_id INT NOT NULL REFERENCES users
+			);
+			CREATE INDEX ix_user ON events (user_id);
+			CREATE INDEX ix_user ON public.users (id);
+			ALTER TYPE level ADD VALUE 'warning' BEFORE 'error';
+			ALTER TABLE events RENAME TO entries;`},
+		&Migration{UpSQL: `
+			CREATE TABLE logins (user_id INT NOT NULL REFERENCES users);
+			DROP INDEX audit.ix_user;`},
+	}
+	model, err := ModelFromMigrations(migrations)
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"public", "audit"}, model.Schemas)
+	require.Len(t, model.SearchPath, 0)
+	require.Len(t, model.Tables, 4)
+
+	entries := model.Tables["audit.entries"]
+	require.Equal(t, "audit", entries.Schema)
+	require.Equal(t, "users", entries.Constraints[1].References.TableName)
+	require.Equal(t, "audit.users", entries.Constraints[2].References.TableName)
+	require.Equal(t, "events_pkey", entries.Constraints[0].Name)
+	require.Len(t, entries.Indexes, 0)
+	require.Len(t, model.Tables["users"].Indexes, 1)
+
+	level := model.Tables["audit.users"].Columns[1]
+	require.Equal(t, "audit.level", level.TypeName)
+	require.Equal(t, []string{"info", "warning", "error"}, level.Values)
+
+	// The second migration started with the default search path again
+	logins := model.Tables["logins"]
+	require.Equal(t, "public", logins.Schema)
+	require.Equal(t, "users", logins.Constraints[0].References.TableName)
+
+	// Queries use the search path of the model
+	model.SearchPath = []string{"audit", "public"}
+	tbl, ok := model.table("users")
+	require.True(t, ok)
+	require.Equal(t, "audit.users", tbl.Name)
+	tbl, ok = model.table("public.users")
+	require.True(t, ok)
+	require.Equal(t, "users", tbl.Name)
+}
+
+func TestModelBuilderQuotedSchemas(t *testing.T) {
+	migrations := []*Migration{
+		&Migration{UpSQL: `
+			CREATE SCHEMA a;
+			CREATE SCHEMA "a.b";
+			CREATE TABLE "a.b".c (id INT NOT NULL PRIMARY KEY);
+			CREATE TABLE a."b.c" (id INT NOT NULL PRIMARY KEY, name TEXT NOT NULL);
+			CREATE INDEX ix_name ON a."b.c" (name);`},
+	}
+	model, err := ModelFromMigrations(migrations)
+	require.NoError(t, err)
+	require.Len(t, model.Tables, 2)
+
+	first := model.Tables[`"a.b".c`]
+	require.Equal(t, "a.b", first.Schema)
+	require.Equal(t, "c", first.localName())
+	require.Len(t, first.Columns, 1)
+
+	second := model.Tables[`a."b.c"`]
+	require.Equal(t, "a", second.Schema)
+	require.Equal(t, "b.c", second.localName())
+	require.Len(t, second.Columns, 2)
+	require.Equal(t, "ix_name", second.Indexes[0].Name)
+}
+
+func TestModelBuilderSchemasFail(t *testing.T) {
+	setup := "CREATE SCHEMA audit; CREATE TABLE audit.events (id INT NOT NULL);"
+	invalid := []string{
+		"CREATE SCHEMA audit",
+		"CREATE TABLE nope.events (id INT)",
+		"CREATE TABLE audit.events (id INT)",
+		"SET search_path TO nope; CREATE TABLE t (id INT)",
+		"CREATE TYPE nope.level AS ENUM ('a')",
+		"CREATE TABLE t (id INT NOT NULL REFERENCES events)",
+		"CREATE TABLE t (level audit.level)",
+		"DROP TABLE events",
+		"CREATE INDEX ix ON audit.events (id); CREATE INDEX ix ON audit.events (id)",
+	}
+	for _, sql := range invalid {
+		migrations := []*Migration{&Migration{UpSQL: setup + sql}}
+		_, err := ModelFromMigrations(migrations)
+		require.Error(t, err, sql)
+	}
+
+	_, err := ModelFromMigrations([]*Migration{&Migration{UpSQL: setup + `
+		CREATE SCHEMA IF NOT EXISTS audit;
+		CREATE TABLE events (id INT NOT NULL);
+		CREATE INDEX ix ON audit.events (id);
+		CREATE INDEX ix ON events (id);`}})
+	require.NoError(t, err)
+}
+
+func TestModelBuilderIdentifierCase(t *testing.T) {
+	migrations := []*Migration{
+		&Migration{UpSQL: `
+			CREATE TABLE Users (Id INT NOT NULL PRIMARY KEY);
+			CREATE TABLE "Users" ("Id" INT NOT NULL, "from" TEXT NOT NULL);
+			ALTER TABLE USERS ADD COLUMN Email TEXT NOT NULL;`},
+	}
+	model, err := ModelFromMigrations(migrations)
+	require.NoError(t, err)
+	require.Len(t, model.Tables, 2)
+
+	users := model.Tables["users"]
+	require.Equal(t, "id", users.Columns[0].Name)
+	require.Equal(t, "email", users.Columns[1].Name)
+
+	quoted := model.Tables["Users"]
+	require.Equal(t, "Id", quoted.Columns[0].Name)
+	require.Equal(t, "from", quoted.Columns[1].Name)
+
+	prog, err := Parse(`
+		SELECT ID, EMAIL FROM users;
+		SELECT "Id", u."from" FROM "Users" u;`)
+	require.NoError(t, err)
+
+	shape, err := getShape(prog.Statements[0], model)
+	require.NoError(t, err)
+	require.Equal(t, "id", shape.Columns[0].Name)
+	require.Equal(t, "email", shape.Columns[1].Name)
+
+	shape, err = getShape(prog.Statements[1], model)
+	require.NoError(t, err)
+	require.Equal(t, "Id", shape.Columns[0].Name)
+	require.Equal(t, "from", shape.Columns[1].Name)
+
+	prog, err = Parse(`SELECT "id" FROM "Users"`)
+	require.NoError(t, err)
+	_, err = getShape(prog.Statements[0], model)
+	require.Error(t, err)
+}
+
+func TestModelBuilderErrorsShowNamesAsWritten(t *testing.T) {
+	tables := `
+		CREATE TABLE users (id INT NOT NULL PRIMARY KEY, email TEXT NOT NULL);
+		CREATE VIEW emails AS SELECT Email FROM users;`
+	invalid := map[string]string{
+		"ALTER TABLE USERS DROP COLUMN Foo":                    "Cannot drop column 'Foo' from the table 'USERS' because the column does not exist",
+		"ALTER TABLE Nope DROP COLUMN Foo":                     "Cannot drop column 'Foo' because the table 'Nope' does not exist",
+		"ALTER TABLE Users DROP COLUMN EMAIL":                  "Cannot drop column 'EMAIL' from the table 'Users' because the view 'emails' depends on it",
+		`ALTER TABLE users ALTER COLUMN "Email" DROP NOT NULL`: `Cannot alter column '"Email"' of the table 'users' because the column does not exist`,
+		"ALTER TABLE Nope ALTER COLUMN Id SET NOT NULL":        "Cannot alter column 'Id' because the table 'Nope' does not exist",
+		"ALTER TABLE Users ALTER COLUMN ID DROP NOT NULL":      "Cannot drop NOT NULL from the column 'ID' of the table 'Users' because it is in the primary key",
+		"ALTER TABLE USERS ALTER COLUMN Email TYPE VARCHAR":    "Cannot change the type of the column 'Email' of the table 'USERS' because the view 'emails' depends on it",
+		"ALTER TABLE Users RENAME COLUMN Foo TO Bar":           "Cannot rename column 'Foo' of the table 'Users' because the column does not exist",
+		"ALTER TABLE Users RENAME COLUMN ID TO Email":          "Cannot rename column 'ID' of the table 'Users' to 'Email' because that column already exists",
+		"ALTER TABLE Nope RENAME COLUMN Foo TO Bar":            "Cannot rename column 'Foo' because the table 'Nope' does not exist",
+	}
+	for sql, message := range invalid {
+		_, err := ModelFromMigrations([]*Migration{&Migration{UpSQL: tables + sql}})
+		require.Error(t, err, sql)
+		require.Contains(t, err.Error(), message, sql)
+	}
+}
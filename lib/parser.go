@@ -11,9 +11,15 @@ func Parse(sql string) (Program, error) {
 	buffer := newTokenBuffer()
 	p := parser{reader: buffer, parameters: []Parameter{}}
 	var lexErr error = nil
+	comments := []comment{}
 
 	go (func() {
-		lexErr = lex(sql, buffer.Write)
+		lexErr = lex(sql, func(tok token) {
+			comments = append(comments, tok.trivia...)
+			if tok.tokType != tokenTypeEOF {
+				buffer.Write(tok)
+			}
+		})
 		buffer.Done()
 	})()
 
@@ -21,12 +27,42 @@ func Parse(sql string) (Program, error) {
 	if err == nil {
 		err = lexErr
 	}
+	if err != nil {
+		return prog, err
+	}
+
+	// Every token has been read by now so the comments are all there
+	err = applyParamAnnotations(prog.Parameters, comments)
 	return prog, err
 }
 
 type parser struct {
 	reader     tokenReader
 	parameters []Parameter
+
+	// Where each of the parameters is first used, in the same order.
+	parameterLocations []charLocation
+
+	// Every place that an optional parameter like $status? is used, and the
+	// comparisons that they were found in.
+	optionalUses       []optionalUse
+	optionalConditions []optionalCondition
+}
+
+type optionalUse struct {
+	name     string
+	index    int
+	location charLocation
+	claimed  bool
+}
+
+// A comparison like "status = $status?" that should be true when any of the
+// optional parameters in it are NULL. Start and end are the indexes of its
+// first token and the token after its last one.
+type optionalCondition struct {
+	start  int
+	end    int
+	params []string
 }
 
 func (p *parser) scan() (Program, error) {
@@ -89,9 +125,126 @@ func (p *parser) scan() (Program, error) {
 				continue
 			}
 
+			// CREATE TYPE ...
+			if isKeyword(tok, "TYPE") {
+				createTypeStatement, err := p.scanCreateType()
+				if err != nil {
+					return Program{}, err
+				}
+				statements = append(statements, createTypeStatement)
+				requireSemicolon = true
+				continue
+			}
+
+			// CREATE [UNIQUE] INDEX ...
+			if isKeyword(tok, "UNIQUE") || isKeyword(tok, "INDEX") {
+				unique := isKeyword(tok, "UNIQUE")
+				if unique && !p.checkWord("INDEX") {
+					return Program{}, errors.New("Expecting 'UNIQUE' to be followed by 'INDEX' but was not.")
+				}
+				createIndexStatement, err := p.scanCreateIndex(unique)
+				if err != nil {
+					return Program{}, err
+				}
+				statements = append(statements, createIndexStatement)
+				requireSemicolon = true
+				continue
+			}
+
+			// CREATE SCHEMA ...
+			if isKeyword(tok, "SCHEMA") {
+				createSchemaStatement, err := p.scanCreateSchema()
+				if err != nil {
+					return Program{}, err
+				}
+				statements = append(statements, createSchemaStatement)
+				requireSemicolon = true
+				continue
+			}
+
+			// CREATE [OR REPLACE] [MATERIALIZED] VIEW ...
+			if isKeyword(tok, "OR") || isKeyword(tok, "MATERIALIZED") || isKeyword(tok, "VIEW") {
+				createViewStatement, err := p.scanCreateView(tok)
+				if err != nil {
+					return Program{}, err
+				}
+				statements = append(statements, createViewStatement)
+				requireSemicolon = true
+				continue
+			}
+
 			return Program{}, fmt.Errorf("Invalid CREATE statement at %s", tokenString(tok))
 		}
 
+		// DROP ...
+		if isKeyword(tok, "DROP") {
+			tok, err = p.requireToken(tokenTypeWord)
+			if err != nil {
+				return Program{}, err
+			}
+
+			// DROP TABLE ...
+			if isKeyword(tok, "TABLE") {
+				dropTableStatement, err := p.scanDropTable()
+				if err != nil {
+					return Program{}, err
+				}
+				statements = append(statements, dropTableStatement)
+				requireSemicolon = true
+				continue
+			}
+
+			// DROP INDEX ...
+			if isKeyword(tok, "INDEX") {
+				dropIndexStatement, err := p.scanDropIndex()
+				if err != nil {
+					return Program{}, err
+				}
+				statements = append(statements, dropIndexStatement)
+				requireSemicolon = true
+				continue
+			}
+
+			// DROP [MATERIALIZED] VIEW ...
+			if isKeyword(tok, "MATERIALIZED") || isKeyword(tok, "VIEW") {
+				materialized := isKeyword(tok, "MATERIALIZED")
+				if materialized && !p.checkWord("VIEW") {
+					return Program{}, errors.New("Expecting 'MATERIALIZED' to be followed by 'VIEW' but was not.")
+				}
+				dropViewStatement, err := p.scanDropView(materialized)
+				if err != nil {
+					return Program{}, err
+				}
+				statements = append(statements, dropViewStatement)
+				requireSemicolon = true
+				continue
+			}
+
+			return Program{}, fmt.Errorf("Invalid DROP statement at %s", tokenString(tok))
+		}
+
+		// SET ...
+		if isKeyword(tok, "SET") {
+			setStatement, err := p.scanSet()
+			if err != nil {
+				return Program{}, err
+			}
+			statements = append(statements, setStatement)
+			requireSemicolon = true
+			continue
+		}
+
+		// REFRESH MATERIALIZED VIEW ...
+		if isKeyword(tok, "REFRESH") {
+			refreshStatement, err := p.scanRefreshMaterializedView()
+			if err != nil {
+				return Program{}, err
+			}
+			statements = append(statements, refreshStatement)
+			requireSemicolon = true
+			continue
+		}
+
 		// ALTER ...
 		if isKeyword(tok, "ALTER") {
 			tok, err = p.requireToken(tokenTypeWord)
@@ -101,11 +254,22 @@ func (p *parser) scan() (Program, error) {
 
 			// ALTER TABLE ...
 			if isKeyword(tok, "TABLE") {
-				alterTableStatement, err := p.scanAlterTable()
+				alterTableStatements, err := p.scanAlterTable()
+				if err != nil {
+					return Program{}, err
+				}
+				statements = append(statements, alterTableStatements...)
+				requireSemicolon = true
+				continue
+			}
+
+			// ALTER TYPE ...
+			if isKeyword(tok, "TYPE") {
+				alterTypeStatement, err := p.scanAlterType()
 				if err != nil {
 					return Program{}, err
 				}
-				statements = append(statements, alterTableStatement)
+				statements = append(statements, alterTypeStatement)
 				requireSemicolon = true
 				continue
 			}
@@ -116,14 +280,25 @@ func (p *parser) scan() (Program, error) {
 		return Program{}, fmt.Errorf("Expecting start of statement but got <%s>", tokenString(tok))
 	}
 
+	for _, use := range p.optionalUses {
+		if !use.claimed {
+			return Program{}, newLocatedError(
+				use.location,
+				"The optional parameter '$%s' can only be used in a comparison like 'status = $status?'",
+				use.name)
+		}
+	}
+
 	return Program{
-		Statements: statements,
-		Parameters: p.parameters,
+		Statements:         statements,
+		Parameters:         p.parameters,
+		parameterLocations: p.parameterLocations,
+		optionalConditions: p.optionalConditions,
 	}, nil
 }
 
 func isKeyword(tok token, keyword string) bool {
-	return tok.tokType == tokenTypeWord && strings.EqualFold(string(tok.value), keyword)
+	return tok.tokType == tokenTypeWord && !tok.quoted && strings.EqualFold(string(tok.value), keyword)
 }
 
 // Reads after "INSERT"
@@ -139,6 +314,11 @@ func (p *parser) scanInsert() (Statement, error) {
 	}
 
 	// INSERT INTO table_name...
+	targetTok, _, err := p.reader.Peek()
+	if err != nil {
+		return Insert{}, err
+	}
+	result.TargetLocation = targetTok.location
 	target, err := p.scanTargetTable()
 	if err != nil {
 		return Insert{}, err
@@ -155,7 +335,7 @@ func (p *parser) scanInsert() (Statement, error) {
 	}
 
 	// INSERT INTO table_name (col1, col2...
-	exprs, err := p.scanExprList()
+	exprs, locations, err := p.scanExprList()
 	if err != nil {
 		return Insert{}, err
 	}
@@ -167,17 +347,19 @@ func (p *parser) scanInsert() (Statement, error) {
 		if colExpr.TableName == "" {
 			if target.Alias == "" {
 				colExpr.TableName = target.TableName
+				colExpr.WrittenTableName = target.WrittenTableName
 			} else {
 				colExpr.TableName = target.Alias
 			}
 		}
 		result.Columns = append(result.Columns, colExpr)
 	}
+	result.ColumnLocations = locations
 
 	// INSERT INTO table_name (col1, col2)...
 	_, err = p.requireToken(tokenTypeRParen)
 	if err != nil {
-		return Insert{}, nil
+		return Insert{}, err
 	}
 
 	// INSERT INTO table_name (col1, col2) VALUES...
@@ -192,240 +374,999 @@ func (p *parser) scanInsert() (Statement, error) {
 	}
 
 	// INSERT INTO table_name (col1, col2) VALUES ('one', 'two'...
-	valExprs, err := p.scanExprList()
+	valExprs, valLocations, err := p.scanExprList()
 	if err != nil {
 		return Insert{}, err
 	}
 	result.Values = valExprs
+	result.ValueLocations = valLocations
 
 	// INSERT INTO table_name (col1, col2) VALUES ('one', 'two')
 	_, err = p.requireToken(tokenTypeRParen)
 	if err != nil {
-		return Insert{}, nil
+		return Insert{}, err
 	}
 
 	return result, nil
 }
 
-// Reads after "ALTER TABLE"
-func (p *parser) scanAlterTable() (Statement, error) {
+// Reads after "ALTER TABLE". Actions separated by commas each become their own
+// statement, eg:
+//   ALTER TABLE users ADD COLUMN email TEXT NULL, DROP COLUMN name
+func (p *parser) scanAlterTable() ([]Statement, error) {
+	p.checkWord("ONLY")
 
 	// get table name
-	nameTok, err := p.requireToken(tokenTypeWord)
+	nameTok, err := p.requireQualifiedName()
 	if err != nil {
-		return AddColumn{}, nil
+		return nil, err
 	}
 
-	next, err := p.requireToken(tokenTypeWord)
-	if err != nil {
-		return AddColumn{}, nil
+	// ALTER TABLE ___ RENAME ... can't be combined with other actions
+	if p.checkWord("RENAME") {
+		stmt, err := p.scanRename(nameTok)
+		if err != nil {
+			return nil, err
+		}
+		return []Statement{stmt}, nil
 	}
 
-	// ALTER TABLE ___ ADD ...
-	if isKeyword(next, "ADD") {
-		next, err = p.requireToken(tokenTypeWord)
+	statements := []Statement{}
+	for {
+		stmt, err := p.scanAlterTableAction(nameTok)
 		if err != nil {
-			return AddColumn{}, nil
+			return nil, err
 		}
+		statements = append(statements, stmt)
 
-		// ALTER TABLE ___ ADD COLUMN ...
-		if isKeyword(next, "COLUMN") {
-			def, _, _, err := p.scanColumnDef()
-			if err != nil {
-				return AddColumn{}, err
-			}
-			return AddColumn{
-				TableName: string(nameTok.value),
-				Column:    def,
-			}, nil
+		_, more := p.checkToken(tokenTypeComma)
+		if !more {
+			return statements, nil
 		}
 	}
-
-	return AddColumn{}, fmt.Errorf("Unsupported ALTER TABLE statement at <%s>", tokenString(next))
 }
 
-// Reads after "CREATE TABLE"
-func (p *parser) scanCreateTable() (CreateTable, error) {
-
-	var more bool
-
-	// get table name
-	nameTok, err := p.requireToken(tokenTypeWord)
-	if err != nil {
-		return CreateTable{}, err
-	}
-	createTable := CreateTable{
-		Name:    string(nameTok.value),
-		Columns: []ColumnDefinition{},
-	}
-
-	// look for '(' to start column list
-	_, err = p.requireToken(tokenTypeLParen)
+// Reads one action of an ALTER TABLE statement like "ADD COLUMN ..." or
+// "ALTER COLUMN ...".
+func (p *parser) scanAlterTableAction(nameTok token) (Statement, error) {
+	tableName := string(nameTok.value)
+	next, err := p.requireToken(tokenTypeWord)
 	if err != nil {
-		return CreateTable{}, err
+		return nil, err
 	}
 
-	// columns
-	for {
-		// Stop looping if hit ')'. There may be zero columns!
-		_, foundRParen := p.peekToken(tokenTypeRParen)
-		if foundRParen {
-			_, _, err := p.reader.Next()
+	// ALTER TABLE ___ ADD ...
+	if isKeyword(next, "ADD") {
+		// ALTER TABLE ___ ADD [CONSTRAINT name] UNIQUE ...
+		if p.peekTableConstraint() {
+			constraint, err := p.scanTableConstraint()
 			if err != nil {
-				return CreateTable{}, err
+				return nil, err
 			}
-			break
+			if constraint == nil {
+				return nil, errors.New("Only PRIMARY KEY, UNIQUE, FOREIGN KEY and CHECK constraints can be added")
+			}
+			return AddConstraint{
+				TableName:  tableName,
+				Constraint: *constraint,
+			}, nil
 		}
 
-		// Identify PRIMARY KEY shorthand in column definition
-		if p.checkWord("PRIMARY") {
-			more, err = p.skipColumnDef()
-			if err != nil {
-				return CreateTable{}, nil
-			}
-			if !more {
-				break
+		// ALTER TABLE ___ ADD [COLUMN] [IF NOT EXISTS] ...
+		p.checkWord("COLUMN")
+		add := AddColumn{TableName: tableName}
+		if p.checkWord("IF") {
+			if !p.checkWord("NOT") || !p.checkWord("EXISTS") {
+				return nil, errors.New("Expecting 'IF' to be followed by 'NOT EXISTS' but was not.")
 			}
+			add.IfNotExists = true
+		}
+		add.Column, add.Constraints, err = p.scanColumnDef()
+		if err != nil {
+			return nil, err
 		}
+		return add, nil
+	}
 
-		// Skip CONSTRAINT because we don't need that info
+	// ALTER TABLE ___ DROP ...
+	if isKeyword(next, "DROP") {
+		// ALTER TABLE ___ DROP CONSTRAINT [IF EXISTS] name
 		if p.checkWord("CONSTRAINT") {
-			more, err = p.skipColumnDef()
+			drop := DropConstraint{TableName: tableName}
+			drop.IfExists, err = p.scanIfExists()
 			if err != nil {
-				return CreateTable{}, nil
+				return nil, err
 			}
-			if !more {
-				break
+			nameTok, err := p.requireToken(tokenTypeWord)
+			if err != nil {
+				return nil, err
 			}
+			drop.Name = string(nameTok.value)
+			drop.Cascade = p.scanDropBehavior()
+			return drop, nil
 		}
 
-		col, constraints, more, err := p.scanColumnDef()
+		// ALTER TABLE ___ DROP [COLUMN] [IF EXISTS] name
+		p.checkWord("COLUMN")
+		drop := DropColumn{TableName: tableName, WrittenTableName: writtenName(nameTok)}
+		drop.IfExists, err = p.scanIfExists()
 		if err != nil {
-			return CreateTable{}, err
+			return nil, err
+		}
+		colTok, err := p.requireToken(tokenTypeWord)
+		if err != nil {
+			return nil, err
 		}
-		createTable.Columns = append(createTable.Columns, col)
-		createTable.Constraints = append(createTable.Constraints, constraints...)
+		drop.ColumnName = string(colTok.value)
+		drop.WrittenColumnName = writtenName(colTok)
+		drop.Cascade = p.scanDropBehavior()
+		return drop, nil
+	}
 
-		if !more {
-			break
+	// ALTER TABLE ___ ALTER [COLUMN] name ...
+	if isKeyword(next, "ALTER") {
+		p.checkWord("COLUMN")
+		colTok, err := p.requireToken(tokenTypeWord)
+		if err != nil {
+			return nil, err
 		}
+		return p.scanAlterColumn(nameTok, colTok)
 	}
 
-	return createTable, nil
+	return nil, fmt.Errorf("Unsupported ALTER TABLE statement at <%s>", tokenString(next))
 }
 
-func (p *parser) skipColumnDef() (more bool, err error) {
-	parenCount := 0
-	for {
-		next, done, err := p.reader.Next()
-		if done {
-			return false, errors.New("Unexpected EOF in CREATE TABLE")
+// Reads after "ALTER TABLE ___ ALTER COLUMN ___".
+func (p *parser) scanAlterColumn(nameTok token, colTok token) (Statement, error) {
+	tableName := string(nameTok.value)
+	columnName := string(colTok.value)
+
+	// ... TYPE new_type or SET DATA TYPE new_type
+	if p.checkWord("TYPE") {
+		return p.scanAlterColumnType(nameTok, colTok)
+	}
+
+	if p.checkWord("SET") {
+		if p.checkWord("DATA") {
+			if !p.checkWord("TYPE") {
+				return nil, errors.New("Expecting 'SET DATA' to be followed by 'TYPE' but was not.")
+			}
+			return p.scanAlterColumnType(nameTok, colTok)
 		}
-		if err != nil {
-			return false, err
+
+		// ... SET NOT NULL
+		if p.checkWord("NOT") {
+			if !p.checkWord("NULL") {
+				return nil, errors.New("Expecting 'NOT' to be followed by 'NULL' but was not.")
+			}
+			return AlterColumnNullable{
+				TableName:         tableName,
+				ColumnName:        columnName,
+				Nullable:          false,
+				WrittenTableName:  writtenName(nameTok),
+				WrittenColumnName: writtenName(colTok),
+			}, nil
 		}
 
-		if next.tokType == tokenTypeLParen {
-			parenCount++
-		} else if next.tokType == tokenTypeRParen {
-			if parenCount <= 0 {
-				return false, nil
-			} else {
-				parenCount--
+		// ... SET DEFAULT expr
+		if p.checkWord("DEFAULT") {
+			expr, err := p.scanExpr()
+			if err != nil {
+				return nil, err
 			}
-		} else if next.tokType == tokenTypeComma && parenCount <= 0 {
-			return true, nil
+			return AlterColumnDefault{
+				TableName:         tableName,
+				ColumnName:        columnName,
+				Default:           expr,
+				WrittenTableName:  writtenName(nameTok),
+				WrittenColumnName: writtenName(colTok),
+			}, nil
 		}
 	}
-}
 
-// Reads an expression like "first_name VARCHAR(200) NOT NULL"
-func (p *parser) scanColumnDef() (ColumnDefinition, []CreateConstraint, bool, error) {
-	def := ColumnDefinition{}
-	constraints := []CreateConstraint{}
+	if p.checkWord("DROP") {
+		// ... DROP NOT NULL
+		if p.checkWord("NOT") {
+			if !p.checkWord("NULL") {
+				return nil, errors.New("Expecting 'NOT' to be followed by 'NULL' but was not.")
+			}
+			return AlterColumnNullable{
+				TableName:         tableName,
+				ColumnName:        columnName,
+				Nullable:          true,
+				WrittenTableName:  writtenName(nameTok),
+				WrittenColumnName: writtenName(colTok),
+			}, nil
+		}
 
-	colNameTok, err := p.requireToken(tokenTypeWord)
-	if err != nil {
-		return ColumnDefinition{}, nil, false, err
+		// ... DROP DEFAULT
+		if p.checkWord("DEFAULT") {
+			return AlterColumnDefault{
+				TableName:         tableName,
+				ColumnName:        columnName,
+				WrittenTableName:  writtenName(nameTok),
+				WrittenColumnName: writtenName(colTok),
+			}, nil
+		}
 	}
-	def.Name = string(colNameTok.value)
 
-	typ, err := p.scanDataType()
-	if err != nil {
-		return ColumnDefinition{}, nil, false, err
-	}
-	def.Type = typ
+	next, _, _ := p.reader.Peek()
+	return nil, fmt.Errorf("Unsupported ALTER COLUMN statement at <%s>", tokenString(next))
+}
 
-	err = p.applyTypeParams(&def)
-	if err != nil {
-		return ColumnDefinition{}, nil, false, err
+// Reads after "ALTER COLUMN ___ TYPE", eg: "BIGINT USING id::bigint".
+func (p *parser) scanAlterColumnType(nameTok token, colTok token) (AlterColumnType, error) {
+	alter := AlterColumnType{
+		TableName:         string(nameTok.value),
+		ColumnName:        string(colTok.value),
+		NewType:           ColumnDefinition{Name: string(colTok.value)},
+		WrittenTableName:  writtenName(nameTok),
+		WrittenColumnName: writtenName(colTok),
 	}
 
-	constraint, err := p.applyConstraints(&def)
+	err := p.scanDataType(&alter.NewType)
 	if err != nil {
-		return ColumnDefinition{}, nil, false, err
+		return AlterColumnType{}, err
 	}
-	if constraint != nil {
-		constraints = append(constraints, *constraint)
+
+	// The collation makes no difference here
+	if p.checkWord("COLLATE") {
+		_, err = p.requireToken(tokenTypeWord)
+		if err != nil {
+			return AlterColumnType{}, err
+		}
 	}
 
-	next, done, err := p.reader.Next()
-	if err != nil {
-		return ColumnDefinition{}, nil, false, err
+	if p.checkWord("USING") {
+		alter.Using, err = p.scanExpr()
+		if err != nil {
+			return AlterColumnType{}, err
+		}
 	}
-	more := !done && next.tokType == tokenTypeComma
 
-	return def, constraints, more, nil
+	return alter, nil
 }
 
-func (p *parser) applyTypeParams(def *ColumnDefinition) error {
-	_, hasParams := p.checkToken(tokenTypeLParen)
-	if hasParams {
-		tok, err := p.requireToken(tokenTypeNumber)
-		if err != nil {
-			return err
-		}
-		num, err := strconv.Atoi(string(tok.value))
-		if err != nil {
-			return err
-		}
-		def.Param1 = num
+// Reads an optional "IF EXISTS".
+func (p *parser) scanIfExists() (bool, error) {
+	if !p.checkWord("IF") {
+		return false, nil
+	}
+	if !p.checkWord("EXISTS") {
+		return false, errors.New("Expecting 'IF' to be followed by 'EXISTS' but was not.")
+	}
+	return true, nil
+}
 
-		_, comma := p.checkToken(tokenTypeComma)
-		if comma {
-			tok, err = p.requireToken(tokenTypeNumber)
-			if err != nil {
-				return err
-			}
-			num, err = strconv.Atoi(string(tok.value))
-			if err != nil {
-				return err
-			}
-			def.Param2 = num
-		}
+// Reads an optional CASCADE or RESTRICT at the end of a DROP. Returns true for
+// CASCADE.
+func (p *parser) scanDropBehavior() bool {
+	if p.checkWord("CASCADE") {
+		return true
+	}
+	p.checkWord("RESTRICT")
+	return false
+}
 
-		_, err = p.requireToken(tokenTypeRParen)
+// Reads after "ALTER TABLE name RENAME", which is either "TO new_name" or
+// "[COLUMN] old_name TO new_name".
+func (p *parser) scanRename(nameTok token) (Statement, error) {
+	tableName := string(nameTok.value)
+	if p.checkWord("TO") {
+		toTok, err := p.requireToken(tokenTypeWord)
 		if err != nil {
-			return err
+			return RenameTable{}, err
 		}
+		return RenameTable{
+			From: tableName,
+			To:   string(toTok.value),
+		}, nil
 	}
 
-	return nil
-}
-
-func (p *parser) applyConstraints(def *ColumnDefinition) (*CreateConstraint, error) {
-	// Defaults
-	def.Nullable = true
+	p.checkWord("COLUMN")
+	fromTok, err := p.requireToken(tokenTypeWord)
+	if err != nil {
+		return RenameColumn{}, err
+	}
+	if !p.checkWord("TO") {
+		next, _, _ := p.reader.Peek()
+		return RenameColumn{}, fmt.Errorf("Expected 'TO' but got <%s>", tokenString(next))
+	}
+	toTok, err := p.requireToken(tokenTypeWord)
+	if err != nil {
+		return RenameColumn{}, err
+	}
 
-	// Flags to avoid duplicate constraints
-	alreadyNullable := false
-	alreadyPrimaryKey := false
+	return RenameColumn{
+		TableName:        tableName,
+		From:             string(fromTok.value),
+		To:               string(toTok.value),
+		WrittenTableName: writtenName(nameTok),
+		WrittenFrom:      writtenName(fromTok),
+		WrittenTo:        writtenName(toTok),
+	}, nil
+}
 
-	didAnything := false
+// Reads after "DROP TABLE", eg: "IF EXISTS users, groups CASCADE".
+func (p *parser) scanDropTable() (DropTable, error) {
+	drop := DropTable{TableNames: []string{}}
 
-	var constraint *CreateConstraint = nil
+	ifExists, err := p.scanIfExists()
+	if err != nil {
+		return DropTable{}, err
+	}
+	drop.IfExists = ifExists
 
 	for {
+		nameTok, err := p.requireQualifiedName()
+		if err != nil {
+			return DropTable{}, err
+		}
+		drop.TableNames = append(drop.TableNames, string(nameTok.value))
+
+		_, more := p.checkToken(tokenTypeComma)
+		if !more {
+			break
+		}
+	}
+
+	drop.Cascade = p.scanDropBehavior()
+	return drop, nil
+}
+
+// Reads after "CREATE TABLE"
+func (p *parser) scanCreateTable() (CreateTable, error) {
+
+	// get table name
+	nameTok, err := p.requireQualifiedName()
+	if err != nil {
+		return CreateTable{}, err
+	}
+	createTable := CreateTable{
+		Name:        string(nameTok.value),
+		Columns:     []ColumnDefinition{},
+		Constraints: []CreateConstraint{},
+	}
+
+	// look for '(' to start column list
+	_, err = p.requireToken(tokenTypeLParen)
+	if err != nil {
+		return CreateTable{}, err
+	}
+
+	// columns
+	for {
+		// Stop looping if hit ')'. There may be zero columns!
+		_, foundRParen := p.peekToken(tokenTypeRParen)
+		if foundRParen {
+			_, _, err := p.reader.Next()
+			if err != nil {
+				return CreateTable{}, err
+			}
+			break
+		}
+
+		// Table constraints like "PRIMARY KEY (tid, id)" can be mixed in with the
+		// columns
+		if p.peekTableConstraint() {
+			constraint, err := p.scanTableConstraint()
+			if err != nil {
+				return CreateTable{}, err
+			}
+			if constraint != nil {
+				createTable.Constraints = append(createTable.Constraints, *constraint)
+			}
+		} else {
+			col, constraints, err := p.scanColumnDef()
+			if err != nil {
+				return CreateTable{}, err
+			}
+			createTable.Columns = append(createTable.Columns, col)
+			createTable.Constraints = append(createTable.Constraints, constraints...)
+		}
+
+		next, done, err := p.reader.Next()
+		if err != nil {
+			return CreateTable{}, err
+		}
+		if done {
+			return CreateTable{}, errors.New("Expecting ')' but got EOF")
+		}
+		if next.tokType == tokenTypeRParen {
+			break
+		}
+		if next.tokType != tokenTypeComma {
+			return CreateTable{}, fmt.Errorf("Expected ',' or ')' but got <%s>", tokenString(next))
+		}
+	}
+
+	return createTable, nil
+}
+
+// Reads after "CREATE TYPE". Enums are the only kind of type supported.
+func (p *parser) scanCreateType() (CreateEnum, error) {
+	nameTok, err := p.requireQualifiedName()
+	if err != nil {
+		return CreateEnum{}, err
+	}
+
+	if !p.checkWord("AS") || !p.checkWord("ENUM") {
+		next, _, _ := p.reader.Peek()
+		return CreateEnum{}, fmt.Errorf("Expected 'AS ENUM' but got <%s>", tokenString(next))
+	}
+
+	_, err = p.requireToken(tokenTypeLParen)
+	if err != nil {
+		return CreateEnum{}, err
+	}
+
+	createEnum := CreateEnum{
+		Name:   string(nameTok.value),
+		Values: []string{},
+	}
+
+	// There may be zero values!
+	_, empty := p.checkToken(tokenTypeRParen)
+	if empty {
+		return createEnum, nil
+	}
+
+	for {
+		valueTok, err := p.requireToken(tokenTypeString)
+		if err != nil {
+			return CreateEnum{}, err
+		}
+		createEnum.Values = append(createEnum.Values, string(valueTok.value))
+
+		next, done, err := p.reader.Next()
+		if err != nil {
+			return CreateEnum{}, err
+		}
+		if done {
+			return CreateEnum{}, errors.New("Expecting ')' but got EOF")
+		}
+		if next.tokType == tokenTypeRParen {
+			return createEnum, nil
+		}
+		if next.tokType != tokenTypeComma {
+			return CreateEnum{}, fmt.Errorf("Expected ',' or ')' but got <%s>", tokenString(next))
+		}
+	}
+}
+
+// Reads after "ALTER TYPE". Adding a value to an enum is the only change
+// supported.
+func (p *parser) scanAlterType() (AddEnumValue, error) {
+	nameTok, err := p.requireQualifiedName()
+	if err != nil {
+		return AddEnumValue{}, err
+	}
+
+	if !p.checkWord("ADD") || !p.checkWord("VALUE") {
+		next, _, _ := p.reader.Peek()
+		return AddEnumValue{}, fmt.Errorf("Unsupported ALTER TYPE statement at <%s>", tokenString(next))
+	}
+
+	add := AddEnumValue{TypeName: string(nameTok.value)}
+
+	if p.checkWord("IF") {
+		if !p.checkWord("NOT") || !p.checkWord("EXISTS") {
+			return AddEnumValue{}, errors.New("Expecting 'IF' to be followed by 'NOT EXISTS' but was not.")
+		}
+		add.IfNotExists = true
+	}
+
+	valueTok, err := p.requireToken(tokenTypeString)
+	if err != nil {
+		return AddEnumValue{}, err
+	}
+	add.Value = string(valueTok.value)
+
+	if p.checkWord("BEFORE") {
+		neighbourTok, err := p.requireToken(tokenTypeString)
+		if err != nil {
+			return AddEnumValue{}, err
+		}
+		add.Before = string(neighbourTok.value)
+	} else if p.checkWord("AFTER") {
+		neighbourTok, err := p.requireToken(tokenTypeString)
+		if err != nil {
+			return AddEnumValue{}, err
+		}
+		add.After = string(neighbourTok.value)
+	}
+
+	return add, nil
+}
+
+// Reads after "CREATE SCHEMA", eg: "IF NOT EXISTS audit AUTHORIZATION admin".
+func (p *parser) scanCreateSchema() (CreateSchema, error) {
+	schema := CreateSchema{}
+
+	if p.checkWord("IF") {
+		if !p.checkWord("NOT") || !p.checkWord("EXISTS") {
+			return CreateSchema{}, errors.New("Expecting 'IF' to be followed by 'NOT EXISTS' but was not.")
+		}
+		schema.IfNotExists = true
+	}
+
+	nameTok, err := p.requireToken(tokenTypeWord)
+	if err != nil {
+		return CreateSchema{}, err
+	}
+	schema.Name = string(nameTok.value)
+
+	// The owner makes no difference here
+	if p.checkWord("AUTHORIZATION") {
+		_, err = p.requireToken(tokenTypeWord)
+		if err != nil {
+			return CreateSchema{}, err
+		}
+	}
+
+	return schema, nil
+}
+
+// Reads after "SET", eg: "LOCAL search_path TO audit, 'public'".
+func (p *parser) scanSet() (SetVariable, error) {
+	set := SetVariable{Values: []string{}}
+
+	set.Local = p.checkWord("LOCAL")
+	if !set.Local {
+		p.checkWord("SESSION")
+	}
+
+	nameTok, err := p.requireToken(tokenTypeWord)
+	if err != nil {
+		return SetVariable{}, err
+	}
+	set.Name = strings.ToLower(string(nameTok.value))
+
+	_, isEqual := p.checkToken(tokenTypeEqual)
+	if !isEqual && !p.checkWord("TO") {
+		next, _, _ := p.reader.Peek()
+		return SetVariable{}, fmt.Errorf("Expecting 'TO' or '=' but got <%s>", tokenString(next))
+	}
+
+	if p.checkWord("DEFAULT") {
+		return set, nil
+	}
+
+	for {
+		next, done, err := p.reader.Next()
+		if err != nil {
+			return SetVariable{}, err
+		}
+		if done {
+			return SetVariable{}, errors.New("Expecting a value to SET but got EOF")
+		}
+		switch next.tokType {
+		case tokenTypeWord, tokenTypeString, tokenTypeNumber:
+			set.Values = append(set.Values, string(next.value))
+		default:
+			return SetVariable{}, fmt.Errorf("Expecting a value to SET but got <%s>", tokenString(next))
+		}
+
+		_, more := p.checkToken(tokenTypeComma)
+		if !more {
+			return set, nil
+		}
+	}
+}
+
+// Reads the rest of "CREATE [OR REPLACE] [MATERIALIZED] VIEW" after the first
+// word, eg:
+//   OR REPLACE VIEW active_users (id, email) AS SELECT id, email FROM users
+func (p *parser) scanCreateView(first token) (CreateView, error) {
+	view := CreateView{}
+
+	tok := first
+	if isKeyword(tok, "OR") {
+		if !p.checkWord("REPLACE") {
+			return CreateView{}, errors.New("Expecting 'OR' to be followed by 'REPLACE' but was not.")
+		}
+		view.OrReplace = true
+
+		next, err := p.requireToken(tokenTypeWord)
+		if err != nil {
+			return CreateView{}, err
+		}
+		tok = next
+	}
+
+	if isKeyword(tok, "MATERIALIZED") {
+		if view.OrReplace {
+			return CreateView{}, errors.New("Materialized views cannot be created with OR REPLACE")
+		}
+		view.Materialized = true
+
+		next, err := p.requireToken(tokenTypeWord)
+		if err != nil {
+			return CreateView{}, err
+		}
+		tok = next
+	}
+
+	if !isKeyword(tok, "VIEW") {
+		return CreateView{}, fmt.Errorf("Expecting 'VIEW' but got <%s>", tokenString(tok))
+	}
+
+	// Only materialized views have IF NOT EXISTS
+	if view.Materialized && p.checkWord("IF") {
+		if !p.checkWord("NOT") || !p.checkWord("EXISTS") {
+			return CreateView{}, errors.New("Expecting 'IF' to be followed by 'NOT EXISTS' but was not.")
+		}
+		view.IfNotExists = true
+	}
+
+	nameTok, err := p.requireQualifiedName()
+	if err != nil {
+		return CreateView{}, err
+	}
+	view.Name = string(nameTok.value)
+
+	next, _, _ := p.reader.Peek()
+	if next.tokType == tokenTypeLParen {
+		view.ColumnNames, err = p.scanColumnNames()
+		if err != nil {
+			return CreateView{}, err
+		}
+	}
+
+	if !p.checkWord("AS") {
+		return CreateView{}, errors.New("Expecting 'AS' after the view name but was not.")
+	}
+	if !p.checkWord("SELECT") {
+		return CreateView{}, errors.New("Expecting the view to be defined by a SELECT but was not.")
+	}
+	view.Query, err = p.scanSelect()
+	if err != nil {
+		return CreateView{}, err
+	}
+
+	// Whether a materialized view is filled in right away doesn't change its
+	// shape
+	if view.Materialized && p.checkWord("WITH") {
+		p.checkWord("NO")
+		if !p.checkWord("DATA") {
+			return CreateView{}, errors.New("Expecting 'WITH [NO]' to be followed by 'DATA' but was not.")
+		}
+	}
+
+	return view, nil
+}
+
+// Reads after "DROP [MATERIALIZED] VIEW", eg: "IF EXISTS a, b CASCADE".
+func (p *parser) scanDropView(materialized bool) (DropView, error) {
+	drop := DropView{Names: []string{}, Materialized: materialized}
+
+	ifExists, err := p.scanIfExists()
+	if err != nil {
+		return DropView{}, err
+	}
+	drop.IfExists = ifExists
+
+	for {
+		nameTok, err := p.requireQualifiedName()
+		if err != nil {
+			return DropView{}, err
+		}
+		drop.Names = append(drop.Names, string(nameTok.value))
+
+		_, more := p.checkToken(tokenTypeComma)
+		if !more {
+			break
+		}
+	}
+
+	drop.Cascade = p.scanDropBehavior()
+	return drop, nil
+}
+
+// Reads after "REFRESH", eg: "MATERIALIZED VIEW CONCURRENTLY name WITH DATA".
+func (p *parser) scanRefreshMaterializedView() (RefreshMaterializedView, error) {
+	if !p.checkWord("MATERIALIZED") || !p.checkWord("VIEW") {
+		return RefreshMaterializedView{}, errors.New("Expecting 'REFRESH' to be followed by 'MATERIALIZED VIEW' but was not.")
+	}
+
+	refresh := RefreshMaterializedView{
+		Concurrently: p.checkWord("CONCURRENTLY"),
+	}
+
+	nameTok, err := p.requireQualifiedName()
+	if err != nil {
+		return RefreshMaterializedView{}, err
+	}
+	refresh.Name = string(nameTok.value)
+
+	if p.checkWord("WITH") {
+		p.checkWord("NO")
+		if !p.checkWord("DATA") {
+			return RefreshMaterializedView{}, errors.New("Expecting 'WITH [NO]' to be followed by 'DATA' but was not.")
+		}
+	}
+
+	return refresh, nil
+}
+
+// Reads after "CREATE [UNIQUE] INDEX", eg:
+//   CONCURRENTLY IF NOT EXISTS ix_email ON users (lower(email)) WHERE deleted IS NULL
+func (p *parser) scanCreateIndex(unique bool) (CreateIndex, error) {
+	index := CreateIndex{
+		Unique:  unique,
+		Columns: []string{},
+	}
+
+	index.Concurrently = p.checkWord("CONCURRENTLY")
+
+	if p.checkWord("IF") {
+		if !p.checkWord("NOT") || !p.checkWord("EXISTS") {
+			return CreateIndex{}, errors.New("Expecting 'IF' to be followed by 'NOT EXISTS' but was not.")
+		}
+		index.IfNotExists = true
+	}
+
+	// The name is optional
+	if !p.checkWord("ON") {
+		nameTok, err := p.requireToken(tokenTypeWord)
+		if err != nil {
+			return CreateIndex{}, err
+		}
+		index.Name = string(nameTok.value)
+
+		if !p.checkWord("ON") {
+			next, _, _ := p.reader.Peek()
+			return CreateIndex{}, fmt.Errorf("Expected 'ON' but got <%s>", tokenString(next))
+		}
+	} else if index.IfNotExists {
+		return CreateIndex{}, errors.New("IF NOT EXISTS requires an index name")
+	}
+
+	p.checkWord("ONLY")
+	tableTok, err := p.requireQualifiedName()
+	if err != nil {
+		return CreateIndex{}, err
+	}
+	index.TableName = string(tableTok.value)
+
+	// The index method (btree, hash, etc) makes no difference here
+	if p.checkWord("USING") {
+		_, err = p.requireToken(tokenTypeWord)
+		if err != nil {
+			return CreateIndex{}, err
+		}
+	}
+
+	_, err = p.requireToken(tokenTypeLParen)
+	if err != nil {
+		return CreateIndex{}, err
+	}
+
+	for {
+		expr, err := p.scanExpr()
+		if err != nil {
+			return CreateIndex{}, err
+		}
+		col, isCol := expr.(ColumnExpression)
+		if isCol && col.TableName == "" {
+			index.Columns = append(index.Columns, col.ColumnName)
+		} else {
+			index.HasExpressions = true
+		}
+
+		err = p.skipIndexElementOptions()
+		if err != nil {
+			return CreateIndex{}, err
+		}
+
+		next, done, err := p.reader.Next()
+		if err != nil {
+			return CreateIndex{}, err
+		}
+		if done {
+			return CreateIndex{}, errors.New("Expecting ')' but got EOF")
+		}
+		if next.tokType == tokenTypeRParen {
+			break
+		}
+		if next.tokType != tokenTypeComma {
+			return CreateIndex{}, fmt.Errorf("Expected ',' or ')' but got <%s>", tokenString(next))
+		}
+	}
+
+	// Covering columns are not part of the key
+	if p.checkWord("INCLUDE") {
+		_, err = p.scanColumnNames()
+		if err != nil {
+			return CreateIndex{}, err
+		}
+	}
+
+	// Storage parameters and the tablespace make no difference here
+	if p.checkWord("WITH") {
+		err = p.skipParens()
+		if err != nil {
+			return CreateIndex{}, err
+		}
+	}
+	if p.checkWord("TABLESPACE") {
+		_, err = p.requireToken(tokenTypeWord)
+		if err != nil {
+			return CreateIndex{}, err
+		}
+	}
+
+	if p.checkWord("WHERE") {
+		index.Where, err = p.scanCondition()
+		if err != nil {
+			return CreateIndex{}, err
+		}
+	}
+
+	return index, nil
+}
+
+// Skips the collation, operator class, sort order and NULLS FIRST/LAST that
+// can follow each column of an index.
+func (p *parser) skipIndexElementOptions() error {
+	if p.checkWord("COLLATE") {
+		_, err := p.requireToken(tokenTypeWord)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Anything else that is a word must be an operator class
+	next, done, err := p.reader.Peek()
+	if err != nil {
+		return err
+	}
+	isOption := isKeyword(next, "ASC") || isKeyword(next, "DESC") || isKeyword(next, "NULLS")
+	if !done && next.tokType == tokenTypeWord && !isOption {
+		_ = p.advance()
+	}
+
+	if !p.checkWord("ASC") {
+		p.checkWord("DESC")
+	}
+
+	if p.checkWord("NULLS") {
+		if !p.checkWord("FIRST") && !p.checkWord("LAST") {
+			return errors.New("Expecting 'NULLS' to be followed by 'FIRST' or 'LAST' but was not.")
+		}
+	}
+	return nil
+}
+
+// Reads after "DROP INDEX", eg: "CONCURRENTLY IF EXISTS ix_a, ix_b CASCADE".
+func (p *parser) scanDropIndex() (DropIndex, error) {
+	drop := DropIndex{Names: []string{}}
+
+	drop.Concurrently = p.checkWord("CONCURRENTLY")
+
+	ifExists, err := p.scanIfExists()
+	if err != nil {
+		return DropIndex{}, err
+	}
+	drop.IfExists = ifExists
+
+	for {
+		nameTok, err := p.requireQualifiedName()
+		if err != nil {
+			return DropIndex{}, err
+		}
+		drop.Names = append(drop.Names, string(nameTok.value))
+
+		_, more := p.checkToken(tokenTypeComma)
+		if !more {
+			break
+		}
+	}
+
+	drop.Cascade = p.scanDropBehavior()
+	return drop, nil
+}
+
+// Skips a parenthesized list like "(fillfactor = 70, deduplicate_items = off)".
+func (p *parser) skipParens() error {
+	_, err := p.requireToken(tokenTypeLParen)
+	if err != nil {
+		return err
+	}
+
+	depth := 1
+	for depth > 0 {
+		next, done, err := p.reader.Next()
+		if err != nil {
+			return err
+		}
+		if done {
+			return errors.New("Expecting ')' but got EOF")
+		}
+		if next.tokType == tokenTypeLParen {
+			depth++
+		} else if next.tokType == tokenTypeRParen {
+			depth--
+		}
+	}
+	return nil
+}
+
+// Skips the rest of a column or constraint definition, stopping before the
+// ',' or ')' that ends it.
+func (p *parser) skipDefinitionOptions() error {
+	parenCount := 0
+	for {
+		next, done, err := p.reader.Peek()
+		if err != nil {
+			return err
+		}
+		if done || next.tokType == tokenTypeSemicolon {
+			return nil
+		}
+
+		if next.tokType == tokenTypeLParen {
+			parenCount++
+		} else if next.tokType == tokenTypeRParen {
+			if parenCount <= 0 {
+				return nil
+			}
+			parenCount--
+		} else if next.tokType == tokenTypeComma && parenCount <= 0 {
+			return nil
+		}
+		_ = p.advance()
+	}
+}
+
+// Reads an expression like "first_name VARCHAR(200) NOT NULL"
+func (p *parser) scanColumnDef() (ColumnDefinition, []CreateConstraint, error) {
+	def := ColumnDefinition{}
+
+	colNameTok, err := p.requireToken(tokenTypeWord)
+	if err != nil {
+		return ColumnDefinition{}, nil, err
+	}
+	def.Name = string(colNameTok.value)
+
+	err = p.scanDataType(&def)
+	if err != nil {
+		return ColumnDefinition{}, nil, err
+	}
+
+	constraints, err := p.applyConstraints(&def)
+	if err != nil {
+		return ColumnDefinition{}, nil, err
+	}
+
+	return def, constraints, nil
+}
+
+func (p *parser) applyConstraints(def *ColumnDefinition) ([]CreateConstraint, error) {
+	// Defaults
+	def.Nullable = true
+
+	// Flags to avoid duplicate constraints
+	alreadyNullable := false
+	alreadyPrimaryKey := false
+
+	didAnything := false
+
+	constraints := []CreateConstraint{}
+
+	// Set by "CONSTRAINT name" and used by the constraint that follows it
+	name := ""
+
+	for {
+		if p.checkWord("CONSTRAINT") {
+			didAnything = true
+			nameTok, err := p.requireToken(tokenTypeWord)
+			if err != nil {
+				return nil, err
+			}
+			name = string(nameTok.value)
+		}
+
 		if p.checkWord("NULL") {
 			didAnything = true
 			if alreadyNullable {
@@ -448,47 +1389,605 @@ func (p *parser) applyConstraints(def *ColumnDefinition) (*CreateConstraint, err
 			}
 		}
 
-		if p.checkWord("PRIMARY") {
-			didAnything = true
-			if p.checkWord("KEY") {
-				if alreadyPrimaryKey {
-					return nil, errors.New("Cannot specify PRIMARY KEY more than once")
-				}
-				alreadyPrimaryKey = true
-				constraint = &CreateConstraint{
-					Columns: []string{def.Name},
-					Type:    ConstraintTypePrimaryKey,
-				}
-			}
+		if p.checkWord("PRIMARY") {
+			didAnything = true
+			if p.checkWord("KEY") {
+				if alreadyPrimaryKey {
+					return nil, errors.New("Cannot specify PRIMARY KEY more than once")
+				}
+				alreadyPrimaryKey = true
+				constraints = append(constraints, CreateConstraint{
+					Name:    name,
+					Columns: []string{def.Name},
+					Type:    ConstraintTypePrimaryKey,
+				})
+				name = ""
+			}
+		}
+
+		if p.checkWord("UNIQUE") {
+			didAnything = true
+			constraints = append(constraints, CreateConstraint{
+				Name:    name,
+				Columns: []string{def.Name},
+				Type:    ConstraintTypeUnique,
+			})
+			name = ""
+		}
+
+		if p.checkWord("DEFAULT") {
+			didAnything = true
+			if def.Default != nil {
+				return nil, errors.New("Cannot specify DEFAULT more than once")
+			}
+			expr, err := p.scanExpr()
+			if err != nil {
+				return nil, err
+			}
+			def.Default = expr
+		}
+
+		if p.checkWord("CHECK") {
+			didAnything = true
+			check, err := p.scanCheck()
+			if err != nil {
+				return nil, err
+			}
+			constraints = append(constraints, CreateConstraint{
+				Name:    name,
+				Columns: []string{def.Name},
+				Type:    ConstraintTypeCheck,
+				Check:   check,
+			})
+			name = ""
+		}
+
+		if p.checkWord("REFERENCES") {
+			didAnything = true
+			ref, err := p.scanReferences()
+			if err != nil {
+				return nil, err
+			}
+			constraints = append(constraints, CreateConstraint{
+				Name:       name,
+				Columns:    []string{def.Name},
+				Type:       ConstraintTypeForeignKey,
+				References: &ref,
+			})
+			name = ""
+		}
+
+		if !didAnything {
+			break
+		}
+		didAnything = false
+	}
+
+	return constraints, nil
+}
+
+// Returns true if the next token starts a table constraint rather than a
+// column definition.
+func (p *parser) peekTableConstraint() bool {
+	next, done, err := p.reader.Peek()
+	if err != nil || done {
+		return false
+	}
+	for _, keyword := range []string{"CONSTRAINT", "PRIMARY", "UNIQUE", "FOREIGN", "CHECK", "EXCLUDE"} {
+		if isKeyword(next, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reads a table constraint like "CONSTRAINT uq_key UNIQUE (tid, key)" in a
+// CREATE TABLE or ALTER TABLE statement. Returns nil for kinds of constraints
+// that are not tracked, like EXCLUDE.
+func (p *parser) scanTableConstraint() (*CreateConstraint, error) {
+	constraint := CreateConstraint{}
+
+	if p.checkWord("CONSTRAINT") {
+		nameTok, err := p.requireToken(tokenTypeWord)
+		if err != nil {
+			return nil, err
+		}
+		constraint.Name = string(nameTok.value)
+	}
+
+	if p.checkWord("PRIMARY") {
+		if !p.checkWord("KEY") {
+			return nil, errors.New("Expecting 'PRIMARY' to be followed by 'KEY' but was not.")
+		}
+		constraint.Type = ConstraintTypePrimaryKey
+	} else if p.checkWord("UNIQUE") {
+		constraint.Type = ConstraintTypeUnique
+	} else if p.checkWord("FOREIGN") {
+		if !p.checkWord("KEY") {
+			return nil, errors.New("Expecting 'FOREIGN' to be followed by 'KEY' but was not.")
+		}
+		constraint.Type = ConstraintTypeForeignKey
+	} else if p.checkWord("CHECK") {
+		check, err := p.scanCheck()
+		if err != nil {
+			return nil, err
+		}
+		constraint.Type = ConstraintTypeCheck
+		constraint.Check = check
+		return &constraint, p.skipDefinitionOptions()
+	} else {
+		return nil, p.skipDefinitionOptions()
+	}
+
+	columns, err := p.scanColumnNames()
+	if err != nil {
+		return nil, err
+	}
+	constraint.Columns = columns
+
+	if constraint.Type == ConstraintTypeForeignKey {
+		if !p.checkWord("REFERENCES") {
+			return nil, errors.New("Expecting FOREIGN KEY to be followed by REFERENCES but was not.")
+		}
+		ref, err := p.scanReferences()
+		if err != nil {
+			return nil, err
+		}
+		constraint.References = &ref
+	}
+
+	// Skip anything else like DEFERRABLE or index parameters
+	return &constraint, p.skipDefinitionOptions()
+}
+
+// Reads after "CHECK", eg: "(char_length(key) > 0) NO INHERIT".
+func (p *parser) scanCheck() (Condition, error) {
+	_, err := p.requireToken(tokenTypeLParen)
+	if err != nil {
+		return nil, err
+	}
+
+	check, err := p.scanCondition()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = p.requireToken(tokenTypeRParen)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.checkWord("NO") {
+		if !p.checkWord("INHERIT") {
+			return nil, errors.New("Expecting 'NO' to be followed by 'INHERIT' but was not.")
+		}
+	}
+
+	return check, nil
+}
+
+// Reads a parenthesized list of column names like "(tid, id)".
+func (p *parser) scanColumnNames() ([]string, error) {
+	_, err := p.requireToken(tokenTypeLParen)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for {
+		nameTok, err := p.requireToken(tokenTypeWord)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, string(nameTok.value))
+
+		next, done, err := p.reader.Next()
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			return nil, errors.New("Expecting ')' but got EOF")
+		}
+		if next.tokType == tokenTypeRParen {
+			return names, nil
+		}
+		if next.tokType != tokenTypeComma {
+			return nil, fmt.Errorf("Expected ',' or ')' but got <%s>", tokenString(next))
+		}
+	}
+}
+
+// Reads after "REFERENCES", eg: "users (id) ON DELETE CASCADE".
+func (p *parser) scanReferences() (ForeignKey, error) {
+	tableTok, err := p.requireQualifiedName()
+	if err != nil {
+		return ForeignKey{}, err
+	}
+
+	ref := ForeignKey{
+		TableName: string(tableTok.value),
+		Columns:   []string{},
+	}
+
+	_, hasColumns := p.peekToken(tokenTypeLParen)
+	if hasColumns {
+		ref.Columns, err = p.scanColumnNames()
+		if err != nil {
+			return ForeignKey{}, err
+		}
+	}
+
+	// MATCH FULL, MATCH PARTIAL or MATCH SIMPLE makes no difference here
+	if p.checkWord("MATCH") {
+		_, err = p.requireToken(tokenTypeWord)
+		if err != nil {
+			return ForeignKey{}, err
+		}
+	}
+
+	for p.checkWord("ON") {
+		if p.checkWord("DELETE") {
+			ref.OnDelete, err = p.scanReferentialAction()
+		} else if p.checkWord("UPDATE") {
+			ref.OnUpdate, err = p.scanReferentialAction()
+		} else {
+			err = errors.New("Expecting 'ON' to be followed by 'DELETE' or 'UPDATE' but was not.")
+		}
+		if err != nil {
+			return ForeignKey{}, err
+		}
+	}
+
+	return ref, nil
+}
+
+// Reads the action after "ON DELETE" or "ON UPDATE".
+func (p *parser) scanReferentialAction() (referentialAction, error) {
+	if p.checkWord("NO") {
+		if !p.checkWord("ACTION") {
+			return 0, errors.New("Expecting 'NO' to be followed by 'ACTION' but was not.")
+		}
+		return ReferentialActionNoAction, nil
+	}
+	if p.checkWord("RESTRICT") {
+		return ReferentialActionRestrict, nil
+	}
+	if p.checkWord("CASCADE") {
+		return ReferentialActionCascade, nil
+	}
+	if p.checkWord("SET") {
+		if p.checkWord("NULL") {
+			return ReferentialActionSetNull, nil
+		}
+		if p.checkWord("DEFAULT") {
+			return ReferentialActionSetDefault, nil
+		}
+		return 0, errors.New("Expecting 'SET' to be followed by 'NULL' or 'DEFAULT' but was not.")
+	}
+
+	next, _, _ := p.reader.Peek()
+	return 0, fmt.Errorf("Expected a referential action but got <%s>", tokenString(next))
+}
+
+// Every single word name of a data type, including the aliases that Postgres
+// accepts. Names that can be more than one word like "DOUBLE PRECISION" and
+// "TIMESTAMP WITH TIME ZONE" are handled by scanDataType.
+var dataTypeNames = map[string]dataType{
+	"SMALLINT":    DataTypeSmallInt,
+	"INT2":        DataTypeSmallInt,
+	"INTEGER":     DataTypeInteger,
+	"INT":         DataTypeInteger,
+	"INT4":        DataTypeInteger,
+	"BIGINT":      DataTypeBigInt,
+	"INT8":        DataTypeBigInt,
+	"DECIMAL":     DataTypeDecimal,
+	"NUMERIC":     DataTypeNumeric,
+	"REAL":        DataTypeReal,
+	"FLOAT4":      DataTypeReal,
+	"FLOAT8":      DataTypeDoublePrecision,
+	"SMALLSERIAL": DataTypeSmallSerial,
+	"SERIAL2":     DataTypeSmallSerial,
+	"SERIAL":      DataTypeSerial,
+	"SERIAL4":     DataTypeSerial,
+	"BIGSERIAL":   DataTypeBigSerial,
+	"SERIAL8":     DataTypeBigSerial,
+	"MONEY":       DataTypeMoney,
+	"BPCHAR":      DataTypeChar,
+	"VARCHAR":     DataTypeVarChar,
+	"TEXT":        DataTypeText,
+	"BYTEA":       DataTypeBytea,
+	"TIMESTAMPTZ": DataTypeTimestampWithTimeZone,
+	"DATE":        DataTypeDate,
+	"TIMETZ":      DataTypeTimeWithTimeZone,
+	"INTERVAL":    DataTypeInterval,
+	"BOOLEAN":     DataTypeBoolean,
+	"BOOL":        DataTypeBoolean,
+	"POINT":       DataTypePoint,
+	"LINE":        DataTypeLine,
+	"LSEG":        DataTypeLineSegment,
+	"BOX":         DataTypeBox,
+	"PATH":        DataTypePath,
+	"POLYGON":     DataTypePolygon,
+	"CIRCLE":      DataTypeCircle,
+	"CIDR":        DataTypeCidr,
+	"INET":        DataTypeInet,
+	"MACADDR":     DataTypeMacAddr,
+	"VARBIT":      DataTypeVarBit,
+	"TSVECTOR":    DataTypeTextSearchVector,
+	"TSQUERY":     DataTypeTextSearchQuery,
+	"UUID":        DataTypeUUID,
+	"XML":         DataTypeXML,
+	"JSON":        DataTypeJSON,
+	"JSONB":       DataTypeBinaryJSON,
+}
+
+// Reads a data type along with its modifiers and array dimensions like
+// "NUMERIC(10, 2)", "TIMESTAMP(3) WITH TIME ZONE" or "TEXT[]" into def.
+func (p *parser) scanDataType(def *ColumnDefinition) error {
+	err := p.scanElementDataType(def)
+	if err != nil {
+		return err
+	}
+	return p.scanArrayDims(def)
+}
+
+// Reads the array dimensions after a data type like "INT[]", "INT[3][3]" or
+// "INT ARRAY[3]". Postgres doesn't enforce the sizes so they are not kept.
+func (p *parser) scanArrayDims(def *ColumnDefinition) error {
+	if p.checkWord("ARRAY") {
+		def.ArrayDims = 1
+		_, hasSize := p.checkToken(tokenTypeLBracket)
+		if hasSize {
+			return p.scanArraySize()
+		}
+		return nil
+	}
+
+	for {
+		_, isArray := p.checkToken(tokenTypeLBracket)
+		if !isArray {
+			return nil
+		}
+		err := p.scanArraySize()
+		if err != nil {
+			return err
+		}
+		def.ArrayDims++
+	}
+}
+
+// Reads the optional size and closing bracket of an array dimension.
+func (p *parser) scanArraySize() error {
+	_, _ = p.checkToken(tokenTypeNumber)
+	_, err := p.requireToken(tokenTypeRBracket)
+	return err
+}
+
+func (p *parser) scanElementDataType(def *ColumnDefinition) error {
+	tok, err := p.requireQualifiedName()
+	if err != nil {
+		return err
+	}
+
+	// The built in types live in pg_catalog. A type from any other schema must
+	// have been made with CREATE TYPE.
+	schema, typeName := splitQualifiedName(string(tok.value))
+	if schema != "" && !strings.EqualFold(schema, "pg_catalog") {
+		def.Type = DataTypeEnum
+		def.TypeName = string(tok.value)
+		return nil
+	}
+	tok.value = []rune(typeName)
+	name := strings.ToUpper(typeName)
+
+	switch name {
+	case "DOUBLE":
+		if !p.checkWord("PRECISION") {
+			return errors.New("Expecting 'DOUBLE' to be followed by 'PRECISION' but was not.")
+		}
+		def.Type = DataTypeDoublePrecision
+		name = "DOUBLE PRECISION"
+	case "CHARACTER", "CHAR":
+		def.Type = DataTypeChar
+		if p.checkWord("VARYING") {
+			def.Type = DataTypeVarChar
+			name += " VARYING"
+		}
+	case "BIT":
+		def.Type = DataTypeBit
+		if p.checkWord("VARYING") {
+			def.Type = DataTypeVarBit
+			name += " VARYING"
+		}
+	case "FLOAT":
+		// The precision of FLOAT is in binary digits and only decides which of
+		// the two floating point types it is.
+		mods, err := p.scanTypeModifiers()
+		if err != nil {
+			return err
+		}
+		def.Type = DataTypeDoublePrecision
+		if len(mods) > 1 {
+			return errors.New("FLOAT accepts only one modifier")
+		}
+		if len(mods) == 1 {
+			if mods[0] < 1 || mods[0] > 53 {
+				return errors.New("Precision of FLOAT must be between 1 and 53")
+			}
+			if mods[0] <= 24 {
+				def.Type = DataTypeReal
+			}
+		}
+		return nil
+	case "TIMESTAMP", "TIME":
+		// The precision comes before the time zone, eg TIME(3) WITH TIME ZONE
+		mods, err := p.scanTypeModifiers()
+		if err != nil {
+			return err
+		}
+		withTimeZone, err := p.scanTimeZone()
+		if err != nil {
+			return err
+		}
+		if name == "TIMESTAMP" {
+			def.Type = DataTypeTimestamp
+			if withTimeZone {
+				def.Type = DataTypeTimestampWithTimeZone
+			}
+		} else {
+			def.Type = DataTypeTime
+			if withTimeZone {
+				def.Type = DataTypeTimeWithTimeZone
+			}
+		}
+		return applyTypeModifiers(def, name, mods)
+	default:
+		typ, ok := dataTypeNames[name]
+		if !ok {
+			// Any other name must be a type created with CREATE TYPE, which
+			// the model checks for.
+			def.Type = DataTypeEnum
+			def.TypeName = string(tok.value)
+			return nil
+		}
+		def.Type = typ
+	}
+
+	if def.Type == DataTypeInterval {
+		err = p.scanIntervalFields()
+		if err != nil {
+			return err
+		}
+	}
+
+	mods, err := p.scanTypeModifiers()
+	if err != nil {
+		return err
+	}
+	return applyTypeModifiers(def, name, mods)
+}
+
+// Reads the optional "WITH TIME ZONE" or "WITHOUT TIME ZONE" that follows
+// TIME and TIMESTAMP.
+func (p *parser) scanTimeZone() (bool, error) {
+	withTimeZone := false
+	if p.checkWord("WITH") {
+		withTimeZone = true
+	} else if !p.checkWord("WITHOUT") {
+		return false, nil
+	}
+
+	if !p.checkWord("TIME") || !p.checkWord("ZONE") {
+		return false, errors.New("Expecting 'TIME ZONE' but was not.")
+	}
+	return withTimeZone, nil
+}
+
+// Reads the optional fields of an INTERVAL like "YEAR" or "DAY TO SECOND".
+// They only restrict which values can be stored so they are not kept.
+func (p *parser) scanIntervalFields() error {
+	fields := []string{"YEAR", "MONTH", "DAY", "HOUR", "MINUTE", "SECOND"}
+
+	checkField := func() bool {
+		for _, field := range fields {
+			if p.checkWord(field) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if checkField() && p.checkWord("TO") && !checkField() {
+		return errors.New("Expecting an interval field after 'TO' but was not.")
+	}
+	return nil
+}
+
+// Reads the list of numbers in parenthesis after a data type, if there is
+// one.
+func (p *parser) scanTypeModifiers() ([]int, error) {
+	mods := []int{}
+
+	_, hasParams := p.checkToken(tokenTypeLParen)
+	if !hasParams {
+		return mods, nil
+	}
+
+	for {
+		tok, err := p.requireToken(tokenTypeNumber)
+		if err != nil {
+			return nil, err
+		}
+		num, err := strconv.Atoi(string(tok.value))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid type modifier <%s>", tokenString(tok))
 		}
+		mods = append(mods, num)
 
-		if !didAnything {
+		_, comma := p.checkToken(tokenTypeComma)
+		if !comma {
 			break
 		}
-		didAnything = false
 	}
 
-	return constraint, nil
+	_, err := p.requireToken(tokenTypeRParen)
+	if err != nil {
+		return nil, err
+	}
+	return mods, nil
 }
 
-func (p *parser) scanDataType() (dataType, error) {
-	if p.checkWord("INT") {
-		return DataTypeInteger, nil
-	}
-	if p.checkWord("VARCHAR") {
-		return DataTypeVarChar, nil
-	}
-	if p.checkWord("UUID") {
-		return DataTypeUUID, nil
-	}
-	if p.checkWord("TIMESTAMPTZ") {
-		return DataTypeTimestampWithTimeZone, nil
-	}
-	if p.checkWord("JSONB") {
-		return DataTypeBinaryJSON, nil
+// Checks that the modifiers make sense for the type and stores them in
+// Param1 and Param2. Types like CHAR that have an implicit length get it
+// even when it isn't written.
+func applyTypeModifiers(def *ColumnDefinition, name string, mods []int) error {
+	switch def.Type {
+	case DataTypeDecimal, DataTypeNumeric:
+		if len(mods) > 2 {
+			return fmt.Errorf("%s accepts at most two modifiers", name)
+		}
+		if len(mods) > 0 {
+			if mods[0] < 1 || mods[0] > 1000 {
+				return fmt.Errorf("Precision of %s must be between 1 and 1000", name)
+			}
+			def.Param1 = mods[0]
+		}
+		if len(mods) > 1 {
+			if mods[1] > mods[0] {
+				return fmt.Errorf("Scale of %s must be between 0 and its precision", name)
+			}
+			def.Param2 = mods[1]
+		}
+	case DataTypeChar, DataTypeVarChar, DataTypeBit, DataTypeVarBit:
+		if len(mods) > 1 {
+			return fmt.Errorf("%s accepts only one modifier", name)
+		}
+		if len(mods) > 0 {
+			if mods[0] < 1 {
+				return fmt.Errorf("Length of %s must be at least 1", name)
+			}
+			def.Param1 = mods[0]
+		} else if def.Type == DataTypeChar || def.Type == DataTypeBit {
+			def.Param1 = 1
+		}
+	case DataTypeTimestamp, DataTypeTimestampWithTimeZone, DataTypeTime,
+		DataTypeTimeWithTimeZone, DataTypeInterval:
+		if len(mods) > 1 {
+			return fmt.Errorf("%s accepts only one modifier", name)
+		}
+		if len(mods) > 0 {
+			if mods[0] > 6 {
+				return fmt.Errorf("Precision of %s must be between 0 and 6", name)
+			}
+			def.Param1 = mods[0]
+		}
+	default:
+		if len(mods) > 0 {
+			return fmt.Errorf("%s does not accept modifiers", name)
+		}
 	}
-	next, _, _ := p.reader.Peek()
-	return 0, fmt.Errorf("Unknown data type <%s>", tokenString(next))
+
+	return nil
 }
 
 func (p *parser) requireToken(tokType tokenType) (token, error) {
@@ -517,13 +2016,53 @@ func (p *parser) advance() error {
 
 func (p *parser) checkWord(word string) bool {
 	next, done, err := p.reader.Peek()
-	if err != nil || done || next.tokType != tokenTypeWord || !strings.EqualFold(string(next.value), word) {
+	if err != nil || done || !isKeyword(next, word) {
 		return false
 	}
 	_, _, _ = p.reader.Next()
 	return true
 }
 
+// Reads the name of a table, type or other object, which can be qualified with
+// a schema like "audit.events". The token holds the whole name, with parts
+// that hold a dot or a quote quoted (see splitQualifiedName), and raw holds
+// the name as it was written.
+func (p *parser) requireQualifiedName() (token, error) {
+	tok, err := p.requireToken(tokenTypeWord)
+	if err != nil {
+		return token{}, err
+	}
+
+	_, qualified := p.checkToken(tokenTypeDot)
+	if !qualified {
+		tok.value = []rune(quoteNamePart(string(tok.value)))
+		return tok, nil
+	}
+
+	nameTok, err := p.requireToken(tokenTypeWord)
+	if err != nil {
+		return token{}, err
+	}
+	tok.value = []rune(joinQualifiedName(string(tok.value), string(nameTok.value)))
+
+	raw := append([]rune{}, tok.raw...)
+	raw = append(raw, '.')
+	tok.raw = append(raw, nameTok.raw...)
+	if nameTok.location.line == tok.location.line {
+		tok.length = nameTok.location.col + nameTok.length - tok.location.col
+	}
+	return tok, nil
+}
+
+// The word as it was written, for error messages. Unquoted words keep their
+// case and quoted ones their quotes.
+func writtenName(tok token) string {
+	if tok.raw == nil {
+		return string(tok.value)
+	}
+	return string(tok.raw)
+}
+
 func (p *parser) peekToken(tokType tokenType) (token, bool) {
 	next, done, err := p.reader.Peek()
 	if err != nil {
@@ -572,11 +2111,24 @@ func (p *parser) scanSelect() (Select, error) {
 		return Select{}, err
 	}
 
-	limit, err := p.scanLimit()
+	next, err := p.scanNextSelect()
 	if err != nil {
 		return Select{}, err
 	}
 
+	// The LIMIT after the last SELECT of a UNION applies to all of them, so it
+	// belongs to the first one
+	var limit Limit
+	if next != nil {
+		limit = next.Query.Limit
+		next.Query.Limit = Limit{}
+	} else {
+		limit, err = p.scanLimit()
+		if err != nil {
+			return Select{}, err
+		}
+	}
+
 	return Select{
 		Fields: fields,
 		From:   target,
@@ -584,9 +2136,51 @@ func (p *parser) scanSelect() (Select, error) {
 		Where:  where,
 		Having: having,
 		Limit:  limit,
+		Next:   next,
 	}, nil
 }
 
+// Reads a UNION, INTERSECT or EXCEPT and the SELECT after it, if there is one.
+func (p *parser) scanNextSelect() (*NextSelect, error) {
+	var setOp setOpType
+	var word string
+	switch {
+	case p.checkWord("UNION"):
+		setOp, word = SetOpUnion, "UNION"
+	case p.checkWord("INTERSECT"):
+		setOp, word = SetOpIntersect, "INTERSECT"
+	case p.checkWord("EXCEPT"):
+		setOp, word = SetOpExcept, "EXCEPT"
+	default:
+		return nil, nil
+	}
+
+	all := p.checkWord("ALL")
+	if !all {
+		p.checkWord("DISTINCT")
+	}
+	if all && setOp == SetOpUnion {
+		setOp = SetOpUnionAll
+	}
+
+	tok, done, err := p.reader.Next()
+	if err != nil {
+		return nil, err
+	}
+	if done {
+		return nil, fmt.Errorf("Expecting SELECT after %s but got EOF", word)
+	}
+	if !isKeyword(tok, "SELECT") {
+		return nil, fmt.Errorf("Expecting SELECT after %s but got <%s>", word, tokenString(tok))
+	}
+
+	query, err := p.scanSelect()
+	if err != nil {
+		return nil, err
+	}
+	return &NextSelect{SetOp: setOp, Query: query}, nil
+}
+
 func (p *parser) scanLimit() (Limit, error) {
 	if !p.checkWord("LIMIT") {
 		return Limit{}, nil
@@ -617,19 +2211,26 @@ func (p *parser) scanLimit() (Limit, error) {
 
 // Reads a comma-separated list of expressions. eg:
 //   1+1, 'hello', foo.bar
-// The expressions can NOT have aliases like ""'hello' AS name"
-func (p *parser) scanExprList() ([]Expression, error) {
+// The expressions can NOT have aliases like ""'hello' AS name". Also returns
+// where each expression starts.
+func (p *parser) scanExprList() ([]Expression, []charLocation, error) {
 	exprs := []Expression{}
+	locations := []charLocation{}
 	for {
+		first, _, err := p.reader.Peek()
+		if err != nil {
+			return nil, nil, err
+		}
 		expr, err := p.scanExpr()
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		exprs = append(exprs, expr)
+		locations = append(locations, first.location)
 
 		next, done, err := p.reader.Peek()
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		} else if done {
 			break
 		}
@@ -639,7 +2240,7 @@ func (p *parser) scanExprList() ([]Expression, error) {
 		}
 		_ = p.advance()
 	}
-	return exprs, nil
+	return exprs, locations, nil
 }
 
 func (p *parser) scanFieldList() ([]Field, error) {
@@ -810,16 +2411,18 @@ func (p *parser) scanCondition() (Condition, error) {
 }
 
 func (p *parser) scanBinaryCondition() (BinaryCondition, error) {
+	start := p.reader.Index()
+
 	// Left
 	left, err := p.scanExpr()
 	if err != nil {
-		return BinaryCondition{}, nil
+		return BinaryCondition{}, err
 	}
 
 	// Operator
 	next, done, err := p.reader.Next()
 	if err != nil {
-		return BinaryCondition{}, nil
+		return BinaryCondition{}, err
 	}
 	if done {
 		return BinaryCondition{}, errors.New("Expecting operator but got EOF")
@@ -827,14 +2430,15 @@ func (p *parser) scanBinaryCondition() (BinaryCondition, error) {
 
 	op, err := getBinaryConditionOperator(next)
 	if err != nil {
-		return BinaryCondition{}, nil
+		return BinaryCondition{}, err
 	}
 
 	// Right
 	right, err := p.scanExpr()
 	if err != nil {
-		return BinaryCondition{}, nil
+		return BinaryCondition{}, err
 	}
+	p.claimOptionalUses(start, p.reader.Index())
 
 	return BinaryCondition{
 		Left:  left,
@@ -843,6 +2447,29 @@ func (p *parser) scanBinaryCondition() (BinaryCondition, error) {
 	}, nil
 }
 
+// Records a comparison for every optional parameter used between the tokens at
+// start and end that wasn't already part of a comparison within it.
+func (p *parser) claimOptionalUses(start int, end int) {
+	names := []string{}
+	for i, use := range p.optionalUses {
+		if use.claimed || use.index < start || use.index >= end {
+			continue
+		}
+		p.optionalUses[i].claimed = true
+		if !containsString(names, use.name) {
+			names = append(names, use.name)
+		}
+	}
+
+	if len(names) > 0 {
+		p.optionalConditions = append(p.optionalConditions, optionalCondition{
+			start:  start,
+			end:    end,
+			params: names,
+		})
+	}
+}
+
 func getBinaryConditionOperator(tok token) (binaryCondOpType, error) {
 	switch tok.tokType {
 	case tokenTypeLess:
@@ -858,7 +2485,7 @@ func getBinaryConditionOperator(tok token) (binaryCondOpType, error) {
 	case tokenTypeNotEqual:
 		return BinaryCondOpNotEqual, nil
 	case tokenTypeWord:
-		if strings.EqualFold("IS", string(tok.value)) {
+		if isKeyword(tok, "IS") {
 			return BinaryCondOpIs, nil
 		}
 	}
@@ -878,7 +2505,19 @@ func (p *parser) scanTargetTable() (TargetTable, error) {
 	target := TargetTable{}
 
 	if next.tokType == tokenTypeWord {
-		target.TableName = string(next.value)
+		target.TableName = quoteNamePart(string(next.value))
+		target.WrittenTableName = writtenName(next)
+
+		// Schema qualified table name (eg: audit.events)
+		_, qualified := p.checkToken(tokenTypeDot)
+		if qualified {
+			nameTok, err := p.requireToken(tokenTypeWord)
+			if err != nil {
+				return TargetTable{}, err
+			}
+			target.TableName = joinQualifiedName(string(next.value), string(nameTok.value))
+			target.WrittenTableName += "." + writtenName(nameTok)
+		}
 	} else if next.tokType == tokenTypeLParen {
 		next, done, err = p.reader.Next()
 		if err != nil {
@@ -933,6 +2572,9 @@ func isTargetTableAlias(tok token) bool {
 	if tok.tokType != tokenTypeWord {
 		return false
 	}
+	if tok.quoted {
+		return true
+	}
 
 	switch strings.ToUpper(string(tok.value)) {
 	case "INNER":
@@ -945,6 +2587,12 @@ func isTargetTableAlias(tok token) bool {
 		return false
 	case "CROSS":
 		return false
+	case "JOIN":
+		return false
+	case "ON":
+		return false
+	case "WITH":
+		return false
 	case "WHERE":
 		return false
 	case "HAVING":
@@ -955,6 +2603,12 @@ func isTargetTableAlias(tok token) bool {
 		return false
 	case "LIMIT":
 		return false
+	case "UNION":
+		return false
+	case "INTERSECT":
+		return false
+	case "EXCEPT":
+		return false
 	default:
 		return true
 	}
@@ -1031,17 +2685,187 @@ func (p *parser) scanExpr() (Expression, error) {
 	return left, nil
 }
 
-func (p *parser) foundParameter(param Parameter) {
-	for _, existing := range p.parameters {
+func (p *parser) foundParameter(param Parameter, location charLocation) error {
+	for i, existing := range p.parameters {
 		if existing.Name == param.Name {
-			// Parameter already exists so do nothing
-			return
+			// Parameter already exists so only its type can be new
+			if existing.Optional != param.Optional {
+				return fmt.Errorf("The parameter '$%s' is only optional in some places", param.Name)
+			}
+			if param.Type == nil {
+				return nil
+			}
+			if existing.Type != nil && !sameType(*existing.Type, *param.Type) {
+				return fmt.Errorf("The parameter '$%s' is cast to more than one type", param.Name)
+			}
+			p.parameters[i].Type = param.Type
+			return nil
 		}
 	}
 	p.parameters = append(p.parameters, param)
+	p.parameterLocations = append(p.parameterLocations, location)
+	return nil
+}
+
+// Returns true if the two definitions are of the same type, ignoring their
+// names and nullability.
+func sameType(a ColumnDefinition, b ColumnDefinition) bool {
+	return a.Type == b.Type &&
+		a.Param1 == b.Param1 &&
+		a.Param2 == b.Param2 &&
+		a.ArrayDims == b.ArrayDims &&
+		a.TypeName == b.TypeName
+}
+
+// Pins the types of parameters with comments like:
+//   -- param: $since timestamptz null
+//   -- param: $ids int[]
+// A parameter is not nullable unless its annotation ends with NULL. The type
+// must agree with any cast of the parameter.
+func applyParamAnnotations(params []Parameter, comments []comment) error {
+	annotated := map[string]bool{}
+
+	for _, c := range comments {
+		text := strings.TrimSpace(c.text)
+		if c.block || !strings.HasPrefix(text, "param:") {
+			continue
+		}
+
+		name, def, err := parseParamAnnotation(strings.TrimPrefix(text, "param:"))
+		if err != nil {
+			return newLocatedError(c.location, "Invalid parameter annotation: %s", err.Error())
+		}
+		if annotated[name] {
+			return newLocatedError(c.location, "The parameter '$%s' is annotated more than once", name)
+		}
+		annotated[name] = true
+
+		found := false
+		for i, param := range params {
+			if param.Name != name {
+				continue
+			}
+			if param.Type != nil && !sameType(*param.Type, def) {
+				return newLocatedError(
+					c.location,
+					"The annotated type of '$%s' does not match its cast",
+					name)
+			}
+			params[i].Type = &def
+			found = true
+		}
+		if !found {
+			return newLocatedError(c.location, "The parameter '$%s' is annotated but never used", name)
+		}
+	}
+
+	return nil
+}
+
+// Reads the "$since timestamptz null" part of a parameter annotation.
+func parseParamAnnotation(text string) (string, ColumnDefinition, error) {
+	tokens := []token{}
+	err := lex(text, func(tok token) {
+		if tok.tokType != tokenTypeEOF {
+			tokens = append(tokens, tok)
+		}
+	})
+	if err != nil {
+		return "", ColumnDefinition{}, err
+	}
+
+	p := parser{reader: newTokenSliceReader(tokens)}
+	paramTok, err := p.requireToken(tokenTypeParameter)
+	if err != nil {
+		return "", ColumnDefinition{}, err
+	}
+	name := string(paramTok.value)
+
+	def := ColumnDefinition{Name: name}
+	err = p.scanDataType(&def)
+	if err != nil {
+		return "", ColumnDefinition{}, err
+	}
+
+	if p.checkWord("NULL") {
+		def.Nullable = true
+	} else if p.checkWord("NOT") && !p.checkWord("NULL") {
+		return "", ColumnDefinition{}, errors.New("Expecting 'NOT' to be followed by 'NULL' but was not.")
+	}
+
+	extra, done, err := p.reader.Next()
+	if err != nil {
+		return "", ColumnDefinition{}, err
+	}
+	if !done {
+		return "", ColumnDefinition{}, fmt.Errorf("Unexpected <%s>", tokenValueString(extra))
+	}
+
+	return name, def, nil
 }
 
+// Reads an operand along with any subscripts after it like "labels[1]".
 func (p *parser) scanSubExpr() (Expression, error) {
+	expr, err := p.scanOperand()
+	if err != nil {
+		return ColumnExpression{}, err
+	}
+
+	for {
+		_, isCast := p.checkToken(tokenTypeCast)
+		if isCast {
+			expr, err = p.scanCast(expr)
+			if err != nil {
+				return ColumnExpression{}, err
+			}
+			continue
+		}
+
+		_, isSubscript := p.checkToken(tokenTypeLBracket)
+		if !isSubscript {
+			return expr, nil
+		}
+
+		index, err := p.scanExpr()
+		if err != nil {
+			return ColumnExpression{}, err
+		}
+		_, err = p.requireToken(tokenTypeRBracket)
+		if err != nil {
+			return ColumnExpression{}, err
+		}
+
+		expr = SubscriptExpression{
+			Array: expr,
+			Index: index,
+		}
+	}
+}
+
+// Reads the type after the "::" of a cast. A cast of a parameter decides the
+// type of the parameter.
+func (p *parser) scanCast(expr Expression) (Expression, error) {
+	def := ColumnDefinition{}
+	err := p.scanDataType(&def)
+	if err != nil {
+		return ColumnExpression{}, err
+	}
+
+	// The parameter was found when its expression was read, so the location
+	// is never used
+	param, isParam := expr.(ParameterExpression)
+	if isParam {
+		def.Name = param.Name
+		err = p.foundParameter(Parameter{Name: param.Name, Type: &def, Optional: param.Optional}, charLocation{})
+		if err != nil {
+			return ColumnExpression{}, err
+		}
+	}
+
+	return CastExpression{Expr: expr, Type: def}, nil
+}
+
+func (p *parser) scanOperand() (Expression, error) {
 	tok, done, err := p.reader.Next()
 	if err != nil {
 		return ColumnExpression{}, err
@@ -1053,9 +2877,20 @@ func (p *parser) scanSubExpr() (Expression, error) {
 	// Parameters (eg: $foo)
 	if tok.tokType == tokenTypeParameter {
 		param := ParameterExpression{
-			Name: string(tok.value),
+			Name:     string(tok.value),
+			Optional: tok.optional,
+		}
+		if param.Optional {
+			p.optionalUses = append(p.optionalUses, optionalUse{
+				name:     param.Name,
+				index:    p.reader.Index() - 1,
+				location: tok.location,
+			})
+		}
+		err = p.foundParameter(Parameter{Name: param.Name, Optional: param.Optional}, tok.location)
+		if err != nil {
+			return ColumnExpression{}, err
 		}
-		p.foundParameter(Parameter{Name: param.Name})
 		return param, nil
 	}
 
@@ -1107,7 +2942,8 @@ func (p *parser) scanColumnOrCall(firstToken token) (Expression, error) {
 	}
 	if done {
 		return ColumnExpression{
-			ColumnName: string(firstToken.value),
+			ColumnName:        string(firstToken.value),
+			WrittenColumnName: writtenName(firstToken),
 		}, nil
 	}
 
@@ -1126,9 +2962,51 @@ func (p *parser) scanColumnOrCall(firstToken token) (Expression, error) {
 			return ColumnExpression{}, fmt.Errorf(
 				"Expected column name but got <%s>", tokenString(colToken))
 		}
+
+		// Schema qualified function call (eg: pg_catalog.now())
+		_, isCall := p.checkToken(tokenTypeLParen)
+		if isCall {
+			params, err := p.scanFunctionParams()
+			if err != nil {
+				return ColumnExpression{}, err
+			}
+			return FunctionExpression{
+				FuncName:   string(firstToken.value) + "." + string(colToken.value),
+				Parameters: params,
+			}, nil
+		}
+
 		return ColumnExpression{
-			ColumnName: string(colToken.value),
-			TableName:  string(firstToken.value),
+			ColumnName:        string(colToken.value),
+			TableName:         string(firstToken.value),
+			WrittenColumnName: writtenName(colToken),
+			WrittenTableName:  writtenName(firstToken),
+		}, nil
+	}
+
+	// Array constructor (eg: ARRAY[1, 2])
+	if secondToken.tokType == tokenTypeLBracket && isKeyword(firstToken, "ARRAY") {
+		_, _, err = p.reader.Next()
+		if err != nil {
+			return ColumnExpression{}, err
+		}
+		return p.scanArrayElements()
+	}
+
+	// Comparison to the elements of an array (eg: ANY($ids))
+	quantifier, isQuantifier := getQuantifier(firstToken)
+	if secondToken.tokType == tokenTypeLParen && isQuantifier {
+		_, _, err = p.reader.Next()
+		if err != nil {
+			return ColumnExpression{}, err
+		}
+		array, err := p.scanParenthetical()
+		if err != nil {
+			return ColumnExpression{}, err
+		}
+		return QuantifiedExpression{
+			Quantifier: quantifier,
+			Array:      array,
 		}, nil
 	}
 
@@ -1141,7 +3019,7 @@ func (p *parser) scanColumnOrCall(firstToken token) (Expression, error) {
 
 		params, err := p.scanFunctionParams()
 		if err != nil {
-			return ColumnExpression{}, nil
+			return ColumnExpression{}, err
 		}
 		return FunctionExpression{
 			FuncName:   string(firstToken.value),
@@ -1150,12 +3028,71 @@ func (p *parser) scanColumnOrCall(firstToken token) (Expression, error) {
 	}
 
 	return ColumnExpression{
-		ColumnName: string(firstToken.value),
+		ColumnName:        string(firstToken.value),
+		WrittenColumnName: writtenName(firstToken),
 	}, nil
 }
 
+func getQuantifier(tok token) (quantifierType, bool) {
+	if isKeyword(tok, "ANY") || isKeyword(tok, "SOME") {
+		return QuantifierAny, true
+	}
+	if isKeyword(tok, "ALL") {
+		return QuantifierAll, true
+	}
+	return 0, false
+}
+
+// Reads the elements of an array constructor after the opening bracket. The
+// elements of a multidimensional array don't repeat the ARRAY keyword, eg
+// "ARRAY[[1, 2], [3, 4]]".
+func (p *parser) scanArrayElements() (Expression, error) {
+	array := ArrayExpression{Elements: []Expression{}}
+
+	_, empty := p.checkToken(tokenTypeRBracket)
+	if empty {
+		return array, nil
+	}
+
+	for {
+		var elem Expression
+		var err error
+		_, nested := p.checkToken(tokenTypeLBracket)
+		if nested {
+			elem, err = p.scanArrayElements()
+		} else {
+			elem, err = p.scanExpr()
+		}
+		if err != nil {
+			return ColumnExpression{}, err
+		}
+		array.Elements = append(array.Elements, elem)
+
+		next, done, err := p.reader.Next()
+		if err != nil {
+			return ColumnExpression{}, err
+		}
+		if done {
+			return ColumnExpression{}, errors.New("Expecting ']' but got EOF")
+		}
+		if next.tokType == tokenTypeRBracket {
+			return array, nil
+		}
+		if next.tokType != tokenTypeComma {
+			return ColumnExpression{}, fmt.Errorf("Expected ',' or ']' but got <%s>", tokenString(next))
+		}
+	}
+}
+
 func (p *parser) scanFunctionParams() ([]Expression, error) {
 	params := []Expression{}
+
+	// There may be zero params, eg: now()
+	_, empty := p.checkToken(tokenTypeRParen)
+	if empty {
+		return params, nil
+	}
+
 	for {
 		expr, err := p.scanExpr()
 		if err != nil {
@@ -1218,11 +3155,18 @@ func tokenString(tok token) string {
 func tokenValueString(tok token) string {
 	switch tok.tokType {
 	case tokenTypeWord:
+		if tok.raw != nil {
+			return fmt.Sprintf("word: %s", string(tok.raw))
+		}
 		return fmt.Sprintf("word: %s", string(tok.value))
 	case tokenTypeLParen:
 		return "("
 	case tokenTypeRParen:
 		return ")"
+	case tokenTypeLBracket:
+		return "["
+	case tokenTypeRBracket:
+		return "]"
 	case tokenTypeString:
 		return fmt.Sprintf("string: '%s'", string(tok.value))
 	case tokenTypeDot:
@@ -1239,6 +3183,10 @@ func tokenValueString(tok token) string {
 		return "/"
 	case tokenTypeAsterisk:
 		return "*"
+	case tokenTypeCast:
+		return "::"
+	case tokenTypeEOF:
+		return "EOF"
 	default:
 		return "?"
 	}
@@ -135,6 +135,413 @@ func TestCreateTable(t *testing.T) {
 	require.True(t, create.Columns[1].Nullable)
 }
 
+func TestCreateTableConstraints(t *testing.T) {
+	prog, err := Parse(`
+		CREATE TABLE issues (
+			tid UUID NOT NULL REFERENCES tenants ON DELETE CASCADE,
+			id INT NOT NULL,
+			email TEXT CONSTRAINT uq_email UNIQUE NOT NULL,
+			project_key TEXT NOT NULL,
+			parent_id INT NULL REFERENCES issues (id) ON UPDATE SET NULL ON DELETE NO ACTION,
+			PRIMARY KEY (tid, id),
+			CHECK (id > 0),
+			CONSTRAINT fk_project FOREIGN KEY (tid, project_key)
+				REFERENCES projects (tid, "key") MATCH FULL ON DELETE SET DEFAULT DEFERRABLE
+		)`)
+	require.NoError(t, err)
+	require.Len(t, prog.Statements, 1)
+
+	create, ok := prog.Statements[0].(CreateTable)
+	require.True(t, ok)
+	require.Len(t, create.Columns, 5)
+	require.Equal(t, []CreateConstraint{
+		CreateConstraint{
+			Type:    ConstraintTypeForeignKey,
+			Columns: []string{"tid"},
+			References: &ForeignKey{
+				TableName: "tenants",
+				Columns:   []string{},
+				OnDelete:  ReferentialActionCascade,
+			},
+		},
+		CreateConstraint{
+			Name:    "uq_email",
+			Type:    ConstraintTypeUnique,
+			Columns: []string{"email"},
+		},
+		CreateConstraint{
+			Type:    ConstraintTypeForeignKey,
+			Columns: []string{"parent_id"},
+			References: &ForeignKey{
+				TableName: "issues",
+				Columns:   []string{"id"},
+				OnDelete:  ReferentialActionNoAction,
+				OnUpdate:  ReferentialActionSetNull,
+			},
+		},
+		CreateConstraint{
+			Type:    ConstraintTypePrimaryKey,
+			Columns: []string{"tid", "id"},
+		},
+		CreateConstraint{
+			Type: ConstraintTypeCheck,
+			Check: BinaryCondition{
+				Left:  ColumnExpression{ColumnName: "id", WrittenColumnName: "id"},
+				Right: NumberLiteral{Value: "0"},
+				Op:    BinaryCondOpGreatThan,
+			},
+		},
+		CreateConstraint{
+			Name:    "fk_project",
+			Type:    ConstraintTypeForeignKey,
+			Columns: []string{"tid", "project_key"},
+			References: &ForeignKey{
+				TableName: "projects",
+				Columns:   []string{"tid", "key"},
+				OnDelete:  ReferentialActionSetDefault,
+			},
+		},
+	}, create.Constraints)
+	require.False(t, create.Columns[2].Nullable)
+	require.True(t, create.Columns[4].Nullable)
+
+	invalid := []string{
+		"CREATE TABLE t (a INT REFERENCES)",
+		"CREATE TABLE t (a INT REFERENCES u ON INSERT CASCADE)",
+		"CREATE TABLE t (a INT REFERENCES u ON DELETE SET)",
+		"CREATE TABLE t (a INT, FOREIGN KEY (a) u (b))",
+		"CREATE TABLE t (a INT, PRIMARY (a))",
+	}
+	for _, sql := range invalid {
+		_, err := Parse(sql)
+		require.Error(t, err, sql)
+	}
+}
+
+func TestCreateTableDefaultsAndChecks(t *testing.T) {
+	prog, err := Parse(`
+		CREATE TABLE projects (
+			"key" VARCHAR(4) NOT NULL CHECK (char_length("key") > 0),
+			created TIMESTAMPTZ NOT NULL DEFAULT now(),
+			priority INT DEFAULT -1 NOT NULL,
+			"name" TEXT NULL DEFAULT 'untitled' CONSTRAINT ck_name CHECK ("name" <> '') NO INHERIT,
+			CONSTRAINT ck_priority CHECK (priority >= 0 AND priority < 10)
+		);
+		ALTER TABLE projects ADD COLUMN archived INT NOT NULL DEFAULT 0`)
+	require.NoError(t, err)
+	require.Len(t, prog.Statements, 2)
+
+	create, ok := prog.Statements[0].(CreateTable)
+	require.True(t, ok)
+	require.Len(t, create.Columns, 4)
+
+	require.Nil(t, create.Columns[0].Default)
+	require.Equal(t, FunctionExpression{FuncName: "now", Parameters: []Expression{}}, create.Columns[1].Default)
+	require.False(t, create.Columns[1].Nullable)
+	require.Equal(t, UnaryExpression{Right: NumberLiteral{Value: "1"}, Op: UnaryExprOpNegative}, create.Columns[2].Default)
+	require.False(t, create.Columns[2].Nullable)
+	require.Equal(t, StringLiteral{Value: "untitled"}, create.Columns[3].Default)
+	require.True(t, create.Columns[3].Nullable)
+
+	require.Len(t, create.Constraints, 3)
+	require.Equal(t, ConstraintTypeCheck, create.Constraints[0].Type)
+	require.Equal(t, []string{"key"}, create.Constraints[0].Columns)
+	require.Equal(t, BinaryCondition{
+		Left: FunctionExpression{
+			FuncName:   "char_length",
+			Parameters: []Expression{ColumnExpression{ColumnName: "key", WrittenColumnName: `"key"`}},
+		},
+		Right: NumberLiteral{Value: "0"},
+		Op:    BinaryCondOpGreatThan,
+	}, create.Constraints[0].Check)
+	require.Equal(t, "ck_name", create.Constraints[1].Name)
+	require.Equal(t, "ck_priority", create.Constraints[2].Name)
+	require.Empty(t, create.Constraints[2].Columns)
+	_, ok = create.Constraints[2].Check.(LogicalCondition)
+	require.True(t, ok)
+
+	add, ok := prog.Statements[1].(AddColumn)
+	require.True(t, ok)
+	require.Equal(t, NumberLiteral{Value: "0"}, add.Column.Default)
+
+	invalid := []string{
+		"CREATE TABLE t (a INT DEFAULT 1 DEFAULT 2)",
+		"CREATE TABLE t (a INT CHECK a > 0)",
+		"CREATE TABLE t (a INT CHECK (a > 0) NO)",
+		"CREATE TABLE t (a INT NOT NULL BOGUS)",
+	}
+	for _, sql := range invalid {
+		_, err := Parse(sql)
+		require.Error(t, err, sql)
+	}
+}
+
+func TestCreateTableDataTypes(t *testing.T) {
+	prog, err := Parse(`CREATE TABLE everything (
+		a BIGINT,
+		b TEXT,
+		c BOOLEAN,
+		d NUMERIC(10,2),
+		e TIMESTAMP WITH TIME ZONE,
+		f DOUBLE PRECISION,
+		g CHARACTER VARYING(20),
+		h int4,
+		i int8,
+		j bool,
+		k float8,
+		l serial,
+		m CHAR,
+		n TIMESTAMP(3) WITHOUT TIME ZONE,
+		o TIME WITH TIME ZONE,
+		p FLOAT(10),
+		q INTERVAL DAY TO SECOND(2),
+		r BIT VARYING(8),
+		s DECIMAL(5)
+	)`)
+	require.NoError(t, err)
+	require.Len(t, prog.Statements, 1)
+
+	create, ok := prog.Statements[0].(CreateTable)
+	require.True(t, ok)
+	require.Len(t, create.Columns, 19)
+
+	expected := []ColumnDefinition{
+		{Type: DataTypeBigInt},
+		{Type: DataTypeText},
+		{Type: DataTypeBoolean},
+		{Type: DataTypeNumeric, Param1: 10, Param2: 2},
+		{Type: DataTypeTimestampWithTimeZone},
+		{Type: DataTypeDoublePrecision},
+		{Type: DataTypeVarChar, Param1: 20},
+		{Type: DataTypeInteger},
+		{Type: DataTypeBigInt},
+		{Type: DataTypeBoolean},
+		{Type: DataTypeDoublePrecision},
+		{Type: DataTypeSerial},
+		{Type: DataTypeChar, Param1: 1},
+		{Type: DataTypeTimestamp, Param1: 3},
+		{Type: DataTypeTimeWithTimeZone},
+		{Type: DataTypeReal},
+		{Type: DataTypeInterval, Param1: 2},
+		{Type: DataTypeVarBit, Param1: 8},
+		{Type: DataTypeDecimal, Param1: 5},
+	}
+	for i, col := range create.Columns {
+		require.Equal(t, expected[i].Type, col.Type, col.Name)
+		require.Equal(t, expected[i].Param1, col.Param1, col.Name)
+		require.Equal(t, expected[i].Param2, col.Param2, col.Name)
+	}
+}
+
+func TestCreateTableInvalidTypeModifiers(t *testing.T) {
+	invalid := []string{
+		"CREATE TABLE t (a INT(4))",
+		"CREATE TABLE t (a VARCHAR(0))",
+		"CREATE TABLE t (a VARCHAR(10, 2))",
+		"CREATE TABLE t (a NUMERIC(2, 5))",
+		"CREATE TABLE t (a NUMERIC(1, 2, 3))",
+		"CREATE TABLE t (a TIMESTAMP(7))",
+		"CREATE TABLE t (a FLOAT(54))",
+		"CREATE TABLE t (a DOUBLE)",
+		"CREATE TABLE t (a TIME WITH ZONE)",
+	}
+	for _, sql := range invalid {
+		_, err := Parse(sql)
+		require.Error(t, err, sql)
+	}
+}
+
+func TestCreateTableArrays(t *testing.T) {
+	prog, err := Parse("CREATE TABLE t (a TEXT[], b INT[3][3], c INT ARRAY, d VARCHAR(10) ARRAY[4], e UUID)")
+	require.NoError(t, err)
+
+	create, ok := prog.Statements[0].(CreateTable)
+	require.True(t, ok)
+	require.Len(t, create.Columns, 5)
+
+	require.Equal(t, DataTypeText, create.Columns[0].Type)
+	require.Equal(t, 1, create.Columns[0].ArrayDims)
+	require.Equal(t, DataTypeInteger, create.Columns[1].Type)
+	require.Equal(t, 2, create.Columns[1].ArrayDims)
+	require.Equal(t, 1, create.Columns[2].ArrayDims)
+	require.Equal(t, DataTypeVarChar, create.Columns[3].Type)
+	require.Equal(t, 10, create.Columns[3].Param1)
+	require.Equal(t, 1, create.Columns[3].ArrayDims)
+	require.Equal(t, 0, create.Columns[4].ArrayDims)
+}
+
+func TestArrayExpressions(t *testing.T) {
+	prog, err := Parse(`
+		SELECT labels[1], ARRAY[a, b], ARRAY[[1, 2], [3, 4]], ARRAY[]
+		FROM t
+		WHERE id = ANY($ids) AND $label <> ALL(labels)`)
+	require.NoError(t, err)
+	require.Len(t, prog.Parameters, 2)
+
+	query, ok := prog.Statements[0].(Select)
+	require.True(t, ok)
+	require.Len(t, query.Fields, 4)
+
+	require.Equal(t, SubscriptExpression{
+		Array: ColumnExpression{ColumnName: "labels", WrittenColumnName: "labels"},
+		Index: NumberLiteral{Value: "1"},
+	}, query.Fields[0].Expr)
+	require.Equal(t, ArrayExpression{Elements: []Expression{
+		ColumnExpression{ColumnName: "a", WrittenColumnName: "a"},
+		ColumnExpression{ColumnName: "b", WrittenColumnName: "b"},
+	}}, query.Fields[1].Expr)
+	require.Equal(t, ArrayExpression{Elements: []Expression{
+		ArrayExpression{Elements: []Expression{NumberLiteral{Value: "1"}, NumberLiteral{Value: "2"}}},
+		ArrayExpression{Elements: []Expression{NumberLiteral{Value: "3"}, NumberLiteral{Value: "4"}}},
+	}}, query.Fields[2].Expr)
+	require.Equal(t, ArrayExpression{Elements: []Expression{}}, query.Fields[3].Expr)
+
+	where, ok := query.Where.(LogicalCondition)
+	require.True(t, ok)
+	require.Equal(t, BinaryCondition{
+		Left:  ColumnExpression{ColumnName: "id", WrittenColumnName: "id"},
+		Right: QuantifiedExpression{Quantifier: QuantifierAny, Array: ParameterExpression{Name: "ids"}},
+		Op:    BinaryCondOpEqual,
+	}, where.Left)
+	require.Equal(t, BinaryCondition{
+		Left:  ParameterExpression{Name: "label"},
+		Right: QuantifiedExpression{Quantifier: QuantifierAll, Array: ColumnExpression{ColumnName: "labels", WrittenColumnName: "labels"}},
+		Op:    BinaryCondOpNotEqual,
+	}, where.Right)
+}
+
+func TestCreateType(t *testing.T) {
+	prog, err := Parse(`
+		CREATE TYPE status AS ENUM ('open', 'closed');
+		ALTER TYPE status ADD VALUE IF NOT EXISTS 'in_progress' BEFORE 'closed';
+		ALTER TYPE status ADD VALUE 'archived' AFTER 'closed';
+		ALTER TYPE status ADD VALUE 'reopened';
+		CREATE TABLE issues (s status NOT NULL, history status[])`)
+	require.NoError(t, err)
+	require.Len(t, prog.Statements, 5)
+
+	require.Equal(t, CreateEnum{Name: "status", Values: []string{"open", "closed"}}, prog.Statements[0])
+	require.Equal(t, AddEnumValue{
+		TypeName:    "status",
+		Value:       "in_progress",
+		IfNotExists: true,
+		Before:      "closed",
+	}, prog.Statements[1])
+	require.Equal(t, AddEnumValue{TypeName: "status", Value: "archived", After: "closed"}, prog.Statements[2])
+	require.Equal(t, AddEnumValue{TypeName: "status", Value: "reopened"}, prog.Statements[3])
+
+	create, ok := prog.Statements[4].(CreateTable)
+	require.True(t, ok)
+	require.Equal(t, DataTypeEnum, create.Columns[0].Type)
+	require.Equal(t, "status", create.Columns[0].TypeName)
+	require.Equal(t, "status", create.Columns[1].TypeName)
+	require.Equal(t, 1, create.Columns[1].ArrayDims)
+}
+
+func TestDropTableAndRename(t *testing.T) {
+	prog, err := Parse(`
+		DROP TABLE users;
+		DROP TABLE IF EXISTS users, groups CASCADE;
+		ALTER TABLE users RENAME TO people;
+		ALTER TABLE users RENAME COLUMN name TO full_name;
+		ALTER TABLE users RENAME email TO email_address`)
+	require.NoError(t, err)
+	require.Len(t, prog.Statements, 5)
+
+	require.Equal(t, DropTable{TableNames: []string{"users"}}, prog.Statements[0])
+	require.Equal(t, DropTable{
+		TableNames: []string{"users", "groups"},
+		IfExists:   true,
+		Cascade:    true,
+	}, prog.Statements[1])
+	require.Equal(t, RenameTable{From: "users", To: "people"}, prog.Statements[2])
+	require.Equal(t, RenameColumn{
+		TableName:        "users",
+		From:             "name",
+		To:               "full_name",
+		WrittenTableName: "users",
+		WrittenFrom:      "name",
+		WrittenTo:        "full_name",
+	}, prog.Statements[3])
+	require.Equal(t, RenameColumn{
+		TableName:        "users",
+		From:             "email",
+		To:               "email_address",
+		WrittenTableName: "users",
+		WrittenFrom:      "email",
+		WrittenTo:        "email_address",
+	}, prog.Statements[4])
+
+	invalid := []string{
+		"DROP TABLE",
+		"DROP TABLE IF users",
+		"ALTER TABLE users RENAME TO",
+		"ALTER TABLE users RENAME COLUMN a b",
+	}
+	for _, sql := range invalid {
+		_, err := Parse(sql)
+		require.Error(t, err, sql)
+	}
+}
+
+func TestCreateIndex(t *testing.T) {
+	prog, err := Parse(`
+		CREATE INDEX ON users (email);
+		CREATE UNIQUE INDEX CONCURRENTLY IF NOT EXISTS ix_email ON ONLY users USING btree
+			(tid, email text_pattern_ops DESC NULLS LAST) INCLUDE (name) WITH (fillfactor = 70)
+			WHERE deleted IS NULL;
+		CREATE INDEX ix_lower_email ON users (lower(email) COLLATE "C", id);
+		DROP INDEX CONCURRENTLY IF EXISTS ix_email, ix_lower_email CASCADE;
+		DROP INDEX users_email_idx`)
+	require.NoError(t, err)
+	require.Len(t, prog.Statements, 5)
+
+	require.Equal(t, CreateIndex{
+		TableName: "users",
+		Columns:   []string{"email"},
+	}, prog.Statements[0])
+	require.Equal(t, CreateIndex{
+		Name:         "ix_email",
+		TableName:    "users",
+		Columns:      []string{"tid", "email"},
+		Unique:       true,
+		Concurrently: true,
+		IfNotExists:  true,
+		Where: BinaryCondition{
+			Left:  ColumnExpression{ColumnName: "deleted", WrittenColumnName: "deleted"},
+			Right: ColumnExpression{ColumnName: "null", WrittenColumnName: "NULL"},
+			Op:    BinaryCondOpIs,
+		},
+	}, prog.Statements[1])
+	require.Equal(t, CreateIndex{
+		Name:           "ix_lower_email",
+		TableName:      "users",
+		Columns:        []string{"id"},
+		HasExpressions: true,
+	}, prog.Statements[2])
+	require.Equal(t, DropIndex{
+		Names:        []string{"ix_email", "ix_lower_email"},
+		Concurrently: true,
+		IfExists:     true,
+		Cascade:      true,
+	}, prog.Statements[3])
+	require.Equal(t, DropIndex{Names: []string{"users_email_idx"}}, prog.Statements[4])
+
+	invalid := []string{
+		"CREATE UNIQUE users_email ON users (email)",
+		"CREATE INDEX IF NOT EXISTS ON users (email)",
+		"CREATE INDEX ix users (email)",
+		"CREATE INDEX ix ON users (email NULLS)",
+		"CREATE INDEX ix ON users (email",
+		"DROP INDEX IF ix",
+		"DROP INDEX",
+	}
+	for _, sql := range invalid {
+		_, err := Parse(sql)
+		require.Error(t, err, sql)
+	}
+}
+
 func TestAddColumn(t *testing.T) {
 	prog, err := Parse("ALTER TABLE people ADD COLUMN name VARCHAR(200) NOT NULL")
 	require.NoError(t, err)
@@ -149,6 +556,247 @@ func TestAddColumn(t *testing.T) {
 	require.Equal(t, 200, addColumn.Column.Param1)
 }
 
+func TestAlterTable(t *testing.T) {
+	prog, err := Parse(`
+		ALTER TABLE ONLY users
+			ADD IF NOT EXISTS email TEXT NULL UNIQUE,
+			ADD CONSTRAINT users_name_key UNIQUE (name),
+			DROP COLUMN IF EXISTS age CASCADE,
+			DROP CONSTRAINT users_old_check RESTRICT;
+		ALTER TABLE users
+			ALTER COLUMN id TYPE BIGINT USING id::bigint,
+			ALTER name SET DATA TYPE VARCHAR(100) COLLATE "C",
+			ALTER COLUMN name SET NOT NULL,
+			ALTER COLUMN email DROP NOT NULL,
+			ALTER COLUMN email SET DEFAULT '',
+			ALTER COLUMN email DROP DEFAULT`)
+	require.NoError(t, err)
+	require.Len(t, prog.Statements, 10)
+
+	add, ok := prog.Statements[0].(AddColumn)
+	require.True(t, ok)
+	require.True(t, add.IfNotExists)
+	require.Equal(t, "email", add.Column.Name)
+	require.True(t, add.Column.Nullable)
+	require.Len(t, add.Constraints, 1)
+	require.Equal(t, ConstraintTypeUnique, add.Constraints[0].Type)
+
+	require.Equal(t, AddConstraint{
+		TableName: "users",
+		Constraint: CreateConstraint{
+			Name:    "users_name_key",
+			Type:    ConstraintTypeUnique,
+			Columns: []string{"name"},
+		},
+	}, prog.Statements[1])
+	require.Equal(t, DropColumn{
+		TableName:         "users",
+		ColumnName:        "age",
+		IfExists:          true,
+		Cascade:           true,
+		WrittenTableName:  "users",
+		WrittenColumnName: "age",
+	}, prog.Statements[2])
+	require.Equal(t, DropConstraint{TableName: "users", Name: "users_old_check"}, prog.Statements[3])
+
+	alterType, ok := prog.Statements[4].(AlterColumnType)
+	require.True(t, ok)
+	require.Equal(t, "id", alterType.ColumnName)
+	require.Equal(t, DataTypeBigInt, alterType.NewType.Type)
+	require.NotNil(t, alterType.Using)
+
+	alterType, ok = prog.Statements[5].(AlterColumnType)
+	require.True(t, ok)
+	require.Equal(t, DataTypeVarChar, alterType.NewType.Type)
+	require.Equal(t, 100, alterType.NewType.Param1)
+	require.Nil(t, alterType.Using)
+
+	require.Equal(t, AlterColumnNullable{
+		TableName:         "users",
+		ColumnName:        "name",
+		WrittenTableName:  "users",
+		WrittenColumnName: "name",
+	}, prog.Statements[6])
+	require.Equal(t, AlterColumnNullable{
+		TableName:         "users",
+		ColumnName:        "email",
+		Nullable:          true,
+		WrittenTableName:  "users",
+		WrittenColumnName: "email",
+	}, prog.Statements[7])
+
+	setDefault, ok := prog.Statements[8].(AlterColumnDefault)
+	require.True(t, ok)
+	require.Equal(t, StringLiteral{Value: ""}, setDefault.Default)
+	require.Equal(t, AlterColumnDefault{
+		TableName:         "users",
+		ColumnName:        "email",
+		WrittenTableName:  "users",
+		WrittenColumnName: "email",
+	}, prog.Statements[9])
+
+	invalid := []string{
+		"ALTER TABLE users",
+		"ALTER TABLE users ADD",
+		"ALTER TABLE users ADD COLUMN IF EXISTS a INT",
+		"ALTER TABLE users ADD EXCLUDE USING gist (a WITH =)",
+		"ALTER TABLE users DROP",
+		"ALTER TABLE users DROP CONSTRAINT",
+		"ALTER TABLE users ALTER COLUMN a SET",
+		"ALTER TABLE users ALTER COLUMN a DROP NULL",
+		"ALTER TABLE users ALTER COLUMN a SET DATA BIGINT",
+		"ALTER TABLE users ALTER COLUMN a TYPE",
+		"ALTER TABLE users ADD COLUMN a INT,",
+		"ALTER TABLE users OWNER TO bob",
+	}
+	for _, sql := range invalid {
+		_, err := Parse(sql)
+		require.Error(t, err, sql)
+	}
+}
+
+func TestCreateView(t *testing.T) {
+	prog, err := Parse(`
+		CREATE VIEW active_users AS SELECT id FROM users WHERE deleted IS NULL;
+		CREATE OR REPLACE VIEW active_users (user_id) AS SELECT id FROM users;
+		CREATE MATERIALIZED VIEW IF NOT EXISTS user_logins AS
+			SELECT u.id, logins.at FROM users u JOIN logins ON logins.user_id = u.id WITH NO DATA;
+		REFRESH MATERIALIZED VIEW CONCURRENTLY user_logins WITH DATA;
+		DROP VIEW IF EXISTS active_users, others CASCADE;
+		DROP MATERIALIZED VIEW user_logins`)
+	require.NoError(t, err)
+	require.Len(t, prog.Statements, 6)
+
+	view, ok := prog.Statements[0].(CreateView)
+	require.True(t, ok)
+	require.Equal(t, "active_users", view.Name)
+	require.Equal(t, "users", view.Query.From.TableName)
+	require.NotNil(t, view.Query.Where)
+	require.False(t, view.OrReplace)
+	require.False(t, view.Materialized)
+
+	view, ok = prog.Statements[1].(CreateView)
+	require.True(t, ok)
+	require.True(t, view.OrReplace)
+	require.Equal(t, []string{"user_id"}, view.ColumnNames)
+
+	view, ok = prog.Statements[2].(CreateView)
+	require.True(t, ok)
+	require.True(t, view.Materialized)
+	require.True(t, view.IfNotExists)
+	require.Len(t, view.Query.Joins, 1)
+	require.Equal(t, "", view.Query.Joins[0].Target.Alias)
+
+	require.Equal(t, RefreshMaterializedView{Name: "user_logins", Concurrently: true}, prog.Statements[3])
+	require.Equal(t, DropView{
+		Names:    []string{"active_users", "others"},
+		IfExists: true,
+		Cascade:  true,
+	}, prog.Statements[4])
+	require.Equal(t, DropView{Names: []string{"user_logins"}, Materialized: true}, prog.Statements[5])
+
+	invalid := []string{
+		"CREATE OR VIEW v AS SELECT id FROM users",
+		"CREATE OR REPLACE MATERIALIZED VIEW v AS SELECT id FROM users",
+		"CREATE VIEW IF NOT EXISTS v AS SELECT id FROM users",
+		"CREATE VIEW v SELECT id FROM users",
+		"CREATE VIEW v AS INSERT INTO users (id) VALUES (1)",
+		"CREATE MATERIALIZED VIEW v AS SELECT id FROM users WITH NO",
+		"REFRESH VIEW v",
+		"DROP MATERIALIZED v",
+	}
+	for _, sql := range invalid {
+		_, err := Parse(sql)
+		require.Error(t, err, sql)
+	}
+}
+
+func TestSchemas(t *testing.T) {
+	prog, err := Parse(`
+		CREATE SCHEMA IF NOT EXISTS audit AUTHORIZATION admin;
+		SET search_path TO audit, 'public';
+		SET LOCAL search_path = DEFAULT;
+		CREATE TYPE audit.level AS ENUM ('info');
+		CREATE TABLE audit.events (
+			id pg_catalog.int4 NOT NULL,
+			level audit.level NOT NULL,
+			user_id INT NOT NULL REFERENCES public.users
+		);
+		SELECT e.id, pg_catalog.now() FROM audit.events e JOIN public.users ON users.id = e.user_id;
+		INSERT INTO audit.events (id) VALUES (1)`)
+	require.NoError(t, err)
+	require.Len(t, prog.Statements, 7)
+
+	require.Equal(t, CreateSchema{Name: "audit", IfNotExists: true}, prog.Statements[0])
+	require.Equal(t, SetVariable{Name: "search_path", Values: []string{"audit", "public"}}, prog.Statements[1])
+	require.Equal(t, SetVariable{Name: "search_path", Values: []string{}, Local: true}, prog.Statements[2])
+	require.Equal(t, "audit.level", prog.Statements[3].(CreateEnum).Name)
+
+	create := prog.Statements[4].(CreateTable)
+	require.Equal(t, "audit.events", create.Name)
+	require.Equal(t, DataTypeInteger, create.Columns[0].Type)
+	require.Equal(t, DataTypeEnum, create.Columns[1].Type)
+	require.Equal(t, "audit.level", create.Columns[1].TypeName)
+	require.Equal(t, "public.users", create.Constraints[0].References.TableName)
+
+	sel := prog.Statements[5].(Select)
+	require.Equal(t, TargetTable{TableName: "audit.events", Alias: "e", WrittenTableName: "audit.events"}, sel.From)
+	require.Equal(t, TargetTable{TableName: "public.users", WrittenTableName: "public.users"}, sel.Joins[0].Target)
+	require.Equal(t, "pg_catalog.now", sel.Fields[1].Expr.(FunctionExpression).FuncName)
+
+	require.Equal(t, "audit.events", prog.Statements[6].(Insert).Target.TableName)
+
+	invalid := []string{
+		"CREATE SCHEMA",
+		"CREATE SCHEMA IF EXISTS audit",
+		"SET search_path audit",
+		"SET search_path TO",
+		"CREATE TABLE audit. (id INT)",
+		"SELECT id FROM audit.",
+	}
+	for _, sql := range invalid {
+		_, err := Parse(sql)
+		require.Error(t, err, sql)
+	}
+}
+
+func TestQuotedQualifiedNames(t *testing.T) {
+	prog, err := Parse(`
+		CREATE TABLE "a.b".c (id INT NOT NULL);
+		CREATE TABLE a."b.c" (id INT NOT NULL);
+		CREATE TABLE "x.y" (id INT NOT NULL);
+		SELECT x.id FROM "a.b".c x JOIN a."b.c" y ON y.id = x.id`)
+	require.NoError(t, err)
+
+	require.Equal(t, `"a.b".c`, prog.Statements[0].(CreateTable).Name)
+	require.Equal(t, `a."b.c"`, prog.Statements[1].(CreateTable).Name)
+	require.Equal(t, `"x.y"`, prog.Statements[2].(CreateTable).Name)
+
+	sel := prog.Statements[3].(Select)
+	require.Equal(t, TargetTable{TableName: `"a.b".c`, Alias: "x", WrittenTableName: `"a.b".c`}, sel.From)
+	require.Equal(t, TargetTable{TableName: `a."b.c"`, Alias: "y", WrittenTableName: `a."b.c"`}, sel.Joins[0].Target)
+
+	schema, name := splitQualifiedName(`"a.b".c`)
+	require.Equal(t, []string{"a.b", "c"}, []string{schema, name})
+	schema, name = splitQualifiedName(`a."b.c"`)
+	require.Equal(t, []string{"a", "b.c"}, []string{schema, name})
+	schema, name = splitQualifiedName(`"say ""hi"".x"`)
+	require.Equal(t, []string{"", `say "hi".x`}, []string{schema, name})
+
+	// The token keeps the whole name as it was written
+	buffer := newTokenBuffer()
+	go (func() {
+		_ = lex(`Audit."Big.Events"`, buffer.Write)
+		buffer.Done()
+	})()
+	p := parser{reader: buffer, parameters: []Parameter{}}
+	tok, err := p.requireQualifiedName()
+	require.NoError(t, err)
+	require.Equal(t, `audit."Big.Events"`, string(tok.value))
+	require.Equal(t, `Audit."Big.Events"`, string(tok.raw))
+	require.Equal(t, 18, tok.length)
+}
+
 func TestSelectFeatures(t *testing.T) {
 	prog, err := Parse(`
 		SELECT 
@@ -280,3 +928,167 @@ func TestLimit(t *testing.T) {
 	require.True(t, selectStmt.Limit.HasLimit)
 	require.Equal(t, selectStmt.Limit.Count, 1)
 }
+
+func TestSetOperations(t *testing.T) {
+	prog, err := Parse(`
+		SELECT id FROM a WHERE x = 1
+		UNION SELECT id FROM b
+		UNION ALL SELECT id FROM c
+		EXCEPT SELECT id FROM d LIMIT 1`)
+	require.NoError(t, err)
+	require.Len(t, prog.Statements, 1)
+
+	selectStmt, ok := prog.Statements[0].(Select)
+	require.True(t, ok)
+	require.Equal(t, "a", selectStmt.From.TableName)
+	require.Equal(t, "", selectStmt.From.Alias)
+	require.Equal(t, 1, selectStmt.Limit.Count)
+
+	next := selectStmt.Next
+	require.NotNil(t, next)
+	require.Equal(t, SetOpUnion, next.SetOp)
+	require.Equal(t, "b", next.Query.From.TableName)
+	require.Equal(t, SetOpUnionAll, next.Query.Next.SetOp)
+	require.Equal(t, SetOpExcept, next.Query.Next.Query.Next.SetOp)
+	require.Equal(t, "d", next.Query.Next.Query.Next.Query.From.TableName)
+	require.False(t, next.Query.Next.Query.Next.Query.Limit.HasLimit)
+
+	_, err = Parse("SELECT id FROM a UNION id FROM b")
+	require.Error(t, err)
+}
+
+func TestParseErrorShowsIdentifierAsWritten(t *testing.T) {
+	_, err := Parse(`CREATE TABLE Users (Id INT NOT NULL PRIMARY KEY FooBar)`)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "FooBar")
+}
+
+func TestParseWithComments(t *testing.T) {
+	prog, err := Parse(`
+		-- The users table
+		CREATE TABLE users (
+			id INT NOT NULL, -- always set
+			/* email TEXT, */
+			name TEXT
+		);
+		SELECT id -- , name
+		FROM users /* u */;`)
+	require.NoError(t, err)
+	require.Len(t, prog.Statements, 2)
+
+	create, ok := prog.Statements[0].(CreateTable)
+	require.True(t, ok)
+	require.Len(t, create.Columns, 2)
+
+	sel, ok := prog.Statements[1].(Select)
+	require.True(t, ok)
+	require.Len(t, sel.Fields, 1)
+	require.Equal(t, "", sel.From.Alias)
+}
+
+func TestParseStringForms(t *testing.T) {
+	prog, err := Parse(`
+		CREATE TYPE mood AS ENUM ('it''s', E'a\tb', $$c$$, $tag$it's$tag$);
+		CREATE TABLE notes (body TEXT NOT NULL DEFAULT E'l\'été');`)
+	require.NoError(t, err)
+	require.Len(t, prog.Statements, 2)
+
+	enum, ok := prog.Statements[0].(CreateEnum)
+	require.True(t, ok)
+	require.Equal(t, []string{"it's", "a\tb", "c", "it's"}, enum.Values)
+
+	create, ok := prog.Statements[1].(CreateTable)
+	require.True(t, ok)
+	require.Equal(t, StringLiteral{Value: "l'été"}, create.Columns[0].Default)
+}
+
+func TestParseCast(t *testing.T) {
+	prog, err := Parse(`SELECT created::date, $n::numeric(10, 2) FROM t WHERE created > $since::timestamptz AND id = $id`)
+	require.NoError(t, err)
+
+	sel, ok := prog.Statements[0].(Select)
+	require.True(t, ok)
+	require.Equal(t, CastExpression{
+		Expr: ColumnExpression{ColumnName: "created", WrittenColumnName: "created"},
+		Type: ColumnDefinition{Type: DataTypeDate},
+	}, sel.Fields[0].Expr)
+
+	numeric := ColumnDefinition{Name: "n", Type: DataTypeNumeric, Param1: 10, Param2: 2}
+	since := ColumnDefinition{Name: "since", Type: DataTypeTimestampWithTimeZone}
+	require.Equal(t, []Parameter{
+		{Name: "n", Type: &numeric},
+		{Name: "since", Type: &since},
+		{Name: "id"},
+	}, prog.Parameters)
+
+	_, err = Parse(`SELECT a FROM t WHERE a = $a::int AND b = $a::text`)
+	require.Error(t, err)
+}
+
+func TestParseParamAnnotations(t *testing.T) {
+	prog, err := Parse(`
+		-- param: $since timestamptz null
+		-- param: $ids int[] not null
+		/* param: $skipped text */
+		SELECT a FROM t WHERE b > $since AND c = ANY($ids::int[]) AND d = $skipped`)
+	require.NoError(t, err)
+
+	since := ColumnDefinition{Name: "since", Type: DataTypeTimestampWithTimeZone, Nullable: true}
+	ids := ColumnDefinition{Name: "ids", Type: DataTypeInteger, ArrayDims: 1}
+	require.Equal(t, []Parameter{
+		{Name: "since", Type: &since},
+		{Name: "ids", Type: &ids},
+		{Name: "skipped"},
+	}, prog.Parameters)
+
+	// Annotations after the last statement still apply
+	prog, err = Parse("SELECT a FROM t WHERE b = $b;\n-- param: $b text")
+	require.NoError(t, err)
+	require.Equal(t, DataTypeText, prog.Parameters[0].Type.Type)
+
+	invalid := []string{
+		"-- param: $nope text\nSELECT a FROM t WHERE b = $b",
+		"-- param: $b text\n-- param: $b text\nSELECT a FROM t WHERE b = $b",
+		"-- param: $b text\nSELECT a FROM t WHERE b = $b::int",
+		"-- param: b text\nSELECT a FROM t WHERE b = $b",
+		"-- param: $b\nSELECT a FROM t WHERE b = $b",
+		"-- param: $b text maybe\nSELECT a FROM t WHERE b = $b",
+		"-- param: $b text not\nSELECT a FROM t WHERE b = $b",
+		"SELECT a FROM t WHERE b = $b\n-- param: $nope text",
+	}
+	for _, sql := range invalid {
+		_, err := Parse(sql)
+		require.Error(t, err, sql)
+	}
+}
+
+func TestParseOptionalParameters(t *testing.T) {
+	prog, err := Parse(`SELECT a FROM t WHERE b = $b? AND c = ANY($c?) AND d < $b?`)
+	require.NoError(t, err)
+
+	sel, ok := prog.Statements[0].(Select)
+	require.True(t, ok)
+	where, ok := sel.Where.(LogicalCondition)
+	require.True(t, ok)
+	require.Equal(t, []Parameter{
+		{Name: "b", Optional: true},
+		{Name: "c", Optional: true},
+	}, prog.Parameters)
+	require.Equal(t, ParameterExpression{Name: "b", Optional: true}, where.Left.(LogicalCondition).Left.(BinaryCondition).Right)
+
+	require.Equal(t, []optionalCondition{
+		{start: 5, end: 8, params: []string{"b"}},
+		{start: 9, end: 15, params: []string{"c"}},
+		{start: 16, end: 19, params: []string{"b"}},
+	}, prog.optionalConditions)
+
+	invalid := []string{
+		"SELECT $a? FROM t",
+		"SELECT a FROM t WHERE b = $b? AND c = $b",
+		"INSERT INTO t (a) VALUES ($a?)",
+	}
+	for _, sql := range invalid {
+		_, err := Parse(sql)
+		require.Error(t, err, sql)
+	}
+}
@@ -1,17 +1,60 @@
 package lib
 
 import (
+	"fmt"
 	"io/ioutil"
 	"path"
 	"strings"
+	"unicode"
 )
 
 type QueryBatch struct {
 	Name       string
+	Kind       queryKind
 	SQL        string
 	AST        []Statement
 	Shapes     []Shape
 	Parameters []Parameter
+
+	// The SQL of each statement in AST on its own, ready to send to Postgres.
+	Positional []PositionalStatement
+
+	// The column that decides the type of each parameter, for the parameters
+	// where one could be found.
+	ParameterColumns map[string]ColumnDefinition
+}
+
+// A single statement where named parameters like $tid have been replaced by
+// the positional parameters ($1, $2, etc) that Postgres understands.
+// Parameters holds the name of the parameter at each position.
+type PositionalStatement struct {
+	SQL        string
+	Parameters []string
+}
+
+// Enum for the kinds of query that an annotation like "-- name: GetIssue :one"
+// can declare. The kind is checked against the shape of every statement in the
+// query.
+//   QueryKindNone: there was no annotation
+//   QueryKindOne: :one, returns at most one row
+//   QueryKindMany: :many, returns any number of rows
+//   QueryKindExec: :exec, returns no rows
+//   QueryKindExecRows: :execrows, returns no rows
+type queryKind int
+
+const (
+	QueryKindNone queryKind = iota
+	QueryKindOne
+	QueryKindMany
+	QueryKindExec
+	QueryKindExecRows
+)
+
+var queryKindNames = map[string]queryKind{
+	":one":      QueryKindOne,
+	":many":     QueryKindMany,
+	":exec":     QueryKindExec,
+	":execrows": QueryKindExecRows,
 }
 
 func ReadQueriesFromDir(dir string, model Model) ([]QueryBatch, error) {
@@ -24,26 +67,284 @@ func ReadQueriesFromDir(dir string, model Model) ([]QueryBatch, error) {
 
 	for _, file := range files {
 		filePath := path.Join(dir, file.Name())
-		b, err := ReadBatchFromFile(filePath, model)
+		b, err := ReadBatchesFromFile(filePath, model)
 		if err != nil {
 			return nil, err
 		}
-		batches = append(batches, b)
+		batches = append(batches, b...)
 	}
 
 	return batches, nil
 }
 
+// Reads a file that holds a single query. See ReadBatchesFromFile.
 func ReadBatchFromFile(filePath string, model Model) (QueryBatch, error) {
+	batches, err := ReadBatchesFromFile(filePath, model)
+	if err != nil {
+		return QueryBatch{}, err
+	}
+	if len(batches) != 1 {
+		return QueryBatch{}, newQueryError(
+			filePath,
+			fmt.Errorf("Expecting one query but found %d", len(batches)))
+	}
+	return batches[0], nil
+}
+
+// A file without annotations is one query that is named after the file. A file
+// with annotations holds a query for each one, made up of the statements up to
+// the next annotation:
+//   -- name: GetTag :one
+//   SELECT "key" FROM tags WHERE tid = $tid AND "key" = $key;
+//
+//   -- name: CreateTag :exec
+//   INSERT INTO tags (tid, "key") VALUES ($tid, $key);
+func ReadBatchesFromFile(filePath string, model Model) ([]QueryBatch, error) {
 	// Read text from file
 	bytes, err := ioutil.ReadFile(filePath)
 	if err != nil {
-		return QueryBatch{}, err
+		return nil, err
+	}
+
+	batches, err := newQueryBatches(batchNameFromPath(filePath), string(bytes), model)
+	if err != nil {
+		return nil, newQueryError(filePath, err)
+	}
+	return batches, nil
+}
+
+// An error in a query file. Line and Col are zero when the error can not be
+// traced back to a specific place in the file.
+type QueryError struct {
+	File    string
+	Line    int
+	Col     int
+	Message string
+}
+
+func newQueryError(filePath string, err error) QueryError {
+	_, fileName := path.Split(filePath)
+	located, ok := err.(locatedError)
+	if !ok {
+		return QueryError{File: fileName, Message: err.Error()}
+	}
+	return QueryError{
+		File:    fileName,
+		Line:    located.location.line,
+		Col:     located.location.col,
+		Message: located.message,
+	}
+}
+
+func (e QueryError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.File, e.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Col, e.Message)
+}
+
+// A "-- name: GetIssue :one" comment that starts a query within a file.
+// Offset is the index of the first character of the comment.
+type queryAnnotation struct {
+	name     string
+	kind     queryKind
+	location charLocation
+	offset   int
+}
+
+func newQueryBatches(fileName string, sql string, model Model) ([]QueryBatch, error) {
+	annotations, err := findQueryAnnotations(sql)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(annotations) == 0 {
+		batch, err := newQueryBatch(fileName, sql, model)
+		if err != nil {
+			return nil, err
+		}
+		return []QueryBatch{batch}, nil
+	}
+
+	text := []rune(sql)
+	batches := []QueryBatch{}
+	for i, annotation := range annotations {
+		end := len(text)
+		if i+1 < len(annotations) {
+			end = annotations[i+1].offset
+		}
+
+		// Everything before the query is blanked out rather than cut off so
+		// that the locations in errors are still right for the whole file.
+		batch, err := newQueryBatch(annotation.name, blankBefore(text[:end], annotation.offset), model)
+		if err != nil {
+			return nil, err
+		}
+		batch.SQL = strings.TrimSpace(string(text[annotation.offset:end]))
+		batch.Kind = annotation.kind
+
+		err = checkQueryKind(batch, annotation)
+		if err != nil {
+			return nil, err
+		}
+		batches = append(batches, batch)
+	}
+
+	return batches, nil
+}
+
+// Finds the annotations in sql, which must each come right before a
+// statement.
+func findQueryAnnotations(sql string) ([]queryAnnotation, error) {
+	tokens := []token{}
+	err := lex(sql, func(tok token) {
+		tokens = append(tokens, tok)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := newRuneOffsets([]rune(sql))
+	annotations := []queryAnnotation{}
+	startsStatement := true
+
+	// The start of a statement that came before any annotation, which is an
+	// error when the file turns out to have annotations.
+	var unannotated *charLocation
+
+	for _, tok := range tokens {
+		for _, c := range tok.trivia {
+			annotation, ok, err := parseQueryAnnotation(c)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+			if !startsStatement {
+				return nil, newLocatedError(
+					c.location,
+					"The annotation of '%s' must come before the start of a statement",
+					annotation.name)
+			}
+			annotation.offset = offsets.of(c.location)
+			annotations = append(annotations, annotation)
+		}
+		if tok.tokType == tokenTypeEOF {
+			break
+		}
+
+		isStart := startsStatement && tok.tokType != tokenTypeSemicolon
+		if isStart && len(annotations) == 0 && unannotated == nil {
+			location := tok.location
+			unannotated = &location
+		}
+		startsStatement = tok.tokType == tokenTypeSemicolon
+	}
+
+	if len(annotations) > 0 && unannotated != nil {
+		return nil, newLocatedError(*unannotated, "Expecting a '-- name:' annotation before the statement")
+	}
+
+	return annotations, nil
+}
+
+// Reads a comment like "-- name: GetIssue :one". Other comments are ignored.
+func parseQueryAnnotation(c comment) (queryAnnotation, bool, error) {
+	text := strings.TrimSpace(c.text)
+	if c.block || !strings.HasPrefix(text, "name:") {
+		return queryAnnotation{}, false, nil
+	}
+
+	fields := strings.Fields(strings.TrimPrefix(text, "name:"))
+	if len(fields) != 2 {
+		return queryAnnotation{}, false, newLocatedError(
+			c.location,
+			"Expecting an annotation like '-- name: GetIssue :one' but got '--%s'",
+			c.text)
+	}
+
+	name := fields[0]
+	for i, ch := range name {
+		valid := ch == '_' || unicode.IsLetter(ch) || (i > 0 && unicode.IsDigit(ch))
+		if !valid {
+			return queryAnnotation{}, false, newLocatedError(c.location, "Invalid query name '%s'", name)
+		}
+	}
+
+	kind, ok := queryKindNames[fields[1]]
+	if !ok {
+		return queryAnnotation{}, false, newLocatedError(
+			c.location,
+			"Unknown query kind '%s' for '%s', expecting :one, :many, :exec or :execrows",
+			fields[1],
+			name)
+	}
+
+	return queryAnnotation{name: name, kind: kind, location: c.location}, true, nil
+}
+
+// Returns text with everything before start replaced by spaces, keeping the
+// line breaks.
+func blankBefore(text []rune, start int) string {
+	blanked := make([]rune, len(text))
+	for i, ch := range text {
+		if i < start && ch != '\n' {
+			ch = ' '
+		}
+		blanked[i] = ch
+	}
+	return string(blanked)
+}
+
+// Makes sure that the inferred shape of every statement agrees with the kind
+// of query that the annotation declared.
+func checkQueryKind(batch QueryBatch, annotation queryAnnotation) error {
+	if len(batch.AST) == 0 {
+		return newLocatedError(annotation.location, "The query '%s' has no statements", annotation.name)
+	}
+
+	for _, shape := range batch.Shapes {
+		switch {
+		case annotation.kind == QueryKindOne && shape.Type == QueryResultTypeManyRows:
+			return newLocatedError(
+				annotation.location,
+				"The query '%s' is annotated :one but can return more than one row",
+				annotation.name)
+		case (annotation.kind == QueryKindOne || annotation.kind == QueryKindMany) &&
+			shape.Type == QueryResultTypeCommand:
+			return newLocatedError(
+				annotation.location,
+				"The query '%s' is annotated %s but does not return rows",
+				annotation.name,
+				annotation.kind)
+		case (annotation.kind == QueryKindExec || annotation.kind == QueryKindExecRows) &&
+			shape.Type != QueryResultTypeCommand:
+			return newLocatedError(
+				annotation.location,
+				"The query '%s' is annotated %s but returns rows",
+				annotation.name,
+				annotation.kind)
+		}
 	}
+	return nil
+}
+
+func (k queryKind) String() string {
+	for name, kind := range queryKindNames {
+		if kind == k {
+			return name
+		}
+	}
+	return ""
+}
+
+func newQueryBatch(name string, sql string, model Model) (QueryBatch, error) {
 	query := QueryBatch{
-		Name:   batchNameFromPath(filePath),
-		SQL:    string(bytes),
-		Shapes: []Shape{},
+		Name:             name,
+		SQL:              sql,
+		Shapes:           []Shape{},
+		ParameterColumns: map[string]ColumnDefinition{},
 	}
 
 	// Parse the file to get AST
@@ -54,6 +355,30 @@ func ReadBatchFromFile(filePath string, model Model) (QueryBatch, error) {
 	query.AST = prog.Statements
 	query.Parameters = prog.Parameters
 
+	for i, param := range prog.Parameters {
+		if isGeneratedIdentifier(param.Name) {
+			return QueryBatch{}, newLocatedError(
+				prog.parameterLocations[i],
+				"The parameter '$%s' can't be used because '%s' is reserved in the generated Go code",
+				param.Name,
+				param.Name)
+		}
+	}
+
+	// Split the file into statements that can each be prepared on their own
+	positional, err := positionalStatements(query.SQL, prog.optionalConditions)
+	if err != nil {
+		return QueryBatch{}, err
+	}
+	if len(positional) != len(prog.Statements) {
+		return QueryBatch{}, fmt.Errorf(
+			"Found %d statements in '%s' but parsed %d",
+			len(positional),
+			name,
+			len(prog.Statements))
+	}
+	query.Positional = positional
+
 	// Extract the shape of each statement within the query
 	for _, stmt := range prog.Statements {
 		shape, err := getShape(stmt, model)
@@ -61,6 +386,36 @@ func ReadBatchFromFile(filePath string, model Model) (QueryBatch, error) {
 			return QueryBatch{}, err
 		}
 		query.Shapes = append(query.Shapes, shape)
+
+		paramColumns, err := getParameterColumns(stmt, model)
+		if err != nil {
+			return QueryBatch{}, err
+		}
+		for name, def := range paramColumns {
+			addParameterColumn(query.ParameterColumns, name, def)
+		}
+	}
+
+	// A type that the query pinned beats the inferred one
+	for _, param := range query.Parameters {
+		if param.Type == nil {
+			continue
+		}
+		def := *param.Type
+		err = resolveQueryType(&def, model)
+		if err != nil {
+			return QueryBatch{}, fmt.Errorf("%s for the parameter '$%s'", err.Error(), param.Name)
+		}
+		query.ParameterColumns[param.Name] = def
+	}
+
+	// Optional parameters can always be NULL
+	for _, param := range query.Parameters {
+		def, ok := query.ParameterColumns[param.Name]
+		if ok && param.Optional {
+			def.Nullable = true
+			query.ParameterColumns[param.Name] = def
+		}
 	}
 
 	return query, nil
@@ -71,3 +426,125 @@ func batchNameFromPath(filePath string) string {
 	parts := strings.Split(fileName, ".")
 	return parts[0]
 }
+
+// Splits sql on its semicolons and rewrites the named parameters in each
+// statement to be positional. eg:
+//   SELECT a FROM b WHERE c = $c AND d = $d; SELECT e FROM f WHERE g = $d
+// becomes
+//   SELECT a FROM b WHERE c = $1 AND d = $2     (c, d)
+//   SELECT e FROM f WHERE g = $1                (d)
+// The comparisons that use optional parameters are rewritten so that they are
+// true when the parameter is NULL. eg:
+//   SELECT a FROM b WHERE c = $c?
+// becomes
+//   SELECT a FROM b WHERE (c = $1 OR $1 IS NULL)   (c)
+// The comparison comes first because Postgres can only work out the type of
+// the parameter from it.
+func positionalStatements(sql string, optional []optionalCondition) ([]PositionalStatement, error) {
+	tokens := []token{}
+	err := lex(sql, func(tok token) {
+		tokens = append(tokens, tok)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	text := []rune(sql)
+	offsets := newRuneOffsets(text)
+	statements := []PositionalStatement{}
+
+	builder := strings.Builder{}
+	current := PositionalStatement{Parameters: []string{}}
+	copied := 0
+	hasTokens := false
+
+	finishStatement := func(end int) {
+		if hasTokens {
+			builder.WriteString(string(text[copied:end]))
+			current.SQL = strings.TrimSpace(builder.String())
+			statements = append(statements, current)
+		}
+		builder.Reset()
+		current = PositionalStatement{Parameters: []string{}}
+		copied = end
+		hasTokens = false
+	}
+
+	for i, tok := range tokens {
+		if tok.tokType == tokenTypeEOF {
+			break
+		}
+		start := offsets.of(tok.location)
+
+		if tok.tokType == tokenTypeSemicolon {
+			finishStatement(start)
+			copied++
+			continue
+		}
+		if !hasTokens {
+			// Leave out comments like annotations that come before the
+			// statement
+			copied = start
+		}
+		hasTokens = true
+
+		for _, cond := range optional {
+			if cond.start == i {
+				builder.WriteString(string(text[copied:start]))
+				builder.WriteString("(")
+				copied = start
+			}
+		}
+
+		if tok.tokType == tokenTypeParameter {
+			builder.WriteString(string(text[copied:start]))
+			builder.WriteString(fmt.Sprintf("$%d", current.position(string(tok.value))))
+			copied = start + tok.length
+		}
+
+		for _, cond := range optional {
+			if cond.end == i+1 {
+				end := start + tok.length
+				builder.WriteString(string(text[copied:end]))
+				for _, name := range cond.params {
+					builder.WriteString(fmt.Sprintf(" OR $%d IS NULL", current.position(name)))
+				}
+				builder.WriteString(")")
+				copied = end
+			}
+		}
+	}
+	finishStatement(len(text))
+
+	return statements, nil
+}
+
+// Returns the 1-based position of the named parameter, giving it the next
+// position if it hasn't been seen yet.
+func (s *PositionalStatement) position(name string) int {
+	for i, existing := range s.Parameters {
+		if existing == name {
+			return i + 1
+		}
+	}
+	s.Parameters = append(s.Parameters, name)
+	return len(s.Parameters)
+}
+
+// Converts the line/column locations produced by the lexer back into indexes
+// of the original text.
+type runeOffsets []int
+
+func newRuneOffsets(text []rune) runeOffsets {
+	lineStarts := runeOffsets{0}
+	for i, ch := range text {
+		if ch == '\n' {
+			lineStarts = append(lineStarts, i+1)
+		}
+	}
+	return lineStarts
+}
+
+func (lineStarts runeOffsets) of(loc charLocation) int {
+	return lineStarts[loc.line-1] + loc.col - 1
+}
@@ -1,6 +1,9 @@
 package lib
 
 import (
+	"io/ioutil"
+	"os"
+	"path"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -51,5 +54,224 @@ func TestGetShapeBugTracker(t *testing.T) {
 
 	batches, err := ReadQueriesFromDir("../test/bugtracker/queries", model)
 	require.NoError(t, err)
-	require.Len(t, batches, 7)
+	require.Len(t, batches, 17)
+}
+
+func TestReadBatchFromFileError(t *testing.T) {
+	migrations, err := ReadMigrationsDir("../test/bugtracker/migrations")
+	require.NoError(t, err)
+	model, err := ModelFromMigrations(migrations)
+	require.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "sqlstuff")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	filePath := path.Join(dir, "create_issue.sql")
+	err = ioutil.WriteFile(filePath, []byte("INSERT INTO issues\n  (tid, nme)\nVALUES ($tid, $name)"), 0644)
+	require.NoError(t, err)
+
+	_, err = ReadBatchFromFile(filePath, model)
+	require.Error(t, err)
+	queryErr, ok := err.(QueryError)
+	require.True(t, ok)
+	require.Equal(t, "create_issue.sql", queryErr.File)
+	require.Equal(t, 2, queryErr.Line)
+	require.Equal(t, 9, queryErr.Col)
+	require.Equal(t, "create_issue.sql:2:9: Column 'nme' does not exist in the table 'issues'", err.Error())
+
+	// Errors without a location still name the file
+	err = ioutil.WriteFile(filePath, []byte("INSERT INTO issues"), 0644)
+	require.NoError(t, err)
+	_, err = ReadBatchFromFile(filePath, model)
+	require.Error(t, err)
+	require.Regexp(t, "^create_issue.sql: ", err.Error())
+}
+
+func TestPositionalStatements(t *testing.T) {
+	statements, err := positionalStatements(`
+		SELECT a FROM b WHERE c = $c AND d = $d AND e = $c;
+
+		SELECT f FROM g WHERE h = $d;
+	`, nil)
+	require.NoError(t, err)
+	require.Len(t, statements, 2)
+
+	require.Equal(t, "SELECT a FROM b WHERE c = $1 AND d = $2 AND e = $1", statements[0].SQL)
+	require.Equal(t, []string{"c", "d"}, statements[0].Parameters)
+
+	require.Equal(t, "SELECT f FROM g WHERE h = $1", statements[1].SQL)
+	require.Equal(t, []string{"d"}, statements[1].Parameters)
+}
+
+func TestPositionalStatementsWithoutSemicolon(t *testing.T) {
+	statements, err := positionalStatements("SELECT 'a;b' FROM c WHERE d=$d", nil)
+	require.NoError(t, err)
+	require.Len(t, statements, 1)
+
+	require.Equal(t, "SELECT 'a;b' FROM c WHERE d=$1", statements[0].SQL)
+	require.Equal(t, []string{"d"}, statements[0].Parameters)
+}
+
+func TestPositionalStatementsWithComments(t *testing.T) {
+	statements, err := positionalStatements(`
+		-- name: GetA :many
+		SELECT a FROM b WHERE c = $c; -- $d
+		/* $e */ SELECT f FROM g -- trailing
+	`, nil)
+	require.NoError(t, err)
+	require.Len(t, statements, 2)
+
+	require.Equal(t, "SELECT a FROM b WHERE c = $1", statements[0].SQL)
+	require.Equal(t, []string{"c"}, statements[0].Parameters)
+
+	require.Equal(t, "SELECT f FROM g -- trailing", statements[1].SQL)
+	require.Len(t, statements[1].Parameters, 0)
+}
+
+func TestReadBatchesFromAnnotatedFile(t *testing.T) {
+	migrations, err := ReadMigrationsDir("../test/bugtracker/migrations")
+	require.NoError(t, err)
+	model, err := ModelFromMigrations(migrations)
+	require.NoError(t, err)
+
+	batches, err := ReadBatchesFromFile("../test/bugtracker/queries/tags.sql", model)
+	require.NoError(t, err)
+	require.Len(t, batches, 4)
+
+	require.Equal(t, "CreateTag", batches[0].Name)
+	require.Equal(t, QueryKindExec, batches[0].Kind)
+	require.Len(t, batches[0].AST, 1)
+	require.Equal(t, []string{"tid", "key"}, batches[0].Positional[0].Parameters)
+
+	require.Equal(t, "GetTag", batches[1].Name)
+	require.Equal(t, QueryKindOne, batches[1].Kind)
+	require.Equal(t, QueryResultTypeOneRow, batches[1].Shapes[0].Type)
+	require.Equal(t, `SELECT "key", created FROM tags WHERE tid = $1 AND "key" = $2`, batches[1].Positional[0].SQL)
+
+	require.Equal(t, "AddTag", batches[3].Name)
+	require.Equal(t, QueryKindExecRows, batches[3].Kind)
+
+	// Files without annotations are still one query named after the file
+	batches, err = ReadBatchesFromFile("../test/bugtracker/queries/get_issue.sql", model)
+	require.NoError(t, err)
+	require.Len(t, batches, 1)
+	require.Equal(t, "get_issue", batches[0].Name)
+	require.Equal(t, QueryKindNone, batches[0].Kind)
+	require.Len(t, batches[0].AST, 2)
+}
+
+func TestReadBatchesFromAnnotatedFileError(t *testing.T) {
+	migrations, err := ReadMigrationsDir("../test/bugtracker/migrations")
+	require.NoError(t, err)
+	model, err := ModelFromMigrations(migrations)
+	require.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "sqlstuff")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	invalid := []struct {
+		sql      string
+		expected string
+	}{
+		{"-- name: GetTags :one\nSELECT \"key\" FROM tags WHERE tid = $tid", "1:1: The query 'GetTags' is annotated :one but can return more than one row"},
+		{"-- name: GetTags :exec\nSELECT \"key\" FROM tags", "1:1: The query 'GetTags' is annotated :exec but returns rows"},
+		{"-- name: CreateTag :many\nINSERT INTO tags (tid, \"key\", created) VALUES ($a, $b, $c)", "1:1: The query 'CreateTag' is annotated :many but does not return rows"},
+		{"-- name: GetTags :some\nSELECT \"key\" FROM tags", "1:1: Unknown query kind ':some' for 'GetTags', expecting :one, :many, :exec or :execrows"},
+		{"-- name: GetTags\nSELECT \"key\" FROM tags", "1:1: Expecting an annotation like '-- name: GetIssue :one' but got '-- name: GetTags'"},
+		{"-- name: Get-Tags :many\nSELECT \"key\" FROM tags", "1:1: Invalid query name 'Get-Tags'"},
+		{"SELECT 1;\n-- name: GetTags :many\nSELECT \"key\" FROM tags", "1:1: Expecting a '-- name:' annotation before the statement"},
+		{"SELECT \"key\"\n-- name: GetTags :many\nFROM tags", "2:1: The annotation of 'GetTags' must come before the start of a statement"},
+		{"-- name: GetTags :many\n-- name: GetTag :one\nSELECT \"key\" FROM tags", "1:1: The query 'GetTags' has no statements"},
+		{"-- name: GetTags :many\nSELECT \"key\" FROM tags;\n-- name: CreateTag :exec", "3:1: The query 'CreateTag' has no statements"},
+		{"-- name: GetTags :many\nSELECT \"key\" FROM tags\n-- name: CreateTag :exec", "3:1: The annotation of 'CreateTag' must come before the start of a statement"},
+		{"-- name: GetTags :many\nSELECT \"key\" FROM tags;\n\n-- name: CreateTag :exec\nINSERT INTO tags (tid, nme) VALUES ($a, $b)", "5:24: Column 'nme' does not exist in the table 'tags'"},
+		{"-- name: GetTags :many\nSELECT \"key\" FROM tags WHERE tid = $ctx", "2:36: The parameter '$ctx' can't be used because 'ctx' is reserved in the generated Go code"},
+		{"-- name: GetTags :many\nSELECT \"key\" FROM tags WHERE tid = $tid AND \"key\" = $fn", "2:53: The parameter '$fn' can't be used because 'fn' is reserved in the generated Go code"},
+		{"-- name: CreateTag :exec\nINSERT INTO tags (tid, \"key\", created) VALUES ($r1, $b, $c)", "2:48: The parameter '$r1' can't be used because 'r1' is reserved in the generated Go code"},
+		{"-- name: GetTags :many\nSELECT \"key\" FROM tags WHERE tid = $type", "2:36: The parameter '$type' can't be used because 'type' is reserved in the generated Go code"},
+	}
+	filePath := path.Join(dir, "tags.sql")
+	for _, c := range invalid {
+		err = ioutil.WriteFile(filePath, []byte(c.sql), 0644)
+		require.NoError(t, err)
+		_, err = ReadBatchesFromFile(filePath, model)
+		require.Error(t, err, c.sql)
+		require.Equal(t, "tags.sql:"+c.expected, err.Error(), c.sql)
+	}
+}
+
+func TestParameterTypeAnnotations(t *testing.T) {
+	model, err := ModelFromMigrations([]*Migration{&Migration{UpSQL: `
+		CREATE TYPE status AS ENUM ('open', 'closed');
+		CREATE TABLE t (id INT NOT NULL, created TIMESTAMPTZ NOT NULL, status status NULL)`}})
+	require.NoError(t, err)
+
+	batch, err := newQueryBatch("get_t", `
+		-- param: $since timestamptz null
+		-- param: $id bigint
+		SELECT id FROM t WHERE id = $id AND created > $since AND status = $status::status`, model)
+	require.NoError(t, err)
+
+	since := batch.ParameterColumns["since"]
+	require.Equal(t, DataTypeTimestampWithTimeZone, since.Type)
+	require.True(t, since.Nullable)
+
+	// The annotation beats the type of the compared column
+	require.Equal(t, DataTypeBigInt, batch.ParameterColumns["id"].Type)
+
+	status := batch.ParameterColumns["status"]
+	require.Equal(t, DataTypeEnum, status.Type)
+	require.Equal(t, []string{"open", "closed"}, status.Values)
+	require.False(t, status.Nullable)
+
+	_, err = newQueryBatch("get_t", "SELECT id FROM t WHERE status = $status::nope", model)
+	require.EqualError(t, err, "Unknown data type 'nope' for the parameter '$status'")
+}
+
+func TestPositionalStatementsWithOptionalParameters(t *testing.T) {
+	sql := `SELECT a FROM b WHERE c = $c AND d = ANY($d?) AND e::text = $e?::text;
+		SELECT f FROM g WHERE $h? < h AND $i = i`
+	prog, err := Parse(sql)
+	require.NoError(t, err)
+
+	statements, err := positionalStatements(sql, prog.optionalConditions)
+	require.NoError(t, err)
+	require.Len(t, statements, 2)
+
+	require.Equal(t, "SELECT a FROM b WHERE c = $1 AND (d = ANY($2) OR $2 IS NULL) AND (e::text = $3::text OR $3 IS NULL)", statements[0].SQL)
+	require.Equal(t, []string{"c", "d", "e"}, statements[0].Parameters)
+
+	require.Equal(t, "SELECT f FROM g WHERE ($1 < h OR $1 IS NULL) AND $2 = i", statements[1].SQL)
+	require.Equal(t, []string{"h", "i"}, statements[1].Parameters)
+}
+
+func TestReadBatchWithOptionalParameters(t *testing.T) {
+	migrations, err := ReadMigrationsDir("../test/bugtracker/migrations")
+	require.NoError(t, err)
+	model, err := ModelFromMigrations(migrations)
+	require.NoError(t, err)
+
+	batch, err := ReadBatchFromFile("../test/bugtracker/queries/list_issues.sql", model)
+	require.NoError(t, err)
+
+	require.Equal(t, []Parameter{
+		{Name: "tid"},
+		{Name: "status", Optional: true},
+		{Name: "min_priority", Optional: true},
+	}, batch.Parameters)
+	require.Equal(t,
+		"SELECT id, \"name\", status, priority\nFROM issues\nWHERE tid = $1 AND (status = $2 OR $2 IS NULL) AND (priority >= $3 OR $3 IS NULL)",
+		batch.Positional[0].SQL)
+
+	// The shape is the same as without the optional parameters
+	require.Len(t, batch.Shapes[0].Columns, 4)
+	require.Equal(t, QueryResultTypeManyRows, batch.Shapes[0].Type)
+
+	require.False(t, batch.ParameterColumns["tid"].Nullable)
+	require.True(t, batch.ParameterColumns["status"].Nullable)
+	require.Equal(t, DataTypeEnum, batch.ParameterColumns["status"].Type)
+	require.True(t, batch.ParameterColumns["min_priority"].Nullable)
+	require.Equal(t, DataTypeInteger, batch.ParameterColumns["min_priority"].Type)
 }
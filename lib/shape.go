@@ -3,6 +3,7 @@ package lib
 import (
 	"errors"
 	"fmt"
+	"reflect"
 	"strings"
 )
 
@@ -34,6 +35,10 @@ func getShape(stmt Statement, model Model) (Shape, error) {
 		return getSelectShape(typed, model)
 	case Insert:
 		return getInsertShape(typed, model)
+	case RefreshMaterializedView:
+		return getRefreshShape(typed, model)
+	case DropView:
+		return getDropViewShape(typed, model)
 	default:
 		return Shape{}, errors.New("getShape not implemented for this type of statement yet")
 	}
@@ -43,6 +48,11 @@ func getShape(stmt Statement, model Model) (Shape, error) {
 // any result columns, but it you use RETURNING then it will behave like a
 // SELECT.
 func getInsertShape(query Insert, model Model) (Shape, error) {
+	err := validateInsert(query, model)
+	if err != nil {
+		return Shape{}, err
+	}
+
 	// Since RETURNS is not implemented yet always assume no fields are selected
 	return Shape{
 		Columns: []ColumnDefinition{},
@@ -50,6 +60,33 @@ func getInsertShape(query Insert, model Model) (Shape, error) {
 	}, nil
 }
 
+func getRefreshShape(query RefreshMaterializedView, model Model) (Shape, error) {
+	view, ok := model.view(query.Name)
+	if !ok {
+		return Shape{}, fmt.Errorf("Unknown materialized view '%s'", query.Name)
+	}
+	if !view.Materialized {
+		return Shape{}, fmt.Errorf("Cannot refresh '%s' because it is not a materialized view", query.Name)
+	}
+	return Shape{
+		Columns: []ColumnDefinition{},
+		Type:    QueryResultTypeCommand,
+	}, nil
+}
+
+func getDropViewShape(query DropView, model Model) (Shape, error) {
+	for _, name := range query.Names {
+		_, ok := model.view(name)
+		if !ok && !query.IfExists {
+			return Shape{}, fmt.Errorf("Unknown view '%s'", name)
+		}
+	}
+	return Shape{
+		Columns: []ColumnDefinition{},
+		Type:    QueryResultTypeCommand,
+	}, nil
+}
+
 // Returns the data types and names of the columns that will come out of the
 // given query/model pair.
 func getSelectShape(query Select, model Model) (Shape, error) {
@@ -67,6 +104,23 @@ func getSelectShape(query Select, model Model) (Shape, error) {
 		resultColumns = append(resultColumns, def)
 	}
 
+	// The columns of a UNION are named after the first SELECT and are nullable
+	// when they are in any of them
+	if query.Next != nil {
+		next, err := getSelectShape(query.Next.Query, model)
+		if err != nil {
+			return Shape{}, err
+		}
+		if len(next.Columns) != len(resultColumns) {
+			return Shape{}, errors.New("Each SELECT of a UNION, INTERSECT or EXCEPT must have the same number of columns")
+		}
+		for i, col := range next.Columns {
+			if col.Nullable {
+				resultColumns[i].Nullable = true
+			}
+		}
+	}
+
 	resultType, err := getSelectCardinality(query, model)
 	if err != nil {
 		return Shape{}, err
@@ -79,17 +133,35 @@ func getSelectShape(query Select, model Model) (Shape, error) {
 }
 
 func conditionsCoverConstraint(constraint TableUniqueConstraint, conditions ...Condition) bool {
+	// A column can be fixed by equating it to one that an earlier pass fixed,
+	// so keep going until nothing new turns up
 	fixed := []string{}
-	for _, cond := range conditions {
-		fixed = union(fixed, conditionFixedColumns(constraint, cond))
+	for {
+		found := fixed
+		for _, cond := range conditions {
+			found = union(found, conditionFixedColumns(constraint, cond, fixed))
+		}
+		if len(found) == len(fixed) {
+			break
+		}
+		fixed = found
 	}
-	
+
 	for _, col := range constraint.UniqueConstraint.Columns {
-		found := false
-		for _, fixedCol := range fixed {
-			if fixedCol == col
+		if !isFixedColumn(constraint, col, fixed) {
+			return false
+		}
+	}
+	return true
+}
+
+func isFixedColumn(constraint TableUniqueConstraint, col string, fixed []string) bool {
+	for _, fixedCol := range fixed {
+		if fixedCol == col || fixedCol == constraint.TableName+"."+col {
+			return true
 		}
 	}
+	return false
 }
 
 // insersect([["a","b"], ["a","c","b"]]) -> ["a","b"]
@@ -131,27 +203,25 @@ func union(sets ...[]string) []string {
 	return result
 }
 
-func conditionFixedColumns(constraint TableUniqueConstraint, condition Condition) []string {
+func conditionFixedColumns(constraint TableUniqueConstraint, condition Condition, fixed []string) []string {
 	// Break down multi-part logical conditions (eg: "a AND b" or "a OR b")
 	logical, ok := condition.(LogicalCondition)
 	if ok {
 		if logical.Op == LogicalOpAnd {
 			return union(
-				conditionFixedColumns(constraint, logical.Left),
-				conditionFixedColumns(constraint, logical.Right))
+				conditionFixedColumns(constraint, logical.Left, fixed),
+				conditionFixedColumns(constraint, logical.Right, fixed))
 		}
 		return intersect(
-			conditionFixedColumns(constraint, logical.Left),
-			conditionFixedColumns(constraint, logical.Right))
+			conditionFixedColumns(constraint, logical.Left, fixed),
+			conditionFixedColumns(constraint, logical.Right, fixed))
 	}
 
 	// Break down binary conditions (eg: 1+foo > 6)
 	binary, ok := condition.(BinaryCondition)
 	if ok {
 		if binary.Op == BinaryCondOpEqual {
-			l := binary.Left
-			r := binary.Right
-			return findFixedColumns(constraint, binary)
+			return findFixedColumns(constraint, binary, fixed)
 		}
 		return []string{}
 	}
@@ -159,44 +229,42 @@ func conditionFixedColumns(constraint TableUniqueConstraint, condition Condition
 	return []string{}
 }
 
-func findFixedColumns(constraint TableUniqueConstraint, cond BinaryCondition) []string {
-	if cond.Op != BinaryCondOpEqual {
-		return false
+// A column of the constraint's table is fixed by equating it to a parameter, a
+// literal, a column of another table (which has one value for each of its
+// rows) or a column of the same table that is already fixed. Equating two
+// columns of the same table like "a.x = a.y" fixes neither.
+func findFixedColumns(constraint TableUniqueConstraint, cond BinaryCondition, fixed []string) []string {
+	// A column that equals ANY of an array can still match many rows
+	if cond.Op != BinaryCondOpEqual || isQuantified(cond) {
+		return []string{}
 	}
 
-	leftColumn, leftIsColumn := cond.Left.(ColumnExpression)
-	rightColumn, rightIsColumn := cond.Right.(ColumnExpression)
-
-	leftLiteral, leftIsLiteral := cond.Left.(Literal)
-	rightLiteral, rightIsLiteral := cond.Right.(Literal)
-
-	if leftIsColumn && rightIsLiteral {
-		return []string{leftColumn.String()}
-	} else if leftIsColumn && rightIsColumn {
-		return []string{leftColumn.String(), rightColumn.String()}
-	} else if leftIsLiteral && rightIsColumn {
-		return []string{rightColumn.String()}
+	result := []string{}
+	if col, ok := cond.Left.(ColumnExpression); ok && isFixedValue(constraint, cond.Right, fixed) {
+		result = append(result, col.String())
 	}
-
-	return []string{}
-}
-
-func anyConditionCoversConstraint(constraint TableUniqueConstraint, conditions ...Condition) bool {
-	for _, cond := range conditions {
-		if conditionCoversConstraint(constraint, cond) {
-			return true
-		}
+	if col, ok := cond.Right.(ColumnExpression); ok && isFixedValue(constraint, cond.Left, fixed) {
+		result = append(result, col.String())
 	}
-	return false
+	return result
 }
 
-func allConditionsCoverConstraint(constraint TableUniqueConstraint, conditions ...Condition) bool {
-	for _, cond := range conditions {
-		if !conditionCoversConstraint(constraint, cond) {
-			return false
+// Returns true iff the expression has one value for every row of the
+// constraint's table.
+func isFixedValue(constraint TableUniqueConstraint, expr Expression, fixed []string) bool {
+	switch typed := expr.(type) {
+	case ParameterExpression, StringLiteral, NumberLiteral:
+		return true
+	case CastExpression:
+		return isFixedValue(constraint, typed.Expr, fixed)
+	case ColumnExpression:
+		if typed.TableName != "" && typed.TableName != constraint.TableName {
+			return true
 		}
+		return isFixedColumn(constraint, typed.ColumnName, fixed)
+	default:
+		return false
 	}
-	return true
 }
 
 // Returns true iff the conditions cover any of the constraints.
@@ -211,27 +279,26 @@ func checkConstraints(constraints []TableUniqueConstraint, conditions ...Conditi
 
 // Returns true iff the given conditions are enough to select no more than one
 // row from the given table.
-func unique(m Model, tableName string, alias string, conditions ...Condition) (bool, error) {
-	table, ok := m.Tables[tableName]
-	if !ok {
-		return false, fmt.Errorf("Unknown table '%s'", tableName)
-	}
-
-	name := alias
-	if name == "" {
-		name = tableName
+func unique(m Model, target TargetTable, conditions ...Condition) (bool, error) {
+	name := targetKey(target)
+
+	// Views have no constraints so only their own query or the unique indexes
+	// of a materialized view can make them unique
+	view, isView := m.view(target.TableName)
+	if isView {
+		if view.OneRow {
+			return true, nil
+		}
+		uniqueIndexes := getIndexUniqueConstraints(view.Indexes, view.Name, name, conditions...)
+		return checkConstraints(uniqueIndexes, conditions...), nil
 	}
 
-	uniqueConstraints := []TableUniqueConstraint{}
-	for _, constraint := range table.Constraints {
-		if constraint.IsUnique() {
-			uniqueConstraints = append(uniqueConstraints, TableUniqueConstraint{
-				TableName:        name,
-				UniqueConstraint: constraint,
-			})
-		}
+	table, ok := m.table(target.TableName)
+	if !ok {
+		return false, fmt.Errorf("Unknown table '%s'", asWritten(target.WrittenTableName, target.TableName))
 	}
 
+	uniqueConstraints := getTableUniqueConstraints(table, name, conditions...)
 	return checkConstraints(uniqueConstraints, conditions...), nil
 }
 
@@ -279,17 +346,22 @@ func newCardinalitySource(t TargetTable, m Model) (cardinalitySource, error) {
 	}
 
 	// If there is not a subselect and just a table name
-	name := t.Alias
-	if name == "" {
-		name = t.TableName
+	name := targetKey(t)
+
+	view, isView := m.view(t.TableName)
+	if isView {
+		return cardinalitySource{
+			name:              name,
+			uniqueConstraints: getIndexUniqueConstraints(view.Indexes, view.Name, name),
+		}, nil
 	}
 
-	tbl, ok := m.Tables[t.TableName]
+	tbl, ok := m.table(t.TableName)
 	if !ok {
-		return cardinalitySource{}, fmt.Errorf("Unknown table '%s'", t.TableName)
+		return cardinalitySource{}, fmt.Errorf("Unknown table '%s'", asWritten(t.WrittenTableName, t.TableName))
 	}
 
-	uniqueConstraints := getTableUniqueConstraints(tbl.Constraints, name)
+	uniqueConstraints := getTableUniqueConstraints(tbl, name)
 	return cardinalitySource{
 		name:              name,
 		uniqueConstraints: uniqueConstraints,
@@ -344,7 +416,8 @@ func (c *cardinalityCalculator) updateEntanglements(cond Condition) {
 	// It's just a single binary expr like "1 > 2" or "u.email = $email"
 	binary, ok := cond.(BinaryCondition)
 	if ok {
-		if binary.Op == BinaryCondOpEqual {
+		// A column that equals ANY of an array can still match many rows
+		if binary.Op == BinaryCondOpEqual && !isQuantified(binary) {
 			c.setEntanglement(binary.Left, binary.Right)
 		}
 		return
@@ -360,15 +433,23 @@ func (c *cardinalityCalculator) updateEntanglements(cond Condition) {
 	}
 }
 
+func isQuantified(cond BinaryCondition) bool {
+	_, left := cond.Left.(QuantifiedExpression)
+	_, right := cond.Right.(QuantifiedExpression)
+	return left || right
+}
+
 func getEntanglements(cs cardinalitySource) map[string][]string {
 	res := map[string][]string{}
 	// TO DO
 	return res
 }
 
-func getTableUniqueConstraints(constraints []Constraint, name string) []TableUniqueConstraint {
+// Returns the unique constraints and unique indexes of the table. A partial
+// unique index is only included when the conditions imply its predicate.
+func getTableUniqueConstraints(tbl *Table, name string, conditions ...Condition) []TableUniqueConstraint {
 	uniqueConstraints := []TableUniqueConstraint{}
-	for _, constraint := range constraints {
+	for _, constraint := range tbl.Constraints {
 		if constraint.IsUnique() {
 			uniqueConstraints = append(uniqueConstraints, TableUniqueConstraint{
 				TableName:        name,
@@ -376,9 +457,210 @@ func getTableUniqueConstraints(constraints []Constraint, name string) []TableUni
 			})
 		}
 	}
+	return append(uniqueConstraints, getIndexUniqueConstraints(tbl.Indexes, tbl.Name, name, conditions...)...)
+}
+
+// Returns the unique indexes of a table or materialized view as constraints.
+// A partial unique index is only included when the conditions imply its
+// predicate.
+func getIndexUniqueConstraints(
+	indexes []Index,
+	relationName string,
+	name string,
+	conditions ...Condition,
+) []TableUniqueConstraint {
+	uniqueConstraints := []TableUniqueConstraint{}
+	for _, index := range indexes {
+		if !index.Unique || index.HasExpressions {
+			continue
+		}
+		if index.Where != nil && !conditionsImply(index.Where, relationName, name, conditions...) {
+			continue
+		}
+		uniqueConstraints = append(uniqueConstraints, TableUniqueConstraint{
+			TableName: name,
+			UniqueConstraint: Constraint{
+				Name:    index.Name,
+				Type:    ConstraintTypeUnique,
+				Columns: index.Columns,
+			},
+		})
+	}
 	return uniqueConstraints
 }
 
+// Returns true if the conditions guarantee the predicate of a partial index
+// on the given table, which the query refers to by name. This only recognizes
+// predicates whose ANDed parts each appear in the conditions, eg:
+//   WHERE u.email = $email AND u.deleted IS NULL
+// implies the predicate "deleted IS NULL".
+func conditionsImply(predicate Condition, tableName string, name string, conditions ...Condition) bool {
+	known := []Condition{}
+	for _, cond := range conditions {
+		for _, part := range conjuncts(cond) {
+			known = append(known, unqualifyCondition(part, name))
+		}
+	}
+
+	for _, required := range conjuncts(predicate) {
+		required = unqualifyCondition(required, tableName)
+		found := false
+		for _, cond := range known {
+			if sameCondition(cond, required) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Splits a condition into the parts that are ANDed together.
+func conjuncts(cond Condition) []Condition {
+	if cond == nil {
+		return nil
+	}
+	logical, ok := cond.(LogicalCondition)
+	if ok && logical.Op == LogicalOpAnd {
+		return append(conjuncts(logical.Left), conjuncts(logical.Right)...)
+	}
+	return []Condition{cond}
+}
+
+// Comparisons are the same when they only differ by which side is which, eg:
+// "0 < a" and "a > 0".
+func sameCondition(a Condition, b Condition) bool {
+	if reflect.DeepEqual(a, b) {
+		return true
+	}
+
+	binaryA, okA := a.(BinaryCondition)
+	binaryB, okB := b.(BinaryCondition)
+	if !okA || !okB {
+		return false
+	}
+	mirrored, ok := mirroredConditionOps[binaryB.Op]
+	if !ok {
+		return false
+	}
+	return reflect.DeepEqual(binaryA, BinaryCondition{
+		Left:  binaryB.Right,
+		Right: binaryB.Left,
+		Op:    mirrored,
+	})
+}
+
+var mirroredConditionOps = map[binaryCondOpType]binaryCondOpType{
+	BinaryCondOpEqual:            BinaryCondOpEqual,
+	BinaryCondOpNotEqual:         BinaryCondOpNotEqual,
+	BinaryCondOpLessThan:         BinaryCondOpGreatThan,
+	BinaryCondOpLessThanOrEqual:  BinaryCondOpGreatThanOrEqual,
+	BinaryCondOpGreatThan:        BinaryCondOpLessThan,
+	BinaryCondOpGreatThanOrEqual: BinaryCondOpLessThanOrEqual,
+}
+
+// Returns the names of the columns that the condition refers to.
+func conditionColumns(cond Condition) []string {
+	switch typed := cond.(type) {
+	case BinaryCondition:
+		return append(expressionColumns(typed.Left), expressionColumns(typed.Right)...)
+	case LogicalCondition:
+		return append(conditionColumns(typed.Left), conditionColumns(typed.Right)...)
+	}
+	return []string{}
+}
+
+func expressionColumns(expr Expression) []string {
+	switch typed := expr.(type) {
+	case ColumnExpression:
+		return []string{typed.ColumnName}
+	case BinaryExpression:
+		return append(expressionColumns(typed.Left), expressionColumns(typed.Right)...)
+	case UnaryExpression:
+		return expressionColumns(typed.Right)
+	case CastExpression:
+		return expressionColumns(typed.Expr)
+	case FunctionExpression:
+		columns := []string{}
+		for _, param := range typed.Parameters {
+			columns = append(columns, expressionColumns(param)...)
+		}
+		return columns
+	}
+	return []string{}
+}
+
+// Removes the table name from columns that belong to the named table so that
+// conditions from a query can be compared with an index predicate. How the
+// columns were written is removed too since it makes no difference to Postgres.
+func unqualifyCondition(cond Condition, name string) Condition {
+	return mapConditionColumns(cond, func(col ColumnExpression) ColumnExpression {
+		if col.TableName == name {
+			col.TableName = ""
+		}
+		col.WrittenColumnName = ""
+		col.WrittenTableName = ""
+		return col
+	})
+}
+
+// Returns a copy of the condition with every column replaced by fn(column).
+func mapConditionColumns(cond Condition, fn func(ColumnExpression) ColumnExpression) Condition {
+	switch typed := cond.(type) {
+	case BinaryCondition:
+		typed.Left = mapExpressionColumns(typed.Left, fn)
+		typed.Right = mapExpressionColumns(typed.Right, fn)
+		return typed
+	case LogicalCondition:
+		typed.Left = mapConditionColumns(typed.Left, fn)
+		typed.Right = mapConditionColumns(typed.Right, fn)
+		return typed
+	}
+	return cond
+}
+
+func mapExpressionColumns(expr Expression, fn func(ColumnExpression) ColumnExpression) Expression {
+	switch typed := expr.(type) {
+	case ColumnExpression:
+		return fn(typed)
+	case BinaryExpression:
+		typed.Left = mapExpressionColumns(typed.Left, fn)
+		typed.Right = mapExpressionColumns(typed.Right, fn)
+		return typed
+	case UnaryExpression:
+		typed.Right = mapExpressionColumns(typed.Right, fn)
+		return typed
+	case CastExpression:
+		typed.Expr = mapExpressionColumns(typed.Expr, fn)
+		return typed
+	case FunctionExpression:
+		params := []Expression{}
+		for _, param := range typed.Parameters {
+			params = append(params, mapExpressionColumns(param, fn))
+		}
+		typed.Parameters = params
+		return typed
+	case SubscriptExpression:
+		typed.Array = mapExpressionColumns(typed.Array, fn)
+		typed.Index = mapExpressionColumns(typed.Index, fn)
+		return typed
+	case ArrayExpression:
+		elements := []Expression{}
+		for _, element := range typed.Elements {
+			elements = append(elements, mapExpressionColumns(element, fn))
+		}
+		typed.Elements = elements
+		return typed
+	case QuantifiedExpression:
+		typed.Array = mapExpressionColumns(typed.Array, fn)
+		return typed
+	}
+	return expr
+}
+
 func newCardinalityCalculator(s Select, m Model) (cardinalityCalculator, error) {
 	calc := cardinalityCalculator{
 		model: m,
@@ -473,7 +755,7 @@ func getTargetCardinality(target TargetTable, s Select, m Model, conditions ...C
 		return QueryResultTypeManyRows, nil
 	} else {
 		// Just a table name
-		isUnique, err := unique(m, target.TableName, target.Alias, append(conditions, s.Where)...)
+		isUnique, err := unique(m, target, append(conditions, s.Where)...)
 		if err != nil {
 			return 0, err
 		}
@@ -484,6 +766,81 @@ func getTargetCardinality(target TargetTable, s Select, m Model, conditions ...C
 	}
 }
 
+// Returns true iff the ON clause of the join equates every column of a
+// foreign key on another table in the query with the columns it references in
+// the joined table. Foreign keys always reference unique columns so such a
+// join finds at most one row, eg:
+//   FROM issues i JOIN projects p ON p.tid = i.tid AND p.key = i.project_key
+func joinFollowsForeignKey(join Join, s Select, m Model) bool {
+	if join.Target.Subselect != nil {
+		return false
+	}
+
+	equal := map[string]struct{}{}
+	for _, pair := range andedEqualities(join.On) {
+		equal[pair[0]+"="+pair[1]] = struct{}{}
+		equal[pair[1]+"="+pair[0]] = struct{}{}
+	}
+
+	parent, ok := m.table(join.Target.TableName)
+	if !ok {
+		return false
+	}
+
+	parentName := targetKey(join.Target)
+	children := append([]TargetTable{s.From}, joinTargets(s.Joins)...)
+	for _, child := range children {
+		if child.Subselect != nil || targetKey(child) == parentName {
+			continue
+		}
+		tbl, ok := m.table(child.TableName)
+		if !ok {
+			continue
+		}
+		for _, fk := range tbl.ForeignKeysTo(parent.Name) {
+			followed := true
+			for i, col := range fk.Columns {
+				childCol := targetKey(child) + "." + col
+				parentCol := parentName + "." + fk.References.Columns[i]
+				if _, ok := equal[childCol+"="+parentCol]; !ok {
+					followed = false
+					break
+				}
+			}
+			if followed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns the qualified column pairs that the condition requires to be equal,
+// looking only through ANDs.
+func andedEqualities(cond Condition) [][2]string {
+	switch typed := cond.(type) {
+	case BinaryCondition:
+		left, leftIsCol := typed.Left.(ColumnExpression)
+		right, rightIsCol := typed.Right.(ColumnExpression)
+		if typed.Op == BinaryCondOpEqual && leftIsCol && rightIsCol {
+			return [][2]string{{left.String(), right.String()}}
+		}
+	case LogicalCondition:
+		if typed.Op == LogicalOpAnd {
+			return append(andedEqualities(typed.Left), andedEqualities(typed.Right)...)
+		}
+	}
+	return nil
+}
+
+func joinTargets(joins []Join) []TargetTable {
+	targets := []TargetTable{}
+	for _, join := range joins {
+		targets = append(targets, join.Target)
+	}
+	return targets
+}
+
 func getSelectCardinality(s Select, model Model) (queryResultType, error) {
 	// If the top level query explcitly includes "LIMIT 1" then we know is a
 	// single row result and we're done here.
@@ -491,10 +848,18 @@ func getSelectCardinality(s Select, model Model) (queryResultType, error) {
 		return QueryResultTypeOneRow, nil
 	}
 
+	// The rows of the other SELECTs of a UNION are added to these ones
+	if s.Next != nil {
+		return QueryResultTypeManyRows, nil
+	}
+
 	// Evaluate the effect of each join on cardinality. If there is a join that
 	// could select many rows even when filters from the parent select are
 	// considered then we know it's a many result and we can stop processing.
 	for _, join := range s.Joins {
+		if joinFollowsForeignKey(join, s, model) {
+			continue
+		}
 		joinCardinality, err := getTargetCardinality(join.Target, s, model, join.On)
 		if err != nil {
 			return 0, err
@@ -541,48 +906,147 @@ func exprAsColumnDefinition(
 ) (ColumnDefinition, error) {
 	switch typed := expr.(type) {
 	case ColumnExpression:
-		return findColumn(typed.TableName, typed.ColumnName, available)
+		return findColumn(typed, available)
 	case FunctionExpression:
 		return getFuncReturnType(typed)
+	case SubscriptExpression:
+		def, err := exprAsColumnDefinition(typed.Array, model, available)
+		if err != nil {
+			return ColumnDefinition{}, err
+		}
+		return arrayElement(def)
+	case ArrayExpression:
+		return arrayOfElements(typed, model, available)
+	case CastExpression:
+		return castAsColumnDefinition(typed, model, available)
 	default:
 		return ColumnDefinition{}, errors.New("Expression type not implemented yet")
 	}
 }
 
+// A cast keeps the name and nullability of what it converts. A parameter could
+// be passed NULL so it is nullable.
+func castAsColumnDefinition(
+	cast CastExpression,
+	model Model,
+	available map[string][]ColumnDefinition,
+) (ColumnDefinition, error) {
+	def := cast.Type
+	err := resolveQueryType(&def, model)
+	if err != nil {
+		return ColumnDefinition{}, err
+	}
+	if _, isParam := cast.Expr.(ParameterExpression); isParam {
+		def.Nullable = true
+		return def, nil
+	}
+
+	inner, err := exprAsColumnDefinition(cast.Expr, model, available)
+	if err != nil {
+		return ColumnDefinition{}, err
+	}
+	def.Name = inner.Name
+	def.Nullable = inner.Nullable
+	return def, nil
+}
+
+// Fills in the values of an enum type that a query names in a cast or
+// parameter annotation.
+func resolveQueryType(def *ColumnDefinition, model Model) error {
+	if def.TypeName == "" {
+		return nil
+	}
+
+	enum, ok := model.enum(def.TypeName)
+	if !ok {
+		return fmt.Errorf("Unknown data type '%s'", def.TypeName)
+	}
+
+	def.TypeName = enum.Name
+	def.Values = append([]string{}, enum.Values...)
+	return nil
+}
+
+// The type of one element of an array. It is nullable since a subscript that
+// is out of bounds is NULL.
+func arrayElement(def ColumnDefinition) (ColumnDefinition, error) {
+	if def.ArrayDims == 0 {
+		return ColumnDefinition{}, fmt.Errorf("Column '%s' is not an array", def.Name)
+	}
+	def.ArrayDims--
+	def.Nullable = true
+	return def, nil
+}
+
+// The type of an ARRAY[...] constructor, which is decided by its first
+// element that has a known type.
+func arrayOfElements(
+	array ArrayExpression,
+	model Model,
+	available map[string][]ColumnDefinition,
+) (ColumnDefinition, error) {
+	for _, elem := range array.Elements {
+		def, err := exprAsColumnDefinition(elem, model, available)
+		if err != nil {
+			continue
+		}
+		return ColumnDefinition{
+			Name:      "array",
+			Type:      def.Type,
+			Param1:    def.Param1,
+			Param2:    def.Param2,
+			ArrayDims: def.ArrayDims + 1,
+			Source:    def.Source,
+		}, nil
+	}
+	return ColumnDefinition{}, errors.New("Cannot determine the type of ARRAY[...]")
+}
+
+// The built in functions can be qualified with pg_catalog.
 func getFuncReturnType(fnExpr FunctionExpression) (ColumnDefinition, error) {
-	switch strings.ToUpper(fnExpr.FuncName) {
+	schema, name := splitQualifiedName(fnExpr.FuncName)
+	if schema != "" && !strings.EqualFold(schema, "pg_catalog") {
+		return ColumnDefinition{}, fmt.Errorf("Function '%s' not supported", fnExpr.FuncName)
+	}
+
+	switch strings.ToUpper(name) {
 	case "COUNT":
 		return ColumnDefinition{
 			Type: DataTypeBigInt,
 		}, nil
+	case "NOW":
+		return ColumnDefinition{
+			Type: DataTypeTimestampWithTimeZone,
+		}, nil
 	default:
 		return ColumnDefinition{}, fmt.Errorf("Function '%s' not supported", fnExpr.FuncName)
 	}
 }
 
 func findColumn(
-	table string,
-	column string,
+	col ColumnExpression,
 	available map[string][]ColumnDefinition,
 ) (ColumnDefinition, error) {
-	if len(table) > 0 {
-		return findAliasedColumn(table, column, available)
+	if len(col.TableName) > 0 {
+		return findAliasedColumn(col, available)
 	}
-	return findUnaliasedColumn(column, available)
+	return findUnaliasedColumn(col, available)
 }
 
 func findAliasedColumn(
-	table string,
-	column string,
+	col ColumnExpression,
 	available map[string][]ColumnDefinition,
 ) (ColumnDefinition, error) {
-	defs, ok := available[table]
+	table := asWritten(col.WrittenTableName, col.TableName)
+	column := asWritten(col.WrittenColumnName, col.ColumnName)
+
+	defs, ok := available[col.TableName]
 	if !ok {
 		return ColumnDefinition{}, fmt.Errorf("Invalid table/alias '%s'", table)
 	}
 
 	for _, def := range defs {
-		if def.Name == column {
+		if def.Name == col.ColumnName {
 			return def, nil
 		}
 	}
@@ -591,15 +1055,16 @@ func findAliasedColumn(
 }
 
 func findUnaliasedColumn(
-	column string,
+	col ColumnExpression,
 	available map[string][]ColumnDefinition,
 ) (ColumnDefinition, error) {
+	column := asWritten(col.WrittenColumnName, col.ColumnName)
 	found := false
 	result := ColumnDefinition{}
 
 	for _, defs := range available {
 		for _, def := range defs {
-			if def.Name == column {
+			if def.Name == col.ColumnName {
 				if found {
 					return ColumnDefinition{}, fmt.Errorf("Ambiguous column '%s'", column)
 				}
@@ -629,38 +1094,79 @@ func getAvailableColumns(
 	}
 
 	// JOINs
+	joined := []string{targetKey(query.From)}
 	for _, join := range query.Joins {
 		err = addTargetTable(available, model, join.Target)
 		if err != nil {
 			return nil, err
 		}
+
+		// Outer joins fill the side without a match with NULLs
+		key := targetKey(join.Target)
+		if join.Type == JoinTypeLeftOuter || join.Type == JoinTypeFullOuter {
+			available[key] = nullableColumns(available[key])
+		}
+		if join.Type == JoinTypeRightOuter || join.Type == JoinTypeFullOuter {
+			for _, prev := range joined {
+				available[prev] = nullableColumns(available[prev])
+			}
+		}
+		joined = append(joined, key)
 	}
 
 	return available, nil
 }
 
+// The name that columns of a FROM or JOIN target are qualified with. Like
+// Postgres, a table from another schema is referred to without its schema.
+func targetKey(target TargetTable) string {
+	if len(target.Alias) > 0 {
+		return target.Alias
+	}
+	_, name := splitQualifiedName(target.TableName)
+	return name
+}
+
+func nullableColumns(columns []ColumnDefinition) []ColumnDefinition {
+	result := []ColumnDefinition{}
+	for _, col := range columns {
+		col.Nullable = true
+		result = append(result, col)
+	}
+	return result
+}
+
 func addTargetTable(
 	available map[string][]ColumnDefinition,
 	model Model,
 	target TargetTable,
 ) error {
 	if len(target.TableName) > 0 {
-		// Just a table name (possibly aliased)
-		key := target.TableName
-		if len(target.Alias) > 0 {
-			key = target.Alias
-		}
-		tbl, ok := model.Tables[target.TableName]
-		if !ok {
-			return fmt.Errorf("Unknown table '%s'", target.TableName)
-		}
-
+		// Just a table or view name (possibly aliased)
+		key := targetKey(target)
 		_, exists := available[key]
 		if exists {
 			return fmt.Errorf("Duplicate alias '%s'", key)
 		}
 
-		available[key] = tbl.Columns
+		// View columns already know which table column they come from
+		view, isView := model.view(target.TableName)
+		if isView {
+			available[key] = append([]ColumnDefinition{}, view.Columns...)
+			return nil
+		}
+
+		tbl, ok := model.table(target.TableName)
+		if !ok {
+			return fmt.Errorf("Unknown table '%s'", asWritten(target.WrittenTableName, target.TableName))
+		}
+
+		columns := []ColumnDefinition{}
+		for _, col := range tbl.Columns {
+			col.Source = ColumnSource{TableName: tbl.Name, ColumnName: col.Name}
+			columns = append(columns, col)
+		}
+		available[key] = columns
 	} else {
 		// With a subselect (must be aliased)
 		if len(target.Alias) <= 0 {
@@ -679,3 +1185,180 @@ func addTargetTable(
 
 	return nil
 }
+
+// Finds the column that each parameter of a statement is compared to or
+// inserted into, which tells us what type the parameter should be. eg:
+//   SELECT id FROM users WHERE email = $email
+// gives $email the type of users.email. Parameters that are only used in ways
+// that don't reveal a type are left out.
+func getParameterColumns(stmt Statement, model Model) (map[string]ColumnDefinition, error) {
+	params := map[string]ColumnDefinition{}
+	var err error
+
+	switch typed := stmt.(type) {
+	case Select:
+		err = addSelectParameterColumns(params, typed, model)
+	case Insert:
+		err = addInsertParameterColumns(params, typed, model)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return params, nil
+}
+
+func addSelectParameterColumns(
+	params map[string]ColumnDefinition,
+	query Select,
+	model Model,
+) error {
+	available, err := getAvailableColumns(query, model)
+	if err != nil {
+		return err
+	}
+
+	// Sub-selects have their own columns and conditions
+	targets := []TargetTable{query.From}
+	for _, join := range query.Joins {
+		targets = append(targets, join.Target)
+	}
+	for _, target := range targets {
+		if target.Subselect != nil {
+			err = addSelectParameterColumns(params, *target.Subselect, model)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	conditions := []Condition{query.Where, query.Having}
+	for _, join := range query.Joins {
+		conditions = append(conditions, join.On)
+	}
+	for _, cond := range conditions {
+		err = addConditionParameterColumns(params, cond, model, available)
+		if err != nil {
+			return err
+		}
+	}
+
+	if query.Next != nil {
+		return addSelectParameterColumns(params, query.Next.Query, model)
+	}
+	return nil
+}
+
+func addConditionParameterColumns(
+	params map[string]ColumnDefinition,
+	cond Condition,
+	model Model,
+	available map[string][]ColumnDefinition,
+) error {
+	switch typed := cond.(type) {
+	case LogicalCondition:
+		err := addConditionParameterColumns(params, typed.Left, model, available)
+		if err != nil {
+			return err
+		}
+		return addConditionParameterColumns(params, typed.Right, model, available)
+	case BinaryCondition:
+		err := addComparedParameterColumn(params, typed.Left, typed.Right, model, available)
+		if err != nil {
+			return err
+		}
+		return addComparedParameterColumn(params, typed.Right, typed.Left, model, available)
+	}
+
+	return nil
+}
+
+// Gives a parameter the type of the column that it is compared to, eg $id in
+// "id = $id". When either side is ANY(...) or ALL(...) the parameter is an
+// array of, or an element of, the other side instead. eg:
+//   id = ANY($ids)
+//   $label = ANY(labels)
+func addComparedParameterColumn(
+	params map[string]ColumnDefinition,
+	paramSide Expression,
+	otherSide Expression,
+	model Model,
+	available map[string][]ColumnDefinition,
+) error {
+	quantified, paramIsArray := paramSide.(QuantifiedExpression)
+	if paramIsArray {
+		paramSide = quantified.Array
+	}
+	param, ok := paramSide.(ParameterExpression)
+	if !ok {
+		return nil
+	}
+
+	quantified, paramIsElement := otherSide.(QuantifiedExpression)
+	if paramIsElement {
+		otherSide = quantified.Array
+	}
+	switch otherSide.(type) {
+	case ColumnExpression, SubscriptExpression:
+	default:
+		return nil
+	}
+
+	def, err := exprAsColumnDefinition(otherSide, model, available)
+	if err != nil {
+		return err
+	}
+	if paramIsElement {
+		def, err = arrayElement(def)
+		if err != nil {
+			return err
+		}
+	}
+	if paramIsArray {
+		def.ArrayDims++
+	}
+
+	// Comparing to NULL is never true so there is no point in passing one
+	def.Nullable = false
+	addParameterColumn(params, param.Name, def)
+	return nil
+}
+
+func addInsertParameterColumns(
+	params map[string]ColumnDefinition,
+	query Insert,
+	model Model,
+) error {
+	tbl, ok := model.table(query.Target.TableName)
+	if !ok {
+		return nil
+	}
+
+	for i, col := range query.Columns {
+		if i >= len(query.Values) {
+			break
+		}
+		param, isParam := query.Values[i].(ParameterExpression)
+		if !isParam {
+			continue
+		}
+
+		for _, def := range tbl.Columns {
+			if def.Name == col.ColumnName {
+				def.Source = ColumnSource{TableName: tbl.Name, ColumnName: def.Name}
+				addParameterColumn(params, param.Name, def)
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// The first use of a parameter decides its type.
+func addParameterColumn(params map[string]ColumnDefinition, name string, def ColumnDefinition) {
+	_, exists := params[name]
+	if !exists {
+		params[name] = def
+	}
+}
@@ -74,3 +74,370 @@ func TestGetShape(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, QueryResultTypeManyRows, shape.Type)
 }
+
+func TestGetParameterColumns(t *testing.T) {
+	migrations, err := ReadMigrationsDir("../test/basic/migrations")
+	require.NoError(t, err)
+	model, err := ModelFromMigrations(migrations)
+	require.NoError(t, err)
+	prog, err := Parse(`
+		SELECT u.email FROM users u JOIN user_groups ug ON ug.user_id = u.id WHERE $group = ug.group_id AND u.first_name = $name;
+		INSERT INTO users (id, email, last_name) VALUES ($id, 'foo@bar.com', $last_name);`)
+	require.NoError(t, err)
+	require.Len(t, prog.Statements, 2)
+
+	params, err := getParameterColumns(prog.Statements[0], model)
+	require.NoError(t, err)
+	require.Len(t, params, 2)
+
+	require.Equal(t, DataTypeInteger, params["group"].Type)
+	require.Equal(t, ColumnSource{TableName: "user_groups", ColumnName: "group_id"}, params["group"].Source)
+
+	// Only used in a comparison so a NULL is not useful
+	require.Equal(t, DataTypeVarChar, params["name"].Type)
+	require.False(t, params["name"].Nullable)
+
+	params, err = getParameterColumns(prog.Statements[1], model)
+	require.NoError(t, err)
+	require.Len(t, params, 2)
+
+	require.Equal(t, DataTypeInteger, params["id"].Type)
+	require.Equal(t, ColumnSource{TableName: "users", ColumnName: "id"}, params["id"].Source)
+	require.False(t, params["id"].Nullable)
+
+	require.Equal(t, DataTypeVarChar, params["last_name"].Type)
+	require.True(t, params["last_name"].Nullable)
+}
+
+func TestGetShapeOuterJoinNullable(t *testing.T) {
+	migrations, err := ReadMigrationsDir("../test/basic/migrations")
+	require.NoError(t, err)
+	model, err := ModelFromMigrations(migrations)
+	require.NoError(t, err)
+	prog, err := Parse(`
+		SELECT u.id, ug.group_id FROM users u LEFT JOIN user_groups ug ON ug.user_id = u.id;
+		SELECT u.id, ug.group_id FROM users u RIGHT JOIN user_groups ug ON ug.user_id = u.id;`)
+	require.NoError(t, err)
+	require.Len(t, prog.Statements, 2)
+
+	shape, err := getShape(prog.Statements[0], model)
+	require.NoError(t, err)
+	require.False(t, shape.Columns[0].Nullable)
+	require.True(t, shape.Columns[1].Nullable)
+
+	shape, err = getShape(prog.Statements[1], model)
+	require.NoError(t, err)
+	require.True(t, shape.Columns[0].Nullable)
+	require.False(t, shape.Columns[1].Nullable)
+}
+
+func TestGetShapeUnion(t *testing.T) {
+	migrations, err := ReadMigrationsDir("../test/basic/migrations")
+	require.NoError(t, err)
+	model, err := ModelFromMigrations(migrations)
+	require.NoError(t, err)
+	prog, err := Parse(`
+		SELECT id, email FROM users WHERE id = $id
+		UNION SELECT ug.group_id, u.email FROM users u LEFT JOIN user_groups ug ON ug.user_id = u.id;
+		SELECT id FROM users UNION SELECT id FROM users LIMIT 1;
+		SELECT id FROM users UNION SELECT id, email FROM users;`)
+	require.NoError(t, err)
+	require.Len(t, prog.Statements, 3)
+
+	// Rows from either side come out, with the names of the first
+	shape, err := getShape(prog.Statements[0], model)
+	require.NoError(t, err)
+	require.Equal(t, QueryResultTypeManyRows, shape.Type)
+	require.Equal(t, "id", shape.Columns[0].Name)
+	require.True(t, shape.Columns[0].Nullable)
+
+	shape, err = getShape(prog.Statements[1], model)
+	require.NoError(t, err)
+	require.Equal(t, QueryResultTypeOneRow, shape.Type)
+
+	_, err = getShape(prog.Statements[2], model)
+	require.Error(t, err)
+}
+
+func TestGetShapeArrays(t *testing.T) {
+	model, err := ModelFromMigrations([]*Migration{&Migration{UpSQL: `
+		CREATE TABLE issues (
+			id INT NOT NULL PRIMARY KEY,
+			labels TEXT[] NOT NULL
+		)`}})
+	require.NoError(t, err)
+	prog, err := Parse(`
+		SELECT labels, labels[1] AS first_label, ARRAY[id] AS ids FROM issues WHERE id = ANY($ids);
+		SELECT id FROM issues WHERE $label = ANY(labels);
+		SELECT id FROM issues WHERE id = ANY(labels);`)
+	require.NoError(t, err)
+
+	shape, err := getShape(prog.Statements[0], model)
+	require.NoError(t, err)
+	require.Len(t, shape.Columns, 3)
+	require.Equal(t, 1, shape.Columns[0].ArrayDims)
+	require.Equal(t, DataTypeText, shape.Columns[1].Type)
+	require.Equal(t, 0, shape.Columns[1].ArrayDims)
+	require.True(t, shape.Columns[1].Nullable)
+	require.Equal(t, DataTypeInteger, shape.Columns[2].Type)
+	require.Equal(t, 1, shape.Columns[2].ArrayDims)
+
+	// Many ids can match
+	require.Equal(t, QueryResultTypeManyRows, shape.Type)
+
+	params, err := getParameterColumns(prog.Statements[0], model)
+	require.NoError(t, err)
+	require.Equal(t, DataTypeInteger, params["ids"].Type)
+	require.Equal(t, 1, params["ids"].ArrayDims)
+
+	params, err = getParameterColumns(prog.Statements[1], model)
+	require.NoError(t, err)
+	require.Equal(t, DataTypeText, params["label"].Type)
+	require.Equal(t, 0, params["label"].ArrayDims)
+	require.False(t, params["label"].Nullable)
+
+	_, err = getShape(prog.Statements[2], model)
+	require.NoError(t, err)
+	_, err = getParameterColumns(prog.Statements[2], model)
+	require.NoError(t, err)
+}
+
+func TestGetShapeForeignKeyJoin(t *testing.T) {
+	model, err := ModelFromMigrations([]*Migration{&Migration{UpSQL: `
+		CREATE TABLE projects (
+			tid INT NOT NULL,
+			"key" TEXT NOT NULL,
+			"name" TEXT NOT NULL,
+			PRIMARY KEY (tid, "key")
+		);
+		CREATE TABLE issues (
+			tid INT NOT NULL,
+			id INT NOT NULL,
+			project_key TEXT NOT NULL,
+			PRIMARY KEY (tid, id),
+			FOREIGN KEY (tid, project_key) REFERENCES projects
+		)`}})
+	require.NoError(t, err)
+	prog, err := Parse(`
+		SELECT i.id, p.name FROM issues i JOIN projects p ON p.tid = i.tid AND p.key = i.project_key WHERE i.tid = $tid AND i.id = $id;
+		SELECT i.id, p.name FROM issues i JOIN projects p ON p.key = i.project_key WHERE i.tid = $tid AND i.id = $id;
+		SELECT i.id, p.name FROM projects p JOIN issues i ON p.tid = i.tid AND p.key = i.project_key WHERE p.tid = $tid AND p.key = $key;`)
+	require.NoError(t, err)
+
+	// Following the foreign key to the parent finds one project per issue
+	join := prog.Statements[0].(Select).Joins[0]
+	require.True(t, joinFollowsForeignKey(join, prog.Statements[0].(Select), model))
+	shape, err := getShape(prog.Statements[0], model)
+	require.NoError(t, err)
+	require.Equal(t, QueryResultTypeOneRow, shape.Type)
+
+	// Only part of the foreign key
+	join = prog.Statements[1].(Select).Joins[0]
+	require.False(t, joinFollowsForeignKey(join, prog.Statements[1].(Select), model))
+	shape, err = getShape(prog.Statements[1], model)
+	require.NoError(t, err)
+	require.Equal(t, QueryResultTypeManyRows, shape.Type)
+
+	// A project has many issues
+	shape, err = getShape(prog.Statements[2], model)
+	require.NoError(t, err)
+	require.Equal(t, QueryResultTypeManyRows, shape.Type)
+}
+
+func TestGetShapeUniqueIndexes(t *testing.T) {
+	model, err := ModelFromMigrations([]*Migration{&Migration{UpSQL: `
+		CREATE TABLE users (
+			id INT NOT NULL PRIMARY KEY,
+			tid INT NOT NULL,
+			email TEXT NOT NULL,
+			username TEXT NOT NULL,
+			deleted TIMESTAMP NULL
+		);
+		CREATE UNIQUE INDEX ix_username ON users (tid, username);
+		CREATE UNIQUE INDEX ix_email ON users (email) WHERE deleted IS NULL;
+		CREATE UNIQUE INDEX ix_lower_username ON users (lower(username));`}})
+	require.NoError(t, err)
+
+	queries := map[string]queryResultType{
+		"SELECT id FROM users WHERE tid = $tid AND username = $username":                                    QueryResultTypeOneRow,
+		"SELECT id FROM users WHERE username = $username":                                                   QueryResultTypeManyRows,
+		"SELECT id FROM users WHERE email = $email AND deleted IS NULL":                                     QueryResultTypeOneRow,
+		"SELECT u.id FROM users u WHERE u.deleted IS NULL AND u.email = $email":                             QueryResultTypeOneRow,
+		"SELECT id FROM users WHERE email = $email":                                                         QueryResultTypeManyRows,
+		"SELECT id FROM users WHERE email = $email OR deleted IS NULL":                                      QueryResultTypeManyRows,
+		"SELECT u.id FROM users u JOIN users o ON o.email = u.email AND o.deleted IS NULL WHERE u.id = $id": QueryResultTypeOneRow,
+	}
+	for sql, expected := range queries {
+		prog, err := Parse(sql)
+		require.NoError(t, err, sql)
+		shape, err := getShape(prog.Statements[0], model)
+		require.NoError(t, err, sql)
+		require.Equal(t, expected, shape.Type, sql)
+	}
+}
+
+func TestGetShapeFixedColumns(t *testing.T) {
+	model, err := ModelFromMigrations([]*Migration{&Migration{UpSQL: `
+		CREATE TABLE pairs (
+			a INT NOT NULL,
+			b INT NOT NULL,
+			label TEXT NOT NULL,
+			PRIMARY KEY (a, b)
+		);`}})
+	require.NoError(t, err)
+
+	queries := map[string]queryResultType{
+		"SELECT label FROM pairs WHERE a = $a AND b = 2":                                                  QueryResultTypeOneRow,
+		"SELECT label FROM pairs WHERE a = $a AND b = a":                                                  QueryResultTypeOneRow,
+		"SELECT p.label FROM pairs p WHERE p.b = p.a AND p.a = $a":                                        QueryResultTypeOneRow,
+		"SELECT label FROM pairs WHERE a = b":                                                             QueryResultTypeManyRows,
+		"SELECT p.label FROM pairs p WHERE p.a = p.b":                                                     QueryResultTypeManyRows,
+		"SELECT p.label FROM pairs p WHERE p.a = $a AND p.b = p.b":                                        QueryResultTypeManyRows,
+		"SELECT o.label FROM pairs p JOIN pairs o ON o.a = p.b AND o.b = p.a":                             QueryResultTypeManyRows,
+		"SELECT o.label FROM pairs p JOIN pairs o ON o.a = p.b AND o.b = p.a WHERE p.a = $a AND p.b = $b": QueryResultTypeOneRow,
+	}
+	for sql, expected := range queries {
+		prog, err := Parse(sql)
+		require.NoError(t, err, sql)
+		shape, err := getShape(prog.Statements[0], model)
+		require.NoError(t, err, sql)
+		require.Equal(t, expected, shape.Type, sql)
+	}
+}
+
+func TestGetShapeViews(t *testing.T) {
+	model, err := ModelFromMigrations([]*Migration{&Migration{UpSQL: `
+		CREATE TABLE users (
+			id INT NOT NULL PRIMARY KEY,
+			email TEXT NOT NULL,
+			deleted TIMESTAMP NULL
+		);
+		CREATE TABLE logins (user_id INT NOT NULL, at TIMESTAMP NOT NULL);
+		CREATE VIEW active_users (user_id) AS SELECT id, email FROM users WHERE deleted IS NULL;
+		CREATE MATERIALIZED VIEW first_user AS SELECT id FROM users LIMIT 1;
+		CREATE MATERIALIZED VIEW user_emails AS SELECT id, email FROM users;
+		CREATE UNIQUE INDEX ix_user_emails_id ON user_emails (id);`}})
+	require.NoError(t, err)
+
+	prog, err := Parse("SELECT a.user_id, a.email, l.at FROM active_users a LEFT JOIN logins l ON l.user_id = a.user_id WHERE a.email = $email")
+	require.NoError(t, err)
+	shape, err := getShape(prog.Statements[0], model)
+	require.NoError(t, err)
+	require.Equal(t, QueryResultTypeManyRows, shape.Type)
+	require.Len(t, shape.Columns, 3)
+	require.Equal(t, "user_id", shape.Columns[0].Name)
+	require.Equal(t, DataTypeInteger, shape.Columns[0].Type)
+	require.Equal(t, ColumnSource{TableName: "users", ColumnName: "id"}, shape.Columns[0].Source)
+	require.Equal(t, DataTypeText, shape.Columns[1].Type)
+	require.True(t, shape.Columns[2].Nullable)
+
+	params, err := getParameterColumns(prog.Statements[0], model)
+	require.NoError(t, err)
+	require.Equal(t, DataTypeText, params["email"].Type)
+
+	queries := map[string]queryResultType{
+		"SELECT id FROM first_user":                       QueryResultTypeOneRow,
+		"SELECT email FROM user_emails WHERE id = $id":    QueryResultTypeOneRow,
+		"SELECT id FROM user_emails WHERE email = $email": QueryResultTypeManyRows,
+		"REFRESH MATERIALIZED VIEW first_user":            QueryResultTypeCommand,
+		"DROP VIEW IF EXISTS active_users, nope":          QueryResultTypeCommand,
+	}
+	for sql, expected := range queries {
+		prog, err := Parse(sql)
+		require.NoError(t, err, sql)
+		shape, err := getShape(prog.Statements[0], model)
+		require.NoError(t, err, sql)
+		require.Equal(t, expected, shape.Type, sql)
+	}
+
+	invalid := []string{
+		"SELECT id FROM active_users",
+		"REFRESH MATERIALIZED VIEW active_users",
+		"REFRESH MATERIALIZED VIEW nope",
+		"DROP VIEW nope",
+	}
+	for _, sql := range invalid {
+		prog, err := Parse(sql)
+		require.NoError(t, err, sql)
+		_, err = getShape(prog.Statements[0], model)
+		require.Error(t, err, sql)
+	}
+}
+
+func TestGetShapeSchemas(t *testing.T) {
+	model, err := ModelFromMigrations([]*Migration{&Migration{UpSQL: `
+		CREATE SCHEMA audit;
+		CREATE TABLE users (id INT NOT NULL PRIMARY KEY, email TEXT NOT NULL);
+		CREATE TABLE audit.events (
+			id BIGINT NOT NULL PRIMARY KEY,
+			user_id INT NOT NULL REFERENCES users,
+			note TEXT NULL
+		);
+		CREATE TABLE audit.users (id UUID NOT NULL PRIMARY KEY);`}})
+	require.NoError(t, err)
+
+	prog, err := Parse(`
+		SELECT events.id, u.email, pg_catalog.now() AS at
+		FROM audit.events
+		JOIN public.users u ON u.id = events.user_id
+		WHERE events.id = $id`)
+	require.NoError(t, err)
+	shape, err := getShape(prog.Statements[0], model)
+	require.NoError(t, err)
+	require.Equal(t, QueryResultTypeOneRow, shape.Type)
+	require.Equal(t, DataTypeBigInt, shape.Columns[0].Type)
+	require.Equal(t, ColumnSource{TableName: "audit.events", ColumnName: "id"}, shape.Columns[0].Source)
+	require.Equal(t, DataTypeText, shape.Columns[1].Type)
+	require.Equal(t, DataTypeTimestampWithTimeZone, shape.Columns[2].Type)
+
+	// Unqualified names follow the search path
+	prog, err = Parse("SELECT id FROM users")
+	require.NoError(t, err)
+	shape, err = getShape(prog.Statements[0], model)
+	require.NoError(t, err)
+	require.Equal(t, DataTypeInteger, shape.Columns[0].Type)
+
+	model.SearchPath = []string{"audit", "public"}
+	shape, err = getShape(prog.Statements[0], model)
+	require.NoError(t, err)
+	require.Equal(t, DataTypeUUID, shape.Columns[0].Type)
+
+	prog, err = Parse("INSERT INTO events (id, user_id) VALUES ($id, $user_id)")
+	require.NoError(t, err)
+	_, err = getShape(prog.Statements[0], model)
+	require.NoError(t, err)
+
+	model.SearchPath = nil
+	_, err = getShape(prog.Statements[0], model)
+	require.Error(t, err)
+
+	prog, err = Parse("SELECT id FROM nope.users")
+	require.NoError(t, err)
+	_, err = getShape(prog.Statements[0], model)
+	require.Error(t, err)
+}
+
+func TestGetShapeErrorsShowNamesAsWritten(t *testing.T) {
+	model, err := ModelFromMigrations([]*Migration{&Migration{UpSQL: `
+		CREATE TABLE users (id INT NOT NULL PRIMARY KEY);
+		CREATE TABLE admins (id INT NOT NULL PRIMARY KEY);`}})
+	require.NoError(t, err)
+
+	invalid := map[string]string{
+		"SELECT Idd FROM USERS":                                "Column 'Idd' not found",
+		`SELECT "Id" FROM users`:                               `Column '"Id"' not found`,
+		"SELECT id FROM UserZ":                                 "Unknown table 'UserZ'",
+		"SELECT id FROM Audit.Users":                           "Unknown table 'Audit.Users'",
+		"SELECT U.Idd FROM users u":                            "Column 'Idd' not found on 'U'",
+		"SELECT Nope.id FROM users":                            "Invalid table/alias 'Nope'",
+		"SELECT ID FROM users u JOIN admins a ON a.id = u.id":  "Ambiguous column 'ID'",
+		"SELECT u.id FROM users u JOIN UserZ z ON z.id = u.id": "Unknown table 'UserZ'",
+	}
+	for sql, message := range invalid {
+		prog, err := Parse(sql)
+		require.NoError(t, err, sql)
+		_, err = getShape(prog.Statements[0], model)
+		require.Error(t, err, sql)
+		require.Contains(t, err.Error(), message, sql)
+	}
+}
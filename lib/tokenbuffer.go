@@ -20,6 +20,7 @@ type tokenBuffer struct {
 	doneChan     chan struct{}
 	peeked       *peekResult
 	doneReceived bool
+	read         int
 }
 
 func newTokenBuffer() *tokenBuffer {
@@ -35,12 +36,31 @@ func (tb *tokenBuffer) Next() (tok token, done bool, err error) {
 	if tb.peeked != nil {
 		res := tb.peeked
 		tb.peeked = nil
-		return res.tok, res.done, res.err
+		tok, done, err = res.tok, res.done, res.err
+	} else {
+		tok, done, err = tb.receive()
 	}
 
-	timeout := TokenReadTimeout
+	if !done && err == nil {
+		tb.read++
+	}
+	return tok, done, err
+}
+
+func (tb *tokenBuffer) Index() int {
+	return tb.read
+}
+
+func (tb *tokenBuffer) receive() (token, bool, error) {
+	// Once the writer is done every token has already been written, so drain
+	// whatever is left without waiting.
 	if tb.doneReceived {
-		timeout = 0
+		select {
+		case tok := <-tb.tokChan:
+			return tok, false, nil
+		default:
+			return token{}, true, nil
+		}
 	}
 
 	select {
@@ -48,11 +68,8 @@ func (tb *tokenBuffer) Next() (tok token, done bool, err error) {
 		return tok, false, nil
 	case <-tb.doneChan:
 		tb.doneReceived = true
-		return tb.Next()
-	case <-time.After(timeout):
-		if tb.doneReceived {
-			return token{}, true, nil
-		}
+		return tb.receive()
+	case <-time.After(TokenReadTimeout):
 		return token{}, false, errors.New("timed out waiting for next token")
 	}
 }
@@ -61,7 +78,7 @@ func (tb *tokenBuffer) Peek() (token, bool, error) {
 	if tb.peeked != nil {
 		return tb.peeked.tok, tb.peeked.done, tb.peeked.err
 	}
-	tok, done, err := tb.Next()
+	tok, done, err := tb.receive()
 	tb.peeked = &peekResult{tok: tok, done: done, err: err}
 	return tok, done, err
 }
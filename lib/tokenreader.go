@@ -3,4 +3,37 @@ package lib
 type tokenReader interface {
 	Next() (tok token, done bool, err error)
 	Peek() (tok token, done bool, err error)
+
+	// The number of tokens that Next has returned so far, which is also the
+	// index of the next token.
+	Index() int
+}
+
+// Reads tokens that have already been lexed, like the ones in a comment.
+type tokenSliceReader struct {
+	tokens []token
+	read   int
+}
+
+func newTokenSliceReader(tokens []token) *tokenSliceReader {
+	return &tokenSliceReader{tokens: tokens}
+}
+
+func (r *tokenSliceReader) Next() (token, bool, error) {
+	tok, done, err := r.Peek()
+	if !done {
+		r.read++
+	}
+	return tok, done, err
+}
+
+func (r *tokenSliceReader) Peek() (token, bool, error) {
+	if r.read >= len(r.tokens) {
+		return token{}, true, nil
+	}
+	return r.tokens[r.read], false, nil
+}
+
+func (r *tokenSliceReader) Index() int {
+	return r.read
 }
@@ -8,6 +8,8 @@ const (
 	tokenTypeParameter
 	tokenTypeLParen
 	tokenTypeRParen
+	tokenTypeLBracket
+	tokenTypeRBracket
 	tokenTypeString
 	tokenTypeDot
 	tokenTypeComma
@@ -22,10 +24,38 @@ const (
 	tokenTypeGreaterOrEqual
 	tokenTypeEqual
 	tokenTypeNotEqual
+	tokenTypeCast
+
+	// Only emitted when there are comments after the last token, to carry them.
+	tokenTypeEOF
 )
 
 type token struct {
 	tokType  tokenType
 	value    []rune
 	location charLocation
+
+	// The number of characters that the token takes up in the SQL.
+	length int
+
+	// Words are identifiers or keywords. Unquoted words are folded to lower
+	// case like Postgres does, so raw keeps the text as it was written for
+	// error messages. Quoted words keep their case and are never keywords.
+	raw    []rune
+	quoted bool
+
+	// Parameters like $status? that may be NULL to leave out the condition
+	// they are compared in.
+	optional bool
+
+	// Comments that came before this token and after the previous one.
+	trivia []comment
+}
+
+// A -- line comment or /* */ block comment. The text does not include the
+// comment markers.
+type comment struct {
+	text     string
+	block    bool
+	location charLocation
 }
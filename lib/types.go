@@ -1,6 +1,25 @@
 package lib
 
+import "fmt"
+
 type charLocation struct {
 	line int
 	col  int
 }
+
+// An error that can be traced back to a specific place in the SQL.
+type locatedError struct {
+	location charLocation
+	message  string
+}
+
+func newLocatedError(location charLocation, format string, args ...interface{}) locatedError {
+	return locatedError{
+		location: location,
+		message:  fmt.Sprintf(format, args...),
+	}
+}
+
+func (e locatedError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.location.line, e.location.col, e.message)
+}
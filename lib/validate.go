@@ -0,0 +1,223 @@
+package lib
+
+import "strings"
+
+// Broad groups of data types. A value can only be assigned to a column of
+// the same group, except that anything can be assigned to a string column
+// because Postgres converts it to its text form.
+type typeCategory int
+
+const (
+	typeCategoryUnknown typeCategory = iota
+	typeCategoryNumeric
+	typeCategoryString
+	typeCategoryBoolean
+	typeCategoryDateTime
+	typeCategoryOther
+)
+
+func getTypeCategory(t dataType) typeCategory {
+	switch t {
+	case DataTypeSmallInt, DataTypeInteger, DataTypeBigInt, DataTypeDecimal,
+		DataTypeNumeric, DataTypeReal, DataTypeDoublePrecision,
+		DataTypeSmallSerial, DataTypeSerial, DataTypeBigSerial, DataTypeMoney:
+		return typeCategoryNumeric
+	case DataTypeChar, DataTypeVarChar, DataTypeText:
+		return typeCategoryString
+	case DataTypeBoolean:
+		return typeCategoryBoolean
+	case DataTypeTimestamp, DataTypeTimestampWithTimeZone, DataTypeDate,
+		DataTypeTime, DataTypeTimeWithTimeZone:
+		return typeCategoryDateTime
+	default:
+		return typeCategoryOther
+	}
+}
+
+// Checks an INSERT against the model: the table and columns exist, there is a
+// value for each column, the values have types that fit their columns, and
+// every NOT NULL column without a default is given a value.
+func validateInsert(query Insert, model Model) error {
+	tbl, ok := model.table(query.Target.TableName)
+	if !ok {
+		return newLocatedError(
+			query.TargetLocation,
+			"Unknown table '%s'",
+			asWritten(query.Target.WrittenTableName, query.Target.TableName))
+	}
+
+	columns := []ColumnDefinition{}
+	for i, colExpr := range query.Columns {
+		location := locationAt(query.ColumnLocations, i, query.TargetLocation)
+		columnName := asWritten(colExpr.WrittenColumnName, colExpr.ColumnName)
+
+		for _, prev := range query.Columns[:i] {
+			if prev.ColumnName == colExpr.ColumnName {
+				return newLocatedError(location, "Column '%s' is given more than once", columnName)
+			}
+		}
+
+		def, found := tbl.column(colExpr.ColumnName)
+		if !found {
+			return newLocatedError(
+				location,
+				"Column '%s' does not exist in the table '%s'",
+				columnName,
+				tbl.Name)
+		}
+		columns = append(columns, def)
+	}
+
+	if len(query.Values) != len(query.Columns) {
+		location := query.TargetLocation
+		if len(query.Values) > len(query.Columns) {
+			location = locationAt(query.ValueLocations, len(query.Columns), location)
+		}
+		return newLocatedError(
+			location,
+			"INSERT into '%s' has %d columns but %d values",
+			tbl.Name,
+			len(query.Columns),
+			len(query.Values))
+	}
+
+	for i, value := range query.Values {
+		location := locationAt(query.ValueLocations, i, query.TargetLocation)
+		err := checkAssignable(value, columns[i], tbl, location)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, def := range tbl.Columns {
+		if def.Nullable || def.HasDefault() {
+			continue
+		}
+		if !insertHasColumn(query, def.Name) {
+			return newLocatedError(
+				query.TargetLocation,
+				"INSERT into '%s' must give a value for the column '%s' because it is NOT NULL and has no default",
+				tbl.Name,
+				def.Name)
+		}
+	}
+
+	return nil
+}
+
+// Returns an error if the value can not be stored in the column.
+func checkAssignable(value Expression, def ColumnDefinition, tbl *Table, location charLocation) error {
+	if keyword, ok := valueKeyword(value); ok {
+		switch keyword {
+		case "NULL":
+			if !def.Nullable {
+				return newLocatedError(
+					location,
+					"Cannot insert NULL into the column '%s' of '%s' because it is NOT NULL",
+					def.Name,
+					tbl.Name)
+			}
+			return nil
+		case "DEFAULT":
+			if !def.Nullable && !def.HasDefault() {
+				return newLocatedError(
+					location,
+					"Cannot insert DEFAULT into the column '%s' of '%s' because it has no default and is NOT NULL",
+					def.Name,
+					tbl.Name)
+			}
+			return nil
+		}
+	}
+
+	if col, ok := value.(ColumnExpression); ok {
+		if _, isKeyword := valueKeyword(col); !isKeyword {
+			return newLocatedError(location, "Cannot use the column '%s' as a value in an INSERT", col.String())
+		}
+	}
+
+	category := getValueCategory(value)
+	if category == typeCategoryUnknown {
+		return nil
+	}
+
+	column := getTypeCategory(def.Type)
+	assignable := def.ArrayDims == 0 &&
+		(category == column || column == typeCategoryString)
+	if !assignable {
+		return newLocatedError(
+			location,
+			"Value for the column '%s' of '%s' does not have a compatible type",
+			def.Name,
+			tbl.Name)
+	}
+	return nil
+}
+
+// The keywords that can be used as values. The parser reads them as columns.
+var valueKeywordCategories = map[string]typeCategory{
+	"NULL":              typeCategoryUnknown,
+	"DEFAULT":           typeCategoryUnknown,
+	"TRUE":              typeCategoryBoolean,
+	"FALSE":             typeCategoryBoolean,
+	"CURRENT_TIMESTAMP": typeCategoryDateTime,
+	"CURRENT_DATE":      typeCategoryDateTime,
+	"CURRENT_TIME":      typeCategoryDateTime,
+	"LOCALTIMESTAMP":    typeCategoryDateTime,
+	"LOCALTIME":         typeCategoryDateTime,
+}
+
+func valueKeyword(value Expression) (string, bool) {
+	col, ok := value.(ColumnExpression)
+	if !ok || col.TableName != "" {
+		return "", false
+	}
+	keyword := strings.ToUpper(col.ColumnName)
+	_, ok = valueKeywordCategories[keyword]
+	return keyword, ok
+}
+
+// Returns the category of a value when it can be known without any context.
+// String literals and parameters take on the type of their column so they are
+// unknown.
+func getValueCategory(value Expression) typeCategory {
+	switch typed := value.(type) {
+	case NumberLiteral:
+		return typeCategoryNumeric
+	case UnaryExpression:
+		return getValueCategory(typed.Right)
+	case CastExpression:
+		if typed.Type.ArrayDims == 0 {
+			return getTypeCategory(typed.Type.Type)
+		}
+	case ColumnExpression:
+		keyword, ok := valueKeyword(typed)
+		if ok {
+			return valueKeywordCategories[keyword]
+		}
+	case FunctionExpression:
+		def, err := getFuncReturnType(typed)
+		if err == nil && def.ArrayDims == 0 {
+			return getTypeCategory(def.Type)
+		}
+	}
+	return typeCategoryUnknown
+}
+
+func insertHasColumn(query Insert, name string) bool {
+	for _, col := range query.Columns {
+		if col.ColumnName == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns locations[i], or the fallback for statements that were not built by
+// the parser.
+func locationAt(locations []charLocation, i int, fallback charLocation) charLocation {
+	if i < len(locations) {
+		return locations[i]
+	}
+	return fallback
+}
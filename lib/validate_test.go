@@ -0,0 +1,58 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateInsert(t *testing.T) {
+	model, err := ModelFromMigrations([]*Migration{&Migration{UpSQL: `
+		CREATE TABLE issues (
+			id SERIAL NOT NULL PRIMARY KEY,
+			tid INT NOT NULL,
+			"name" TEXT NOT NULL,
+			priority INT NOT NULL DEFAULT 0,
+			done BOOLEAN NULL,
+			created TIMESTAMPTZ NOT NULL,
+			labels TEXT[] NULL
+		)`}})
+	require.NoError(t, err)
+
+	valid := []string{
+		"INSERT INTO issues (tid, name, created) VALUES ($tid, $name, CURRENT_TIMESTAMP)",
+		"INSERT INTO issues (tid, name, created, priority) VALUES (1, 'x', now(), -1)",
+		"INSERT INTO issues (tid, name, created, done, labels) VALUES (1, 2, '2019-01-01', TRUE, NULL)",
+		"INSERT INTO issues (id, tid, name, created, priority) VALUES (DEFAULT, 1, 'x', now(), DEFAULT)",
+	}
+	for _, sql := range valid {
+		prog, err := Parse(sql)
+		require.NoError(t, err, sql)
+		require.NoError(t, validateInsert(prog.Statements[0].(Insert), model), sql)
+	}
+
+	invalid := map[string]string{
+		"INSERT INTO nope (tid) VALUES (1)":                                         "1:13: Unknown table 'nope'",
+		"INSERT INTO issues (tid, nme, created) VALUES ($tid, $name, now())":        "1:26: Column 'nme' does not exist in the table 'issues'",
+		"INSERT INTO issues (tid, tid) VALUES (1, 1)":                               "1:26: Column 'tid' is given more than once",
+		"INSERT INTO issues (tid, name, created) VALUES ($tid)":                     "1:13: INSERT into 'issues' has 3 columns but 1 values",
+		"INSERT INTO issues (tid) VALUES ($tid, $name)":                             "1:40: INSERT into 'issues' has 1 columns but 2 values",
+		"INSERT INTO issues (tid, name) VALUES ($tid, $name)":                       "1:13: INSERT into 'issues' must give a value for the column 'created' because it is NOT NULL and has no default",
+		"INSERT INTO issues (tid, name, created) VALUES (NULL, 'x', now())":         "1:49: Cannot insert NULL into the column 'tid' of 'issues' because it is NOT NULL",
+		"INSERT INTO issues (tid, name, created) VALUES (DEFAULT, 'x', now())":      "1:49: Cannot insert DEFAULT into the column 'tid' of 'issues' because it has no default and is NOT NULL",
+		"INSERT INTO issues (tid, name, created) VALUES (1, 'x', 5)":                "1:57: Value for the column 'created' of 'issues' does not have a compatible type",
+		"INSERT INTO issues (tid, name, created) VALUES (TRUE, 'x', now())":         "1:49: Value for the column 'tid' of 'issues' does not have a compatible type",
+		"INSERT INTO issues (tid, name, created, labels) VALUES (1, 'x', now(), 1)": "1:72: Value for the column 'labels' of 'issues' does not have a compatible type",
+		"INSERT INTO issues (tid, name, created) VALUES (1, name, now())":           "1:52: Cannot use the column 'name' as a value in an INSERT",
+		"INSERT INTO Nope (tid) VALUES (1)":                                         "1:13: Unknown table 'Nope'",
+		"INSERT INTO issues (tid, Nme, created) VALUES ($tid, $name, now())":        "1:26: Column 'Nme' does not exist in the table 'issues'",
+		"INSERT INTO issues (tid, TID) VALUES (1, 1)":                               "1:26: Column 'TID' is given more than once",
+	}
+	for sql, message := range invalid {
+		prog, err := Parse(sql)
+		require.NoError(t, err, sql)
+		err = validateInsert(prog.Statements[0].(Insert), model)
+		require.Error(t, err, sql)
+		require.Equal(t, message, err.Error(), sql)
+	}
+}
@@ -3,15 +3,15 @@
 package store
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
+
 	_ "github.com/lib/pq"
-	"os"
-	"time"
 )
 
 type DBClient interface {
-	GetUsers() ([]GetUsersResult, error)
+	GetUsers(ctx context.Context) (r1 []GetUsersResult, err error)
+	ForEachGetUsers(ctx context.Context, fn func(GetUsersResult) error) (err error)
 	Close()
 }
 
@@ -36,21 +36,29 @@ func (client SQLDBClient) Close() {
 
 /******************************************************************************
  * get_users
- ****************************************************************************/
+ *****************************************************************************/
+
+const getUsersSQL = "SELECT\n  u.id, u.email, u.first_name, u.last_name, g.name AS group_name\nFROM\n  users u\nLEFT JOIN user_groups ug ON u.id = ug.user_id\nLEFT JOIN groups g ON g.id = ug.group_id"
 
 type GetUsersResult struct {
 	Id        int32
 	Email     string
-	FirstName string
-	LastName  string
-	GroupName string
+	FirstName *string
+	LastName  *string
+	GroupName *string
 }
 
-func (client SQLDBClient) GetUsers() (r1 []GetUsersResult, err error) {
-	r1 = nil
+func (client SQLDBClient) queryGetUsersResult(ctx context.Context) (result []GetUsersResult, err error) {
+	err = client.eachGetUsersResult(ctx, func(row GetUsersResult) error {
+		result = append(result, row)
+		return nil
+	})
+	return
+}
 
-	sql := "SELECT\n  u.id, u.email, u.first_name, u.last_name, g.name AS group_name\nFROM\n  users u\nLEFT JOIN user_groups ug ON u.id = ug.user_id\nLEFT JOIN groups g ON g.id = ug.group_id;"
-	rows, err := client.db.Query(sql)
+// Calls fn with each row as it is scanned and stops at the first error.
+func (client SQLDBClient) eachGetUsersResult(ctx context.Context, fn func(GetUsersResult) error) (err error) {
+	rows, err := client.db.QueryContext(ctx, getUsersSQL)
 	if err != nil {
 		return
 	}
@@ -60,23 +68,42 @@ func (client SQLDBClient) GetUsers() (r1 []GetUsersResult, err error) {
 		var (
 			id        int32
 			email     string
-			firstName string
-			lastName  string
-			groupName string
+			firstName *string
+			lastName  *string
+			groupName *string
 		)
 		err = rows.Scan(&id, &email, &firstName, &lastName, &groupName)
 		if err != nil {
 			return
 		}
 
-		r1 = append(r1, GetUsersResult{
+		err = fn(GetUsersResult{
 			Id:        id,
 			Email:     email,
 			FirstName: firstName,
 			LastName:  lastName,
 			GroupName: groupName,
 		})
+		if err != nil {
+			return
+		}
 	}
 
+	err = rows.Err()
 	return
 }
+
+func (client SQLDBClient) GetUsers(ctx context.Context) (r1 []GetUsersResult, err error) {
+	r1, err = client.queryGetUsersResult(ctx)
+	if err != nil {
+		return
+	}
+	return
+}
+
+// ForEachGetUsers calls fn with each row that GetUsers would return as it
+// is read, without holding them all in memory. It stops at the first error
+// from fn and returns it.
+func (client SQLDBClient) ForEachGetUsers(ctx context.Context, fn func(GetUsersResult) error) (err error) {
+	return client.eachGetUsersResult(ctx, fn)
+}
@@ -0,0 +1 @@
+ALTER TABLE issues ADD COLUMN labels TEXT[] NULL;
\ No newline at end of file
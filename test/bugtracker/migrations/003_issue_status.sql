@@ -0,0 +1,5 @@
+CREATE TYPE issue_status AS ENUM ('open', 'closed');
+
+ALTER TYPE issue_status ADD VALUE 'in_progress' BEFORE 'closed';
+
+ALTER TABLE issues ADD COLUMN status issue_status NULL;
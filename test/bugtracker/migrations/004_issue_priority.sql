@@ -0,0 +1 @@
+ALTER TABLE issues ADD COLUMN priority INT NOT NULL DEFAULT 0 CHECK (priority >= 0);
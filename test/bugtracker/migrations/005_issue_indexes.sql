@@ -0,0 +1,3 @@
+CREATE INDEX ix_issues_status ON issues (tid, status);
+
+CREATE UNIQUE INDEX ix_issues_name ON issues (tid, "name") WHERE status IS NULL;
@@ -0,0 +1,5 @@
+-- Issues that nobody has resolved yet. Resolved issues always have a status.
+CREATE VIEW open_issues AS
+SELECT i.tid, i.id, i."name", i.project_key, i.priority
+FROM issues i
+WHERE i.status IS NULL; /* closed issues are excluded */
@@ -0,0 +1,2027 @@
+// This code was generated by a tool (>^_^)>
+
+package pgxstore
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+type DBClient interface {
+	CreateIssue(ctx context.Context, params CreateIssueParams) (r1 []CreateIssueResult, err error)
+	CreateIssueType(ctx context.Context, tid pgtype.UUID, id pgtype.UUID, key string) (r1 []CreateIssueTypeResult, err error)
+	CopyFromCreateIssueType(ctx context.Context, rows []CreateIssueTypeParams) (err error)
+	CreateProject(ctx context.Context, tid pgtype.UUID, key string, name string) (r1 []CreateProjectResult, err error)
+	CreateTenant(ctx context.Context, id pgtype.UUID, key string, name string) (r1 []CreateTenantResult, err error)
+	GetIssue(ctx context.Context, tid pgtype.UUID, id string) (r1 []GetIssueResult1, r2 []GetIssueResult2, err error)
+	GetIssuesByLabel(ctx context.Context, tid pgtype.UUID, ids []string, label string) (r1 []GetIssuesByLabelResult, err error)
+	ForEachGetIssuesByLabel(ctx context.Context, tid pgtype.UUID, ids []string, label string, fn func(GetIssuesByLabelResult) error) (err error)
+	GetIssuesByStatus(ctx context.Context, tid pgtype.UUID, status IssueStatus) (r1 []GetIssuesByStatusResult, err error)
+	ForEachGetIssuesByStatus(ctx context.Context, tid pgtype.UUID, status IssueStatus, fn func(GetIssuesByStatusResult) error) (err error)
+	GetOpenIssues(ctx context.Context, tid pgtype.UUID, project_key string) (r1 []GetOpenIssuesResult, err error)
+	ForEachGetOpenIssues(ctx context.Context, tid pgtype.UUID, project_key string, fn func(GetOpenIssuesResult) error) (err error)
+	GetProjects(ctx context.Context, tid pgtype.UUID) (r1 []GetProjectsResult, err error)
+	ForEachGetProjects(ctx context.Context, tid pgtype.UUID, fn func(GetProjectsResult) error) (err error)
+	GetTags(ctx context.Context, tid pgtype.UUID) (r1 []GetTagsResult, err error)
+	ForEachGetTags(ctx context.Context, tid pgtype.UUID, fn func(GetTagsResult) error) (err error)
+	ImportIssue(ctx context.Context, params ImportIssueParams) (r1 []ImportIssueResult, err error)
+	CopyFromImportIssue(ctx context.Context, rows []ImportIssueParams) (err error)
+	ListIssues(ctx context.Context, tid pgtype.UUID, status *IssueStatus, min_priority *int32) (r1 []ListIssuesResult, err error)
+	ForEachListIssues(ctx context.Context, tid pgtype.UUID, status *IssueStatus, min_priority *int32, fn func(ListIssuesResult) error) (err error)
+	TagIssue(ctx context.Context, tid pgtype.UUID, issue_id string, tag_key string, created pgtype.Timestamptz) (r1 []TagIssueResult, err error)
+	CopyFromTagIssue(ctx context.Context, rows []TagIssueParams) (err error)
+	CreateTag(ctx context.Context, tid pgtype.UUID, key string) (err error)
+	GetTag(ctx context.Context, tid pgtype.UUID, key string) (r1 *GetTagResult, err error)
+	GetTagsCreatedWithin(ctx context.Context, tid pgtype.UUID, age pgtype.Interval) (r1 []GetTagsCreatedWithinResult, err error)
+	ForEachGetTagsCreatedWithin(ctx context.Context, tid pgtype.UUID, age pgtype.Interval, fn func(GetTagsCreatedWithinResult) error) (err error)
+	AddTag(ctx context.Context, tid pgtype.UUID, key string) (r1 int64, err error)
+	Close()
+}
+
+// PgxDB is the part of *pgx.Conn, *pgxpool.Pool and pgx.Tx that PgxDBClient
+// needs, so any of them can run the generated queries.
+type PgxDB interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+var (
+	_ PgxDB = (*pgx.Conn)(nil)
+	_ PgxDB = (*pgxpool.Pool)(nil)
+	_ PgxDB = pgx.Tx(nil)
+)
+
+type PgxDBClient struct {
+	db    PgxDB
+	close func()
+}
+
+// NewDBClient connects a new pgxpool.Pool that is closed along with the client.
+func NewDBClient(ctx context.Context, connectionString string) (DBClient, error) {
+	pool, err := pgxpool.Connect(ctx, connectionString)
+	if err != nil {
+		return PgxDBClient{}, err
+	}
+
+	return PgxDBClient{
+		db:    pool,
+		close: pool.Close,
+	}, nil
+}
+
+// NewDBClientFromPgx runs queries on an existing connection, pool or
+// transaction. Closing the client does not close db.
+func NewDBClientFromPgx(db PgxDB) DBClient {
+	return PgxDBClient{
+		db:    db,
+		close: func() {},
+	}
+}
+
+func (client PgxDBClient) Close() {
+	client.close()
+}
+
+// pgxCopier is the part of *pgx.Conn, *pgxpool.Pool and pgx.Tx that the
+// CopyFrom methods use. They insert without COPY when the PgxDB is something
+// else.
+type pgxCopier interface {
+	CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error)
+}
+
+var (
+	_ pgxCopier = (*pgx.Conn)(nil)
+	_ pgxCopier = (*pgxpool.Pool)(nil)
+	_ pgxCopier = pgx.Tx(nil)
+)
+
+/******************************************************************************
+ * issue_status enum
+ *****************************************************************************/
+
+type IssueStatus string
+
+const (
+	IssueStatusOpen       IssueStatus = "open"
+	IssueStatusInProgress IssueStatus = "in_progress"
+	IssueStatusClosed     IssueStatus = "closed"
+)
+
+// Valid returns true if e is one of the values that issue_status had when this
+// code was generated.
+func (e IssueStatus) Valid() bool {
+	switch e {
+	case IssueStatusOpen, IssueStatusInProgress, IssueStatusClosed:
+		return true
+	}
+	return false
+}
+
+// Scan implements sql.Scanner so that query results can be read into IssueStatus.
+func (e *IssueStatus) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case string:
+		*e = IssueStatus(v)
+	case []byte:
+		*e = IssueStatus(v)
+	default:
+		return fmt.Errorf("cannot scan %T into IssueStatus", src)
+	}
+	return nil
+}
+
+// Value implements driver.Valuer so that IssueStatus can be passed to queries.
+func (e IssueStatus) Value() (driver.Value, error) {
+	return string(e), nil
+}
+
+/******************************************************************************
+ * create_issue
+ *****************************************************************************/
+
+// CreateIssueParams holds the parameters of DBClient.CreateIssue.
+type CreateIssueParams struct {
+	// Tid is passed as $tid and has the type of the column tid.
+	Tid pgtype.UUID
+	// Id is passed as $id and has the type of the column id.
+	Id string
+	// Name is passed as $name and has the type of the column name.
+	Name string
+	// ProjectKey is passed as $project_key and has the type of the column project_key.
+	ProjectKey string
+	// Fields is passed as $fields and has the type of the column fields.
+	Fields pgtype.JSONB
+}
+
+const createIssueSQL = "INSERT INTO issues\n  (tid, id, \"name\", project_key, fields, created)\nVALUES\n  ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP)"
+
+type CreateIssueResult struct {
+}
+
+func (client PgxDBClient) queryCreateIssueResult(ctx context.Context, tid pgtype.UUID, id string, name string, project_key string, fields pgtype.JSONB) (result []CreateIssueResult, err error) {
+	err = client.eachCreateIssueResult(ctx, tid, id, name, project_key, fields, func(row CreateIssueResult) error {
+		result = append(result, row)
+		return nil
+	})
+	return
+}
+
+// Calls fn with each row as it is scanned and stops at the first error.
+func (client PgxDBClient) eachCreateIssueResult(ctx context.Context, tid pgtype.UUID, id string, name string, project_key string, fields pgtype.JSONB, fn func(CreateIssueResult) error) (err error) {
+	rows, err := client.db.Query(ctx, createIssueSQL, tid, id, name, project_key, fields)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ()
+		err = rows.Scan()
+		if err != nil {
+			return
+		}
+
+		err = fn(CreateIssueResult{})
+		if err != nil {
+			return
+		}
+	}
+
+	err = rows.Err()
+	return
+}
+
+func (client PgxDBClient) CreateIssue(ctx context.Context, params CreateIssueParams) (r1 []CreateIssueResult, err error) {
+	tid := params.Tid
+	id := params.Id
+	name := params.Name
+	project_key := params.ProjectKey
+	fields := params.Fields
+
+	r1, err = client.queryCreateIssueResult(ctx, tid, id, name, project_key, fields)
+	if err != nil {
+		return
+	}
+	return
+}
+
+/******************************************************************************
+ * create_issue_type
+ *****************************************************************************/
+
+// CreateIssueTypeParams holds the parameters of DBClient.CreateIssueType.
+type CreateIssueTypeParams struct {
+	// Tid is passed as $tid and has the type of the column tid.
+	Tid pgtype.UUID
+	// Id is passed as $id and has the type of the column id.
+	Id pgtype.UUID
+	// Key is passed as $key and has the type of the column key.
+	Key string
+}
+
+const createIssueTypeSQL = "INSERT INTO issue_type\n  (tid, id, \"key\")\nVALUES\n  ($1, $2, $3)"
+
+type CreateIssueTypeResult struct {
+}
+
+func (client PgxDBClient) queryCreateIssueTypeResult(ctx context.Context, tid pgtype.UUID, id pgtype.UUID, key string) (result []CreateIssueTypeResult, err error) {
+	err = client.eachCreateIssueTypeResult(ctx, tid, id, key, func(row CreateIssueTypeResult) error {
+		result = append(result, row)
+		return nil
+	})
+	return
+}
+
+// Calls fn with each row as it is scanned and stops at the first error.
+func (client PgxDBClient) eachCreateIssueTypeResult(ctx context.Context, tid pgtype.UUID, id pgtype.UUID, key string, fn func(CreateIssueTypeResult) error) (err error) {
+	rows, err := client.db.Query(ctx, createIssueTypeSQL, tid, id, key)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ()
+		err = rows.Scan()
+		if err != nil {
+			return
+		}
+
+		err = fn(CreateIssueTypeResult{})
+		if err != nil {
+			return
+		}
+	}
+
+	err = rows.Err()
+	return
+}
+
+func (client PgxDBClient) CreateIssueType(ctx context.Context, tid pgtype.UUID, id pgtype.UUID, key string) (r1 []CreateIssueTypeResult, err error) {
+	r1, err = client.queryCreateIssueTypeResult(ctx, tid, id, key)
+	if err != nil {
+		return
+	}
+	return
+}
+
+// CopyFromCreateIssueType inserts every row like CreateIssueType does, but in bulk.
+func (client PgxDBClient) CopyFromCreateIssueType(ctx context.Context, rows []CreateIssueTypeParams) (err error) {
+	copier, ok := client.db.(pgxCopier)
+	if ok {
+		_, err = copier.CopyFrom(
+			ctx,
+			pgx.Identifier{"issue_type"},
+			[]string{"tid", "id", "key"},
+			pgx.CopyFromSlice(len(rows), func(i int) ([]interface{}, error) {
+				row := rows[i]
+				return []interface{}{row.Tid, row.Id, row.Key}, nil
+			}),
+		)
+		return
+	}
+
+	// The rows go in one transaction so that a failure doesn't leave the chunks
+	// before it behind
+	tx, err := client.db.Begin(ctx)
+	if err != nil {
+		return
+	}
+	client.db = tx
+
+	for start := 0; start < len(rows); start += 1000 {
+		end := start + 1000
+		if end > len(rows) {
+			end = len(rows)
+		}
+
+		args := make([]interface{}, 0, (end-start)*3)
+		for _, row := range rows[start:end] {
+			args = append(args, row.Tid, row.Id, row.Key)
+		}
+
+		query := insertValuesSQL("INSERT INTO \"issue_type\" (\"tid\", \"id\", \"key\") VALUES ", 3, end-start)
+		var inserted pgx.Rows
+		inserted, err = client.db.Query(ctx, query, args...)
+		if err == nil {
+			inserted.Close()
+			err = inserted.Err()
+		}
+		if err != nil {
+			tx.Rollback(ctx)
+			return
+		}
+	}
+	err = tx.Commit(ctx)
+	return
+}
+
+/******************************************************************************
+ * create_project
+ *****************************************************************************/
+
+const createProjectSQL = "INSERT INTO projects\n  (tid, \"key\", \"name\", created)\nVALUES\n  ($1, $2, $3, CURRENT_TIMESTAMP)"
+
+type CreateProjectResult struct {
+}
+
+func (client PgxDBClient) queryCreateProjectResult(ctx context.Context, tid pgtype.UUID, key string, name string) (result []CreateProjectResult, err error) {
+	err = client.eachCreateProjectResult(ctx, tid, key, name, func(row CreateProjectResult) error {
+		result = append(result, row)
+		return nil
+	})
+	return
+}
+
+// Calls fn with each row as it is scanned and stops at the first error.
+func (client PgxDBClient) eachCreateProjectResult(ctx context.Context, tid pgtype.UUID, key string, name string, fn func(CreateProjectResult) error) (err error) {
+	rows, err := client.db.Query(ctx, createProjectSQL, tid, key, name)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ()
+		err = rows.Scan()
+		if err != nil {
+			return
+		}
+
+		err = fn(CreateProjectResult{})
+		if err != nil {
+			return
+		}
+	}
+
+	err = rows.Err()
+	return
+}
+
+func (client PgxDBClient) CreateProject(ctx context.Context, tid pgtype.UUID, key string, name string) (r1 []CreateProjectResult, err error) {
+	r1, err = client.queryCreateProjectResult(ctx, tid, key, name)
+	if err != nil {
+		return
+	}
+	return
+}
+
+/******************************************************************************
+ * create_tenant
+ *****************************************************************************/
+
+const createTenantSQL = "INSERT INTO tenants \n  (id, \"key\", \"name\", created)\nVALUES\n  ($1, $2, $3, CURRENT_TIMESTAMP)"
+
+type CreateTenantResult struct {
+}
+
+func (client PgxDBClient) queryCreateTenantResult(ctx context.Context, id pgtype.UUID, key string, name string) (result []CreateTenantResult, err error) {
+	err = client.eachCreateTenantResult(ctx, id, key, name, func(row CreateTenantResult) error {
+		result = append(result, row)
+		return nil
+	})
+	return
+}
+
+// Calls fn with each row as it is scanned and stops at the first error.
+func (client PgxDBClient) eachCreateTenantResult(ctx context.Context, id pgtype.UUID, key string, name string, fn func(CreateTenantResult) error) (err error) {
+	rows, err := client.db.Query(ctx, createTenantSQL, id, key, name)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ()
+		err = rows.Scan()
+		if err != nil {
+			return
+		}
+
+		err = fn(CreateTenantResult{})
+		if err != nil {
+			return
+		}
+	}
+
+	err = rows.Err()
+	return
+}
+
+func (client PgxDBClient) CreateTenant(ctx context.Context, id pgtype.UUID, key string, name string) (r1 []CreateTenantResult, err error) {
+	r1, err = client.queryCreateTenantResult(ctx, id, key, name)
+	if err != nil {
+		return
+	}
+	return
+}
+
+/******************************************************************************
+ * get_issue
+ *****************************************************************************/
+
+const getIssueSQL1 = "SELECT\n  i.id,\n  i.name,\n  i.fields,\n  i.created,\n  i.modified,\n  p.name AS project_name\nFROM issues i\nJOIN projects p ON p.tid = i.tid AND p.\"key\" = i.project_key\nWHERE i.tid = $1 AND i.id = $2\nLIMIT 1"
+
+type GetIssueResult1 struct {
+	Id          string
+	Name        string
+	Fields      pgtype.JSONB
+	Created     pgtype.Timestamptz
+	Modified    pgtype.Timestamptz
+	ProjectName string
+}
+
+func (client PgxDBClient) queryGetIssueResult1(ctx context.Context, tid pgtype.UUID, id string) (result []GetIssueResult1, err error) {
+	err = client.eachGetIssueResult1(ctx, tid, id, func(row GetIssueResult1) error {
+		result = append(result, row)
+		return nil
+	})
+	return
+}
+
+// Calls fn with each row as it is scanned and stops at the first error.
+func (client PgxDBClient) eachGetIssueResult1(ctx context.Context, tid pgtype.UUID, id string, fn func(GetIssueResult1) error) (err error) {
+	rows, err := client.db.Query(ctx, getIssueSQL1, tid, id)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id          string
+			name        string
+			fields      pgtype.JSONB
+			created     pgtype.Timestamptz
+			modified    pgtype.Timestamptz
+			projectName string
+		)
+		err = rows.Scan(&id, &name, &fields, &created, &modified, &projectName)
+		if err != nil {
+			return
+		}
+
+		err = fn(GetIssueResult1{
+			Id:          id,
+			Name:        name,
+			Fields:      fields,
+			Created:     created,
+			Modified:    modified,
+			ProjectName: projectName,
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	err = rows.Err()
+	return
+}
+
+const getIssueSQL2 = "SELECT\n  tag_key,\n  created\nFROM issue_tags\nWHERE tid = $1 AND issue_id = $2"
+
+type GetIssueResult2 struct {
+	TagKey  string
+	Created pgtype.Timestamptz
+}
+
+func (client PgxDBClient) queryGetIssueResult2(ctx context.Context, tid pgtype.UUID, id string) (result []GetIssueResult2, err error) {
+	err = client.eachGetIssueResult2(ctx, tid, id, func(row GetIssueResult2) error {
+		result = append(result, row)
+		return nil
+	})
+	return
+}
+
+// Calls fn with each row as it is scanned and stops at the first error.
+func (client PgxDBClient) eachGetIssueResult2(ctx context.Context, tid pgtype.UUID, id string, fn func(GetIssueResult2) error) (err error) {
+	rows, err := client.db.Query(ctx, getIssueSQL2, tid, id)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			tagKey  string
+			created pgtype.Timestamptz
+		)
+		err = rows.Scan(&tagKey, &created)
+		if err != nil {
+			return
+		}
+
+		err = fn(GetIssueResult2{
+			TagKey:  tagKey,
+			Created: created,
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	err = rows.Err()
+	return
+}
+
+func (client PgxDBClient) GetIssue(ctx context.Context, tid pgtype.UUID, id string) (r1 []GetIssueResult1, r2 []GetIssueResult2, err error) {
+	// The statements run in one transaction, like they would if Postgres got
+	// them all at once
+	tx, err := client.db.Begin(ctx)
+	if err != nil {
+		return
+	}
+	client.db = tx
+	r1, err = client.queryGetIssueResult1(ctx, tid, id)
+	if err != nil {
+		tx.Rollback(ctx)
+		return
+	}
+	r2, err = client.queryGetIssueResult2(ctx, tid, id)
+	if err != nil {
+		tx.Rollback(ctx)
+		return
+	}
+	err = tx.Commit(ctx)
+	return
+}
+
+/******************************************************************************
+ * get_issues_by_label
+ *****************************************************************************/
+
+const getIssuesByLabelSQL = "SELECT id, \"name\", labels\nFROM issues\nWHERE tid = $1 AND id = ANY($2) AND $3 = ANY(labels)"
+
+type GetIssuesByLabelResult struct {
+	Id     string
+	Name   string
+	Labels []string
+}
+
+func (client PgxDBClient) queryGetIssuesByLabelResult(ctx context.Context, tid pgtype.UUID, ids []string, label string) (result []GetIssuesByLabelResult, err error) {
+	err = client.eachGetIssuesByLabelResult(ctx, tid, ids, label, func(row GetIssuesByLabelResult) error {
+		result = append(result, row)
+		return nil
+	})
+	return
+}
+
+// Calls fn with each row as it is scanned and stops at the first error.
+func (client PgxDBClient) eachGetIssuesByLabelResult(ctx context.Context, tid pgtype.UUID, ids []string, label string, fn func(GetIssuesByLabelResult) error) (err error) {
+	rows, err := client.db.Query(ctx, getIssuesByLabelSQL, tid, ids, label)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id     string
+			name   string
+			labels []string
+		)
+		err = rows.Scan(&id, &name, &labels)
+		if err != nil {
+			return
+		}
+
+		err = fn(GetIssuesByLabelResult{
+			Id:     id,
+			Name:   name,
+			Labels: labels,
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	err = rows.Err()
+	return
+}
+
+func (client PgxDBClient) GetIssuesByLabel(ctx context.Context, tid pgtype.UUID, ids []string, label string) (r1 []GetIssuesByLabelResult, err error) {
+	r1, err = client.queryGetIssuesByLabelResult(ctx, tid, ids, label)
+	if err != nil {
+		return
+	}
+	return
+}
+
+// ForEachGetIssuesByLabel calls fn with each row that GetIssuesByLabel would return as it
+// is read, without holding them all in memory. It stops at the first error
+// from fn and returns it.
+func (client PgxDBClient) ForEachGetIssuesByLabel(ctx context.Context, tid pgtype.UUID, ids []string, label string, fn func(GetIssuesByLabelResult) error) (err error) {
+	return client.eachGetIssuesByLabelResult(ctx, tid, ids, label, fn)
+}
+
+/******************************************************************************
+ * get_issues_by_status
+ *****************************************************************************/
+
+const getIssuesByStatusSQL = "SELECT id, \"name\", status\nFROM issues\nWHERE tid = $1 AND status = $2"
+
+type GetIssuesByStatusResult struct {
+	Id     string
+	Name   string
+	Status *IssueStatus
+}
+
+func (client PgxDBClient) queryGetIssuesByStatusResult(ctx context.Context, tid pgtype.UUID, status IssueStatus) (result []GetIssuesByStatusResult, err error) {
+	err = client.eachGetIssuesByStatusResult(ctx, tid, status, func(row GetIssuesByStatusResult) error {
+		result = append(result, row)
+		return nil
+	})
+	return
+}
+
+// Calls fn with each row as it is scanned and stops at the first error.
+func (client PgxDBClient) eachGetIssuesByStatusResult(ctx context.Context, tid pgtype.UUID, status IssueStatus, fn func(GetIssuesByStatusResult) error) (err error) {
+	rows, err := client.db.Query(ctx, getIssuesByStatusSQL, tid, status)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id     string
+			name   string
+			status *IssueStatus
+		)
+		err = rows.Scan(&id, &name, &status)
+		if err != nil {
+			return
+		}
+
+		err = fn(GetIssuesByStatusResult{
+			Id:     id,
+			Name:   name,
+			Status: status,
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	err = rows.Err()
+	return
+}
+
+func (client PgxDBClient) GetIssuesByStatus(ctx context.Context, tid pgtype.UUID, status IssueStatus) (r1 []GetIssuesByStatusResult, err error) {
+	r1, err = client.queryGetIssuesByStatusResult(ctx, tid, status)
+	if err != nil {
+		return
+	}
+	return
+}
+
+// ForEachGetIssuesByStatus calls fn with each row that GetIssuesByStatus would return as it
+// is read, without holding them all in memory. It stops at the first error
+// from fn and returns it.
+func (client PgxDBClient) ForEachGetIssuesByStatus(ctx context.Context, tid pgtype.UUID, status IssueStatus, fn func(GetIssuesByStatusResult) error) (err error) {
+	return client.eachGetIssuesByStatusResult(ctx, tid, status, fn)
+}
+
+/******************************************************************************
+ * get_open_issues
+ *****************************************************************************/
+
+const getOpenIssuesSQL = "SELECT id, \"name\", priority\nFROM open_issues\nWHERE tid = $1 AND project_key = $2"
+
+type GetOpenIssuesResult struct {
+	Id       string
+	Name     string
+	Priority int32
+}
+
+func (client PgxDBClient) queryGetOpenIssuesResult(ctx context.Context, tid pgtype.UUID, project_key string) (result []GetOpenIssuesResult, err error) {
+	err = client.eachGetOpenIssuesResult(ctx, tid, project_key, func(row GetOpenIssuesResult) error {
+		result = append(result, row)
+		return nil
+	})
+	return
+}
+
+// Calls fn with each row as it is scanned and stops at the first error.
+func (client PgxDBClient) eachGetOpenIssuesResult(ctx context.Context, tid pgtype.UUID, project_key string, fn func(GetOpenIssuesResult) error) (err error) {
+	rows, err := client.db.Query(ctx, getOpenIssuesSQL, tid, project_key)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id       string
+			name     string
+			priority int32
+		)
+		err = rows.Scan(&id, &name, &priority)
+		if err != nil {
+			return
+		}
+
+		err = fn(GetOpenIssuesResult{
+			Id:       id,
+			Name:     name,
+			Priority: priority,
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	err = rows.Err()
+	return
+}
+
+func (client PgxDBClient) GetOpenIssues(ctx context.Context, tid pgtype.UUID, project_key string) (r1 []GetOpenIssuesResult, err error) {
+	r1, err = client.queryGetOpenIssuesResult(ctx, tid, project_key)
+	if err != nil {
+		return
+	}
+	return
+}
+
+// ForEachGetOpenIssues calls fn with each row that GetOpenIssues would return as it
+// is read, without holding them all in memory. It stops at the first error
+// from fn and returns it.
+func (client PgxDBClient) ForEachGetOpenIssues(ctx context.Context, tid pgtype.UUID, project_key string, fn func(GetOpenIssuesResult) error) (err error) {
+	return client.eachGetOpenIssuesResult(ctx, tid, project_key, fn)
+}
+
+/******************************************************************************
+ * get_projects
+ *****************************************************************************/
+
+const getProjectsSQL = "SELECT \"key\", \"name\", created, modified FROM projects WHERE tid = $1"
+
+type GetProjectsResult struct {
+	Key      string
+	Name     string
+	Created  pgtype.Timestamptz
+	Modified pgtype.Timestamptz
+}
+
+func (client PgxDBClient) queryGetProjectsResult(ctx context.Context, tid pgtype.UUID) (result []GetProjectsResult, err error) {
+	err = client.eachGetProjectsResult(ctx, tid, func(row GetProjectsResult) error {
+		result = append(result, row)
+		return nil
+	})
+	return
+}
+
+// Calls fn with each row as it is scanned and stops at the first error.
+func (client PgxDBClient) eachGetProjectsResult(ctx context.Context, tid pgtype.UUID, fn func(GetProjectsResult) error) (err error) {
+	rows, err := client.db.Query(ctx, getProjectsSQL, tid)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			key      string
+			name     string
+			created  pgtype.Timestamptz
+			modified pgtype.Timestamptz
+		)
+		err = rows.Scan(&key, &name, &created, &modified)
+		if err != nil {
+			return
+		}
+
+		err = fn(GetProjectsResult{
+			Key:      key,
+			Name:     name,
+			Created:  created,
+			Modified: modified,
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	err = rows.Err()
+	return
+}
+
+func (client PgxDBClient) GetProjects(ctx context.Context, tid pgtype.UUID) (r1 []GetProjectsResult, err error) {
+	r1, err = client.queryGetProjectsResult(ctx, tid)
+	if err != nil {
+		return
+	}
+	return
+}
+
+// ForEachGetProjects calls fn with each row that GetProjects would return as it
+// is read, without holding them all in memory. It stops at the first error
+// from fn and returns it.
+func (client PgxDBClient) ForEachGetProjects(ctx context.Context, tid pgtype.UUID, fn func(GetProjectsResult) error) (err error) {
+	return client.eachGetProjectsResult(ctx, tid, fn)
+}
+
+/******************************************************************************
+ * get_tags
+ *****************************************************************************/
+
+const getTagsSQL = "SELECT \"key\", created FROM tags WHERE tid = $1"
+
+type GetTagsResult struct {
+	Key     string
+	Created pgtype.Timestamptz
+}
+
+func (client PgxDBClient) queryGetTagsResult(ctx context.Context, tid pgtype.UUID) (result []GetTagsResult, err error) {
+	err = client.eachGetTagsResult(ctx, tid, func(row GetTagsResult) error {
+		result = append(result, row)
+		return nil
+	})
+	return
+}
+
+// Calls fn with each row as it is scanned and stops at the first error.
+func (client PgxDBClient) eachGetTagsResult(ctx context.Context, tid pgtype.UUID, fn func(GetTagsResult) error) (err error) {
+	rows, err := client.db.Query(ctx, getTagsSQL, tid)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			key     string
+			created pgtype.Timestamptz
+		)
+		err = rows.Scan(&key, &created)
+		if err != nil {
+			return
+		}
+
+		err = fn(GetTagsResult{
+			Key:     key,
+			Created: created,
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	err = rows.Err()
+	return
+}
+
+func (client PgxDBClient) GetTags(ctx context.Context, tid pgtype.UUID) (r1 []GetTagsResult, err error) {
+	r1, err = client.queryGetTagsResult(ctx, tid)
+	if err != nil {
+		return
+	}
+	return
+}
+
+// ForEachGetTags calls fn with each row that GetTags would return as it
+// is read, without holding them all in memory. It stops at the first error
+// from fn and returns it.
+func (client PgxDBClient) ForEachGetTags(ctx context.Context, tid pgtype.UUID, fn func(GetTagsResult) error) (err error) {
+	return client.eachGetTagsResult(ctx, tid, fn)
+}
+
+/******************************************************************************
+ * import_issue
+ *****************************************************************************/
+
+// ImportIssueParams holds the parameters of DBClient.ImportIssue.
+type ImportIssueParams struct {
+	// Tid is passed as $tid and has the type of the column tid.
+	Tid pgtype.UUID
+	// Id is passed as $id and has the type of the column id.
+	Id string
+	// Name is passed as $name and has the type of the column name.
+	Name string
+	// ProjectKey is passed as $project_key and has the type of the column project_key.
+	ProjectKey string
+	// Fields is passed as $fields and has the type of the column fields.
+	Fields pgtype.JSONB
+	// Created is passed as $created and has the type of the column created.
+	Created pgtype.Timestamptz
+}
+
+const importIssueSQL = "INSERT INTO issues\n  (tid, id, \"name\", project_key, fields, created)\nVALUES\n  ($1, $2, $3, $4, $5, $6)"
+
+type ImportIssueResult struct {
+}
+
+func (client PgxDBClient) queryImportIssueResult(ctx context.Context, tid pgtype.UUID, id string, name string, project_key string, fields pgtype.JSONB, created pgtype.Timestamptz) (result []ImportIssueResult, err error) {
+	err = client.eachImportIssueResult(ctx, tid, id, name, project_key, fields, created, func(row ImportIssueResult) error {
+		result = append(result, row)
+		return nil
+	})
+	return
+}
+
+// Calls fn with each row as it is scanned and stops at the first error.
+func (client PgxDBClient) eachImportIssueResult(ctx context.Context, tid pgtype.UUID, id string, name string, project_key string, fields pgtype.JSONB, created pgtype.Timestamptz, fn func(ImportIssueResult) error) (err error) {
+	rows, err := client.db.Query(ctx, importIssueSQL, tid, id, name, project_key, fields, created)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ()
+		err = rows.Scan()
+		if err != nil {
+			return
+		}
+
+		err = fn(ImportIssueResult{})
+		if err != nil {
+			return
+		}
+	}
+
+	err = rows.Err()
+	return
+}
+
+func (client PgxDBClient) ImportIssue(ctx context.Context, params ImportIssueParams) (r1 []ImportIssueResult, err error) {
+	tid := params.Tid
+	id := params.Id
+	name := params.Name
+	project_key := params.ProjectKey
+	fields := params.Fields
+	created := params.Created
+
+	r1, err = client.queryImportIssueResult(ctx, tid, id, name, project_key, fields, created)
+	if err != nil {
+		return
+	}
+	return
+}
+
+// CopyFromImportIssue inserts every row like ImportIssue does, but in bulk.
+func (client PgxDBClient) CopyFromImportIssue(ctx context.Context, rows []ImportIssueParams) (err error) {
+	copier, ok := client.db.(pgxCopier)
+	if ok {
+		_, err = copier.CopyFrom(
+			ctx,
+			pgx.Identifier{"issues"},
+			[]string{"tid", "id", "name", "project_key", "fields", "created"},
+			pgx.CopyFromSlice(len(rows), func(i int) ([]interface{}, error) {
+				row := rows[i]
+				return []interface{}{row.Tid, row.Id, row.Name, row.ProjectKey, row.Fields, row.Created}, nil
+			}),
+		)
+		return
+	}
+
+	// The rows go in one transaction so that a failure doesn't leave the chunks
+	// before it behind
+	tx, err := client.db.Begin(ctx)
+	if err != nil {
+		return
+	}
+	client.db = tx
+
+	for start := 0; start < len(rows); start += 1000 {
+		end := start + 1000
+		if end > len(rows) {
+			end = len(rows)
+		}
+
+		args := make([]interface{}, 0, (end-start)*6)
+		for _, row := range rows[start:end] {
+			args = append(args, row.Tid, row.Id, row.Name, row.ProjectKey, row.Fields, row.Created)
+		}
+
+		query := insertValuesSQL("INSERT INTO \"issues\" (\"tid\", \"id\", \"name\", \"project_key\", \"fields\", \"created\") VALUES ", 6, end-start)
+		var inserted pgx.Rows
+		inserted, err = client.db.Query(ctx, query, args...)
+		if err == nil {
+			inserted.Close()
+			err = inserted.Err()
+		}
+		if err != nil {
+			tx.Rollback(ctx)
+			return
+		}
+	}
+	err = tx.Commit(ctx)
+	return
+}
+
+/******************************************************************************
+ * ListIssues
+ *****************************************************************************/
+
+const listIssuesSQL = "SELECT id, \"name\", status, priority\nFROM issues\nWHERE tid = $1 AND (status = $2 OR $2 IS NULL) AND (priority >= $3 OR $3 IS NULL)"
+
+type ListIssuesResult struct {
+	Id       string
+	Name     string
+	Status   *IssueStatus
+	Priority int32
+}
+
+func (client PgxDBClient) queryListIssuesResult(ctx context.Context, tid pgtype.UUID, status *IssueStatus, min_priority *int32) (result []ListIssuesResult, err error) {
+	err = client.eachListIssuesResult(ctx, tid, status, min_priority, func(row ListIssuesResult) error {
+		result = append(result, row)
+		return nil
+	})
+	return
+}
+
+// Calls fn with each row as it is scanned and stops at the first error.
+func (client PgxDBClient) eachListIssuesResult(ctx context.Context, tid pgtype.UUID, status *IssueStatus, min_priority *int32, fn func(ListIssuesResult) error) (err error) {
+	rows, err := client.db.Query(ctx, listIssuesSQL, tid, status, min_priority)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id       string
+			name     string
+			status   *IssueStatus
+			priority int32
+		)
+		err = rows.Scan(&id, &name, &status, &priority)
+		if err != nil {
+			return
+		}
+
+		err = fn(ListIssuesResult{
+			Id:       id,
+			Name:     name,
+			Status:   status,
+			Priority: priority,
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	err = rows.Err()
+	return
+}
+
+func (client PgxDBClient) ListIssues(ctx context.Context, tid pgtype.UUID, status *IssueStatus, min_priority *int32) (r1 []ListIssuesResult, err error) {
+	r1, err = client.queryListIssuesResult(ctx, tid, status, min_priority)
+	if err != nil {
+		return
+	}
+	return
+}
+
+// ForEachListIssues calls fn with each row that ListIssues would return as it
+// is read, without holding them all in memory. It stops at the first error
+// from fn and returns it.
+func (client PgxDBClient) ForEachListIssues(ctx context.Context, tid pgtype.UUID, status *IssueStatus, min_priority *int32, fn func(ListIssuesResult) error) (err error) {
+	return client.eachListIssuesResult(ctx, tid, status, min_priority, fn)
+}
+
+/******************************************************************************
+ * tag_issue
+ *****************************************************************************/
+
+// TagIssueParams holds the parameters of DBClient.TagIssue.
+type TagIssueParams struct {
+	// Tid is passed as $tid and has the type of the column tid.
+	Tid pgtype.UUID
+	// IssueId is passed as $issue_id and has the type of the column issue_id.
+	IssueId string
+	// TagKey is passed as $tag_key and has the type of the column tag_key.
+	TagKey string
+	// Created is passed as $created and has the type of the column created.
+	Created pgtype.Timestamptz
+}
+
+const tagIssueSQL = "INSERT INTO issue_tags\n  (tid, issue_id, tag_key, created)\nVALUES\n  ($1, $2, $3, $4)"
+
+type TagIssueResult struct {
+}
+
+func (client PgxDBClient) queryTagIssueResult(ctx context.Context, tid pgtype.UUID, issue_id string, tag_key string, created pgtype.Timestamptz) (result []TagIssueResult, err error) {
+	err = client.eachTagIssueResult(ctx, tid, issue_id, tag_key, created, func(row TagIssueResult) error {
+		result = append(result, row)
+		return nil
+	})
+	return
+}
+
+// Calls fn with each row as it is scanned and stops at the first error.
+func (client PgxDBClient) eachTagIssueResult(ctx context.Context, tid pgtype.UUID, issue_id string, tag_key string, created pgtype.Timestamptz, fn func(TagIssueResult) error) (err error) {
+	rows, err := client.db.Query(ctx, tagIssueSQL, tid, issue_id, tag_key, created)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ()
+		err = rows.Scan()
+		if err != nil {
+			return
+		}
+
+		err = fn(TagIssueResult{})
+		if err != nil {
+			return
+		}
+	}
+
+	err = rows.Err()
+	return
+}
+
+func (client PgxDBClient) TagIssue(ctx context.Context, tid pgtype.UUID, issue_id string, tag_key string, created pgtype.Timestamptz) (r1 []TagIssueResult, err error) {
+	r1, err = client.queryTagIssueResult(ctx, tid, issue_id, tag_key, created)
+	if err != nil {
+		return
+	}
+	return
+}
+
+// CopyFromTagIssue inserts every row like TagIssue does, but in bulk.
+func (client PgxDBClient) CopyFromTagIssue(ctx context.Context, rows []TagIssueParams) (err error) {
+	copier, ok := client.db.(pgxCopier)
+	if ok {
+		_, err = copier.CopyFrom(
+			ctx,
+			pgx.Identifier{"issue_tags"},
+			[]string{"tid", "issue_id", "tag_key", "created"},
+			pgx.CopyFromSlice(len(rows), func(i int) ([]interface{}, error) {
+				row := rows[i]
+				return []interface{}{row.Tid, row.IssueId, row.TagKey, row.Created}, nil
+			}),
+		)
+		return
+	}
+
+	// The rows go in one transaction so that a failure doesn't leave the chunks
+	// before it behind
+	tx, err := client.db.Begin(ctx)
+	if err != nil {
+		return
+	}
+	client.db = tx
+
+	for start := 0; start < len(rows); start += 1000 {
+		end := start + 1000
+		if end > len(rows) {
+			end = len(rows)
+		}
+
+		args := make([]interface{}, 0, (end-start)*4)
+		for _, row := range rows[start:end] {
+			args = append(args, row.Tid, row.IssueId, row.TagKey, row.Created)
+		}
+
+		query := insertValuesSQL("INSERT INTO \"issue_tags\" (\"tid\", \"issue_id\", \"tag_key\", \"created\") VALUES ", 4, end-start)
+		var inserted pgx.Rows
+		inserted, err = client.db.Query(ctx, query, args...)
+		if err == nil {
+			inserted.Close()
+			err = inserted.Err()
+		}
+		if err != nil {
+			tx.Rollback(ctx)
+			return
+		}
+	}
+	err = tx.Commit(ctx)
+	return
+}
+
+/******************************************************************************
+ * CreateTag
+ *****************************************************************************/
+
+const createTagSQL = "INSERT INTO tags\n  (tid, \"key\", created)\nVALUES\n  ($1, $2, CURRENT_TIMESTAMP)"
+
+// Runs createTagSQL.
+func (client PgxDBClient) createTagExec(ctx context.Context, tid pgtype.UUID, key string) (err error) {
+	rows, err := client.db.Query(ctx, createTagSQL, tid, key)
+	if err != nil {
+		return
+	}
+	// The command tag is only complete once the rows are closed
+	rows.Close()
+	err = rows.Err()
+	return
+}
+
+func (client PgxDBClient) CreateTag(ctx context.Context, tid pgtype.UUID, key string) (err error) {
+	err = client.createTagExec(ctx, tid, key)
+	if err != nil {
+		return
+	}
+	return
+}
+
+/******************************************************************************
+ * GetTag
+ *****************************************************************************/
+
+const getTagSQL = "SELECT \"key\", created FROM tags WHERE tid = $1 AND \"key\" = $2"
+
+type GetTagResult struct {
+	Key     string
+	Created pgtype.Timestamptz
+}
+
+// Returns the first row, or nil when there are none.
+func (client PgxDBClient) queryOneGetTagResult(ctx context.Context, tid pgtype.UUID, key string) (result *GetTagResult, err error) {
+	err = client.eachGetTagResult(ctx, tid, key, func(row GetTagResult) error {
+		if result == nil {
+			result = &row
+		}
+		return nil
+	})
+	return
+}
+
+// Calls fn with each row as it is scanned and stops at the first error.
+func (client PgxDBClient) eachGetTagResult(ctx context.Context, tid pgtype.UUID, key string, fn func(GetTagResult) error) (err error) {
+	rows, err := client.db.Query(ctx, getTagSQL, tid, key)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			key     string
+			created pgtype.Timestamptz
+		)
+		err = rows.Scan(&key, &created)
+		if err != nil {
+			return
+		}
+
+		err = fn(GetTagResult{
+			Key:     key,
+			Created: created,
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	err = rows.Err()
+	return
+}
+
+func (client PgxDBClient) GetTag(ctx context.Context, tid pgtype.UUID, key string) (r1 *GetTagResult, err error) {
+	r1, err = client.queryOneGetTagResult(ctx, tid, key)
+	if err != nil {
+		return
+	}
+	return
+}
+
+/******************************************************************************
+ * GetTagsCreatedWithin
+ *****************************************************************************/
+
+const getTagsCreatedWithinSQL = "SELECT \"key\", created FROM tags WHERE tid = $1 AND created >= now() - $2::interval"
+
+type GetTagsCreatedWithinResult struct {
+	Key     string
+	Created pgtype.Timestamptz
+}
+
+func (client PgxDBClient) queryGetTagsCreatedWithinResult(ctx context.Context, tid pgtype.UUID, age pgtype.Interval) (result []GetTagsCreatedWithinResult, err error) {
+	err = client.eachGetTagsCreatedWithinResult(ctx, tid, age, func(row GetTagsCreatedWithinResult) error {
+		result = append(result, row)
+		return nil
+	})
+	return
+}
+
+// Calls fn with each row as it is scanned and stops at the first error.
+func (client PgxDBClient) eachGetTagsCreatedWithinResult(ctx context.Context, tid pgtype.UUID, age pgtype.Interval, fn func(GetTagsCreatedWithinResult) error) (err error) {
+	rows, err := client.db.Query(ctx, getTagsCreatedWithinSQL, tid, age)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			key     string
+			created pgtype.Timestamptz
+		)
+		err = rows.Scan(&key, &created)
+		if err != nil {
+			return
+		}
+
+		err = fn(GetTagsCreatedWithinResult{
+			Key:     key,
+			Created: created,
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	err = rows.Err()
+	return
+}
+
+func (client PgxDBClient) GetTagsCreatedWithin(ctx context.Context, tid pgtype.UUID, age pgtype.Interval) (r1 []GetTagsCreatedWithinResult, err error) {
+	r1, err = client.queryGetTagsCreatedWithinResult(ctx, tid, age)
+	if err != nil {
+		return
+	}
+	return
+}
+
+// ForEachGetTagsCreatedWithin calls fn with each row that GetTagsCreatedWithin would return as it
+// is read, without holding them all in memory. It stops at the first error
+// from fn and returns it.
+func (client PgxDBClient) ForEachGetTagsCreatedWithin(ctx context.Context, tid pgtype.UUID, age pgtype.Interval, fn func(GetTagsCreatedWithinResult) error) (err error) {
+	return client.eachGetTagsCreatedWithinResult(ctx, tid, age, fn)
+}
+
+/******************************************************************************
+ * AddTag
+ *****************************************************************************/
+
+const addTagSQL = "INSERT INTO tags\n  (tid, \"key\", created)\nVALUES\n  ($1, $2, CURRENT_TIMESTAMP)"
+
+// Runs addTagSQL and returns the number of rows it affected.
+func (client PgxDBClient) addTagRowsAffected(ctx context.Context, tid pgtype.UUID, key string) (result int64, err error) {
+	rows, err := client.db.Query(ctx, addTagSQL, tid, key)
+	if err != nil {
+		return
+	}
+	// The command tag is only complete once the rows are closed
+	rows.Close()
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+	result = rows.CommandTag().RowsAffected()
+	return
+}
+
+func (client PgxDBClient) AddTag(ctx context.Context, tid pgtype.UUID, key string) (r1 int64, err error) {
+	r1, err = client.addTagRowsAffected(ctx, tid, key)
+	if err != nil {
+		return
+	}
+	return
+}
+
+// insertValuesSQL appends a row of positional parameters for each of rows to
+// an INSERT that ends with VALUES.
+func insertValuesSQL(insert string, columns int, rows int) string {
+	var sql strings.Builder
+	sql.WriteString(insert)
+	for i := 0; i < rows; i++ {
+		if i > 0 {
+			sql.WriteString(", ")
+		}
+		sql.WriteString("(")
+		for j := 0; j < columns; j++ {
+			if j > 0 {
+				sql.WriteString(", ")
+			}
+			sql.WriteString("$" + strconv.Itoa(i*columns+j+1))
+		}
+		sql.WriteString(")")
+	}
+	return sql.String()
+}
+
+/******************************************************************************
+ * Mock
+ *****************************************************************************/
+
+var _ DBClient = &MockDBClient{}
+
+// MockDBClient is an in-memory DBClient for unit tests. Set the Func field of
+// each method the code under test calls. Every call is recorded in the
+// matching Calls field whether or not its Func is set, and calling a method
+// without a Func returns an error.
+type MockDBClient struct {
+	CreateIssueFunc                  func(ctx context.Context, params CreateIssueParams) (r1 []CreateIssueResult, err error)
+	CreateIssueCalls                 []CreateIssueCall
+	CreateIssueTypeFunc              func(ctx context.Context, tid pgtype.UUID, id pgtype.UUID, key string) (r1 []CreateIssueTypeResult, err error)
+	CreateIssueTypeCalls             []CreateIssueTypeCall
+	CopyFromCreateIssueTypeFunc      func(ctx context.Context, rows []CreateIssueTypeParams) (err error)
+	CopyFromCreateIssueTypeCalls     []CopyFromCreateIssueTypeCall
+	CreateProjectFunc                func(ctx context.Context, tid pgtype.UUID, key string, name string) (r1 []CreateProjectResult, err error)
+	CreateProjectCalls               []CreateProjectCall
+	CreateTenantFunc                 func(ctx context.Context, id pgtype.UUID, key string, name string) (r1 []CreateTenantResult, err error)
+	CreateTenantCalls                []CreateTenantCall
+	GetIssueFunc                     func(ctx context.Context, tid pgtype.UUID, id string) (r1 []GetIssueResult1, r2 []GetIssueResult2, err error)
+	GetIssueCalls                    []GetIssueCall
+	GetIssuesByLabelFunc             func(ctx context.Context, tid pgtype.UUID, ids []string, label string) (r1 []GetIssuesByLabelResult, err error)
+	GetIssuesByLabelCalls            []GetIssuesByLabelCall
+	ForEachGetIssuesByLabelFunc      func(ctx context.Context, tid pgtype.UUID, ids []string, label string, fn func(GetIssuesByLabelResult) error) (err error)
+	ForEachGetIssuesByLabelCalls     []GetIssuesByLabelCall
+	GetIssuesByStatusFunc            func(ctx context.Context, tid pgtype.UUID, status IssueStatus) (r1 []GetIssuesByStatusResult, err error)
+	GetIssuesByStatusCalls           []GetIssuesByStatusCall
+	ForEachGetIssuesByStatusFunc     func(ctx context.Context, tid pgtype.UUID, status IssueStatus, fn func(GetIssuesByStatusResult) error) (err error)
+	ForEachGetIssuesByStatusCalls    []GetIssuesByStatusCall
+	GetOpenIssuesFunc                func(ctx context.Context, tid pgtype.UUID, project_key string) (r1 []GetOpenIssuesResult, err error)
+	GetOpenIssuesCalls               []GetOpenIssuesCall
+	ForEachGetOpenIssuesFunc         func(ctx context.Context, tid pgtype.UUID, project_key string, fn func(GetOpenIssuesResult) error) (err error)
+	ForEachGetOpenIssuesCalls        []GetOpenIssuesCall
+	GetProjectsFunc                  func(ctx context.Context, tid pgtype.UUID) (r1 []GetProjectsResult, err error)
+	GetProjectsCalls                 []GetProjectsCall
+	ForEachGetProjectsFunc           func(ctx context.Context, tid pgtype.UUID, fn func(GetProjectsResult) error) (err error)
+	ForEachGetProjectsCalls          []GetProjectsCall
+	GetTagsFunc                      func(ctx context.Context, tid pgtype.UUID) (r1 []GetTagsResult, err error)
+	GetTagsCalls                     []GetTagsCall
+	ForEachGetTagsFunc               func(ctx context.Context, tid pgtype.UUID, fn func(GetTagsResult) error) (err error)
+	ForEachGetTagsCalls              []GetTagsCall
+	ImportIssueFunc                  func(ctx context.Context, params ImportIssueParams) (r1 []ImportIssueResult, err error)
+	ImportIssueCalls                 []ImportIssueCall
+	CopyFromImportIssueFunc          func(ctx context.Context, rows []ImportIssueParams) (err error)
+	CopyFromImportIssueCalls         []CopyFromImportIssueCall
+	ListIssuesFunc                   func(ctx context.Context, tid pgtype.UUID, status *IssueStatus, min_priority *int32) (r1 []ListIssuesResult, err error)
+	ListIssuesCalls                  []ListIssuesCall
+	ForEachListIssuesFunc            func(ctx context.Context, tid pgtype.UUID, status *IssueStatus, min_priority *int32, fn func(ListIssuesResult) error) (err error)
+	ForEachListIssuesCalls           []ListIssuesCall
+	TagIssueFunc                     func(ctx context.Context, tid pgtype.UUID, issue_id string, tag_key string, created pgtype.Timestamptz) (r1 []TagIssueResult, err error)
+	TagIssueCalls                    []TagIssueCall
+	CopyFromTagIssueFunc             func(ctx context.Context, rows []TagIssueParams) (err error)
+	CopyFromTagIssueCalls            []CopyFromTagIssueCall
+	CreateTagFunc                    func(ctx context.Context, tid pgtype.UUID, key string) (err error)
+	CreateTagCalls                   []CreateTagCall
+	GetTagFunc                       func(ctx context.Context, tid pgtype.UUID, key string) (r1 *GetTagResult, err error)
+	GetTagCalls                      []GetTagCall
+	GetTagsCreatedWithinFunc         func(ctx context.Context, tid pgtype.UUID, age pgtype.Interval) (r1 []GetTagsCreatedWithinResult, err error)
+	GetTagsCreatedWithinCalls        []GetTagsCreatedWithinCall
+	ForEachGetTagsCreatedWithinFunc  func(ctx context.Context, tid pgtype.UUID, age pgtype.Interval, fn func(GetTagsCreatedWithinResult) error) (err error)
+	ForEachGetTagsCreatedWithinCalls []GetTagsCreatedWithinCall
+	AddTagFunc                       func(ctx context.Context, tid pgtype.UUID, key string) (r1 int64, err error)
+	AddTagCalls                      []AddTagCall
+
+	CloseCalls int
+
+	mu sync.Mutex
+}
+
+// CreateIssueCall holds the arguments of one call to MockDBClient.CreateIssue.
+type CreateIssueCall struct {
+	Tid        pgtype.UUID
+	Id         string
+	Name       string
+	ProjectKey string
+	Fields     pgtype.JSONB
+}
+
+func (mock *MockDBClient) CreateIssue(ctx context.Context, params CreateIssueParams) (r1 []CreateIssueResult, err error) {
+	mock.mu.Lock()
+	mock.CreateIssueCalls = append(mock.CreateIssueCalls, CreateIssueCall{
+		Tid:        params.Tid,
+		Id:         params.Id,
+		Name:       params.Name,
+		ProjectKey: params.ProjectKey,
+		Fields:     params.Fields,
+	})
+	mock.mu.Unlock()
+
+	if mock.CreateIssueFunc == nil {
+		err = errors.New("MockDBClient.CreateIssueFunc is not configured")
+		return
+	}
+	return mock.CreateIssueFunc(ctx, params)
+}
+
+// CreateIssueTypeCall holds the arguments of one call to MockDBClient.CreateIssueType.
+type CreateIssueTypeCall struct {
+	Tid pgtype.UUID
+	Id  pgtype.UUID
+	Key string
+}
+
+func (mock *MockDBClient) CreateIssueType(ctx context.Context, tid pgtype.UUID, id pgtype.UUID, key string) (r1 []CreateIssueTypeResult, err error) {
+	mock.mu.Lock()
+	mock.CreateIssueTypeCalls = append(mock.CreateIssueTypeCalls, CreateIssueTypeCall{
+		Tid: tid,
+		Id:  id,
+		Key: key,
+	})
+	mock.mu.Unlock()
+
+	if mock.CreateIssueTypeFunc == nil {
+		err = errors.New("MockDBClient.CreateIssueTypeFunc is not configured")
+		return
+	}
+	return mock.CreateIssueTypeFunc(ctx, tid, id, key)
+}
+
+// CopyFromCreateIssueTypeCall holds the arguments of one call to MockDBClient.CopyFromCreateIssueType.
+type CopyFromCreateIssueTypeCall struct {
+	Rows []CreateIssueTypeParams
+}
+
+func (mock *MockDBClient) CopyFromCreateIssueType(ctx context.Context, rows []CreateIssueTypeParams) (err error) {
+	mock.mu.Lock()
+	mock.CopyFromCreateIssueTypeCalls = append(mock.CopyFromCreateIssueTypeCalls, CopyFromCreateIssueTypeCall{
+		Rows: rows,
+	})
+	mock.mu.Unlock()
+
+	if mock.CopyFromCreateIssueTypeFunc == nil {
+		err = errors.New("MockDBClient.CopyFromCreateIssueTypeFunc is not configured")
+		return
+	}
+	return mock.CopyFromCreateIssueTypeFunc(ctx, rows)
+}
+
+// CreateProjectCall holds the arguments of one call to MockDBClient.CreateProject.
+type CreateProjectCall struct {
+	Tid  pgtype.UUID
+	Key  string
+	Name string
+}
+
+func (mock *MockDBClient) CreateProject(ctx context.Context, tid pgtype.UUID, key string, name string) (r1 []CreateProjectResult, err error) {
+	mock.mu.Lock()
+	mock.CreateProjectCalls = append(mock.CreateProjectCalls, CreateProjectCall{
+		Tid:  tid,
+		Key:  key,
+		Name: name,
+	})
+	mock.mu.Unlock()
+
+	if mock.CreateProjectFunc == nil {
+		err = errors.New("MockDBClient.CreateProjectFunc is not configured")
+		return
+	}
+	return mock.CreateProjectFunc(ctx, tid, key, name)
+}
+
+// CreateTenantCall holds the arguments of one call to MockDBClient.CreateTenant.
+type CreateTenantCall struct {
+	Id   pgtype.UUID
+	Key  string
+	Name string
+}
+
+func (mock *MockDBClient) CreateTenant(ctx context.Context, id pgtype.UUID, key string, name string) (r1 []CreateTenantResult, err error) {
+	mock.mu.Lock()
+	mock.CreateTenantCalls = append(mock.CreateTenantCalls, CreateTenantCall{
+		Id:   id,
+		Key:  key,
+		Name: name,
+	})
+	mock.mu.Unlock()
+
+	if mock.CreateTenantFunc == nil {
+		err = errors.New("MockDBClient.CreateTenantFunc is not configured")
+		return
+	}
+	return mock.CreateTenantFunc(ctx, id, key, name)
+}
+
+// GetIssueCall holds the arguments of one call to MockDBClient.GetIssue.
+type GetIssueCall struct {
+	Tid pgtype.UUID
+	Id  string
+}
+
+func (mock *MockDBClient) GetIssue(ctx context.Context, tid pgtype.UUID, id string) (r1 []GetIssueResult1, r2 []GetIssueResult2, err error) {
+	mock.mu.Lock()
+	mock.GetIssueCalls = append(mock.GetIssueCalls, GetIssueCall{
+		Tid: tid,
+		Id:  id,
+	})
+	mock.mu.Unlock()
+
+	if mock.GetIssueFunc == nil {
+		err = errors.New("MockDBClient.GetIssueFunc is not configured")
+		return
+	}
+	return mock.GetIssueFunc(ctx, tid, id)
+}
+
+// GetIssuesByLabelCall holds the arguments of one call to MockDBClient.GetIssuesByLabel.
+type GetIssuesByLabelCall struct {
+	Tid   pgtype.UUID
+	Ids   []string
+	Label string
+}
+
+func (mock *MockDBClient) GetIssuesByLabel(ctx context.Context, tid pgtype.UUID, ids []string, label string) (r1 []GetIssuesByLabelResult, err error) {
+	mock.mu.Lock()
+	mock.GetIssuesByLabelCalls = append(mock.GetIssuesByLabelCalls, GetIssuesByLabelCall{
+		Tid:   tid,
+		Ids:   ids,
+		Label: label,
+	})
+	mock.mu.Unlock()
+
+	if mock.GetIssuesByLabelFunc == nil {
+		err = errors.New("MockDBClient.GetIssuesByLabelFunc is not configured")
+		return
+	}
+	return mock.GetIssuesByLabelFunc(ctx, tid, ids, label)
+}
+
+func (mock *MockDBClient) ForEachGetIssuesByLabel(ctx context.Context, tid pgtype.UUID, ids []string, label string, fn func(GetIssuesByLabelResult) error) (err error) {
+	mock.mu.Lock()
+	mock.ForEachGetIssuesByLabelCalls = append(mock.ForEachGetIssuesByLabelCalls, GetIssuesByLabelCall{
+		Tid:   tid,
+		Ids:   ids,
+		Label: label,
+	})
+	mock.mu.Unlock()
+
+	if mock.ForEachGetIssuesByLabelFunc == nil {
+		err = errors.New("MockDBClient.ForEachGetIssuesByLabelFunc is not configured")
+		return
+	}
+	return mock.ForEachGetIssuesByLabelFunc(ctx, tid, ids, label, fn)
+}
+
+// GetIssuesByStatusCall holds the arguments of one call to MockDBClient.GetIssuesByStatus.
+type GetIssuesByStatusCall struct {
+	Tid    pgtype.UUID
+	Status IssueStatus
+}
+
+func (mock *MockDBClient) GetIssuesByStatus(ctx context.Context, tid pgtype.UUID, status IssueStatus) (r1 []GetIssuesByStatusResult, err error) {
+	mock.mu.Lock()
+	mock.GetIssuesByStatusCalls = append(mock.GetIssuesByStatusCalls, GetIssuesByStatusCall{
+		Tid:    tid,
+		Status: status,
+	})
+	mock.mu.Unlock()
+
+	if mock.GetIssuesByStatusFunc == nil {
+		err = errors.New("MockDBClient.GetIssuesByStatusFunc is not configured")
+		return
+	}
+	return mock.GetIssuesByStatusFunc(ctx, tid, status)
+}
+
+func (mock *MockDBClient) ForEachGetIssuesByStatus(ctx context.Context, tid pgtype.UUID, status IssueStatus, fn func(GetIssuesByStatusResult) error) (err error) {
+	mock.mu.Lock()
+	mock.ForEachGetIssuesByStatusCalls = append(mock.ForEachGetIssuesByStatusCalls, GetIssuesByStatusCall{
+		Tid:    tid,
+		Status: status,
+	})
+	mock.mu.Unlock()
+
+	if mock.ForEachGetIssuesByStatusFunc == nil {
+		err = errors.New("MockDBClient.ForEachGetIssuesByStatusFunc is not configured")
+		return
+	}
+	return mock.ForEachGetIssuesByStatusFunc(ctx, tid, status, fn)
+}
+
+// GetOpenIssuesCall holds the arguments of one call to MockDBClient.GetOpenIssues.
+type GetOpenIssuesCall struct {
+	Tid        pgtype.UUID
+	ProjectKey string
+}
+
+func (mock *MockDBClient) GetOpenIssues(ctx context.Context, tid pgtype.UUID, project_key string) (r1 []GetOpenIssuesResult, err error) {
+	mock.mu.Lock()
+	mock.GetOpenIssuesCalls = append(mock.GetOpenIssuesCalls, GetOpenIssuesCall{
+		Tid:        tid,
+		ProjectKey: project_key,
+	})
+	mock.mu.Unlock()
+
+	if mock.GetOpenIssuesFunc == nil {
+		err = errors.New("MockDBClient.GetOpenIssuesFunc is not configured")
+		return
+	}
+	return mock.GetOpenIssuesFunc(ctx, tid, project_key)
+}
+
+func (mock *MockDBClient) ForEachGetOpenIssues(ctx context.Context, tid pgtype.UUID, project_key string, fn func(GetOpenIssuesResult) error) (err error) {
+	mock.mu.Lock()
+	mock.ForEachGetOpenIssuesCalls = append(mock.ForEachGetOpenIssuesCalls, GetOpenIssuesCall{
+		Tid:        tid,
+		ProjectKey: project_key,
+	})
+	mock.mu.Unlock()
+
+	if mock.ForEachGetOpenIssuesFunc == nil {
+		err = errors.New("MockDBClient.ForEachGetOpenIssuesFunc is not configured")
+		return
+	}
+	return mock.ForEachGetOpenIssuesFunc(ctx, tid, project_key, fn)
+}
+
+// GetProjectsCall holds the arguments of one call to MockDBClient.GetProjects.
+type GetProjectsCall struct {
+	Tid pgtype.UUID
+}
+
+func (mock *MockDBClient) GetProjects(ctx context.Context, tid pgtype.UUID) (r1 []GetProjectsResult, err error) {
+	mock.mu.Lock()
+	mock.GetProjectsCalls = append(mock.GetProjectsCalls, GetProjectsCall{
+		Tid: tid,
+	})
+	mock.mu.Unlock()
+
+	if mock.GetProjectsFunc == nil {
+		err = errors.New("MockDBClient.GetProjectsFunc is not configured")
+		return
+	}
+	return mock.GetProjectsFunc(ctx, tid)
+}
+
+func (mock *MockDBClient) ForEachGetProjects(ctx context.Context, tid pgtype.UUID, fn func(GetProjectsResult) error) (err error) {
+	mock.mu.Lock()
+	mock.ForEachGetProjectsCalls = append(mock.ForEachGetProjectsCalls, GetProjectsCall{
+		Tid: tid,
+	})
+	mock.mu.Unlock()
+
+	if mock.ForEachGetProjectsFunc == nil {
+		err = errors.New("MockDBClient.ForEachGetProjectsFunc is not configured")
+		return
+	}
+	return mock.ForEachGetProjectsFunc(ctx, tid, fn)
+}
+
+// GetTagsCall holds the arguments of one call to MockDBClient.GetTags.
+type GetTagsCall struct {
+	Tid pgtype.UUID
+}
+
+func (mock *MockDBClient) GetTags(ctx context.Context, tid pgtype.UUID) (r1 []GetTagsResult, err error) {
+	mock.mu.Lock()
+	mock.GetTagsCalls = append(mock.GetTagsCalls, GetTagsCall{
+		Tid: tid,
+	})
+	mock.mu.Unlock()
+
+	if mock.GetTagsFunc == nil {
+		err = errors.New("MockDBClient.GetTagsFunc is not configured")
+		return
+	}
+	return mock.GetTagsFunc(ctx, tid)
+}
+
+func (mock *MockDBClient) ForEachGetTags(ctx context.Context, tid pgtype.UUID, fn func(GetTagsResult) error) (err error) {
+	mock.mu.Lock()
+	mock.ForEachGetTagsCalls = append(mock.ForEachGetTagsCalls, GetTagsCall{
+		Tid: tid,
+	})
+	mock.mu.Unlock()
+
+	if mock.ForEachGetTagsFunc == nil {
+		err = errors.New("MockDBClient.ForEachGetTagsFunc is not configured")
+		return
+	}
+	return mock.ForEachGetTagsFunc(ctx, tid, fn)
+}
+
+// ImportIssueCall holds the arguments of one call to MockDBClient.ImportIssue.
+type ImportIssueCall struct {
+	Tid        pgtype.UUID
+	Id         string
+	Name       string
+	ProjectKey string
+	Fields     pgtype.JSONB
+	Created    pgtype.Timestamptz
+}
+
+func (mock *MockDBClient) ImportIssue(ctx context.Context, params ImportIssueParams) (r1 []ImportIssueResult, err error) {
+	mock.mu.Lock()
+	mock.ImportIssueCalls = append(mock.ImportIssueCalls, ImportIssueCall{
+		Tid:        params.Tid,
+		Id:         params.Id,
+		Name:       params.Name,
+		ProjectKey: params.ProjectKey,
+		Fields:     params.Fields,
+		Created:    params.Created,
+	})
+	mock.mu.Unlock()
+
+	if mock.ImportIssueFunc == nil {
+		err = errors.New("MockDBClient.ImportIssueFunc is not configured")
+		return
+	}
+	return mock.ImportIssueFunc(ctx, params)
+}
+
+// CopyFromImportIssueCall holds the arguments of one call to MockDBClient.CopyFromImportIssue.
+type CopyFromImportIssueCall struct {
+	Rows []ImportIssueParams
+}
+
+func (mock *MockDBClient) CopyFromImportIssue(ctx context.Context, rows []ImportIssueParams) (err error) {
+	mock.mu.Lock()
+	mock.CopyFromImportIssueCalls = append(mock.CopyFromImportIssueCalls, CopyFromImportIssueCall{
+		Rows: rows,
+	})
+	mock.mu.Unlock()
+
+	if mock.CopyFromImportIssueFunc == nil {
+		err = errors.New("MockDBClient.CopyFromImportIssueFunc is not configured")
+		return
+	}
+	return mock.CopyFromImportIssueFunc(ctx, rows)
+}
+
+// ListIssuesCall holds the arguments of one call to MockDBClient.ListIssues.
+type ListIssuesCall struct {
+	Tid         pgtype.UUID
+	Status      *IssueStatus
+	MinPriority *int32
+}
+
+func (mock *MockDBClient) ListIssues(ctx context.Context, tid pgtype.UUID, status *IssueStatus, min_priority *int32) (r1 []ListIssuesResult, err error) {
+	mock.mu.Lock()
+	mock.ListIssuesCalls = append(mock.ListIssuesCalls, ListIssuesCall{
+		Tid:         tid,
+		Status:      status,
+		MinPriority: min_priority,
+	})
+	mock.mu.Unlock()
+
+	if mock.ListIssuesFunc == nil {
+		err = errors.New("MockDBClient.ListIssuesFunc is not configured")
+		return
+	}
+	return mock.ListIssuesFunc(ctx, tid, status, min_priority)
+}
+
+func (mock *MockDBClient) ForEachListIssues(ctx context.Context, tid pgtype.UUID, status *IssueStatus, min_priority *int32, fn func(ListIssuesResult) error) (err error) {
+	mock.mu.Lock()
+	mock.ForEachListIssuesCalls = append(mock.ForEachListIssuesCalls, ListIssuesCall{
+		Tid:         tid,
+		Status:      status,
+		MinPriority: min_priority,
+	})
+	mock.mu.Unlock()
+
+	if mock.ForEachListIssuesFunc == nil {
+		err = errors.New("MockDBClient.ForEachListIssuesFunc is not configured")
+		return
+	}
+	return mock.ForEachListIssuesFunc(ctx, tid, status, min_priority, fn)
+}
+
+// TagIssueCall holds the arguments of one call to MockDBClient.TagIssue.
+type TagIssueCall struct {
+	Tid     pgtype.UUID
+	IssueId string
+	TagKey  string
+	Created pgtype.Timestamptz
+}
+
+func (mock *MockDBClient) TagIssue(ctx context.Context, tid pgtype.UUID, issue_id string, tag_key string, created pgtype.Timestamptz) (r1 []TagIssueResult, err error) {
+	mock.mu.Lock()
+	mock.TagIssueCalls = append(mock.TagIssueCalls, TagIssueCall{
+		Tid:     tid,
+		IssueId: issue_id,
+		TagKey:  tag_key,
+		Created: created,
+	})
+	mock.mu.Unlock()
+
+	if mock.TagIssueFunc == nil {
+		err = errors.New("MockDBClient.TagIssueFunc is not configured")
+		return
+	}
+	return mock.TagIssueFunc(ctx, tid, issue_id, tag_key, created)
+}
+
+// CopyFromTagIssueCall holds the arguments of one call to MockDBClient.CopyFromTagIssue.
+type CopyFromTagIssueCall struct {
+	Rows []TagIssueParams
+}
+
+func (mock *MockDBClient) CopyFromTagIssue(ctx context.Context, rows []TagIssueParams) (err error) {
+	mock.mu.Lock()
+	mock.CopyFromTagIssueCalls = append(mock.CopyFromTagIssueCalls, CopyFromTagIssueCall{
+		Rows: rows,
+	})
+	mock.mu.Unlock()
+
+	if mock.CopyFromTagIssueFunc == nil {
+		err = errors.New("MockDBClient.CopyFromTagIssueFunc is not configured")
+		return
+	}
+	return mock.CopyFromTagIssueFunc(ctx, rows)
+}
+
+// CreateTagCall holds the arguments of one call to MockDBClient.CreateTag.
+type CreateTagCall struct {
+	Tid pgtype.UUID
+	Key string
+}
+
+func (mock *MockDBClient) CreateTag(ctx context.Context, tid pgtype.UUID, key string) (err error) {
+	mock.mu.Lock()
+	mock.CreateTagCalls = append(mock.CreateTagCalls, CreateTagCall{
+		Tid: tid,
+		Key: key,
+	})
+	mock.mu.Unlock()
+
+	if mock.CreateTagFunc == nil {
+		err = errors.New("MockDBClient.CreateTagFunc is not configured")
+		return
+	}
+	return mock.CreateTagFunc(ctx, tid, key)
+}
+
+// GetTagCall holds the arguments of one call to MockDBClient.GetTag.
+type GetTagCall struct {
+	Tid pgtype.UUID
+	Key string
+}
+
+func (mock *MockDBClient) GetTag(ctx context.Context, tid pgtype.UUID, key string) (r1 *GetTagResult, err error) {
+	mock.mu.Lock()
+	mock.GetTagCalls = append(mock.GetTagCalls, GetTagCall{
+		Tid: tid,
+		Key: key,
+	})
+	mock.mu.Unlock()
+
+	if mock.GetTagFunc == nil {
+		err = errors.New("MockDBClient.GetTagFunc is not configured")
+		return
+	}
+	return mock.GetTagFunc(ctx, tid, key)
+}
+
+// GetTagsCreatedWithinCall holds the arguments of one call to MockDBClient.GetTagsCreatedWithin.
+type GetTagsCreatedWithinCall struct {
+	Tid pgtype.UUID
+	Age pgtype.Interval
+}
+
+func (mock *MockDBClient) GetTagsCreatedWithin(ctx context.Context, tid pgtype.UUID, age pgtype.Interval) (r1 []GetTagsCreatedWithinResult, err error) {
+	mock.mu.Lock()
+	mock.GetTagsCreatedWithinCalls = append(mock.GetTagsCreatedWithinCalls, GetTagsCreatedWithinCall{
+		Tid: tid,
+		Age: age,
+	})
+	mock.mu.Unlock()
+
+	if mock.GetTagsCreatedWithinFunc == nil {
+		err = errors.New("MockDBClient.GetTagsCreatedWithinFunc is not configured")
+		return
+	}
+	return mock.GetTagsCreatedWithinFunc(ctx, tid, age)
+}
+
+func (mock *MockDBClient) ForEachGetTagsCreatedWithin(ctx context.Context, tid pgtype.UUID, age pgtype.Interval, fn func(GetTagsCreatedWithinResult) error) (err error) {
+	mock.mu.Lock()
+	mock.ForEachGetTagsCreatedWithinCalls = append(mock.ForEachGetTagsCreatedWithinCalls, GetTagsCreatedWithinCall{
+		Tid: tid,
+		Age: age,
+	})
+	mock.mu.Unlock()
+
+	if mock.ForEachGetTagsCreatedWithinFunc == nil {
+		err = errors.New("MockDBClient.ForEachGetTagsCreatedWithinFunc is not configured")
+		return
+	}
+	return mock.ForEachGetTagsCreatedWithinFunc(ctx, tid, age, fn)
+}
+
+// AddTagCall holds the arguments of one call to MockDBClient.AddTag.
+type AddTagCall struct {
+	Tid pgtype.UUID
+	Key string
+}
+
+func (mock *MockDBClient) AddTag(ctx context.Context, tid pgtype.UUID, key string) (r1 int64, err error) {
+	mock.mu.Lock()
+	mock.AddTagCalls = append(mock.AddTagCalls, AddTagCall{
+		Tid: tid,
+		Key: key,
+	})
+	mock.mu.Unlock()
+
+	if mock.AddTagFunc == nil {
+		err = errors.New("MockDBClient.AddTagFunc is not configured")
+		return
+	}
+	return mock.AddTagFunc(ctx, tid, key)
+}
+
+func (mock *MockDBClient) Close() {
+	mock.mu.Lock()
+	mock.CloseCalls++
+	mock.mu.Unlock()
+}
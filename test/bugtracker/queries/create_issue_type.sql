@@ -1,4 +1,4 @@
-INSERT INTO issue_types
+INSERT INTO issue_type
   (tid, id, "key")
 VALUES
   ($tid, $id, $key);
\ No newline at end of file
@@ -0,0 +1,3 @@
+SELECT id, "name", labels
+FROM issues
+WHERE tid = $tid AND id = ANY($ids) AND $label = ANY(labels)
\ No newline at end of file
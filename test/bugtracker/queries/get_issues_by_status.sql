@@ -0,0 +1,3 @@
+SELECT id, "name", status
+FROM issues
+WHERE tid = $tid AND status = $status
\ No newline at end of file
@@ -0,0 +1,3 @@
+SELECT id, "name", priority
+FROM open_issues
+WHERE tid = $tid AND project_key = $project_key
\ No newline at end of file
@@ -0,0 +1,4 @@
+INSERT INTO issues
+  (tid, id, "name", project_key, fields, created)
+VALUES
+  ($tid, $id, $name, $project_key, $fields, $created);
\ No newline at end of file
@@ -0,0 +1,5 @@
+-- name: ListIssues :many
+-- Leaving out a filter by passing nil matches issues with any value.
+SELECT id, "name", status, priority
+FROM issues
+WHERE tid = $tid AND status = $status? AND priority >= $min_priority?;
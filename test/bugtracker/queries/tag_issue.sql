@@ -0,0 +1,4 @@
+INSERT INTO issue_tags
+  (tid, issue_id, tag_key, created)
+VALUES
+  ($tid, $issue_id, $tag_key, $created);
@@ -0,0 +1,19 @@
+-- name: CreateTag :exec
+INSERT INTO tags
+  (tid, "key", created)
+VALUES
+  ($tid, $key, CURRENT_TIMESTAMP);
+
+-- name: GetTag :one
+-- The tag is looked up by its primary key so there can only be one.
+SELECT "key", created FROM tags WHERE tid = $tid AND "key" = $key;
+
+-- name: GetTagsCreatedWithin :many
+SELECT "key", created FROM tags WHERE tid = $tid AND created >= now() - $age::interval;
+
+-- name: AddTag :execrows
+-- Like CreateTag but returns the number of tags that were inserted.
+INSERT INTO tags
+  (tid, "key", created)
+VALUES
+  ($tid, $key, CURRENT_TIMESTAMP);
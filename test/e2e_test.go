@@ -2,7 +2,10 @@ package test
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/graeme-hill/sqlstuff-go/lib"
 	basic "github.com/graeme-hill/sqlstuff-go/test/basic/store"
@@ -19,16 +22,16 @@ func TestBasic(t *testing.T) {
 	err = lib.RunMigrations(ctx, "./basic/migrations", connStr)
 	require.NoError(t, err)
 
-	users, err := client.GetUsers()
+	users, err := client.GetUsers(ctx)
 	require.NoError(t, err)
 
 	require.Len(t, users, 2)
 
-	require.Equal(t, "Graeme", users[0].FirstName)
-	require.Equal(t, "Hill", users[0].LastName)
+	require.Equal(t, "Graeme", *users[0].FirstName)
+	require.Equal(t, "Hill", *users[0].LastName)
 
-	require.Equal(t, "Graeme", users[1].FirstName)
-	require.Equal(t, "Hill", users[1].LastName)
+	require.Equal(t, "Graeme", *users[1].FirstName)
+	require.Equal(t, "Hill", *users[1].LastName)
 }
 
 func TestBugTracker(t *testing.T) {
@@ -37,9 +40,85 @@ func TestBugTracker(t *testing.T) {
 	require.NoError(t, err)
 
 	ctx := context.Background()
-	err = lib.RunMigrations(ctx, "./basic/migrations", connStr)
+	err = lib.RunMigrations(ctx, "./bugtracker/migrations", connStr)
+	require.NoError(t, err)
+
+	_, _, err = client.GetIssue(ctx, "00000000-0000-0000-0000-000000000001", "BUG-1")
+	require.NoError(t, err)
+
+	// Postgres has to work out the types of optional parameters that are NULL
+	_, err = client.ListIssues(ctx, "00000000-0000-0000-0000-000000000001", nil, nil)
+	require.NoError(t, err)
+
+	status := bugtracker.IssueStatusOpen
+	_, err = client.ListIssues(ctx, "00000000-0000-0000-0000-000000000001", &status, nil)
+	require.NoError(t, err)
+
+	// :one returns nil until the tag exists and :execrows counts the insert
+	tid := "00000000-0000-0000-0000-000000000001"
+	key := fmt.Sprintf("e2e-%d", time.Now().UnixNano()%1e9)
+	tag, err := client.GetTag(ctx, tid, key)
+	require.NoError(t, err)
+	require.Nil(t, tag)
+
+	added, err := client.AddTag(ctx, tid, key)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), added)
+
+	tag, err = client.GetTag(ctx, tid, key)
+	require.NoError(t, err)
+	require.Equal(t, key, tag.Key)
+}
+
+func TestBugTrackerCopyFromChunks(t *testing.T) {
+	connStr := "user=postgres password=password"
+	client, err := bugtracker.NewDBClient(connStr)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	err = lib.RunMigrations(ctx, "./bugtracker/migrations", connStr)
 	require.NoError(t, err)
 
-	_, _, err = client.GetIssue(1, 2)
+	// JSONB can't be copied by lib/pq so the rows are inserted 1000 at a time.
+	// 1000 rows fill exactly one INSERT and 1001 spill into a second one.
+	for i, count := range []int{1000, 1001} {
+		tid := testTenantID(i)
+		err = client.CopyFromImportIssue(ctx, importIssueRows(tid, count))
+		require.NoError(t, err)
+
+		issues, err := client.ListIssues(ctx, tid, nil, nil)
+		require.NoError(t, err)
+		require.Len(t, issues, count)
+	}
+
+	// A duplicate in the second chunk rolls back the first chunk too
+	tid := testTenantID(2)
+	rows := importIssueRows(tid, 1001)
+	rows[1000].Id = rows[0].Id
+	err = client.CopyFromImportIssue(ctx, rows)
+	require.Error(t, err)
+
+	issues, err := client.ListIssues(ctx, tid, nil, nil)
 	require.NoError(t, err)
+	require.Len(t, issues, 0)
+}
+
+// A tenant that no earlier run of the tests has used.
+func testTenantID(n int) string {
+	return fmt.Sprintf("00000000-0000-0000-%04d-%012d", n, time.Now().UnixNano()%1e12)
+}
+
+func importIssueRows(tid string, count int) []bugtracker.ImportIssueParams {
+	rows := []bugtracker.ImportIssueParams{}
+	for i := 0; i < count; i++ {
+		rows = append(rows, bugtracker.ImportIssueParams{
+			Tid:        tid,
+			Id:         fmt.Sprintf("IMP-%d", i),
+			Name:       fmt.Sprintf("Imported %d", i),
+			ProjectKey: "IMP",
+			Fields:     json.RawMessage("{}"),
+			Created:    time.Now(),
+		})
+	}
+	return rows
 }